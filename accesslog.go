@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"math"
+	mathrand "math/rand"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type contextKey int
+
+const (
+	requestInfoKey contextKey = iota
+	spanContextKey
+)
+
+// requestInfo is what handlers tell the access log about the request
+type requestInfo struct {
+	id          string
+	dummyID     string
+	mutations   []string        // how the response is broken on purpose
+	cert        *clientCertInfo // verified client certificate
+	certChecked bool
+}
+
+// infoOf returns requestInfo of the request. It is empty outside of the access log middleware
+func infoOf(r *http.Request) *requestInfo {
+	if info, ok := r.Context().Value(requestInfoKey).(*requestInfo); ok {
+		return info
+	}
+	return &requestInfo{}
+}
+
+// requestLog is the logger with the request ID
+func requestLog(r *http.Request) *log.Entry {
+	if id := infoOf(r).id; id != "" {
+		return log.WithField("request_id", id)
+	}
+	return log.NewEntry(log.StandardLogger())
+}
+
+// requestErrorResponse is errorResponse with the request ID to find the request in logs
+type requestErrorResponse struct {
+	*errorResponse
+	RequestID string `json:"request_id,omitempty"`
+}
+
+func requestError(r *http.Request, e *errorResponse) requestErrorResponse {
+	return requestErrorResponse{e, infoOf(r).id}
+}
+
+// requestIDPattern limits request IDs from clients to what can be logged safely
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:+/=-]{1,128}$`)
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// clientIP is the peer address, or the first address of X-Forwarded-For from a trusted proxy
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if isTrustedProxy(r.RemoteAddr) {
+		if forwarded := forwardedValue(r, "X-Forwarded-For"); forwarded != "" {
+			return forwarded
+		}
+	}
+	return host
+}
+
+// accessLog assigns X-Request-ID if the client does not send a valid one,
+// and logs a line for each request. Lines are sampled but server errors and mutated responses
+// are always logged
+func accessLog(c accessLogConfig, next http.Handler) http.Handler {
+	level, _ := log.ParseLevel(c.Level)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := &requestInfo{id: r.Header.Get("X-Request-ID")}
+		if !requestIDPattern.MatchString(info.id) {
+			info.id = newRequestID()
+		}
+		r = r.WithContext(context.WithValue(r.Context(), requestInfoKey, info))
+		w.Header().Set("X-Request-ID", info.id)
+
+		started := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+
+		if !c.Enabled {
+			return
+		}
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		lineLevel := level
+		if sw.status >= http.StatusInternalServerError {
+			if lineLevel > log.ErrorLevel {
+				lineLevel = log.ErrorLevel
+			}
+		} else if c.SampleRate < 1 && len(info.mutations) == 0 && mathrand.Float64() >= c.SampleRate {
+			return
+		}
+
+		entry := log.WithFields(log.Fields{
+			"request_id": info.id,
+			"method":     r.Method,
+			"protocol":   r.Proto,
+			"path":       r.URL.Path,
+			"status":     sw.status,
+			"bytes":      sw.bytes,
+			"latency_ms": math.Round(float64(time.Since(started))/float64(time.Millisecond)*1000) / 1000,
+			"client_ip":  clientIP(r),
+		})
+		if info.dummyID != "" {
+			entry = entry.WithField("dummy_id", info.dummyID)
+		}
+		if len(info.mutations) > 0 {
+			entry = entry.WithField("mutations", strings.Join(info.mutations, ","))
+		}
+		if ua := r.UserAgent(); ua != "" {
+			entry = entry.WithField("user_agent", strings.TrimSpace(ua))
+		}
+		logAt(entry, lineLevel, "access")
+	})
+}
+
+// logAt logs the message at the level. Levels above error are logged as error not to exit
+func logAt(entry *log.Entry, level log.Level, msg string) {
+	switch level {
+	case log.DebugLevel:
+		entry.Debug(msg)
+	case log.InfoLevel:
+		entry.Info(msg)
+	case log.WarnLevel:
+		entry.Warn(msg)
+	default:
+		entry.Error(msg)
+	}
+}
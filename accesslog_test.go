@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+)
+
+var _ = Describe("Access log", func() {
+	var output *bytes.Buffer
+	var c accessLogConfig
+
+	BeforeEach(func() {
+		output = &bytes.Buffer{}
+		log.SetOutput(output)
+		log.SetFormatter(&log.JSONFormatter{})
+		c = defaultConfig().AccessLog
+	})
+
+	AfterEach(func() {
+		log.SetOutput(os.Stdout)
+	})
+
+	serve := func(handler http.Handler, req *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		accessLog(c, handler).ServeHTTP(rec, req)
+		return rec
+	}
+
+	lines := func() []map[string]interface{} {
+		var entries []map[string]interface{}
+		decoder := json.NewDecoder(output)
+		for decoder.More() {
+			var entry map[string]interface{}
+			Expect(decoder.Decode(&entry)).To(Succeed())
+			entries = append(entries, entry)
+		}
+		return entries
+	}
+
+	It("should log a line with the request ID", func() {
+		req := httptest.NewRequest("GET", "/v1/5a0e3b7c9d1e2f3a4b5c6d7e", nil)
+		req.RemoteAddr = "192.0.2.1:4321"
+		rec := serve(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			infoOf(r).dummyID = "5a0e3b7c9d1e2f3a4b5c6d7e"
+			requestLog(r).Info("serving")
+			w.Write([]byte("hello"))
+		}), req)
+
+		id := rec.Header().Get("X-Request-ID")
+		Expect(id).To(HaveLen(32))
+		entries := lines()
+		Expect(entries).To(HaveLen(2))
+		Expect(entries[0]).To(HaveKeyWithValue("request_id", id))
+		Expect(entries[1]).To(HaveKeyWithValue("msg", "access"))
+		Expect(entries[1]).To(HaveKeyWithValue("request_id", id))
+		Expect(entries[1]).To(HaveKeyWithValue("method", "GET"))
+		Expect(entries[1]).To(HaveKeyWithValue("path", "/v1/5a0e3b7c9d1e2f3a4b5c6d7e"))
+		Expect(entries[1]).To(HaveKeyWithValue("dummy_id", "5a0e3b7c9d1e2f3a4b5c6d7e"))
+		Expect(entries[1]).To(HaveKeyWithValue("status", 200.0))
+		Expect(entries[1]).To(HaveKeyWithValue("bytes", 5.0))
+		Expect(entries[1]).To(HaveKeyWithValue("client_ip", "192.0.2.1"))
+		Expect(entries[1]).To(HaveKey("latency_ms"))
+	})
+
+	It("should propagate a valid request ID and replace an invalid one", func() {
+		req := httptest.NewRequest("GET", "/echo", nil)
+		req.Header.Set("X-Request-ID", "abc-123")
+		rec := serve(http.NotFoundHandler(), req)
+		Expect(rec.Header().Get("X-Request-ID")).To(Equal("abc-123"))
+
+		req = httptest.NewRequest("GET", "/echo", nil)
+		req.Header.Set("X-Request-ID", "bad id\n")
+		rec = serve(http.NotFoundHandler(), req)
+		Expect(rec.Header().Get("X-Request-ID")).NotTo(Equal("bad id\n"))
+		Expect(rec.Header().Get("X-Request-ID")).To(HaveLen(32))
+	})
+
+	It("should add the request ID to error responses", func() {
+		req := httptest.NewRequest("GET", "/v1/invalid", nil)
+		req.Header.Set("X-Request-ID", "req-1")
+		rec := serve(createRoute(), req)
+		Expect(rec.Code).To(Equal(http.StatusBadRequest))
+
+		var body map[string]string
+		Expect(json.Unmarshal(rec.Body.Bytes(), &body)).To(Succeed())
+		Expect(body).To(HaveKeyWithValue("error", "InvalidID"))
+		Expect(body).To(HaveKeyWithValue("request_id", "req-1"))
+	})
+
+	It("should sample lines but keep server errors", func() {
+		c.SampleRate = 0
+		serve(http.NotFoundHandler(), httptest.NewRequest("GET", "/echo", nil))
+		Expect(lines()).To(BeEmpty())
+
+		serve(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}), httptest.NewRequest("GET", "/echo", nil))
+		entries := lines()
+		Expect(entries).To(HaveLen(1))
+		Expect(entries[0]).To(HaveKeyWithValue("level", "error"))
+	})
+
+	It("should always log mutated responses with the mutations", func() {
+		c.SampleRate = 0
+		echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { handleEcho(w, r, nil) })
+		serve(echo, httptest.NewRequest("GET", "/echo?mutate=bom,nul_bytes", nil))
+		entries := lines()
+		Expect(entries).To(HaveLen(1))
+		Expect(entries[0]).To(HaveKeyWithValue("mutations", "bom,nul_bytes"))
+	})
+})
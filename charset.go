@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// validateCharset checks the charset is supported and can represent the content.
+// Charsets are labels of the WHATWG Encoding Standard. e.g. utf-8, ISO8859-1 or utf-16
+func validateCharset(charset, content string) error {
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return fmt.Errorf("charset %s is not supported", charset)
+	}
+	if _, err := encodeContent(enc, content); err != nil {
+		return fmt.Errorf("content can not be encoded in %s", charset)
+	}
+	return nil
+}
+
+// encodeContent encodes the UTF-8 content with the encoding.
+// UTF-8 content is returned as it is so that the stored bytes are sent untouched
+func encodeContent(enc encoding.Encoding, content string) ([]byte, error) {
+	if name, _ := htmlindex.Name(enc); name == "utf-8" {
+		return []byte(content), nil
+	}
+	return enc.NewEncoder().Bytes([]byte(content))
+}
+
+// dummyContent is content of a dummy in its charset. Characters which the charset can not represent
+// are replaced, and the content is sent as it is stored if the charset is unknown. e.g. imported dummies
+func dummyContent(content, charset string) []byte {
+	enc, err := htmlindex.Get(charset)
+	if err != nil || content == "" {
+		return []byte(content)
+	}
+	encoded, err := encodeContent(enc, content)
+	if err != nil {
+		encoded, err = encoding.ReplaceUnsupported(enc.NewEncoder()).Bytes([]byte(content))
+	}
+	if err != nil {
+		return []byte(content)
+	}
+	return encoded
+}
+
+type acceptedCharset struct {
+	label string
+	q     float64
+}
+
+// parseAcceptCharset returns charsets of Accept-Charset from the most preferred one
+func parseAcceptCharset(header string) []acceptedCharset {
+	var accepted []acceptedCharset
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		label := strings.ToLower(strings.TrimSpace(params[0]))
+		if label == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range params[1:] {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(key, "q") {
+				if parsed, err := strconv.ParseFloat(value, 64); err == nil && parsed >= 0 && parsed <= 1 {
+					q = parsed
+				}
+			}
+		}
+		accepted = append(accepted, acceptedCharset{label, q})
+	}
+	sort.SliceStable(accepted, func(i, j int) bool { return accepted[i].q > accepted[j].q })
+	return accepted
+}
+
+// negotiateCharset switches the charset of the dummy to one in Accept-Charset when the client does not
+// accept the declared one. The first preferred charset which can represent the content is taken.
+// The declared charset is kept if there is none, as a server may disregard the header
+func negotiateCharset(w http.ResponseWriter, r *http.Request, dummyOne *dummyModel) {
+	if dummyOne.ContentType == "" {
+		return
+	}
+	w.Header().Add("Vary", "Accept-Charset")
+	header := r.Header.Get("Accept-Charset")
+	if header == "" || dummyOne.Content == "" {
+		return
+	}
+	declared, err := htmlindex.Get(dummyOne.Charset)
+	if err != nil {
+		return
+	}
+	declaredName, _ := htmlindex.Name(declared)
+
+	accepted := parseAcceptCharset(header)
+	if charsetQuality(accepted, declaredName) > 0 {
+		return
+	}
+
+	for _, charset := range accepted {
+		if charset.q == 0 || charset.label == "*" {
+			continue
+		}
+		enc, err := htmlindex.Get(charset.label)
+		if err != nil {
+			continue
+		}
+		if _, err := encodeContent(enc, dummyOne.Content); err == nil {
+			requestLog(r).Debugf("charset %s is negotiated instead of %s", charset.label, dummyOne.Charset)
+			dummyOne.Charset = charset.label
+			return
+		}
+	}
+}
+
+// charsetQuality is the quality of the charset in Accept-Charset. A charset which is not listed takes
+// the quality of *, and is not acceptable without it
+func charsetQuality(accepted []acceptedCharset, name string) float64 {
+	wildcard := 0.0
+	for _, charset := range accepted {
+		if charset.label == "*" {
+			wildcard = charset.q
+			continue
+		}
+		if enc, err := htmlindex.Get(charset.label); err == nil {
+			if encName, _ := htmlindex.Name(enc); encName == name {
+				return charset.q
+			}
+		}
+	}
+	return wildcard
+}
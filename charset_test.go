@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Charset", func() {
+	serve := func(dummy dummyModel, acceptCharset string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/v1/id", nil)
+		if acceptCharset != "" {
+			req.Header.Set("Accept-Charset", acceptCharset)
+		}
+		w := httptest.NewRecorder()
+		negotiateCharset(w, req, &dummy)
+		writeDummy(w, req, &dummy, 0)
+		return w
+	}
+
+	It("should encode content in the declared charset", func() {
+		dummy := dummyModel{Status: 200, ContentType: "text/plain", Charset: "ISO8859-1", Content: "café"}
+		w := serve(dummy, "")
+		Expect(w.Header().Get("Content-Type")).To(Equal("text/plain; charset=ISO8859-1"))
+		Expect(w.Header().Get("Vary")).To(Equal("Accept-Charset"))
+		Expect(w.Body.Bytes()).To(Equal([]byte{'c', 'a', 'f', 0xe9}))
+
+		dummy.Charset = "utf-16"
+		Expect(serve(dummy, "").Body.Bytes()).To(Equal([]byte{'c', 0, 'a', 0, 'f', 0, 0xe9, 0}))
+
+		dummy.Charset = "utf-8"
+		Expect(serve(dummy, "").Body.String()).To(Equal("café"))
+		dummy.Charset = "binary"
+		Expect(serve(dummy, "").Body.String()).To(Equal("café"), "unknown charsets of imported dummies")
+	})
+
+	It("should negotiate Accept-Charset", func() {
+		dummy := dummyModel{Status: 200, ContentType: "text/plain", Charset: "utf-8", Content: "café"}
+		Expect(serve(dummy, "iso-8859-1, utf-8;q=0.5").Body.String()).To(Equal("café"), "utf-8 is acceptable")
+		Expect(serve(dummy, "*;q=0.1").Body.String()).To(Equal("café"))
+
+		w := serve(dummy, "utf-8;q=0, utf-16be;q=0.5, iso-8859-1;q=0.8")
+		Expect(w.Header().Get("Content-Type")).To(Equal("text/plain; charset=iso-8859-1"))
+		Expect(w.Body.Bytes()).To(Equal([]byte{'c', 'a', 'f', 0xe9}))
+
+		w = serve(dummyModel{Status: 200, ContentType: "text/plain", Charset: "utf-8", Content: "한글"}, "iso-8859-1, euc-kr;q=0.5")
+		Expect(w.Header().Get("Content-Type")).To(Equal("text/plain; charset=euc-kr"), "latin-1 can not represent the content")
+
+		w = serve(dummy, "x-unknown")
+		Expect(w.Header().Get("Content-Type")).To(Equal("text/plain; charset=utf-8"), "the header is disregarded")
+	})
+
+	It("should reject unsupported charsets", func() {
+		reqModel := requestModel{Status: 200, ContentType: "text/plain", Charset: "utf-16", Content: "café"}
+		Expect(reqModel.validate()).To(Succeed())
+		reqModel.Charset = "ebcdic"
+		Expect(reqModel.validate()).To(MatchError("charset ebcdic is not supported"))
+		reqModel.Charset, reqModel.Content = "ISO8859-1", "한글"
+		Expect(reqModel.validate()).To(MatchError("content can not be encoded in ISO8859-1"))
+
+		sse := requestModel{Charset: "ebcdic", SSE: &sseModel{Events: []sseEvent{{Data: "x"}}}}
+		Expect(sse.validate()).To(MatchError("charset ebcdic is not supported"), "protocols are checked too")
+		longPoll := requestModel{Charset: "ebcdic", LongPoll: &longPollModel{Timeout: 10}}
+		Expect(longPoll.validate()).To(MatchError("charset ebcdic is not supported"))
+	})
+})
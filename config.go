@@ -0,0 +1,491 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// config is the server configuration. Values are loaded from a YAML file, environment variables
+// and command line flags. Flags override environment variables which override the file
+type config struct {
+	Listen         string          `yaml:"listen"`          // address to listen. e.g. :3000
+	PublicURL      string          `yaml:"public_url"`      // base URL of links in responses. built from requests if empty
+	PathPrefix     string          `yaml:"path_prefix"`     // path where the router is mounted. e.g. /dummy
+	TrustedProxies []string        `yaml:"trusted_proxies"` // IPs or CIDRs whose X-Forwarded-* headers are trusted
+	Server         serverConfig    `yaml:"server"`
+	TLS            tlsConfig       `yaml:"tls"`
+	GRPC           grpcConfig      `yaml:"grpc"`
+	Storage        storageConfig   `yaml:"storage"`
+	Log            logConfig       `yaml:"log"`
+	CORS           corsConfig      `yaml:"cors"`
+	Limits         limitsConfig    `yaml:"limits"`
+	Stubs          stubsConfig     `yaml:"stubs"`
+	Metrics        metricsConfig   `yaml:"metrics"`
+	AccessLog      accessLogConfig `yaml:"access_log"`
+	Tracing        tracingConfig   `yaml:"tracing"`
+}
+
+type serverConfig struct {
+	ReadTimeout       time.Duration `yaml:"read_timeout"`        // max time to read a request including the body
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"` // max time to read request headers
+	WriteTimeout      time.Duration `yaml:"write_timeout"`       // max time to write a response. delays of dummies are added
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`        // max time to keep an idle connection
+	ShutdownGrace     time.Duration `yaml:"shutdown_grace"`      // time to fail readiness checks before draining on shutdown
+	ShutdownTimeout   time.Duration `yaml:"shutdown_timeout"`    // max time to drain in-flight requests on shutdown
+	H2C               bool          `yaml:"h2c"`                 // serve HTTP/2 without TLS on the plain listener
+}
+
+type tlsConfig struct {
+	Listen       string         `yaml:"listen"`    // address of the HTTPS listener. e.g. :3443. empty to disable
+	CertFile     string         `yaml:"cert_file"` // certificate in PEM. a certificate is generated if empty
+	KeyFile      string         `yaml:"key_file"`  // private key in PEM
+	AutoCert     autoCertConfig `yaml:"auto_cert"`
+	ClientAuth   string         `yaml:"client_auth"`    // none, request or require
+	ClientCAFile string         `yaml:"client_ca_file"` // CA bundle in PEM to verify client certificates
+}
+
+type autoCertConfig struct {
+	Dir   string   `yaml:"dir"`   // directory to keep the generated CA and certificate
+	Hosts []string `yaml:"hosts"` // host names and IPs of the generated certificate
+}
+
+type grpcConfig struct {
+	Listen string `yaml:"listen"` // address of a listener only for gRPC over h2c. e.g. :50051. empty to disable
+}
+
+type storageConfig struct {
+	Backend         string        `yaml:"backend"` // only mongodb is supported
+	MongoDBURI      string        `yaml:"mongodb_uri"`
+	MongoDBDatabase string        `yaml:"mongodb_database"`
+	PingTimeout     time.Duration `yaml:"ping_timeout"` // max time to wait for the storage on readiness checks
+}
+
+type logConfig struct {
+	Level  string `yaml:"level"`  // panic, fatal, error, warning, info or debug
+	Format string `yaml:"format"` // json or text
+}
+
+type corsConfig struct {
+	AllowedOrigins   []string `yaml:"allowed_origins"`
+	AllowedMethods   []string `yaml:"allowed_methods"`
+	AllowedHeaders   []string `yaml:"allowed_headers"`
+	AllowCredentials bool     `yaml:"allow_credentials"`
+	MaxAge           int      `yaml:"max_age"` // seconds to cache preflight results
+}
+
+type limitsConfig struct {
+	MaxBodyBytes int64         `yaml:"max_body_bytes"` // max size of request bodies. 0 means unlimited
+	MaxDelay     time.Duration `yaml:"max_delay"`      // max delay of dummies. 0 means unlimited
+}
+
+type stubsConfig struct {
+	Dir          string        `yaml:"dir"`           // directory of stub files. empty to disable
+	PollInterval time.Duration `yaml:"poll_interval"` // how often the directory is checked for changes
+}
+
+type accessLogConfig struct {
+	Enabled    bool    `yaml:"enabled"`
+	Level      string  `yaml:"level"`       // debug, info, warning or error. server errors are logged as error at least
+	SampleRate float64 `yaml:"sample_rate"` // ratio of requests to log. server errors are always logged
+}
+
+type tracingConfig struct {
+	Endpoint      string        `yaml:"endpoint"`       // OTLP/HTTP traces URL. e.g. http://localhost:4318/v1/traces. empty to disable
+	ServiceName   string        `yaml:"service_name"`   // service.name of spans
+	SampleRate    float64       `yaml:"sample_rate"`    // ratio of traces started here to export. traces from clients follow their sampled flag
+	BatchSize     int           `yaml:"batch_size"`     // max spans in an export
+	FlushInterval time.Duration `yaml:"flush_interval"` // how often spans are exported
+}
+
+type metricsConfig struct {
+	Enabled   bool `yaml:"enabled"`    // serve /metrics
+	DummyHits bool `yaml:"dummy_hits"` // count responses by dummy. a label value is added for each dummy
+}
+
+// cfg is the effective configuration
+var cfg = defaultConfig()
+
+func defaultConfig() config {
+	return config{
+		Listen: ":3000", // default port is 3000 for Heroku
+		Server: serverConfig{
+			ReadTimeout:       30 * time.Second,
+			ReadHeaderTimeout: 10 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       120 * time.Second,
+			ShutdownGrace:     5 * time.Second,
+			ShutdownTimeout:   15 * time.Second,
+		},
+		TLS: tlsConfig{
+			AutoCert: autoCertConfig{
+				Dir:   "certs",
+				Hosts: []string{"localhost", "127.0.0.1", "::1"},
+			},
+			ClientAuth: clientAuthNone,
+		},
+		Storage: storageConfig{
+			Backend:     "mongodb",
+			PingTimeout: 2 * time.Second,
+		},
+		Log: logConfig{
+			Level:  "info",
+			Format: "json",
+		},
+		CORS: corsConfig{
+			AllowedOrigins: []string{"*"},
+			AllowedMethods: []string{"GET", "POST", "HEAD"},
+			AllowedHeaders: []string{"Origin", "Accept", "Content-Type"},
+		},
+		Limits: limitsConfig{
+			MaxBodyBytes: 10 << 20,
+		},
+		Stubs: stubsConfig{
+			PollInterval: 2 * time.Second,
+		},
+		Metrics: metricsConfig{
+			Enabled: true,
+		},
+		Tracing: tracingConfig{
+			ServiceName:   "dummy-http-responser",
+			SampleRate:    1,
+			BatchSize:     512,
+			FlushInterval: 5 * time.Second,
+		},
+		AccessLog: accessLogConfig{
+			Enabled:    true,
+			Level:      "info",
+			SampleRate: 1,
+		},
+	}
+}
+
+// setting is a value which can be set by an environment variable or a command line flag
+type setting struct {
+	env     string
+	flag    string
+	usage   string
+	set     func(c *config, v string) error
+	boolean bool // the flag can be given without a value, like -h2c
+}
+
+// settings are applied in order, so PORT is overridden by LISTEN_ADDR
+var settings = []setting{
+	{"PORT", "", "port to listen", func(c *config, v string) error { c.Listen = ":" + v; return nil }, false},
+	{"LISTEN_ADDR", "listen", "address to listen. e.g. :3000", setString(func(c *config) *string { return &c.Listen }), false},
+	{"PUBLIC_URL", "public-url", "base URL of links in responses", setString(func(c *config) *string { return &c.PublicURL }), false},
+	{"PATH_PREFIX", "path-prefix", "path where the router is mounted. e.g. /dummy", setString(func(c *config) *string { return &c.PathPrefix }), false},
+	{"TRUSTED_PROXIES", "trusted-proxies", "comma separated IPs or CIDRs of proxies whose X-Forwarded-* headers are trusted", setList(func(c *config) *[]string { return &c.TrustedProxies }), false},
+	{"READ_TIMEOUT", "read-timeout", "max time to read a request. e.g. 30s. 0 means unlimited", setDuration(func(c *config) *time.Duration { return &c.Server.ReadTimeout }), false},
+	{"READ_HEADER_TIMEOUT", "read-header-timeout", "max time to read request headers. 0 means unlimited", setDuration(func(c *config) *time.Duration { return &c.Server.ReadHeaderTimeout }), false},
+	{"WRITE_TIMEOUT", "write-timeout", "max time to write a response. 0 means unlimited", setDuration(func(c *config) *time.Duration { return &c.Server.WriteTimeout }), false},
+	{"IDLE_TIMEOUT", "idle-timeout", "max time to keep an idle connection. 0 means unlimited", setDuration(func(c *config) *time.Duration { return &c.Server.IdleTimeout }), false},
+	{"SHUTDOWN_GRACE", "shutdown-grace", "time to fail readiness checks before draining on shutdown. 0 to drain at once", setDuration(func(c *config) *time.Duration { return &c.Server.ShutdownGrace }), false},
+	{"SHUTDOWN_TIMEOUT", "shutdown-timeout", "max time to drain in-flight requests on shutdown", setDuration(func(c *config) *time.Duration { return &c.Server.ShutdownTimeout }), false},
+	{"H2C_ENABLED", "h2c", "serve HTTP/2 without TLS (h2c) on the plain listener", setBool(func(c *config) *bool { return &c.Server.H2C }), true},
+	{"TLS_LISTEN_ADDR", "tls-listen", "address of the HTTPS listener. e.g. :3443", setString(func(c *config) *string { return &c.TLS.Listen }), false},
+	{"TLS_CERT_FILE", "tls-cert", "TLS certificate in PEM. a certificate is generated if empty", setString(func(c *config) *string { return &c.TLS.CertFile }), false},
+	{"TLS_KEY_FILE", "tls-key", "TLS private key in PEM", setString(func(c *config) *string { return &c.TLS.KeyFile }), false},
+	{"TLS_AUTO_CERT_DIR", "tls-auto-cert-dir", "directory to keep the generated CA and certificate", setString(func(c *config) *string { return &c.TLS.AutoCert.Dir }), false},
+	{"TLS_AUTO_CERT_HOSTS", "tls-auto-cert-hosts", "comma separated host names and IPs of the generated certificate", setList(func(c *config) *[]string { return &c.TLS.AutoCert.Hosts }), false},
+	{"TLS_CLIENT_AUTH", "tls-client-auth", "client certificates. none, request or require", setString(func(c *config) *string { return &c.TLS.ClientAuth }), false},
+	{"TLS_CLIENT_CA_FILE", "tls-client-ca", "CA bundle in PEM to verify client certificates", setString(func(c *config) *string { return &c.TLS.ClientCAFile }), false},
+	{"GRPC_LISTEN_ADDR", "grpc-listen", "address of a listener only for gRPC over h2c. e.g. :50051", setString(func(c *config) *string { return &c.GRPC.Listen }), false},
+	{"STORAGE_BACKEND", "storage", "storage backend. only mongodb is supported", setString(func(c *config) *string { return &c.Storage.Backend }), false},
+	{"MONGODB_URI", "mongodb-uri", "MongoDB URI", setString(func(c *config) *string { return &c.Storage.MongoDBURI }), false},
+	{"MONGODB_DATABASE", "mongodb-database", "MongoDB database", setString(func(c *config) *string { return &c.Storage.MongoDBDatabase }), false},
+	{"STORAGE_PING_TIMEOUT", "storage-ping-timeout", "max time to wait for the storage on readiness checks", setDuration(func(c *config) *time.Duration { return &c.Storage.PingTimeout }), false},
+	{"LOG_LEVEL", "log-level", "log level. panic, fatal, error, warning, info or debug", setString(func(c *config) *string { return &c.Log.Level }), false},
+	{"LOG_FORMAT", "log-format", "log format. json or text", setString(func(c *config) *string { return &c.Log.Format }), false},
+	{"CORS_ALLOWED_ORIGINS", "cors-allowed-origins", "comma separated origins allowed by CORS", setList(func(c *config) *[]string { return &c.CORS.AllowedOrigins }), false},
+	{"CORS_ALLOWED_METHODS", "cors-allowed-methods", "comma separated methods allowed by CORS", setList(func(c *config) *[]string { return &c.CORS.AllowedMethods }), false},
+	{"CORS_ALLOWED_HEADERS", "cors-allowed-headers", "comma separated headers allowed by CORS", setList(func(c *config) *[]string { return &c.CORS.AllowedHeaders }), false},
+	{"CORS_ALLOW_CREDENTIALS", "cors-allow-credentials", "allow credentials on CORS requests", setBool(func(c *config) *bool { return &c.CORS.AllowCredentials }), true},
+	{"CORS_MAX_AGE", "cors-max-age", "seconds to cache CORS preflight results", func(c *config, v string) (err error) {
+		c.CORS.MaxAge, err = strconv.Atoi(v)
+		return
+	}, false},
+	{"MAX_BODY_BYTES", "max-body-bytes", "max size of request bodies. 0 means unlimited", func(c *config, v string) (err error) {
+		c.Limits.MaxBodyBytes, err = strconv.ParseInt(v, 10, 64)
+		return
+	}, false},
+	{"MAX_DELAY", "max-delay", "max delay of dummies. e.g. 30s. 0 means unlimited", setDuration(func(c *config) *time.Duration { return &c.Limits.MaxDelay }), false},
+	{"STUBS_DIR", "stubs-dir", "directory of stub files", setString(func(c *config) *string { return &c.Stubs.Dir }), false},
+	{"STUBS_POLL_INTERVAL", "stubs-poll-interval", "how often the stub directory is checked. e.g. 2s", setDuration(func(c *config) *time.Duration { return &c.Stubs.PollInterval }), false},
+	{"ACCESS_LOG_ENABLED", "access-log", "log a line for each request", setBool(func(c *config) *bool { return &c.AccessLog.Enabled }), true},
+	{"ACCESS_LOG_LEVEL", "access-log-level", "level of access log lines. debug, info, warning or error", setString(func(c *config) *string { return &c.AccessLog.Level }), false},
+	{"ACCESS_LOG_SAMPLE_RATE", "access-log-sample-rate", "ratio of requests to log. e.g. 0.1", func(c *config, v string) (err error) {
+		c.AccessLog.SampleRate, err = strconv.ParseFloat(v, 64)
+		return
+	}, false},
+	{"OTLP_ENDPOINT", "otlp-endpoint", "OTLP/HTTP traces URL. e.g. http://localhost:4318/v1/traces", setString(func(c *config) *string { return &c.Tracing.Endpoint }), false},
+	{"OTEL_SERVICE_NAME", "service-name", "service name of spans", setString(func(c *config) *string { return &c.Tracing.ServiceName }), false},
+	{"TRACING_SAMPLE_RATE", "tracing-sample-rate", "ratio of traces started here to export. e.g. 0.1", func(c *config, v string) (err error) {
+		c.Tracing.SampleRate, err = strconv.ParseFloat(v, 64)
+		return
+	}, false},
+	{"METRICS_ENABLED", "metrics", "serve Prometheus metrics on /metrics", setBool(func(c *config) *bool { return &c.Metrics.Enabled }), true},
+	{"METRICS_DUMMY_HITS", "metrics-dummy-hits", "count responses by dummy", setBool(func(c *config) *bool { return &c.Metrics.DummyHits }), true},
+}
+
+func setString(field func(c *config) *string) func(c *config, v string) error {
+	return func(c *config, v string) error {
+		*field(c) = v
+		return nil
+	}
+}
+
+func setList(field func(c *config) *[]string) func(c *config, v string) error {
+	return func(c *config, v string) error {
+		var list []string
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		*field(c) = list
+		return nil
+	}
+}
+
+func setBool(field func(c *config) *bool) func(c *config, v string) error {
+	return func(c *config, v string) (err error) {
+		*field(c), err = strconv.ParseBool(v)
+		return
+	}
+}
+
+func setDuration(field func(c *config) *time.Duration) func(c *config, v string) error {
+	return func(c *config, v string) (err error) {
+		*field(c), err = time.ParseDuration(v)
+		return
+	}
+}
+
+// settingFlag is the value of a flag given on the command line. It is applied after the file and the environment
+type settingFlag struct {
+	value   string
+	boolean bool
+}
+
+func (f *settingFlag) String() string {
+	return f.value
+}
+
+func (f *settingFlag) Set(v string) error {
+	f.value = v
+	return nil
+}
+
+// IsBoolFlag lets boolean settings be given without a value
+func (f *settingFlag) IsBoolFlag() bool {
+	return f.boolean
+}
+
+// loadConfig builds the configuration from the file, the environment and the arguments.
+// It returns the remaining arguments such as a command
+func loadConfig(args []string, getenv func(string) string) (config, []string, error) {
+	c := defaultConfig()
+
+	fs := flag.NewFlagSet("dummy-http-responser", flag.ContinueOnError)
+	configFile := fs.String("config", getenv("CONFIG_FILE"), "path to the YAML configuration file")
+	flagValues := map[string]*settingFlag{}
+	for _, s := range settings {
+		if s.flag != "" {
+			flagValues[s.flag] = &settingFlag{boolean: s.boolean}
+			fs.Var(flagValues[s.flag], s.flag, s.usage+" (env "+s.env+")")
+		}
+	}
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: dummy-http-responser [flags] [print-config]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return c, nil, err
+	}
+
+	if *configFile != "" {
+		raw, err := ioutil.ReadFile(*configFile)
+		if err != nil {
+			return c, nil, err
+		}
+		if err := yaml.UnmarshalStrict(raw, &c); err != nil {
+			return c, nil, fmt.Errorf("%s: %s", *configFile, err.Error())
+		}
+	}
+
+	var errs []string
+	for _, s := range settings {
+		if v := getenv(s.env); v != "" {
+			if err := s.set(&c, v); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: invalid value '%s'", s.env, v))
+			}
+		}
+	}
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flag == f.Name {
+				if err := s.set(&c, flagValues[f.Name].value); err != nil {
+					errs = append(errs, fmt.Sprintf("-%s: invalid value '%s'", f.Name, f.Value))
+				}
+			}
+		}
+	})
+	if len(errs) > 0 {
+		return c, nil, errors.New(strings.Join(errs, "\n"))
+	}
+
+	if c.PublicURL != "" && !strings.HasSuffix(c.PublicURL, "/") {
+		c.PublicURL += "/"
+	}
+	c.PathPrefix = strings.TrimSuffix(c.PathPrefix, "/")
+	return c, fs.Args(), c.validate()
+}
+
+// validate checks all values and reports every invalid one
+func (c *config) validate() error {
+	var errs []string
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		errs = append(errs, fmt.Sprintf("listen: '%s' is not an address like :3000", c.Listen))
+	}
+	if c.PublicURL != "" {
+		if u, err := url.Parse(c.PublicURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Sprintf("public_url: '%s' is not an absolute http(s) URL", c.PublicURL))
+		}
+	}
+	if c.PathPrefix != "" && !strings.HasPrefix(c.PathPrefix, "/") {
+		errs = append(errs, fmt.Sprintf("path_prefix: '%s' should start with /", c.PathPrefix))
+	}
+	for _, proxy := range c.TrustedProxies {
+		if parseProxy(proxy) == nil {
+			errs = append(errs, fmt.Sprintf("trusted_proxies: '%s' is not an IP or a CIDR", proxy))
+		}
+	}
+	if c.Server.ReadTimeout < 0 || c.Server.ReadHeaderTimeout < 0 || c.Server.WriteTimeout < 0 || c.Server.IdleTimeout < 0 || c.Server.ShutdownGrace < 0 {
+		errs = append(errs, "server: timeouts should not be negative")
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		errs = append(errs, "server.shutdown_timeout: should be positive")
+	}
+	if c.TLS.Listen != "" {
+		if _, _, err := net.SplitHostPort(c.TLS.Listen); err != nil {
+			errs = append(errs, fmt.Sprintf("tls.listen: '%s' is not an address like :3443", c.TLS.Listen))
+		} else if c.TLS.Listen == c.Listen {
+			errs = append(errs, "tls.listen: should differ from listen")
+		}
+		if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+			errs = append(errs, "tls: cert_file and key_file should be set together")
+		}
+		if c.TLS.CertFile == "" && (c.TLS.AutoCert.Dir == "" || len(c.TLS.AutoCert.Hosts) == 0) {
+			errs = append(errs, "tls.auto_cert: dir and hosts are required to generate a certificate")
+		}
+	}
+	if c.GRPC.Listen != "" {
+		if _, _, err := net.SplitHostPort(c.GRPC.Listen); err != nil {
+			errs = append(errs, fmt.Sprintf("grpc.listen: '%s' is not an address like :50051", c.GRPC.Listen))
+		} else if c.GRPC.Listen == c.Listen || c.GRPC.Listen == c.TLS.Listen {
+			errs = append(errs, "grpc.listen: should differ from listen and tls.listen")
+		}
+	}
+	switch c.TLS.ClientAuth {
+	case clientAuthNone:
+	case clientAuthRequest, clientAuthRequire:
+		if c.TLS.ClientCAFile == "" {
+			errs = append(errs, "tls.client_ca_file: is required to verify client certificates")
+		}
+	default:
+		errs = append(errs, fmt.Sprintf("tls.client_auth: '%s' is not one of none, request and require", c.TLS.ClientAuth))
+	}
+	if c.Storage.Backend != "mongodb" {
+		errs = append(errs, fmt.Sprintf("storage.backend: '%s' is not supported. only mongodb is supported", c.Storage.Backend))
+	} else if c.Storage.MongoDBURI == "" {
+		errs = append(errs, "storage.mongodb_uri: is empty")
+	}
+	if c.Storage.PingTimeout <= 0 {
+		errs = append(errs, "storage.ping_timeout: should be positive")
+	}
+	if _, err := log.ParseLevel(c.Log.Level); err != nil {
+		errs = append(errs, fmt.Sprintf("log.level: '%s' is not one of panic, fatal, error, warning, info and debug", c.Log.Level))
+	}
+	if c.Log.Format != "json" && c.Log.Format != "text" {
+		errs = append(errs, fmt.Sprintf("log.format: '%s' is not one of json and text", c.Log.Format))
+	}
+	switch c.AccessLog.Level {
+	case "debug", "info", "warning", "warn", "error":
+	default:
+		errs = append(errs, fmt.Sprintf("access_log.level: '%s' is not one of debug, info, warning and error", c.AccessLog.Level))
+	}
+	if c.AccessLog.SampleRate < 0 || c.AccessLog.SampleRate > 1 {
+		errs = append(errs, "access_log.sample_rate: should be between 0 and 1")
+	}
+	if c.Tracing.Endpoint != "" {
+		if u, err := url.Parse(c.Tracing.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Sprintf("tracing.endpoint: '%s' is not an absolute http(s) URL", c.Tracing.Endpoint))
+		}
+	}
+	if c.Tracing.SampleRate < 0 || c.Tracing.SampleRate > 1 {
+		errs = append(errs, "tracing.sample_rate: should be between 0 and 1")
+	}
+	if c.Tracing.BatchSize <= 0 || c.Tracing.FlushInterval <= 0 {
+		errs = append(errs, "tracing: batch_size and flush_interval should be positive")
+	}
+	if c.CORS.MaxAge < 0 {
+		errs = append(errs, "cors.max_age: should not be negative")
+	}
+	if c.Limits.MaxBodyBytes < 0 {
+		errs = append(errs, "limits.max_body_bytes: should not be negative")
+	}
+	if c.Limits.MaxDelay < 0 {
+		errs = append(errs, "limits.max_delay: should not be negative")
+	}
+	if c.Stubs.Dir != "" {
+		if info, err := os.Stat(c.Stubs.Dir); err != nil || !info.IsDir() {
+			errs = append(errs, fmt.Sprintf("stubs.dir: '%s' is not a directory", c.Stubs.Dir))
+		}
+		if c.Stubs.PollInterval <= 0 {
+			errs = append(errs, "stubs.poll_interval: should be positive")
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+// printable returns the configuration in YAML. The password in the MongoDB URI is masked
+func (c config) printable() string {
+	if u, err := url.Parse(c.Storage.MongoDBURI); err == nil && u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), "xxxxx")
+			c.Storage.MongoDBURI = u.String()
+		}
+	}
+	byt, _ := yaml.Marshal(c)
+	return string(byt)
+}
+
+// setupLogger applies the log configuration
+func setupLogger(c logConfig) {
+	if c.Format == "text" {
+		log.SetFormatter(&log.TextFormatter{})
+	} else {
+		// Log as JSON instead of the default ASCII formatter.
+		log.SetFormatter(&log.JSONFormatter{})
+	}
+
+	// Output to stdout instead of the default stderr
+	log.SetOutput(os.Stdout)
+
+	level, _ := log.ParseLevel(c.Level)
+	log.SetLevel(level)
+}
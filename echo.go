@@ -8,13 +8,13 @@ import (
 	"strings"
 
 	"github.com/julienschmidt/httprouter"
-	log "github.com/sirupsen/logrus"
 )
 
 // handlerV1Echo handles echo request
-// This accepts GET, POST, PUT, DELETE and reflect body
+// This accepts GET, POST, PUT, DELETE and reflect body. With 'callback', the body is passed to the callback as JSONP.
+// 'mutate' breaks the response on purpose. e.g. mutate=bom,nul_bytes
 func handleEcho(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	// params: callback
+	// params: callback, mutate
 	echoHeaders := r.URL.Query().Get("echo-hdr")
 	dummyStatus := r.URL.Query().Get("dummy-status")
 
@@ -27,18 +27,44 @@ func handleEcho(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 	} else {
 		convStatus, err = strconv.ParseInt(dummyStatus, 0, 16)
 		if err != nil {
-			log.Warningf("converting %s, but error %s", dummyStatus, err.Error())
+			requestLog(r).Warningf("converting %s, but error %s", dummyStatus, err.Error())
 
 			w.WriteHeader(http.StatusBadRequest)
 			w.Header().Set("Content-Type", "application/json")
 			return
 		}
 	}
-	contentType := r.Header.Get("Content-Type")
-	w.Header().Set("Content-Type", contentType)
-	w.WriteHeader(int(convStatus))
+	callback, err := jsonpCallback(r)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(requestError(r, errorInvalidCallback))
+		return
+	}
+	var mutations []string
+	if mutate := r.URL.Query().Get("mutate"); mutate != "" {
+		mutations = strings.Split(mutate, ",")
+		if validateMutations(mutations) != nil {
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(requestError(r, errorInvalidMutation))
+			return
+		}
+	}
+
+	var body []byte
+	if r.Body != nil {
+		defer r.Body.Close()
+		body, err = ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(requestError(r, errorNotFound))
+			return
+		}
+	}
 
-	// compose headers
+	// compose headers. they have to be set before the status is written.
+	// echoed ones come first so that they can not replace the content type of JSONP
 	hdrs := strings.Split(echoHeaders, ",")
 	for _, hdr := range hdrs {
 		v := r.Header.Get(hdr)
@@ -47,15 +73,31 @@ func handleEcho(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
 		}
 	}
 
-	if r.Body != nil {
-		defer r.Body.Close()
-		body, err := ioutil.ReadAll(r.Body)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			json.NewEncoder(w).Encode(errorNotFound)
-			return
+	contentType := r.Header.Get("Content-Type")
+	if callback != "" {
+		body = wrapJSONP(callback, jsonpArgument(body))
+		contentType = "application/javascript; charset=utf-8"
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+	}
+	if len(mutations) > 0 {
+		infoOf(r).mutations = mutations
+		contentType, body = mutateEcho(contentType, body, mutations)
+	}
+	w.Header().Set("Content-Type", contentType)
+
+	// reflect the negotiated protocol. e.g. HTTP/1.1 or HTTP/2.0
+	w.Header().Set("X-Echo-Protocol", r.Proto)
+
+	// reflect the verified client certificate
+	if cert := clientCert(r); cert != nil {
+		w.Header().Set("X-Echo-Client-Cert-Subject", cert.Subject)
+		w.Header().Set("X-Echo-Client-Cert-Issuer", cert.Issuer)
+		w.Header().Set("X-Echo-Client-Cert-Fingerprint", cert.Fingerprint)
+		for _, san := range cert.SANs {
+			w.Header().Add("X-Echo-Client-Cert-San", san)
 		}
-		w.Write(body)
 	}
+	w.WriteHeader(int(convStatus))
+	w.Write(body)
 	return
 }
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 
 	"github.com/julienschmidt/httprouter"
 	. "github.com/onsi/ginkgo"
@@ -95,4 +96,64 @@ var _ = Describe("Echo test", func() {
 			Expect(w.Code).To(Equal(http.StatusBadRequest))
 		})
 	})
+	Context("test mutations", func() {
+		It("should break the body on purpose", func() {
+			req, _ := http.NewRequest("POST", "/echo?mutate=bom,trailing_garbage,mislabel_charset", bytes.NewBufferString(`{"a": 1}`))
+			req.Header.Add("Content-Type", "application/json; charset=utf-8")
+			w := httptest.NewRecorder()
+			handleEcho(w, req, nil)
+			Expect(w.Header().Get("Content-Type")).To(Equal("application/json; charset=iso-8859-1"))
+			Expect(w.Body.String()).To(Equal("\xef\xbb\xbf{\"a\": 1}\n}]trailing garbage"))
+		})
+
+		It("should reject unknown mutations", func() {
+			req, _ := http.NewRequest("GET", "/echo?mutate=bom,nope", nil)
+			w := httptest.NewRecorder()
+			handleEcho(w, req, nil)
+			Expect(w.Code).To(Equal(http.StatusBadRequest))
+			Expect(w.Body.String()).To(ContainSubstring("InvalidMutation"))
+		})
+	})
+
+	Context("test JSONP", func() {
+		It("should pass the body to the callback", func() {
+			req, _ := http.NewRequest("POST", "/echo?callback=jQuery_123", bytes.NewBufferString(`{"a": 1}`))
+			req.Header.Add("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			handleEcho(w, req, nil)
+			Expect(w.Header().Get("Content-Type")).To(Equal("application/javascript; charset=utf-8"))
+			Expect(w.Header().Get("X-Content-Type-Options")).To(Equal("nosniff"))
+			Expect(w.Body.String()).To(Equal(`/**/jQuery_123({"a": 1});`))
+
+			req, _ = http.NewRequest("GET", "/echo?callback=cb", bytes.NewBufferString("<b>"))
+			w = httptest.NewRecorder()
+			handleEcho(w, req, nil)
+			Expect(w.Body.String()).To(Equal(`/**/cb("\u003cb\u003e");`))
+
+			req, _ = http.NewRequest("GET", "/echo?callback=cb", nil)
+			w = httptest.NewRecorder()
+			handleEcho(w, req, nil)
+			Expect(w.Body.String()).To(Equal(`/**/cb(null);`))
+		})
+
+		It("should not let echoed headers replace the content type", func() {
+			req, _ := http.NewRequest("POST", "/echo?callback=cb&echo-hdr=Content-Type,X-Content-Type-Options", bytes.NewBufferString(`{"a": 1}`))
+			req.Header.Add("Content-Type", "text/html")
+			req.Header.Add("X-Content-Type-Options", "sniff")
+			w := httptest.NewRecorder()
+			handleEcho(w, req, nil)
+			Expect(w.Header().Get("Content-Type")).To(Equal("application/javascript; charset=utf-8"))
+			Expect(w.Header().Get("X-Content-Type-Options")).To(Equal("nosniff"))
+		})
+
+		It("should reject callbacks other than identifiers", func() {
+			for _, callback := range []string{"alert(1)//", "a.b", "1cb", "cb;x"} {
+				req, _ := http.NewRequest("GET", "/echo?callback="+url.QueryEscape(callback), nil)
+				w := httptest.NewRecorder()
+				handleEcho(w, req, nil)
+				Expect(w.Code).To(Equal(http.StatusBadRequest), callback)
+				Expect(w.Body.String()).To(ContainSubstring("InvalidCallback"))
+			}
+		})
+	})
 })
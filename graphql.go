@@ -0,0 +1,1328 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// graphQLModel makes a dummy a GraphQL endpoint of the schema. Responses are shaped to the selection set.
+// A field is resolved from the parent value, then values["Type.field"], then values["Type"] for objects
+// and scalars, and is generated from its type at last
+//
+//	"graphql": {
+//	  "schema": "type Query { user(id: ID!): User } type User { id: ID! name: String friends: [User!]! }",
+//	  "values": {"User.name": "Alice"},
+//	  "operations": {"GetUser": {"data": {"user": {"id": "1", "name": "Bob", "friends": []}}}}
+//	}
+type graphQLModel struct {
+	Schema     string                 `json:"schema"`     // SDL
+	Values     map[string]interface{} `json:"values"`     // values by Type.field or Type
+	Operations map[string]interface{} `json:"operations"` // canned responses by operation name
+}
+
+// validate graphQLModel. do not trust any input
+func (m *graphQLModel) validate() error {
+	if _, err := buildGQLSchema(m.Schema); err != nil {
+		return fmt.Errorf("invalid schema: %s", err.Error())
+	}
+	for name, canned := range m.Operations {
+		if _, ok := canned.(map[string]interface{}); !ok {
+			return fmt.Errorf("canned response of operation %s should be an object", name)
+		}
+	}
+	return nil
+}
+
+// gqlBuiltinSDL is the built-in scalars, directives and introspection types of every schema
+const gqlBuiltinSDL = `
+"The ` + "`String`" + ` scalar type represents textual data, represented as UTF-8 character sequences."
+scalar String
+"The ` + "`Int`" + ` scalar type represents non-fractional signed whole numeric values."
+scalar Int
+"The ` + "`Float`" + ` scalar type represents signed double-precision fractional values."
+scalar Float
+"The ` + "`Boolean`" + ` scalar type represents ` + "`true` or `false`" + `."
+scalar Boolean
+"The ` + "`ID`" + ` scalar type represents a unique identifier."
+scalar ID
+
+"Directs the executor to include this field or fragment only when the ` + "`if`" + ` argument is true."
+directive @include("Included when true." if: Boolean!) on FIELD | FRAGMENT_SPREAD | INLINE_FRAGMENT
+"Directs the executor to skip this field or fragment when the ` + "`if`" + ` argument is true."
+directive @skip("Skipped when true." if: Boolean!) on FIELD | FRAGMENT_SPREAD | INLINE_FRAGMENT
+"Marks an element of a GraphQL schema as no longer supported."
+directive @deprecated(reason: String = "No longer supported") on FIELD_DEFINITION | ARGUMENT_DEFINITION | INPUT_FIELD_DEFINITION | ENUM_VALUE
+"Exposes a URL that specifies the behavior of this scalar."
+directive @specifiedBy(url: String!) on SCALAR
+
+type __Schema {
+  description: String
+  types: [__Type!]!
+  queryType: __Type!
+  mutationType: __Type
+  subscriptionType: __Type
+  directives: [__Directive!]!
+}
+
+type __Type {
+  kind: __TypeKind!
+  name: String
+  description: String
+  specifiedByURL: String
+  fields(includeDeprecated: Boolean = false): [__Field!]
+  interfaces: [__Type!]
+  possibleTypes: [__Type!]
+  enumValues(includeDeprecated: Boolean = false): [__EnumValue!]
+  inputFields(includeDeprecated: Boolean = false): [__InputValue!]
+  ofType: __Type
+  isOneOf: Boolean
+}
+
+enum __TypeKind { SCALAR OBJECT INTERFACE UNION ENUM INPUT_OBJECT LIST NON_NULL }
+
+type __Field {
+  name: String!
+  description: String
+  args(includeDeprecated: Boolean = false): [__InputValue!]!
+  type: __Type!
+  isDeprecated: Boolean!
+  deprecationReason: String
+}
+
+type __InputValue {
+  name: String!
+  description: String
+  type: __Type!
+  defaultValue: String
+  isDeprecated: Boolean!
+  deprecationReason: String
+}
+
+type __EnumValue {
+  name: String!
+  description: String
+  isDeprecated: Boolean!
+  deprecationReason: String
+}
+
+type __Directive {
+  name: String!
+  description: String
+  locations: [__DirectiveLocation!]!
+  args(includeDeprecated: Boolean = false): [__InputValue!]!
+  isRepeatable: Boolean!
+}
+
+enum __DirectiveLocation {
+  QUERY MUTATION SUBSCRIPTION FIELD FRAGMENT_DEFINITION FRAGMENT_SPREAD INLINE_FRAGMENT VARIABLE_DEFINITION
+  SCHEMA SCALAR OBJECT FIELD_DEFINITION ARGUMENT_DEFINITION INTERFACE UNION ENUM ENUM_VALUE INPUT_OBJECT INPUT_FIELD_DEFINITION
+}
+`
+
+// fields on the query root for introspection
+var (
+	gqlSchemaField = &gqlFieldDef{Name: "__schema", Type: &gqlTypeRef{NonNull: true, OfType: &gqlTypeRef{Name: "__Schema"}}}
+	gqlTypeField   = &gqlFieldDef{Name: "__type", Type: &gqlTypeRef{Name: "__Type"}, Args: []*gqlInputValue{
+		{Name: "name", Type: &gqlTypeRef{NonNull: true, OfType: &gqlTypeRef{Name: "String"}}},
+	}}
+)
+
+func isLeafKind(kind string) bool {
+	return kind == gqlScalarKind || kind == gqlEnumKind
+}
+
+func isInputKind(kind string) bool {
+	return isLeafKind(kind) || kind == gqlInputObjectKind
+}
+
+// buildGQLSchema parses the SDL with built-in types and checks references between types
+func buildGQLSchema(sdl string) (*gqlSchema, error) {
+	schema := &gqlSchema{Types: map[string]*gqlTypeDef{}, Directives: map[string]*gqlDirectiveDef{}}
+	if err := parseGQLSchema(gqlBuiltinSDL, schema); err != nil {
+		return nil, err
+	}
+	if err := parseGQLSchema(sdl, schema); err != nil {
+		return nil, err
+	}
+	if schema.Query == "" {
+		schema.Query = "Query"
+	}
+	if schema.Mutation == "" && schema.Types["Mutation"] != nil {
+		schema.Mutation = "Mutation"
+	}
+	if schema.Subscription == "" && schema.Types["Subscription"] != nil {
+		schema.Subscription = "Subscription"
+	}
+	for _, root := range []string{schema.Query, schema.Mutation, schema.Subscription} {
+		if root == "" {
+			continue
+		}
+		if def := schema.Types[root]; def == nil || def.Kind != gqlObjectKind {
+			return nil, fmt.Errorf("root type %s should be an object type", root)
+		}
+	}
+
+	checkRef := func(owner string, t *gqlTypeRef, input bool) error {
+		def := schema.Types[t.namedType()]
+		if def == nil {
+			return fmt.Errorf("unknown type %s in %s", t.namedType(), owner)
+		}
+		if input && !isInputKind(def.Kind) {
+			return fmt.Errorf("%s of %s should be an input type", t.namedType(), owner)
+		}
+		if !input && def.Kind == gqlInputObjectKind {
+			return fmt.Errorf("%s of %s should be an output type", t.namedType(), owner)
+		}
+		return nil
+	}
+	for _, name := range schema.TypeOrder {
+		def := schema.Types[name]
+		switch def.Kind {
+		case gqlObjectKind, gqlInterfaceKind:
+			if len(def.Fields) == 0 {
+				return nil, fmt.Errorf("type %s should define one or more fields", name)
+			}
+			for _, f := range def.Fields {
+				if err := checkRef(name+"."+f.Name, f.Type, false); err != nil {
+					return nil, err
+				}
+				for _, arg := range f.Args {
+					if err := checkRef(name+"."+f.Name+"("+arg.Name+")", arg.Type, true); err != nil {
+						return nil, err
+					}
+				}
+			}
+			for _, iface := range def.Interfaces {
+				ifaceDef := schema.Types[iface]
+				if ifaceDef == nil || ifaceDef.Kind != gqlInterfaceKind {
+					return nil, fmt.Errorf("%s implements %s which is not an interface", name, iface)
+				}
+				if def.Kind == gqlObjectKind {
+					ifaceDef.PossibleTypes = append(ifaceDef.PossibleTypes, name)
+				}
+			}
+		case gqlUnionKind:
+			if len(def.PossibleTypes) == 0 {
+				return nil, fmt.Errorf("union %s should have members", name)
+			}
+			for _, member := range def.PossibleTypes {
+				if memberDef := schema.Types[member]; memberDef == nil || memberDef.Kind != gqlObjectKind {
+					return nil, fmt.Errorf("member %s of union %s should be an object type", member, name)
+				}
+			}
+		case gqlEnumKind:
+			if len(def.EnumValues) == 0 {
+				return nil, fmt.Errorf("enum %s should have values", name)
+			}
+		case gqlInputObjectKind:
+			for _, f := range def.InputFields {
+				if err := checkRef(name+"."+f.Name, f.Type, true); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return schema, nil
+}
+
+// fieldDef finds the field of the type including introspection fields on the query root
+func (s *gqlSchema) fieldDef(parent *gqlTypeDef, name string) *gqlFieldDef {
+	if parent.Name == s.Query {
+		switch name {
+		case "__schema":
+			return gqlSchemaField
+		case "__type":
+			return gqlTypeField
+		}
+	}
+	return parent.field(name)
+}
+
+// possible checks the object type is the type, or a member of the interface or union
+func (s *gqlSchema) possible(object *gqlTypeDef, typeName string) bool {
+	if object.Name == typeName {
+		return true
+	}
+	if def := s.Types[typeName]; def != nil {
+		for _, member := range def.PossibleTypes {
+			if member == object.Name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// gqlValidator checks an operation against the schema. It collects all errors
+type gqlValidator struct {
+	schema    *gqlSchema
+	doc       *gqlDocument
+	variables map[string]*gqlVariableDef
+	spreading map[string]bool // fragments being validated to find cycles
+	validated map[string]bool
+	errs      []*gqlError
+}
+
+func (v *gqlValidator) errorf(pos gqlPos, format string, args ...interface{}) {
+	v.errs = append(v.errs, gqlErrorf(pos, format, args...))
+}
+
+// validateGQLOperation validates the operation and fragments which it spreads
+func validateGQLOperation(schema *gqlSchema, doc *gqlDocument, op *gqlOperation) []*gqlError {
+	v := &gqlValidator{
+		schema:    schema,
+		doc:       doc,
+		variables: map[string]*gqlVariableDef{},
+		spreading: map[string]bool{},
+		validated: map[string]bool{},
+	}
+	names := map[string]bool{}
+	for _, other := range doc.Operations {
+		if other.Name == "" && len(doc.Operations) > 1 {
+			v.errorf(other.Pos, "This anonymous operation must be the only defined operation.")
+		} else if other.Name != "" && names[other.Name] {
+			v.errorf(other.Pos, "There can be only one operation named %q.", other.Name)
+		}
+		names[other.Name] = true
+	}
+
+	for _, varDef := range op.Variables {
+		if v.variables[varDef.Name] != nil {
+			v.errorf(varDef.Pos, "There can be only one variable named \"$%s\".", varDef.Name)
+		}
+		v.variables[varDef.Name] = varDef
+		if def := schema.Types[varDef.Type.namedType()]; def == nil {
+			v.errorf(varDef.Pos, "Unknown type %q.", varDef.Type.namedType())
+		} else if !isInputKind(def.Kind) {
+			v.errorf(varDef.Pos, "Variable \"$%s\" cannot be non-input type %q.", varDef.Name, varDef.Type.String())
+		}
+	}
+
+	root := schema.rootType(op.Type)
+	if root == nil {
+		v.errorf(op.Pos, "Schema is not configured for %ss.", op.Type)
+		return v.errs
+	}
+	if doc.selectionDepth(op.Selections, maxGQLDepth, map[string]int{}) > maxGQLDepth {
+		// fragments can nest selections deeper than the parser allows
+		v.errorf(op.Pos, "Selections are nested deeper than %d levels.", maxGQLDepth)
+		return v.errs
+	}
+	v.directives(op.Directives)
+	v.selections(root, op.Selections)
+	return v.errs
+}
+
+// selectionDepth is how deep the selections nest. Fragments count as a level too.
+// It stops beyond the limit. depths keeps those of fragments, and a fragment in a cycle is taken as 0
+func (doc *gqlDocument) selectionDepth(selections []*gqlSelection, limit int, depths map[string]int) int {
+	if limit < 0 {
+		return 0
+	}
+	max := 0
+	for _, s := range selections {
+		d := 0
+		switch s.Kind {
+		case gqlFieldSelection, gqlInlineFragment:
+			if len(s.Selections) > 0 {
+				d = 1 + doc.selectionDepth(s.Selections, limit-1, depths)
+			}
+		case gqlFragmentSpread:
+			frag := doc.Fragments[s.Name]
+			if frag == nil {
+				continue
+			}
+			known, ok := depths[s.Name]
+			if !ok {
+				depths[s.Name] = 0
+				known = 1 + doc.selectionDepth(frag.Selections, limit-1, depths)
+				depths[s.Name] = known
+			}
+			d = known
+		}
+		if d > max {
+			max = d
+		}
+		if max > limit {
+			break
+		}
+	}
+	return max
+}
+
+// rootType returns the root type of the operation type or nil
+func (s *gqlSchema) rootType(opType string) *gqlTypeDef {
+	switch opType {
+	case "query":
+		return s.Types[s.Query]
+	case "mutation":
+		if s.Mutation != "" {
+			return s.Types[s.Mutation]
+		}
+	case "subscription":
+		if s.Subscription != "" {
+			return s.Types[s.Subscription]
+		}
+	}
+	return nil
+}
+
+func (v *gqlValidator) selections(parent *gqlTypeDef, selections []*gqlSelection) {
+	for _, s := range selections {
+		v.directives(s.Directives)
+		switch s.Kind {
+		case gqlFieldSelection:
+			if s.Name == "__typename" {
+				if len(s.Selections) > 0 {
+					v.errorf(s.Pos, "Field \"__typename\" must not have a selection since type \"String!\" has no subfields.")
+				}
+				continue
+			}
+			field := v.schema.fieldDef(parent, s.Name)
+			if field == nil {
+				v.errorf(s.Pos, "Cannot query field %q on type %q.", s.Name, parent.Name)
+				continue
+			}
+			v.arguments(field.Args, s.Arguments, fmt.Sprintf("Field %q", s.Name), s.Pos)
+			named := v.schema.Types[field.Type.namedType()]
+			if isLeafKind(named.Kind) {
+				if len(s.Selections) > 0 {
+					v.errorf(s.Pos, "Field %q must not have a selection since type %q has no subfields.", s.Name, field.Type.String())
+				}
+			} else if len(s.Selections) == 0 {
+				v.errorf(s.Pos, "Field %q of type %q must have a selection of subfields. Did you mean \"%s { ... }\"?", s.Name, field.Type.String(), s.Name)
+			} else {
+				v.selections(named, s.Selections)
+			}
+		case gqlFragmentSpread:
+			frag := v.doc.Fragments[s.Name]
+			if frag == nil {
+				v.errorf(s.Pos, "Unknown fragment %q.", s.Name)
+				continue
+			}
+			if v.spreading[s.Name] {
+				v.errorf(s.Pos, "Cannot spread fragment %q within itself.", s.Name)
+				continue
+			}
+			cond := v.typeCondition(frag.TypeCondition, frag.Pos)
+			if cond == nil || v.validated[s.Name] {
+				continue
+			}
+			v.validated[s.Name] = true
+			v.spreading[s.Name] = true
+			v.directives(frag.Directives)
+			v.selections(cond, frag.Selections)
+			delete(v.spreading, s.Name)
+		case gqlInlineFragment:
+			cond := parent
+			if s.TypeCondition != "" {
+				if cond = v.typeCondition(s.TypeCondition, s.Pos); cond == nil {
+					continue
+				}
+			}
+			v.selections(cond, s.Selections)
+		}
+	}
+}
+
+func (v *gqlValidator) typeCondition(name string, pos gqlPos) *gqlTypeDef {
+	def := v.schema.Types[name]
+	if def == nil {
+		v.errorf(pos, "Unknown type %q.", name)
+		return nil
+	}
+	if isLeafKind(def.Kind) || def.Kind == gqlInputObjectKind {
+		v.errorf(pos, "Fragment cannot condition on non composite type %q.", name)
+		return nil
+	}
+	return def
+}
+
+func (v *gqlValidator) directives(dirs []*gqlDirective) {
+	for _, d := range dirs {
+		def := v.schema.Directives[d.Name]
+		if def == nil {
+			v.errorf(d.Pos, "Unknown directive \"@%s\".", d.Name)
+			continue
+		}
+		v.arguments(def.Args, d.Arguments, "Directive \"@"+d.Name+"\"", d.Pos)
+	}
+}
+
+func (v *gqlValidator) arguments(defs []*gqlInputValue, args []*gqlArgument, owner string, pos gqlPos) {
+	given := map[string]bool{}
+	for _, arg := range args {
+		var def *gqlInputValue
+		for _, d := range defs {
+			if d.Name == arg.Name {
+				def = d
+			}
+		}
+		if def == nil {
+			v.errorf(arg.Pos, "Unknown argument %q on %s.", arg.Name, strings.ToLower(owner[:1])+owner[1:])
+			continue
+		}
+		given[arg.Name] = true
+		v.value(arg.Value, def.Type)
+	}
+	for _, def := range defs {
+		if def.Type.NonNull && def.Default == nil && !given[def.Name] {
+			v.errorf(pos, "%s argument %q of type %q is required, but it was not provided.", owner, def.Name, def.Type.String())
+		}
+	}
+}
+
+// value checks a literal against the input type. Variables are checked when they are coerced
+func (v *gqlValidator) value(val *gqlValue, t *gqlTypeRef) {
+	if val.Kind == gqlVariableValue {
+		if v.variables[val.Raw] == nil {
+			v.errorf(val.Pos, "Variable \"$%s\" is not defined.", val.Raw)
+		}
+		return
+	}
+	if t.NonNull {
+		if val.Kind == gqlNullValue {
+			v.errorf(val.Pos, "Expected value of type %q, found null.", t.String())
+			return
+		}
+		v.value(val, t.OfType)
+		return
+	}
+	if val.Kind == gqlNullValue {
+		return
+	}
+	if t.List {
+		if val.Kind != gqlListValue {
+			v.value(val, t.OfType)
+			return
+		}
+		for _, item := range val.List {
+			v.value(item, t.OfType)
+		}
+		return
+	}
+
+	def := v.schema.Types[t.Name]
+	ok := true
+	switch def.Kind {
+	case gqlScalarKind:
+		switch t.Name {
+		case "Int":
+			_, err := strconv.ParseInt(val.Raw, 10, 32)
+			ok = val.Kind == gqlIntValue && err == nil
+		case "Float":
+			ok = val.Kind == gqlIntValue || val.Kind == gqlFloatValue
+		case "String":
+			ok = val.Kind == gqlStringValue
+		case "Boolean":
+			ok = val.Kind == gqlBooleanValue
+		case "ID":
+			ok = val.Kind == gqlStringValue || val.Kind == gqlIntValue
+		}
+	case gqlEnumKind:
+		ok = false
+		if val.Kind == gqlEnumValueKind {
+			for _, ev := range def.EnumValues {
+				ok = ok || ev.Name == val.Raw
+			}
+		}
+	case gqlInputObjectKind:
+		if val.Kind != gqlObjectValue {
+			ok = false
+			break
+		}
+		given := map[string]bool{}
+		for _, field := range val.Fields {
+			var fieldDef *gqlInputValue
+			for _, f := range def.InputFields {
+				if f.Name == field.Name {
+					fieldDef = f
+				}
+			}
+			if fieldDef == nil {
+				v.errorf(field.Pos, "Field %q is not defined by type %q.", field.Name, def.Name)
+				continue
+			}
+			given[field.Name] = true
+			v.value(field.Value, fieldDef.Type)
+		}
+		for _, f := range def.InputFields {
+			if f.Type.NonNull && f.Default == nil && !given[f.Name] {
+				v.errorf(val.Pos, "Field \"%s.%s\" of required type %q was not provided.", def.Name, f.Name, f.Type.String())
+			}
+		}
+	}
+	if !ok {
+		v.errorf(val.Pos, "Expected value of type %q, found %s.", t.String(), val.String())
+	}
+}
+
+// gqlObject is a response object which keeps the order of the selection set
+type gqlObject struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func (o *gqlObject) set(key string, value interface{}) {
+	if o.values == nil {
+		o.values = map[string]interface{}{}
+	}
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = value
+}
+
+func (o *gqlObject) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		v, err := json.Marshal(o.values[key])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(k)
+		b.WriteByte(':')
+		b.Write(v)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// gqlResolver computes a field of an introspection object with the arguments
+type gqlResolver func(args map[string]interface{}) interface{}
+
+// maxGQLGeneratedItems limits items of lists generated in a response, as nested lists double at each level
+const maxGQLGeneratedItems = 10000
+
+// gqlExecutor executes an operation against mock values
+type gqlExecutor struct {
+	schema    *gqlSchema
+	doc       *gqlDocument
+	variables map[string]interface{}
+	values    map[string]interface{}
+	generated int       // items of generated lists
+	err       *gqlError // why the execution stopped
+}
+
+// gqlCollectedField is selections of a response key merged
+type gqlCollectedField struct {
+	key        string
+	selections []*gqlSelection
+}
+
+// skipped evaluates @skip and @include
+func (x *gqlExecutor) skipped(dirs []*gqlDirective) bool {
+	for _, d := range dirs {
+		if d.Name != "skip" && d.Name != "include" {
+			continue
+		}
+		for _, arg := range d.Arguments {
+			cond, _ := x.literal(arg.Value).(bool)
+			if arg.Name == "if" && cond == (d.Name == "skip") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (x *gqlExecutor) collectFields(object *gqlTypeDef, selections []*gqlSelection, visited map[string]bool, fields []*gqlCollectedField) []*gqlCollectedField {
+	for _, s := range selections {
+		if x.skipped(s.Directives) {
+			continue
+		}
+		switch s.Kind {
+		case gqlFieldSelection:
+			key := s.responseKey()
+			merged := false
+			for _, f := range fields {
+				if f.key == key {
+					f.selections = append(f.selections, s)
+					merged = true
+				}
+			}
+			if !merged {
+				fields = append(fields, &gqlCollectedField{key: key, selections: []*gqlSelection{s}})
+			}
+		case gqlFragmentSpread:
+			frag := x.doc.Fragments[s.Name]
+			if visited[s.Name] || frag == nil || !x.schema.possible(object, frag.TypeCondition) {
+				continue
+			}
+			visited[s.Name] = true
+			fields = x.collectFields(object, frag.Selections, visited, fields)
+		case gqlInlineFragment:
+			if s.TypeCondition != "" && !x.schema.possible(object, s.TypeCondition) {
+				continue
+			}
+			fields = x.collectFields(object, s.Selections, visited, fields)
+		}
+	}
+	return fields
+}
+
+// literal converts a literal in the document to a JSON value. Variables are replaced
+func (x *gqlExecutor) literal(v *gqlValue) interface{} {
+	switch v.Kind {
+	case gqlVariableValue:
+		return x.variables[v.Raw]
+	case gqlIntValue:
+		n, _ := strconv.ParseFloat(v.Raw, 64)
+		return n
+	case gqlFloatValue:
+		n, _ := strconv.ParseFloat(v.Raw, 64)
+		return n
+	case gqlBooleanValue:
+		return v.Raw == "true"
+	case gqlNullValue:
+		return nil
+	case gqlListValue:
+		list := make([]interface{}, len(v.List))
+		for i, item := range v.List {
+			list[i] = x.literal(item)
+		}
+		return list
+	case gqlObjectValue:
+		obj := map[string]interface{}{}
+		for _, field := range v.Fields {
+			obj[field.Name] = x.literal(field.Value)
+		}
+		return obj
+	}
+	return v.Raw
+}
+
+func (x *gqlExecutor) arguments(field *gqlFieldDef, args []*gqlArgument) map[string]interface{} {
+	values := map[string]interface{}{}
+	for _, def := range field.Args {
+		if def.Default != nil {
+			values[def.Name] = x.literal(def.Default)
+		}
+	}
+	for _, arg := range args {
+		if arg.Value.Kind == gqlVariableValue {
+			if v, ok := x.variables[arg.Value.Raw]; ok {
+				values[arg.Name] = v
+			}
+			continue
+		}
+		values[arg.Name] = x.literal(arg.Value)
+	}
+	return values
+}
+
+// executeObject resolves the selection set on the object. src is the value of the object if it is given.
+// index is the position of the object in a list, which generated leaves of the object follow
+func (x *gqlExecutor) executeObject(object *gqlTypeDef, src map[string]interface{}, index int, selections []*gqlSelection) *gqlObject {
+	result := &gqlObject{}
+	for _, f := range x.collectFields(object, selections, map[string]bool{}, nil) {
+		first := f.selections[0]
+		if first.Name == "__typename" {
+			result.set(f.key, object.Name)
+			continue
+		}
+		field := x.schema.fieldDef(object, first.Name)
+		if field == nil {
+			continue
+		}
+		var sub []*gqlSelection
+		for _, s := range f.selections {
+			sub = append(sub, s.Selections...)
+		}
+		args := x.arguments(field, first.Arguments)
+
+		var value interface{}
+		present := true
+		switch {
+		case field == gqlSchemaField:
+			value = x.schemaObject()
+		case field == gqlTypeField:
+			name, _ := args["name"].(string)
+			if def := x.schema.Types[name]; def != nil {
+				value = x.typeObject(def)
+			}
+		case strings.HasPrefix(object.Name, "__"):
+			value = src[first.Name]
+			if resolve, ok := value.(gqlResolver); ok {
+				value = resolve(args)
+			}
+		default:
+			value, present = src[first.Name]
+			if !present {
+				value, present = x.values[object.Name+"."+first.Name]
+			}
+		}
+		result.set(f.key, x.complete(field.Type, value, present, first.Name, index, sub))
+	}
+	return result
+}
+
+// complete shapes the value to the type. Values which are not present are generated
+func (x *gqlExecutor) complete(t *gqlTypeRef, value interface{}, present bool, fieldName string, index int, selections []*gqlSelection) interface{} {
+	if t.NonNull {
+		return x.complete(t.OfType, value, present, fieldName, index, selections)
+	}
+	if present && value == nil {
+		return nil
+	}
+	if t.List {
+		if !present {
+			if x.generated += 2; x.generated > maxGQLGeneratedItems {
+				if x.err == nil {
+					x.err = &gqlError{Message: fmt.Sprintf("More than %d list items would be generated. Give values of the lists.", maxGQLGeneratedItems)}
+				}
+				return nil
+			}
+			return []interface{}{
+				x.complete(t.OfType, nil, false, fieldName, 0, selections),
+				x.complete(t.OfType, nil, false, fieldName, 1, selections),
+			}
+		}
+		items, ok := value.([]interface{})
+		if !ok {
+			items = []interface{}{value}
+		}
+		list := make([]interface{}, len(items))
+		for i, item := range items {
+			list[i] = x.complete(t.OfType, item, true, fieldName, i, selections)
+		}
+		return list
+	}
+
+	def := x.schema.Types[t.Name]
+	if isLeafKind(def.Kind) {
+		if present {
+			return value
+		}
+		if v, ok := x.values[def.Name]; ok {
+			return v
+		}
+		return generateGQLLeaf(def, fieldName, index)
+	}
+
+	src, _ := value.(map[string]interface{})
+	if !present {
+		src, _ = x.values[def.Name].(map[string]interface{})
+	}
+	object := def
+	if def.Kind != gqlObjectKind {
+		// the type of an interface or a union is given as __typename or the first possible type
+		typeName, _ := src["__typename"].(string)
+		if typeDef := x.schema.Types[typeName]; typeDef != nil && x.schema.possible(typeDef, def.Name) {
+			object = typeDef
+		} else {
+			object = x.schema.Types[def.PossibleTypes[0]]
+		}
+		if !present {
+			if v, ok := x.values[object.Name].(map[string]interface{}); ok && src == nil {
+				src = v
+			}
+		}
+	}
+	return x.executeObject(object, src, index, selections)
+}
+
+// generateGQLLeaf generates a scalar or an enum value. Items of a list get different values
+func generateGQLLeaf(def *gqlTypeDef, fieldName string, index int) interface{} {
+	n := index + 1
+	if n < 1 {
+		n = 1
+	}
+	switch def.Name {
+	case "Int":
+		return n
+	case "Float":
+		return float64(n) + 0.5
+	case "Boolean":
+		return true
+	case "ID":
+		return strconv.Itoa(n)
+	}
+	if def.Kind == gqlEnumKind {
+		return def.EnumValues[(n-1)%len(def.EnumValues)].Name
+	}
+	if index < 0 {
+		return fieldName
+	}
+	return fieldName + " " + strconv.Itoa(n)
+}
+
+func nullable(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func (x *gqlExecutor) schemaObject() map[string]interface{} {
+	s := x.schema
+	root := func(name string) interface{} {
+		if name == "" {
+			return nil
+		}
+		return x.typeObject(s.Types[name])
+	}
+	return map[string]interface{}{
+		"description": nullable(s.Description),
+		"types": gqlResolver(func(map[string]interface{}) interface{} {
+			types := make([]interface{}, len(s.TypeOrder))
+			for i, name := range s.TypeOrder {
+				types[i] = x.typeObject(s.Types[name])
+			}
+			return types
+		}),
+		"queryType":        root(s.Query),
+		"mutationType":     root(s.Mutation),
+		"subscriptionType": root(s.Subscription),
+		"directives": gqlResolver(func(map[string]interface{}) interface{} {
+			dirs := make([]interface{}, len(s.DirOrder))
+			for i, name := range s.DirOrder {
+				d := s.Directives[name]
+				locations := make([]interface{}, len(d.Locations))
+				for j, loc := range d.Locations {
+					locations[j] = loc
+				}
+				dirs[i] = map[string]interface{}{
+					"name":         d.Name,
+					"description":  nullable(d.Description),
+					"locations":    locations,
+					"args":         x.inputValuesResolver(d.Args),
+					"isRepeatable": d.Repeatable,
+				}
+			}
+			return dirs
+		}),
+	}
+}
+
+// typeRefObject is __Type of a type reference. Lists and non-null wrap their types
+func (x *gqlExecutor) typeRefObject(t *gqlTypeRef) map[string]interface{} {
+	if t.OfType == nil {
+		return x.typeObject(x.schema.Types[t.Name])
+	}
+	kind := "LIST"
+	if t.NonNull {
+		kind = "NON_NULL"
+	}
+	return map[string]interface{}{
+		"kind": kind, "name": nil, "description": nil, "specifiedByURL": nil,
+		"fields": nil, "interfaces": nil, "possibleTypes": nil, "enumValues": nil, "inputFields": nil,
+		"ofType":  gqlResolver(func(map[string]interface{}) interface{} { return x.typeRefObject(t.OfType) }),
+		"isOneOf": nil,
+	}
+}
+
+func includeDeprecated(args map[string]interface{}) bool {
+	include, _ := args["includeDeprecated"].(bool)
+	return include
+}
+
+// typeObject is __Type of a named type. Fields which refer to other types are resolved when selected
+func (x *gqlExecutor) typeObject(def *gqlTypeDef) map[string]interface{} {
+	named := func(names []string) []interface{} {
+		types := make([]interface{}, len(names))
+		for i, name := range names {
+			types[i] = x.typeObject(x.schema.Types[name])
+		}
+		return types
+	}
+	obj := map[string]interface{}{
+		"kind":           def.Kind,
+		"name":           def.Name,
+		"description":    nullable(def.Description),
+		"specifiedByURL": nil,
+		"fields":         nil,
+		"interfaces":     nil,
+		"possibleTypes":  nil,
+		"enumValues":     nil,
+		"inputFields":    nil,
+		"ofType":         nil,
+		"isOneOf":        nil,
+	}
+	switch def.Kind {
+	case gqlScalarKind:
+		obj["specifiedByURL"] = nullable(def.SpecifiedByURL)
+	case gqlObjectKind, gqlInterfaceKind:
+		obj["fields"] = gqlResolver(func(args map[string]interface{}) interface{} {
+			fields := []interface{}{}
+			for _, f := range def.Fields {
+				if f.Deprecated && !includeDeprecated(args) {
+					continue
+				}
+				f := f
+				fields = append(fields, map[string]interface{}{
+					"name":              f.Name,
+					"description":       nullable(f.Description),
+					"args":              x.inputValuesResolver(f.Args),
+					"type":              gqlResolver(func(map[string]interface{}) interface{} { return x.typeRefObject(f.Type) }),
+					"isDeprecated":      f.Deprecated,
+					"deprecationReason": nullable(f.DeprecationReason),
+				})
+			}
+			return fields
+		})
+		obj["interfaces"] = gqlResolver(func(map[string]interface{}) interface{} { return named(def.Interfaces) })
+		if def.Kind == gqlInterfaceKind {
+			obj["possibleTypes"] = gqlResolver(func(map[string]interface{}) interface{} { return named(def.PossibleTypes) })
+		}
+	case gqlUnionKind:
+		obj["possibleTypes"] = gqlResolver(func(map[string]interface{}) interface{} { return named(def.PossibleTypes) })
+	case gqlEnumKind:
+		obj["enumValues"] = gqlResolver(func(args map[string]interface{}) interface{} {
+			values := []interface{}{}
+			for _, v := range def.EnumValues {
+				if v.Deprecated && !includeDeprecated(args) {
+					continue
+				}
+				values = append(values, map[string]interface{}{
+					"name":              v.Name,
+					"description":       nullable(v.Description),
+					"isDeprecated":      v.Deprecated,
+					"deprecationReason": nullable(v.DeprecationReason),
+				})
+			}
+			return values
+		})
+	case gqlInputObjectKind:
+		obj["inputFields"] = x.inputValuesResolver(def.InputFields)
+		obj["isOneOf"] = false
+	}
+	return obj
+}
+
+func (x *gqlExecutor) inputValuesResolver(values []*gqlInputValue) gqlResolver {
+	return func(args map[string]interface{}) interface{} {
+		list := []interface{}{}
+		for _, v := range values {
+			if v.Deprecated && !includeDeprecated(args) {
+				continue
+			}
+			v := v
+			var defaultValue interface{}
+			if v.Default != nil {
+				defaultValue = v.Default.String()
+			}
+			list = append(list, map[string]interface{}{
+				"name":              v.Name,
+				"description":       nullable(v.Description),
+				"type":              gqlResolver(func(map[string]interface{}) interface{} { return x.typeRefObject(v.Type) }),
+				"defaultValue":      defaultValue,
+				"isDeprecated":      v.Deprecated,
+				"deprecationReason": nullable(v.DeprecationReason),
+			})
+		}
+		return list
+	}
+}
+
+// coerceVariables checks given variables against their definitions and applies defaults
+func (x *gqlExecutor) coerceVariables(op *gqlOperation, given map[string]interface{}) []*gqlError {
+	var errs []*gqlError
+	x.variables = map[string]interface{}{}
+	for _, def := range op.Variables {
+		value, ok := given[def.Name]
+		if !ok {
+			if def.Default != nil {
+				x.variables[def.Name] = x.literal(def.Default)
+			} else if def.Type.NonNull {
+				errs = append(errs, gqlErrorf(def.Pos, "Variable \"$%s\" of required type %q was not provided.", def.Name, def.Type.String()))
+			}
+			continue
+		}
+		if msg := x.checkInput(def.Type, value); msg != "" {
+			errs = append(errs, gqlErrorf(def.Pos, "Variable \"$%s\" got invalid value %s; %s", def.Name, toString(value), msg))
+			continue
+		}
+		x.variables[def.Name] = value
+	}
+	return errs
+}
+
+// checkInput checks a JSON value against the input type. It returns the reason if it is invalid
+func (x *gqlExecutor) checkInput(t *gqlTypeRef, value interface{}) string {
+	if t.NonNull {
+		if value == nil {
+			return fmt.Sprintf("Expected non-nullable type %q not to be null.", t.String())
+		}
+		return x.checkInput(t.OfType, value)
+	}
+	if value == nil {
+		return ""
+	}
+	if t.List {
+		items, ok := value.([]interface{})
+		if !ok {
+			return x.checkInput(t.OfType, value)
+		}
+		for _, item := range items {
+			if msg := x.checkInput(t.OfType, item); msg != "" {
+				return msg
+			}
+		}
+		return ""
+	}
+	def := x.schema.Types[t.Name]
+	invalid := fmt.Sprintf("Expected type %q.", t.Name)
+	switch def.Kind {
+	case gqlScalarKind:
+		n, isNumber := value.(float64)
+		_, isString := value.(string)
+		_, isBool := value.(bool)
+		switch t.Name {
+		case "Int":
+			if !isNumber || n != math.Trunc(n) || n > math.MaxInt32 || n < math.MinInt32 {
+				return invalid
+			}
+		case "Float":
+			if !isNumber {
+				return invalid
+			}
+		case "String":
+			if !isString {
+				return invalid
+			}
+		case "Boolean":
+			if !isBool {
+				return invalid
+			}
+		case "ID":
+			if !isString && !(isNumber && n == math.Trunc(n)) {
+				return invalid
+			}
+		}
+	case gqlEnumKind:
+		name, _ := value.(string)
+		for _, v := range def.EnumValues {
+			if v.Name == name {
+				return ""
+			}
+		}
+		return fmt.Sprintf("Value %s does not exist in %q enum.", toString(value), t.Name)
+	case gqlInputObjectKind:
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return invalid
+		}
+		for key := range obj {
+			found := false
+			for _, f := range def.InputFields {
+				found = found || f.Name == key
+			}
+			if !found {
+				return fmt.Sprintf("Field %q is not defined by type %q.", key, t.Name)
+			}
+		}
+		for _, f := range def.InputFields {
+			v, ok := obj[f.Name]
+			if !ok {
+				if f.Type.NonNull && f.Default == nil {
+					return fmt.Sprintf("Field %q of required type %q was not provided.", f.Name, f.Type.String())
+				}
+				continue
+			}
+			if msg := x.checkInput(f.Type, v); msg != "" {
+				return msg
+			}
+		}
+	}
+	return ""
+}
+
+// graphQLRequest is a GraphQL request over HTTP
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// graphQLDummy is the parsed GraphQL config of a dummy
+type graphQLDummy struct {
+	raw        string
+	project    bson.ObjectId
+	schema     *gqlSchema
+	values     map[string]interface{}
+	operations map[string]interface{}
+}
+
+// parsed schemas are cached per dummy like specs of projects
+var graphQLCache = struct {
+	sync.Mutex
+	dummies map[bson.ObjectId]*graphQLDummy
+}{dummies: map[bson.ObjectId]*graphQLDummy{}}
+
+// dummyGraphQL returns the parsed GraphQL config of the dummy
+func dummyGraphQL(dummyOne *dummyModel) (*graphQLDummy, error) {
+	graphQLCache.Lock()
+	cached := graphQLCache.dummies[dummyOne.ID]
+	graphQLCache.Unlock()
+	if cached != nil && cached.raw == dummyOne.GraphQL {
+		return cached, nil
+	}
+
+	var m graphQLModel
+	if err := json.Unmarshal([]byte(dummyOne.GraphQL), &m); err != nil {
+		return nil, err
+	}
+	schema, err := buildGQLSchema(m.Schema)
+	if err != nil {
+		return nil, err
+	}
+	parsed := &graphQLDummy{raw: dummyOne.GraphQL, project: dummyOne.Project, schema: schema, values: m.Values, operations: m.Operations}
+	graphQLCache.Lock()
+	graphQLCache.dummies[dummyOne.ID] = parsed
+	graphQLCache.Unlock()
+	return parsed, nil
+}
+
+// evictProjectGraphQL drops the parsed configs of the dummies in the project from the cache
+func evictProjectGraphQL(project bson.ObjectId) {
+	graphQLCache.Lock()
+	for id, cached := range graphQLCache.dummies {
+		if cached.project == project {
+			delete(graphQLCache.dummies, id)
+		}
+	}
+	graphQLCache.Unlock()
+}
+
+// writeGraphQL writes a GraphQL response
+func writeGraphQL(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeGraphQLErrors(w http.ResponseWriter, status int, errs ...*gqlError) {
+	writeGraphQL(w, status, map[string]interface{}{"errors": errs})
+}
+
+// readGraphQLRequest reads the query from parameters of GET, or a JSON or application/graphql body of POST
+func readGraphQLRequest(r *http.Request) (*graphQLRequest, error) {
+	req := &graphQLRequest{}
+	if r.Method == http.MethodGet {
+		q := r.URL.Query()
+		req.Query, req.OperationName = q.Get("query"), q.Get("operationName")
+		if vars := q.Get("variables"); vars != "" {
+			if err := json.Unmarshal([]byte(vars), &req.Variables); err != nil {
+				return nil, errors.New("variables should be a JSON object")
+			}
+		}
+	} else {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "application/graphql") && !strings.Contains(r.Header.Get("Content-Type"), "json") {
+			req.Query = string(body)
+		} else if err := json.Unmarshal(body, req); err != nil {
+			return nil, errors.New("body should be a JSON object with query")
+		}
+	}
+	if strings.TrimSpace(req.Query) == "" {
+		return nil, errors.New("query is empty")
+	}
+	return req, nil
+}
+
+// serveGraphQL answers the GraphQL request with the dummy
+func serveGraphQL(w http.ResponseWriter, r *http.Request, dummyOne *dummyModel) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		writeGraphQLErrors(w, http.StatusMethodNotAllowed, &gqlError{Message: "GraphQL is served on GET and POST"})
+		return
+	}
+	gql, err := dummyGraphQL(dummyOne)
+	if err != nil {
+		requestLog(r).Errorf("fail to parse the GraphQL schema of dummy %s: %s", dummyOne.ID.Hex(), err.Error())
+		writeGraphQLErrors(w, http.StatusInternalServerError, &gqlError{Message: "invalid schema: " + err.Error()})
+		return
+	}
+	if err := setDummyHeaders(w, dummyOne); err != nil {
+		requestLog(r).Errorf("JSON marshal error: %s", err.Error())
+	}
+
+	req, err := readGraphQLRequest(r)
+	if err != nil {
+		writeGraphQLErrors(w, http.StatusBadRequest, &gqlError{Message: err.Error()})
+		return
+	}
+	doc, err := parseGQLDocument(req.Query)
+	if err != nil {
+		gqlErr, ok := err.(*gqlError)
+		if !ok {
+			gqlErr = &gqlError{Message: err.Error()}
+		}
+		writeGraphQLErrors(w, http.StatusBadRequest, gqlErr)
+		return
+	}
+
+	var op *gqlOperation
+	for _, candidate := range doc.Operations {
+		if req.OperationName == "" && len(doc.Operations) == 1 || candidate.Name == req.OperationName {
+			op = candidate
+		}
+	}
+	if op == nil {
+		msg := "Must provide operation name if query contains multiple operations."
+		if req.OperationName != "" {
+			msg = fmt.Sprintf("Unknown operation named %q.", req.OperationName)
+		}
+		writeGraphQLErrors(w, http.StatusBadRequest, &gqlError{Message: msg})
+		return
+	}
+	if r.Method == http.MethodGet && op.Type != "query" {
+		w.Header().Set("Allow", "POST")
+		writeGraphQLErrors(w, http.StatusMethodNotAllowed, &gqlError{Message: "Can only perform a " + op.Type + " operation from a POST request."})
+		return
+	}
+	if errs := validateGQLOperation(gql.schema, doc, op); len(errs) > 0 {
+		writeGraphQLErrors(w, http.StatusBadRequest, errs...)
+		return
+	}
+	if op.Type == "subscription" {
+		writeGraphQLErrors(w, http.StatusBadRequest, gqlErrorf(op.Pos, "Subscriptions are not supported."))
+		return
+	}
+
+	x := &gqlExecutor{schema: gql.schema, doc: doc, values: gql.values}
+	if errs := x.coerceVariables(op, req.Variables); len(errs) > 0 {
+		writeGraphQLErrors(w, http.StatusBadRequest, errs...)
+		return
+	}
+	countDummyHit(dummyOne)
+
+	var root map[string]interface{}
+	if op.Name != "" {
+		if canned, ok := gql.operations[op.Name].(map[string]interface{}); ok {
+			_, hasData := canned["data"]
+			_, hasErrors := canned["errors"]
+			if hasData || hasErrors {
+				// a whole response is served as it is
+				writeGraphQL(w, http.StatusOK, canned)
+				return
+			}
+			root = canned
+		}
+	}
+	data := x.executeObject(gql.schema.rootType(op.Type), root, -1, op.Selections)
+	if x.err != nil {
+		writeGraphQL(w, http.StatusOK, map[string]interface{}{"data": nil, "errors": []*gqlError{x.err}})
+		return
+	}
+	writeGraphQL(w, http.StatusOK, map[string]interface{}{"data": data})
+}
@@ -0,0 +1,1292 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// GraphQL documents are parsed by hand since no GraphQL package is vendored.
+// The lexer and the parser follow the October 2021 spec. Both SDL and executable documents are handled
+
+// gqlPos is a location in a document. It is reported in errors
+type gqlPos struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// gqlError is an error with the location in the document
+type gqlError struct {
+	Message   string   `json:"message"`
+	Locations []gqlPos `json:"locations,omitempty"`
+}
+
+func (e *gqlError) Error() string {
+	if len(e.Locations) == 0 {
+		return e.Message
+	}
+	return fmt.Sprintf("%s (line %d, column %d)", e.Message, e.Locations[0].Line, e.Locations[0].Column)
+}
+
+func gqlErrorf(pos gqlPos, format string, args ...interface{}) *gqlError {
+	return &gqlError{Message: fmt.Sprintf(format, args...), Locations: []gqlPos{pos}}
+}
+
+// token kinds
+const (
+	gqlEOF = iota
+	gqlPunct
+	gqlName
+	gqlInt
+	gqlFloat
+	gqlString
+	gqlBlockString
+)
+
+type gqlToken struct {
+	kind  int
+	value string
+	pos   gqlPos
+}
+
+// gqlLexer splits a document into tokens. Commas and comments are ignored
+type gqlLexer struct {
+	src       string
+	i         int
+	line      int
+	lineStart int
+}
+
+func (l *gqlLexer) pos() gqlPos {
+	return gqlPos{l.line, l.i - l.lineStart + 1}
+}
+
+func (l *gqlLexer) newline() {
+	l.line++
+	l.lineStart = l.i
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isNameContinue(c byte) bool {
+	return isNameStart(c) || c >= '0' && c <= '9'
+}
+
+func (l *gqlLexer) next() (gqlToken, error) {
+	// skip ignored tokens
+	for l.i < len(l.src) {
+		c := l.src[l.i]
+		switch {
+		case c == '\n':
+			l.i++
+			l.newline()
+		case c == '\r':
+			l.i++
+			if l.i < len(l.src) && l.src[l.i] == '\n' {
+				l.i++
+			}
+			l.newline()
+		case c == ' ' || c == '\t' || c == ',':
+			l.i++
+		case c == '#':
+			for l.i < len(l.src) && l.src[l.i] != '\n' && l.src[l.i] != '\r' {
+				l.i++
+			}
+		case strings.HasPrefix(l.src[l.i:], "\ufeff"):
+			l.i += len("\ufeff")
+		default:
+			goto token
+		}
+	}
+	return gqlToken{kind: gqlEOF, pos: l.pos()}, nil
+
+token:
+	pos := l.pos()
+	c := l.src[l.i]
+	switch {
+	case strings.HasPrefix(l.src[l.i:], "..."):
+		l.i += 3
+		return gqlToken{gqlPunct, "...", pos}, nil
+	case strings.IndexByte("!$&()=:@[]{}|", c) >= 0:
+		l.i++
+		return gqlToken{gqlPunct, string(c), pos}, nil
+	case isNameStart(c):
+		start := l.i
+		for l.i < len(l.src) && isNameContinue(l.src[l.i]) {
+			l.i++
+		}
+		return gqlToken{gqlName, l.src[start:l.i], pos}, nil
+	case c == '-' || c >= '0' && c <= '9':
+		return l.number(pos)
+	case strings.HasPrefix(l.src[l.i:], `"""`):
+		return l.blockString(pos)
+	case c == '"':
+		return l.string(pos)
+	}
+	r, _ := utf8.DecodeRuneInString(l.src[l.i:])
+	return gqlToken{}, gqlErrorf(pos, "Syntax Error: Unexpected character %q", r)
+}
+
+func (l *gqlLexer) number(pos gqlPos) (gqlToken, error) {
+	start := l.i
+	digits := func() int {
+		n := 0
+		for l.i < len(l.src) && l.src[l.i] >= '0' && l.src[l.i] <= '9' {
+			l.i++
+			n++
+		}
+		return n
+	}
+	if l.src[l.i] == '-' {
+		l.i++
+	}
+	intStart := l.i
+	if digits() == 0 {
+		return gqlToken{}, gqlErrorf(pos, "Syntax Error: Invalid number")
+	}
+	if l.i-intStart > 1 && l.src[intStart] == '0' {
+		return gqlToken{}, gqlErrorf(pos, "Syntax Error: Invalid number, unexpected digit after 0")
+	}
+	kind := gqlInt
+	if l.i < len(l.src) && l.src[l.i] == '.' {
+		l.i++
+		kind = gqlFloat
+		if digits() == 0 {
+			return gqlToken{}, gqlErrorf(pos, "Syntax Error: Invalid number")
+		}
+	}
+	if l.i < len(l.src) && (l.src[l.i] == 'e' || l.src[l.i] == 'E') {
+		l.i++
+		kind = gqlFloat
+		if l.i < len(l.src) && (l.src[l.i] == '+' || l.src[l.i] == '-') {
+			l.i++
+		}
+		if digits() == 0 {
+			return gqlToken{}, gqlErrorf(pos, "Syntax Error: Invalid number")
+		}
+	}
+	if l.i < len(l.src) && (isNameStart(l.src[l.i]) || l.src[l.i] == '.') {
+		return gqlToken{}, gqlErrorf(pos, "Syntax Error: Invalid number")
+	}
+	return gqlToken{kind, l.src[start:l.i], pos}, nil
+}
+
+func (l *gqlLexer) string(pos gqlPos) (gqlToken, error) {
+	l.i++
+	var b strings.Builder
+	for l.i < len(l.src) {
+		c := l.src[l.i]
+		switch {
+		case c == '"':
+			l.i++
+			return gqlToken{gqlString, b.String(), pos}, nil
+		case c == '\n' || c == '\r':
+			return gqlToken{}, gqlErrorf(pos, "Syntax Error: Unterminated string")
+		case c == '\\':
+			if l.i+1 >= len(l.src) {
+				return gqlToken{}, gqlErrorf(pos, "Syntax Error: Unterminated string")
+			}
+			esc := l.src[l.i+1]
+			l.i += 2
+			switch esc {
+			case '"', '\\', '/':
+				b.WriteByte(esc)
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'u':
+				if l.i+4 > len(l.src) {
+					return gqlToken{}, gqlErrorf(pos, "Syntax Error: Invalid unicode escape")
+				}
+				code, err := strconv.ParseUint(l.src[l.i:l.i+4], 16, 32)
+				if err != nil {
+					return gqlToken{}, gqlErrorf(pos, "Syntax Error: Invalid unicode escape")
+				}
+				b.WriteRune(rune(code))
+				l.i += 4
+			default:
+				return gqlToken{}, gqlErrorf(pos, "Syntax Error: Invalid escape sequence \\%c", esc)
+			}
+		default:
+			b.WriteByte(c)
+			l.i++
+		}
+	}
+	return gqlToken{}, gqlErrorf(pos, "Syntax Error: Unterminated string")
+}
+
+func (l *gqlLexer) blockString(pos gqlPos) (gqlToken, error) {
+	l.i += 3
+	var b strings.Builder
+	for l.i < len(l.src) {
+		switch {
+		case strings.HasPrefix(l.src[l.i:], `"""`):
+			l.i += 3
+			return gqlToken{gqlBlockString, blockStringValue(b.String()), pos}, nil
+		case strings.HasPrefix(l.src[l.i:], `\"""`):
+			b.WriteString(`"""`)
+			l.i += 4
+		default:
+			c := l.src[l.i]
+			b.WriteByte(c)
+			l.i++
+			if c == '\n' || c == '\r' && (l.i >= len(l.src) || l.src[l.i] != '\n') {
+				l.newline()
+			}
+		}
+	}
+	return gqlToken{}, gqlErrorf(pos, "Syntax Error: Unterminated string")
+}
+
+// blockStringValue removes the common indentation and blank leading and trailing lines
+func blockStringValue(raw string) string {
+	lines := strings.Split(strings.Replace(strings.Replace(raw, "\r\n", "\n", -1), "\r", "\n", -1), "\n")
+	common := -1
+	for _, line := range lines[1:] {
+		indent := len(line) - len(strings.TrimLeft(line, " \t"))
+		if indent < len(line) && (common < 0 || indent < common) {
+			common = indent
+		}
+	}
+	if common > 0 {
+		for i := 1; i < len(lines); i++ {
+			if len(lines[i]) >= common {
+				lines[i] = lines[i][common:]
+			} else {
+				lines[i] = ""
+			}
+		}
+	}
+	for len(lines) > 0 && strings.TrimLeft(lines[0], " \t") == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimLeft(lines[len(lines)-1], " \t") == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// value kinds
+const (
+	gqlVariableValue = iota
+	gqlIntValue
+	gqlFloatValue
+	gqlStringValue
+	gqlBooleanValue
+	gqlNullValue
+	gqlEnumValueKind
+	gqlListValue
+	gqlObjectValue
+)
+
+// gqlValue is a literal or a variable in a document
+type gqlValue struct {
+	Kind   int
+	Raw    string // scalar value, enum name or variable name
+	List   []*gqlValue
+	Fields []*gqlArgument // fields of an object value
+	Pos    gqlPos
+}
+
+// String prints the value as GraphQL. It is used for default values in introspection
+func (v *gqlValue) String() string {
+	switch v.Kind {
+	case gqlVariableValue:
+		return "$" + v.Raw
+	case gqlStringValue:
+		return strconv.Quote(v.Raw)
+	case gqlNullValue:
+		return "null"
+	case gqlListValue:
+		items := make([]string, len(v.List))
+		for i, item := range v.List {
+			items[i] = item.String()
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case gqlObjectValue:
+		fields := make([]string, len(v.Fields))
+		for i, field := range v.Fields {
+			fields[i] = field.Name + ": " + field.Value.String()
+		}
+		return "{" + strings.Join(fields, ", ") + "}"
+	}
+	return v.Raw
+}
+
+// gqlTypeRef is a type in a field or a variable definition. e.g. [String!]!
+type gqlTypeRef struct {
+	Name    string      // named type. empty for lists and non-null
+	List    bool        // list of OfType
+	NonNull bool        // non-null of OfType
+	OfType  *gqlTypeRef // wrapped type
+}
+
+func (t *gqlTypeRef) String() string {
+	switch {
+	case t.NonNull:
+		return t.OfType.String() + "!"
+	case t.List:
+		return "[" + t.OfType.String() + "]"
+	}
+	return t.Name
+}
+
+// namedType unwraps lists and non-null
+func (t *gqlTypeRef) namedType() string {
+	for t.OfType != nil {
+		t = t.OfType
+	}
+	return t.Name
+}
+
+// gqlArgument is an argument of a field or a directive, or a field of an object value
+type gqlArgument struct {
+	Name  string
+	Value *gqlValue
+	Pos   gqlPos
+}
+
+type gqlDirective struct {
+	Name      string
+	Arguments []*gqlArgument
+	Pos       gqlPos
+}
+
+// selection kinds
+const (
+	gqlFieldSelection = iota
+	gqlFragmentSpread
+	gqlInlineFragment
+)
+
+// gqlSelection is a field, a fragment spread or an inline fragment
+type gqlSelection struct {
+	Kind          int
+	Alias         string // response key of a field if set
+	Name          string // field name or fragment name
+	TypeCondition string // of an inline fragment
+	Arguments     []*gqlArgument
+	Directives    []*gqlDirective
+	Selections    []*gqlSelection
+	Pos           gqlPos
+}
+
+// responseKey is the alias or the name of a field
+func (s *gqlSelection) responseKey() string {
+	if s.Alias != "" {
+		return s.Alias
+	}
+	return s.Name
+}
+
+type gqlVariableDef struct {
+	Name    string
+	Type    *gqlTypeRef
+	Default *gqlValue
+	Pos     gqlPos
+}
+
+type gqlOperation struct {
+	Type       string // query, mutation or subscription
+	Name       string
+	Variables  []*gqlVariableDef
+	Directives []*gqlDirective
+	Selections []*gqlSelection
+	Pos        gqlPos
+}
+
+type gqlFragment struct {
+	Name          string
+	TypeCondition string
+	Directives    []*gqlDirective
+	Selections    []*gqlSelection
+	Pos           gqlPos
+}
+
+// gqlDocument is an executable document
+type gqlDocument struct {
+	Operations []*gqlOperation
+	Fragments  map[string]*gqlFragment
+}
+
+// type kinds of introspection
+const (
+	gqlScalarKind      = "SCALAR"
+	gqlObjectKind      = "OBJECT"
+	gqlInterfaceKind   = "INTERFACE"
+	gqlUnionKind       = "UNION"
+	gqlEnumKind        = "ENUM"
+	gqlInputObjectKind = "INPUT_OBJECT"
+)
+
+// gqlTypeDef is a named type in a schema
+type gqlTypeDef struct {
+	Kind           string
+	Name           string
+	Description    string
+	Fields         []*gqlFieldDef   // of objects and interfaces
+	Interfaces     []string         // implemented by objects and interfaces
+	PossibleTypes  []string         // members of unions, or objects implementing interfaces
+	EnumValues     []*gqlEnumValue  // of enums
+	InputFields    []*gqlInputValue // of input objects
+	SpecifiedByURL string           // of scalars
+	Pos            gqlPos
+}
+
+func (t *gqlTypeDef) field(name string) *gqlFieldDef {
+	for _, f := range t.Fields {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+type gqlFieldDef struct {
+	Name              string
+	Description       string
+	Args              []*gqlInputValue
+	Type              *gqlTypeRef
+	Deprecated        bool
+	DeprecationReason string
+}
+
+type gqlInputValue struct {
+	Name              string
+	Description       string
+	Type              *gqlTypeRef
+	Default           *gqlValue
+	Deprecated        bool
+	DeprecationReason string
+}
+
+type gqlEnumValue struct {
+	Name              string
+	Description       string
+	Deprecated        bool
+	DeprecationReason string
+}
+
+type gqlDirectiveDef struct {
+	Name        string
+	Description string
+	Args        []*gqlInputValue
+	Locations   []string
+	Repeatable  bool
+}
+
+// gqlSchema is a type system document
+type gqlSchema struct {
+	Types        map[string]*gqlTypeDef
+	TypeOrder    []string // names in definition order
+	Directives   map[string]*gqlDirectiveDef
+	DirOrder     []string
+	Query        string
+	Mutation     string
+	Subscription string
+	Description  string
+}
+
+// maxGQLDepth limits nesting of selection sets, values and types in a document,
+// so that deep documents do not overflow the stack
+const maxGQLDepth = 64
+
+// gqlParser is a recursive descent parser over the lexer
+type gqlParser struct {
+	lexer *gqlLexer
+	tok   gqlToken
+	depth int
+}
+
+func newGQLParser(src string) (*gqlParser, error) {
+	p := &gqlParser{lexer: &gqlLexer{src: src, line: 1}}
+	return p, p.advance()
+}
+
+func (p *gqlParser) advance() error {
+	tok, err := p.lexer.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+// enter goes into a nested selection set, value or type. leave it when it ends
+func (p *gqlParser) enter() error {
+	if p.depth++; p.depth > maxGQLDepth {
+		return gqlErrorf(p.tok.pos, "Syntax Error: Nested deeper than %d levels.", maxGQLDepth)
+	}
+	return nil
+}
+
+func (p *gqlParser) leave() {
+	p.depth--
+}
+
+func (p *gqlParser) unexpected() error {
+	if p.tok.kind == gqlEOF {
+		return gqlErrorf(p.tok.pos, "Syntax Error: Unexpected <EOF>")
+	}
+	return gqlErrorf(p.tok.pos, "Syntax Error: Unexpected %q", p.tok.value)
+}
+
+func (p *gqlParser) peek(punct string) bool {
+	return p.tok.kind == gqlPunct && p.tok.value == punct
+}
+
+func (p *gqlParser) peekKeyword(name string) bool {
+	return p.tok.kind == gqlName && p.tok.value == name
+}
+
+// skip consumes the punctuator if it is next
+func (p *gqlParser) skip(punct string) (bool, error) {
+	if !p.peek(punct) {
+		return false, nil
+	}
+	return true, p.advance()
+}
+
+func (p *gqlParser) expect(punct string) error {
+	if !p.peek(punct) {
+		if p.tok.kind == gqlEOF {
+			return gqlErrorf(p.tok.pos, "Syntax Error: Expected %q, found <EOF>", punct)
+		}
+		return gqlErrorf(p.tok.pos, "Syntax Error: Expected %q, found %q", punct, p.tok.value)
+	}
+	return p.advance()
+}
+
+func (p *gqlParser) expectKeyword(name string) error {
+	if !p.peekKeyword(name) {
+		return p.unexpected()
+	}
+	return p.advance()
+}
+
+func (p *gqlParser) name() (string, error) {
+	if p.tok.kind != gqlName {
+		return "", p.unexpected()
+	}
+	name := p.tok.value
+	return name, p.advance()
+}
+
+// description reads an optional description string
+func (p *gqlParser) description() (string, error) {
+	if p.tok.kind != gqlString && p.tok.kind != gqlBlockString {
+		return "", nil
+	}
+	desc := p.tok.value
+	return desc, p.advance()
+}
+
+func (p *gqlParser) typeRef() (*gqlTypeRef, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+	var t *gqlTypeRef
+	if ok, err := p.skip("["); err != nil {
+		return nil, err
+	} else if ok {
+		inner, err := p.typeRef()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+		t = &gqlTypeRef{List: true, OfType: inner}
+	} else {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		t = &gqlTypeRef{Name: name}
+	}
+	if ok, err := p.skip("!"); err != nil {
+		return nil, err
+	} else if ok {
+		t = &gqlTypeRef{NonNull: true, OfType: t}
+	}
+	return t, nil
+}
+
+func (p *gqlParser) value(constant bool) (*gqlValue, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+	tok := p.tok
+	v := &gqlValue{Raw: tok.value, Pos: tok.pos}
+	switch tok.kind {
+	case gqlPunct:
+		switch tok.value {
+		case "$":
+			if constant {
+				return nil, p.unexpected()
+			}
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			v.Kind, v.Raw = gqlVariableValue, name
+			return v, nil
+		case "[":
+			v.Kind, v.Raw = gqlListValue, ""
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			for !p.peek("]") {
+				item, err := p.value(constant)
+				if err != nil {
+					return nil, err
+				}
+				v.List = append(v.List, item)
+			}
+			return v, p.advance()
+		case "{":
+			v.Kind, v.Raw = gqlObjectValue, ""
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			for !p.peek("}") {
+				field := &gqlArgument{Pos: p.tok.pos}
+				var err error
+				if field.Name, err = p.name(); err != nil {
+					return nil, err
+				}
+				if err := p.expect(":"); err != nil {
+					return nil, err
+				}
+				if field.Value, err = p.value(constant); err != nil {
+					return nil, err
+				}
+				v.Fields = append(v.Fields, field)
+			}
+			return v, p.advance()
+		}
+		return nil, p.unexpected()
+	case gqlInt:
+		v.Kind = gqlIntValue
+	case gqlFloat:
+		v.Kind = gqlFloatValue
+	case gqlString, gqlBlockString:
+		v.Kind = gqlStringValue
+	case gqlName:
+		switch tok.value {
+		case "true", "false":
+			v.Kind = gqlBooleanValue
+		case "null":
+			v.Kind = gqlNullValue
+		default:
+			v.Kind = gqlEnumValueKind
+		}
+	default:
+		return nil, p.unexpected()
+	}
+	return v, p.advance()
+}
+
+func (p *gqlParser) arguments(constant bool) ([]*gqlArgument, error) {
+	if ok, err := p.skip("("); err != nil || !ok {
+		return nil, err
+	}
+	var args []*gqlArgument
+	for !p.peek(")") {
+		arg := &gqlArgument{Pos: p.tok.pos}
+		var err error
+		if arg.Name, err = p.name(); err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		if arg.Value, err = p.value(constant); err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	if len(args) == 0 {
+		return nil, p.unexpected()
+	}
+	return args, p.advance()
+}
+
+func (p *gqlParser) directives(constant bool) ([]*gqlDirective, error) {
+	var dirs []*gqlDirective
+	for p.peek("@") {
+		d := &gqlDirective{Pos: p.tok.pos}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		var err error
+		if d.Name, err = p.name(); err != nil {
+			return nil, err
+		}
+		if d.Arguments, err = p.arguments(constant); err != nil {
+			return nil, err
+		}
+		dirs = append(dirs, d)
+	}
+	return dirs, nil
+}
+
+// parseGQLDocument parses an executable document
+func parseGQLDocument(src string) (*gqlDocument, error) {
+	p, err := newGQLParser(src)
+	if err != nil {
+		return nil, err
+	}
+	doc := &gqlDocument{Fragments: map[string]*gqlFragment{}}
+	for p.tok.kind != gqlEOF {
+		switch {
+		case p.peek("{"):
+			op := &gqlOperation{Type: "query", Pos: p.tok.pos}
+			if op.Selections, err = p.selectionSet(); err != nil {
+				return nil, err
+			}
+			doc.Operations = append(doc.Operations, op)
+		case p.peekKeyword("query") || p.peekKeyword("mutation") || p.peekKeyword("subscription"):
+			op, err := p.operation()
+			if err != nil {
+				return nil, err
+			}
+			doc.Operations = append(doc.Operations, op)
+		case p.peekKeyword("fragment"):
+			f, err := p.fragment()
+			if err != nil {
+				return nil, err
+			}
+			if doc.Fragments[f.Name] != nil {
+				return nil, gqlErrorf(f.Pos, "There can be only one fragment named %q.", f.Name)
+			}
+			doc.Fragments[f.Name] = f
+		default:
+			return nil, p.unexpected()
+		}
+	}
+	if len(doc.Operations) == 0 {
+		return nil, &gqlError{Message: "Syntax Error: the document has no operation"}
+	}
+	return doc, nil
+}
+
+func (p *gqlParser) operation() (*gqlOperation, error) {
+	op := &gqlOperation{Type: p.tok.value, Pos: p.tok.pos}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	var err error
+	if p.tok.kind == gqlName {
+		if op.Name, err = p.name(); err != nil {
+			return nil, err
+		}
+	}
+	if ok, err := p.skip("("); err != nil {
+		return nil, err
+	} else if ok {
+		for !p.peek(")") {
+			v := &gqlVariableDef{Pos: p.tok.pos}
+			if err := p.expect("$"); err != nil {
+				return nil, err
+			}
+			if v.Name, err = p.name(); err != nil {
+				return nil, err
+			}
+			if err := p.expect(":"); err != nil {
+				return nil, err
+			}
+			if v.Type, err = p.typeRef(); err != nil {
+				return nil, err
+			}
+			if ok, err := p.skip("="); err != nil {
+				return nil, err
+			} else if ok {
+				if v.Default, err = p.value(true); err != nil {
+					return nil, err
+				}
+			}
+			if _, err := p.directives(true); err != nil {
+				return nil, err
+			}
+			op.Variables = append(op.Variables, v)
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+	if op.Directives, err = p.directives(false); err != nil {
+		return nil, err
+	}
+	if op.Selections, err = p.selectionSet(); err != nil {
+		return nil, err
+	}
+	return op, nil
+}
+
+func (p *gqlParser) fragment() (*gqlFragment, error) {
+	f := &gqlFragment{Pos: p.tok.pos}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	var err error
+	if f.Name, err = p.name(); err != nil {
+		return nil, err
+	}
+	if f.Name == "on" {
+		return nil, gqlErrorf(f.Pos, "Syntax Error: Unexpected \"on\"")
+	}
+	if err := p.expectKeyword("on"); err != nil {
+		return nil, err
+	}
+	if f.TypeCondition, err = p.name(); err != nil {
+		return nil, err
+	}
+	if f.Directives, err = p.directives(false); err != nil {
+		return nil, err
+	}
+	if f.Selections, err = p.selectionSet(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (p *gqlParser) selectionSet() ([]*gqlSelection, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var selections []*gqlSelection
+	for !p.peek("}") {
+		s, err := p.selection()
+		if err != nil {
+			return nil, err
+		}
+		selections = append(selections, s)
+	}
+	if len(selections) == 0 {
+		return nil, p.unexpected()
+	}
+	return selections, p.advance()
+}
+
+func (p *gqlParser) selection() (*gqlSelection, error) {
+	s := &gqlSelection{Pos: p.tok.pos}
+	var err error
+	if ok, err := p.skip("..."); err != nil {
+		return nil, err
+	} else if ok {
+		if p.tok.kind == gqlName && p.tok.value != "on" {
+			s.Kind = gqlFragmentSpread
+			if s.Name, err = p.name(); err != nil {
+				return nil, err
+			}
+			s.Directives, err = p.directives(false)
+			return s, err
+		}
+		s.Kind = gqlInlineFragment
+		if p.peekKeyword("on") {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			if s.TypeCondition, err = p.name(); err != nil {
+				return nil, err
+			}
+		}
+		if s.Directives, err = p.directives(false); err != nil {
+			return nil, err
+		}
+		s.Selections, err = p.selectionSet()
+		return s, err
+	}
+
+	s.Kind = gqlFieldSelection
+	if s.Name, err = p.name(); err != nil {
+		return nil, err
+	}
+	if ok, err := p.skip(":"); err != nil {
+		return nil, err
+	} else if ok {
+		s.Alias = s.Name
+		if s.Name, err = p.name(); err != nil {
+			return nil, err
+		}
+	}
+	if s.Arguments, err = p.arguments(false); err != nil {
+		return nil, err
+	}
+	if s.Directives, err = p.directives(false); err != nil {
+		return nil, err
+	}
+	if p.peek("{") {
+		if s.Selections, err = p.selectionSet(); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// parseGQLSchema parses SDL into the schema. The schema is not checked yet
+func parseGQLSchema(src string, schema *gqlSchema) error {
+	p, err := newGQLParser(src)
+	if err != nil {
+		return err
+	}
+	for p.tok.kind != gqlEOF {
+		desc, err := p.description()
+		if err != nil {
+			return err
+		}
+		extend := false
+		if p.peekKeyword("extend") {
+			extend = true
+			if err := p.advance(); err != nil {
+				return err
+			}
+		}
+		if p.tok.kind != gqlName {
+			return p.unexpected()
+		}
+		pos := p.tok.pos
+		switch p.tok.value {
+		case "schema":
+			err = p.schemaDefinition(schema, desc)
+		case "directive":
+			err = p.directiveDefinition(schema, desc)
+		case "scalar", "type", "interface", "union", "enum", "input":
+			var def *gqlTypeDef
+			if def, err = p.typeDefinition(desc); err != nil {
+				return err
+			}
+			def.Pos = pos
+			err = schema.addType(def, extend)
+		default:
+			return p.unexpected()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *gqlSchema) addType(def *gqlTypeDef, extend bool) error {
+	existing := s.Types[def.Name]
+	if !extend {
+		if existing != nil {
+			return gqlErrorf(def.Pos, "There can be only one type named %q.", def.Name)
+		}
+		s.Types[def.Name] = def
+		s.TypeOrder = append(s.TypeOrder, def.Name)
+		return nil
+	}
+	if existing == nil || existing.Kind != def.Kind {
+		return gqlErrorf(def.Pos, "Cannot extend type %q because it is not defined.", def.Name)
+	}
+	existing.Fields = append(existing.Fields, def.Fields...)
+	existing.Interfaces = append(existing.Interfaces, def.Interfaces...)
+	existing.PossibleTypes = append(existing.PossibleTypes, def.PossibleTypes...)
+	existing.EnumValues = append(existing.EnumValues, def.EnumValues...)
+	existing.InputFields = append(existing.InputFields, def.InputFields...)
+	return nil
+}
+
+func (p *gqlParser) schemaDefinition(schema *gqlSchema, desc string) error {
+	if err := p.advance(); err != nil {
+		return err
+	}
+	schema.Description = desc
+	if _, err := p.directives(true); err != nil {
+		return err
+	}
+	if err := p.expect("{"); err != nil {
+		return err
+	}
+	for !p.peek("}") {
+		op, err := p.name()
+		if err != nil {
+			return err
+		}
+		if err := p.expect(":"); err != nil {
+			return err
+		}
+		typeName, err := p.name()
+		if err != nil {
+			return err
+		}
+		switch op {
+		case "query":
+			schema.Query = typeName
+		case "mutation":
+			schema.Mutation = typeName
+		case "subscription":
+			schema.Subscription = typeName
+		default:
+			return gqlErrorf(p.tok.pos, "Unknown operation type %q.", op)
+		}
+	}
+	return p.advance()
+}
+
+func (p *gqlParser) directiveDefinition(schema *gqlSchema, desc string) error {
+	if err := p.advance(); err != nil {
+		return err
+	}
+	if err := p.expect("@"); err != nil {
+		return err
+	}
+	d := &gqlDirectiveDef{Description: desc}
+	var err error
+	if d.Name, err = p.name(); err != nil {
+		return err
+	}
+	if d.Args, err = p.inputValues("(", ")"); err != nil {
+		return err
+	}
+	if p.peekKeyword("repeatable") {
+		d.Repeatable = true
+		if err := p.advance(); err != nil {
+			return err
+		}
+	}
+	if err := p.expectKeyword("on"); err != nil {
+		return err
+	}
+	if _, err := p.skip("|"); err != nil {
+		return err
+	}
+	for {
+		loc, err := p.name()
+		if err != nil {
+			return err
+		}
+		d.Locations = append(d.Locations, loc)
+		if ok, err := p.skip("|"); err != nil {
+			return err
+		} else if !ok {
+			break
+		}
+	}
+	if schema.Directives[d.Name] == nil {
+		schema.DirOrder = append(schema.DirOrder, d.Name)
+	}
+	schema.Directives[d.Name] = d
+	return nil
+}
+
+// deprecation reads @deprecated from directives of a definition
+func deprecation(dirs []*gqlDirective) (bool, string) {
+	for _, d := range dirs {
+		if d.Name != "deprecated" {
+			continue
+		}
+		reason := "No longer supported"
+		for _, arg := range d.Arguments {
+			if arg.Name == "reason" && arg.Value.Kind == gqlStringValue {
+				reason = arg.Value.Raw
+			}
+		}
+		return true, reason
+	}
+	return false, ""
+}
+
+func (p *gqlParser) typeDefinition(desc string) (*gqlTypeDef, error) {
+	keyword := p.tok.value
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	def := &gqlTypeDef{Description: desc}
+	var err error
+	if def.Name, err = p.name(); err != nil {
+		return nil, err
+	}
+
+	switch keyword {
+	case "scalar":
+		def.Kind = gqlScalarKind
+		dirs, err := p.directives(true)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range dirs {
+			if d.Name == "specifiedBy" && len(d.Arguments) > 0 {
+				def.SpecifiedByURL = d.Arguments[0].Value.Raw
+			}
+		}
+		return def, nil
+	case "type", "interface":
+		def.Kind = gqlObjectKind
+		if keyword == "interface" {
+			def.Kind = gqlInterfaceKind
+		}
+		if p.peekKeyword("implements") {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			if _, err := p.skip("&"); err != nil {
+				return nil, err
+			}
+			for {
+				name, err := p.name()
+				if err != nil {
+					return nil, err
+				}
+				def.Interfaces = append(def.Interfaces, name)
+				if ok, err := p.skip("&"); err != nil {
+					return nil, err
+				} else if !ok {
+					break
+				}
+			}
+		}
+		if _, err := p.directives(true); err != nil {
+			return nil, err
+		}
+		def.Fields, err = p.fieldDefinitions()
+		return def, err
+	case "union":
+		def.Kind = gqlUnionKind
+		if _, err := p.directives(true); err != nil {
+			return nil, err
+		}
+		if ok, err := p.skip("="); err != nil || !ok {
+			return def, err
+		}
+		if _, err := p.skip("|"); err != nil {
+			return nil, err
+		}
+		for {
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			def.PossibleTypes = append(def.PossibleTypes, name)
+			if ok, err := p.skip("|"); err != nil {
+				return nil, err
+			} else if !ok {
+				break
+			}
+		}
+		return def, nil
+	case "enum":
+		def.Kind = gqlEnumKind
+		if _, err := p.directives(true); err != nil {
+			return nil, err
+		}
+		if ok, err := p.skip("{"); err != nil || !ok {
+			return def, err
+		}
+		for !p.peek("}") {
+			v := &gqlEnumValue{}
+			if v.Description, err = p.description(); err != nil {
+				return nil, err
+			}
+			if v.Name, err = p.name(); err != nil {
+				return nil, err
+			}
+			dirs, err := p.directives(true)
+			if err != nil {
+				return nil, err
+			}
+			v.Deprecated, v.DeprecationReason = deprecation(dirs)
+			def.EnumValues = append(def.EnumValues, v)
+		}
+		return def, p.advance()
+	}
+	def.Kind = gqlInputObjectKind
+	if _, err := p.directives(true); err != nil {
+		return nil, err
+	}
+	def.InputFields, err = p.inputValues("{", "}")
+	return def, err
+}
+
+func (p *gqlParser) fieldDefinitions() ([]*gqlFieldDef, error) {
+	if ok, err := p.skip("{"); err != nil || !ok {
+		return nil, err
+	}
+	var fields []*gqlFieldDef
+	for !p.peek("}") {
+		f := &gqlFieldDef{}
+		var err error
+		if f.Description, err = p.description(); err != nil {
+			return nil, err
+		}
+		if f.Name, err = p.name(); err != nil {
+			return nil, err
+		}
+		if f.Args, err = p.inputValues("(", ")"); err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		if f.Type, err = p.typeRef(); err != nil {
+			return nil, err
+		}
+		dirs, err := p.directives(true)
+		if err != nil {
+			return nil, err
+		}
+		f.Deprecated, f.DeprecationReason = deprecation(dirs)
+		fields = append(fields, f)
+	}
+	return fields, p.advance()
+}
+
+// inputValues reads arguments or input fields between the punctuators if they are there
+func (p *gqlParser) inputValues(open, close string) ([]*gqlInputValue, error) {
+	if ok, err := p.skip(open); err != nil || !ok {
+		return nil, err
+	}
+	var values []*gqlInputValue
+	for !p.peek(close) {
+		v := &gqlInputValue{}
+		var err error
+		if v.Description, err = p.description(); err != nil {
+			return nil, err
+		}
+		if v.Name, err = p.name(); err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		if v.Type, err = p.typeRef(); err != nil {
+			return nil, err
+		}
+		if ok, err := p.skip("="); err != nil {
+			return nil, err
+		} else if ok {
+			if v.Default, err = p.value(true); err != nil {
+				return nil, err
+			}
+		}
+		dirs, err := p.directives(true)
+		if err != nil {
+			return nil, err
+		}
+		v.Deprecated, v.DeprecationReason = deprecation(dirs)
+		values = append(values, v)
+	}
+	return values, p.advance()
+}
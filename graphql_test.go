@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	"github.com/globalsign/mgo/bson"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const testGraphQLSchema = `
+"""The entry point"""
+type Query {
+  user(id: ID!): User
+  users(first: Int = 2): [User!]!
+  search(term: String!): [SearchResult!]!
+}
+
+type Mutation {
+  rename(id: ID!, name: String!): User
+}
+
+interface Node { id: ID! }
+
+type User implements Node {
+  id: ID!
+  name: String
+  role: Role!
+  age: Int @deprecated(reason: "use birthday")
+  friends: [User!]!
+}
+
+type Post implements Node {
+  id: ID!
+  title: String!
+}
+
+union SearchResult = User | Post
+
+enum Role { ADMIN MEMBER }
+`
+
+var _ = Describe("GraphQL", func() {
+	var dummy dummyModel
+
+	BeforeEach(func() {
+		dummy = dummyModel{ID: bson.NewObjectId()}
+		reqModel := requestModel{GraphQL: &graphQLModel{
+			Schema: testGraphQLSchema,
+			Values: map[string]interface{}{"User.name": "Alice"},
+			Operations: map[string]interface{}{
+				"Canned": map[string]interface{}{"data": map[string]interface{}{"user": nil}},
+				"Boss":   map[string]interface{}{"user": map[string]interface{}{"name": "Carol", "role": "ADMIN"}},
+			},
+		}}
+		Expect(reqModel.validate()).To(Succeed())
+		Expect(dummy.updateWithRequestData(&reqModel)).To(Succeed())
+	})
+
+	post := func(query string, variables map[string]interface{}) (*httptest.ResponseRecorder, map[string]interface{}) {
+		body, _ := json.Marshal(graphQLRequest{Query: query, Variables: variables})
+		req, _ := http.NewRequest("POST", "/graphql", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		serveGraphQL(w, req, &dummy)
+		var res map[string]interface{}
+		Expect(json.Unmarshal(w.Body.Bytes(), &res)).To(Succeed())
+		return w, res
+	}
+
+	It("should shape generated data to the selection set", func() {
+		w, _ := post(`{ users { id name role friends { id } } }`, nil)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Header().Get("Content-Type")).To(Equal("application/json; charset=utf-8"))
+		Expect(w.Body.String()).To(MatchJSON(`{"data": {"users": [
+			{"id": "1", "name": "Alice", "role": "ADMIN", "friends": [{"id": "1"}, {"id": "2"}]},
+			{"id": "2", "name": "Alice", "role": "MEMBER", "friends": [{"id": "1"}, {"id": "2"}]}
+		]}}`))
+	})
+
+	It("should keep the order of aliases and fragments", func() {
+		w, _ := post(`
+			query { me: user(id: "1") { ...info name } search(term: "a") { __typename ... on Post { title } } }
+			fragment info on User { role id }`, nil)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(HavePrefix(`{"data":{"me":{"role":"ADMIN","id":"1","name":"Alice"},"search":[`))
+	})
+
+	It("should apply variables and directives", func() {
+		query := `query ($first: Int, $withName: Boolean!) { users(first: $first) { id name @include(if: $withName) } }`
+		w, res := post(query, map[string]interface{}{"first": 3, "withName": false})
+		Expect(w.Code).To(Equal(http.StatusOK))
+		users := res["data"].(map[string]interface{})["users"].([]interface{})
+		Expect(users[0]).To(Equal(map[string]interface{}{"id": "1"}))
+
+		w, res = post(query, map[string]interface{}{"withName": "no"})
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+		Expect(res["errors"]).To(HaveLen(1))
+	})
+
+	It("should serve canned operations", func() {
+		w, _ := post(`query Canned { user(id: "1") { id } }`, nil)
+		Expect(w.Body.String()).To(MatchJSON(`{"data": {"user": null}}`))
+
+		w, _ = post(`query Boss { user(id: "1") { name role } }`, nil)
+		Expect(w.Body.String()).To(MatchJSON(`{"data": {"user": {"name": "Carol", "role": "ADMIN"}}}`))
+	})
+
+	It("should report validation errors with locations", func() {
+		w, res := post("{\n  user(id: 1) { nope }\n}", nil)
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+		errs := res["errors"].([]interface{})
+		Expect(errs).To(HaveLen(1))
+		Expect(errs[0].(map[string]interface{})["message"]).To(ContainSubstring("nope"))
+		Expect(errs[0].(map[string]interface{})["locations"]).To(Equal([]interface{}{map[string]interface{}{"line": 2.0, "column": 17.0}}))
+
+		w, _ = post(`{ user(id: "1") }`, nil)
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+		w, _ = post(`{ user(id: "1") { id `, nil)
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("should limit nesting of documents", func() {
+		w, res := post(`{ user(id: "1") { `+strings.Repeat("friends { ", 100)+"id"+strings.Repeat(" }", 100)+" } }", nil)
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+		Expect(res["errors"].([]interface{})[0].(map[string]interface{})["message"]).To(ContainSubstring("Nested deeper than 64"))
+		w, _ = post(strings.Repeat("{ a ", 1<<20), nil)
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+		w, _ = post(`{ user(id: "1") { name(x: `+strings.Repeat("[", 100)+"1"+strings.Repeat("]", 100)+") } }", nil)
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+
+		// each fragment is shallow, but they nest through spreads
+		var fragments strings.Builder
+		for i := 0; i < 40; i++ {
+			fmt.Fprintf(&fragments, "fragment F%d on User { friends { ...F%d } }\n", i, i+1)
+		}
+		fragments.WriteString("fragment F40 on User { id }")
+		w, res = post(`{ user(id: "1") { ...F0 } }`+fragments.String(), nil)
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+		Expect(res["errors"].([]interface{})[0].(map[string]interface{})["message"]).To(ContainSubstring("nested deeper than 64"))
+	})
+
+	It("should limit generated list items", func() {
+		w, res := post(`{ users { `+strings.Repeat("friends { ", 20)+"id"+strings.Repeat(" }", 20)+" } }", nil)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(res["data"]).To(BeNil())
+		Expect(res["errors"].([]interface{})[0].(map[string]interface{})["message"]).To(ContainSubstring("list items"))
+	})
+
+	It("should take queries from GET but not mutations", func() {
+		req, _ := http.NewRequest("GET", "/graphql?query="+url.QueryEscape(`{ user(id: "1") { name } }`), nil)
+		w := httptest.NewRecorder()
+		serveGraphQL(w, req, &dummy)
+		Expect(w.Body.String()).To(MatchJSON(`{"data": {"user": {"name": "Alice"}}}`))
+
+		req, _ = http.NewRequest("GET", "/graphql?query="+url.QueryEscape(`mutation { rename(id: "1", name: "B") { id } }`), nil)
+		w = httptest.NewRecorder()
+		serveGraphQL(w, req, &dummy)
+		Expect(w.Code).To(Equal(http.StatusMethodNotAllowed))
+	})
+
+	It("should answer introspection", func() {
+		w, res := post(`
+			query IntrospectionQuery {
+			  __schema {
+			    queryType { name }
+			    mutationType { name }
+			    types { kind name fields(includeDeprecated: true) { name isDeprecated deprecationReason type { kind name ofType { kind name } } } possibleTypes { name } }
+			    directives { name locations args { name } }
+			  }
+			  __type(name: "User") { fields { name } interfaces { name } }
+			}`, nil)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		schema := res["data"].(map[string]interface{})["__schema"].(map[string]interface{})
+		Expect(schema["queryType"]).To(Equal(map[string]interface{}{"name": "Query"}))
+		Expect(schema["mutationType"]).To(Equal(map[string]interface{}{"name": "Mutation"}))
+		names := []interface{}{}
+		for _, t := range schema["types"].([]interface{}) {
+			names = append(names, t.(map[string]interface{})["name"])
+		}
+		Expect(names).To(ContainElement("SearchResult"))
+		Expect(names).To(ContainElement("__Schema"))
+
+		user := res["data"].(map[string]interface{})["__type"].(map[string]interface{})
+		Expect(user["fields"]).To(HaveLen(4), "deprecated fields are hidden by default")
+		Expect(user["interfaces"]).To(Equal([]interface{}{map[string]interface{}{"name": "Node"}}))
+	})
+
+	It("should validate schemas", func() {
+		Expect((&graphQLModel{Schema: `type Query { a: Missing }`}).validate()).NotTo(Succeed())
+		Expect((&graphQLModel{Schema: `type Mutation { a: Int }`}).validate()).NotTo(Succeed())
+		Expect((&graphQLModel{Schema: `type Query { a: Int }`, Operations: map[string]interface{}{"A": "x"}}).validate()).NotTo(Succeed())
+		Expect((&requestModel{GraphQL: &graphQLModel{Schema: `type Query { a: Int }`}, SSE: &sseModel{}}).validate()).NotTo(Succeed())
+	})
+})
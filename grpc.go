@@ -0,0 +1,689 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mgo "github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/julienschmidt/httprouter"
+)
+
+// sourceGRPC is the source of dummies added as gRPC dummies
+const sourceGRPC = "grpc"
+
+// grpcProjectHeader selects the project which answers. Otherwise the first project which has the service answers
+const grpcProjectHeader = "X-Dummy-Project"
+
+// names of status codes by number
+var grpcCodes = []string{"OK", "CANCELLED", "UNKNOWN", "INVALID_ARGUMENT", "DEADLINE_EXCEEDED", "NOT_FOUND",
+	"ALREADY_EXISTS", "PERMISSION_DENIED", "RESOURCE_EXHAUSTED", "FAILED_PRECONDITION", "ABORTED", "OUT_OF_RANGE",
+	"UNIMPLEMENTED", "INTERNAL", "UNAVAILABLE", "DATA_LOSS", "UNAUTHENTICATED"}
+
+const (
+	grpcOK                = 0
+	grpcInvalidArgument   = 3
+	grpcNotFound          = 5
+	grpcResourceExhausted = 8
+	grpcUnimplemented     = 12
+	grpcInternal          = 13
+)
+
+// server reflection services. v1alpha is still used by many clients
+const (
+	grpcReflectionV1      = "grpc.reflection.v1.ServerReflection"
+	grpcReflectionV1Alpha = "grpc.reflection.v1alpha.ServerReflection"
+)
+
+// grpcCode is a status code given as a number or its name. e.g. 5 or NOT_FOUND
+type grpcCode int
+
+func (c *grpcCode) UnmarshalJSON(b []byte) error {
+	var name string
+	if json.Unmarshal(b, &name) == nil {
+		for i, code := range grpcCodes {
+			if code == strings.ToUpper(name) {
+				*c = grpcCode(i)
+				return nil
+			}
+		}
+		return fmt.Errorf("unknown gRPC status %s", name)
+	}
+	var n int
+	if err := json.Unmarshal(b, &n); err != nil {
+		return errors.New("gRPC status should be a number or a name")
+	}
+	*c = grpcCode(n)
+	return nil
+}
+
+// grpcModel is the response of a gRPC dummy
+type grpcModel struct {
+	Response string            `bson:",omitempty"` // stringify JSON. a message, or messages of server streaming
+	Code     int               `bson:",omitempty"` // grpc-status. 0 is OK
+	Message  string            `bson:",omitempty"` // grpc-message of errors
+	Trailers map[string]string `bson:",omitempty"`
+}
+
+// grpcDummyRequestModel is a dummy in the body of POST /projects/:id/grpc/dummies
+type grpcDummyRequestModel struct {
+	Method   string            `json:"method"`   // e.g. helloworld.Greeter/SayHello
+	Matchers []matcherModel    `json:"matchers"` // the request message is matched as a JSON body
+	Delay    int               `json:"delay"`    // milliseconds to wait before responding
+	Headers  map[string]string `json:"headers"`  // response metadata
+	Response json.RawMessage   `json:"response"` // message in JSON. an array of messages for server streaming
+	Code     grpcCode          `json:"code"`     // status number or name. e.g. 5 or NOT_FOUND
+	Message  string            `json:"message"`
+	Trailers map[string]string `json:"trailers"`
+}
+
+// grpcMethodPath converts a method to the path of its requests. helloworld.Greeter.SayHello is also accepted
+func grpcMethodPath(method string) string {
+	method = strings.TrimPrefix(method, "/")
+	if !strings.Contains(method, "/") {
+		if i := strings.LastIndex(method, "."); i > 0 {
+			method = method[:i] + "/" + method[i+1:]
+		}
+	}
+	return "/" + method
+}
+
+// validate grpcDummyRequestModel against the descriptors of the project. do not trust any input
+func (m *grpcDummyRequestModel) validate(reg *protoRegistry) error {
+	method := reg.method(grpcMethodPath(m.Method))
+	if method == nil {
+		return fmt.Errorf("method '%s' is not in the descriptor set", m.Method)
+	}
+	if m.Code < 0 || int(m.Code) >= len(grpcCodes) {
+		return fmt.Errorf("gRPC status %d is unknown", m.Code)
+	}
+	if m.Code == grpcOK {
+		if _, err := grpcResponseMessages(method, string(m.Response)); err != nil {
+			return err
+		}
+	}
+	for key := range m.Trailers {
+		if strings.HasPrefix(strings.ToLower(key), "grpc-") {
+			return fmt.Errorf("trailer '%s' is reserved", key)
+		}
+	}
+	for i := range m.Matchers {
+		if err := m.Matchers[i].validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *grpcDummyRequestModel) dummy() (dummyModel, error) {
+	headers, err := json.Marshal(m.Headers)
+	if err != nil {
+		return dummyModel{}, err
+	}
+	return dummyModel{
+		Method:    http.MethodPost,
+		Path:      grpcMethodPath(m.Method),
+		Matchers:  m.Matchers,
+		Delay:     m.Delay,
+		Headers:   string(headers),
+		Status:    http.StatusOK,
+		Version:   apiVersion,
+		CreatedAt: time.Now(),
+		GRPC:      &grpcModel{Response: string(m.Response), Code: int(m.Code), Message: m.Message, Trailers: m.Trailers},
+	}, nil
+}
+
+// grpcResponseMessages encodes the response of a dummy. An array is a stream of messages for server streaming
+func grpcResponseMessages(method *protoMethod, raw string) ([][]byte, error) {
+	if strings.TrimSpace(raw) == "" {
+		raw = "{}"
+	}
+	dec := json.NewDecoder(strings.NewReader(raw))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, errors.New("response is not JSON")
+	}
+	values := []interface{}{v}
+	if items, ok := v.([]interface{}); ok && method.serverStreaming {
+		values = items
+	}
+	messages := make([][]byte, len(values))
+	for i, value := range values {
+		path := "response"
+		if len(values) > 1 {
+			path = fmt.Sprintf("response[%d]", i)
+		}
+		msg, err := method.output.encode(value, path)
+		if err != nil {
+			return nil, err
+		}
+		messages[i] = msg
+	}
+	return messages, nil
+}
+
+// parsed descriptor sets are cached per project like specs
+var descriptorCache = struct {
+	sync.Mutex
+	registries map[bson.ObjectId]cachedDescriptors
+}{registries: map[bson.ObjectId]cachedDescriptors{}}
+
+type cachedDescriptors struct {
+	raw []byte
+	reg *protoRegistry
+}
+
+// projectDescriptors returns the parsed descriptor set of the project
+func projectDescriptors(project *projectModel) (*protoRegistry, error) {
+	descriptorCache.Lock()
+	cached, ok := descriptorCache.registries[project.ID]
+	descriptorCache.Unlock()
+	if ok && bytes.Equal(cached.raw, project.Descriptors) {
+		return cached.reg, nil
+	}
+
+	reg, err := parseDescriptorSet(project.Descriptors)
+	if err != nil {
+		return nil, err
+	}
+	descriptorCache.Lock()
+	descriptorCache.registries[project.ID] = cachedDescriptors{raw: project.Descriptors, reg: reg}
+	descriptorCache.Unlock()
+	return reg, nil
+}
+
+// handler for POST /projects/:id/grpc/descriptors
+// Body is a FileDescriptorSet built with protoc --include_imports --descriptor_set_out
+func handleUploadDescriptors(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	project := findWritableProject(w, r, ps)
+	if project == nil {
+		return
+	}
+
+	raw, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(requestError(r, errorInvalidData))
+		return
+	}
+	reg, err := parseDescriptorSet(raw)
+	if err == nil && len(reg.services) == 0 {
+		err = errors.New("no service in the descriptor set")
+	}
+	if err != nil {
+		requestLog(r).Warningf("fail to parse descriptors %s", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(requestError(r, &errorResponse{"InvalidDescriptors", err.Error()}))
+		return
+	}
+
+	services := reg.serviceNames()
+	update := bson.M{"descriptors": raw, "services": services}
+	err = observeStore(r.Context(), "update_project", func() error {
+		return db.C(collectionProject).UpdateId(project.ID, bson.M{"$set": update})
+	})
+	if err != nil {
+		requestLog(r).Errorf("error on attaching descriptors: %s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	methods := []string{}
+	for _, name := range services {
+		for method := range reg.services[name].methods {
+			methods = append(methods, name+"/"+method)
+		}
+	}
+	sort.Strings(methods)
+	json.NewEncoder(w).Encode(struct {
+		Services []string `json:"services"`
+		Methods  []string `json:"methods"`
+	}{services, methods})
+}
+
+// handler for POST /projects/:id/grpc/dummies
+// Body is an array of gRPC dummies. gRPC dummies which were added before are replaced
+func handleGRPCDummies(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	project := findWritableProject(w, r, ps)
+	if project == nil {
+		return
+	}
+	if len(project.Descriptors) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(requestError(r, &errorResponse{"NoDescriptors", "upload a descriptor set of the services first"}))
+		return
+	}
+	reg, err := projectDescriptors(project)
+	if err != nil {
+		requestLog(r).Errorf("fail to parse the descriptors of project %s: %s", project.ID.Hex(), err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var reqModels []grpcDummyRequestModel
+	if err := json.NewDecoder(r.Body).Decode(&reqModels); err != nil {
+		requestLog(r).Warningf("fail to parse json %s ", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(requestError(r, &errorResponse{"InvalidJSON", "fail to parse JSON"}))
+		return
+	}
+	dummies := make([]dummyModel, len(reqModels))
+	for i := range reqModels {
+		err := reqModels[i].validate(reg)
+		if err == nil {
+			dummies[i], err = reqModels[i].dummy()
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(requestError(r, &errorResponse{"InvalidData", fmt.Sprintf("dummies[%d]: %s", i, err.Error())}))
+			return
+		}
+	}
+
+	imported, err := replaceProjectDummies(r.Context(), project, sourceGRPC, dummies)
+	if err != nil {
+		requestLog(r).Errorf("error on saving gRPC dummies: %s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(struct {
+		Dummies []importedDummy `json:"dummies"`
+	}{imported})
+}
+
+// isGRPCRequest reports whether the request is a gRPC call. gRPC-Web is not served
+func isGRPCRequest(r *http.Request) bool {
+	contentType := r.Header.Get("Content-Type")
+	return r.Method == http.MethodPost && (contentType == "application/grpc" ||
+		strings.HasPrefix(contentType, "application/grpc+") || strings.HasPrefix(contentType, "application/grpc;"))
+}
+
+// routeGRPC serves gRPC calls at the root, and passes other requests to next
+func routeGRPC(next http.Handler) http.Handler {
+	grpc := instrument(http.HandlerFunc(serveGRPC))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isGRPCRequest(r) {
+			grpc.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleNotGRPC answers requests other than gRPC on the dedicated gRPC listener
+func handleNotGRPC(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusUnsupportedMediaType)
+	json.NewEncoder(w).Encode(requestError(r, &errorResponse{"NotGRPC", "only gRPC is served on this listener"}))
+}
+
+// grpcStatus is the status of a call which is sent in trailers
+type grpcStatus struct {
+	code    int
+	message string
+}
+
+func (s *grpcStatus) Error() string {
+	return grpcCodes[s.code] + ": " + s.message
+}
+
+func grpcErrorf(code int, format string, args ...interface{}) *grpcStatus {
+	return &grpcStatus{code, fmt.Sprintf(format, args...)}
+}
+
+// writeGRPCStatus ends the call with the status and trailers after the headers are sent
+func writeGRPCStatus(w http.ResponseWriter, status *grpcStatus, trailers map[string]string) {
+	for key, value := range trailers {
+		w.Header().Set(http.TrailerPrefix+key, value)
+	}
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(status.code))
+	if status.message != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", encodeGRPCMessage(status.message))
+	}
+}
+
+// failGRPC ends the call with the error before any message is sent
+func failGRPC(w http.ResponseWriter, status *grpcStatus) {
+	w.WriteHeader(http.StatusOK)
+	writeGRPCStatus(w, status, nil)
+}
+
+// encodeGRPCMessage percent-encodes grpc-message
+func encodeGRPCMessage(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// readGRPCMessage reads a length-prefixed message. It returns io.EOF at the end of the stream
+func readGRPCMessage(body io.Reader) ([]byte, error) {
+	var head [5]byte
+	if _, err := io.ReadFull(body, head[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, grpcErrorf(grpcInternal, "truncated message")
+		}
+		return nil, err
+	}
+	if head[0] != 0 {
+		return nil, grpcErrorf(grpcUnimplemented, "compressed messages are not supported")
+	}
+	size := binary.BigEndian.Uint32(head[1:])
+	if cfg.Limits.MaxBodyBytes > 0 && int64(size) > cfg.Limits.MaxBodyBytes {
+		return nil, grpcErrorf(grpcResourceExhausted, "message larger than max (%d vs. %d)", size, cfg.Limits.MaxBodyBytes)
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(body, msg); err != nil {
+		return nil, grpcErrorf(grpcInternal, "truncated message")
+	}
+	return msg, nil
+}
+
+func writeGRPCMessage(w http.ResponseWriter, msg []byte) error {
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	_, err := w.Write(append(frame, msg...))
+	return err
+}
+
+// asGRPCStatus converts an error of reading a call to its status
+func asGRPCStatus(err error) *grpcStatus {
+	if status, ok := err.(*grpcStatus); ok {
+		return status
+	}
+	return grpcErrorf(grpcInternal, "fail to read the request: %s", err.Error())
+}
+
+// serveGRPC answers a call with a dummy of the project which has the service
+func serveGRPC(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Grpc-Accept-Encoding", "identity")
+	service := strings.TrimPrefix(r.URL.Path, "/")
+	if i := strings.LastIndex(service, "/"); i >= 0 {
+		service = service[:i]
+	}
+	if service == grpcReflectionV1 || service == grpcReflectionV1Alpha {
+		serveGRPCReflection(w, r)
+		return
+	}
+
+	query := bson.M{"services": service}
+	if id := r.Header.Get(grpcProjectHeader); id != "" {
+		if !bson.IsObjectIdHex(id) {
+			failGRPC(w, grpcErrorf(grpcInvalidArgument, "%s should be a project ID", strings.ToLower(grpcProjectHeader)))
+			return
+		}
+		query["_id"] = bson.ObjectIdHex(id)
+	}
+	var project projectModel
+	err := observeStore(r.Context(), "find_project", func() error {
+		return db.C(collectionProject).Find(query).Sort("_id").One(&project)
+	})
+	if err == mgo.ErrNotFound {
+		failGRPC(w, grpcErrorf(grpcUnimplemented, "unknown service %s", service))
+		return
+	}
+	if err != nil {
+		requestLog(r).Errorf("fail to find the project of service %s: %s", service, err.Error())
+		failGRPC(w, grpcErrorf(grpcInternal, "fail to find the project"))
+		return
+	}
+	reg, err := projectDescriptors(&project)
+	if err != nil {
+		requestLog(r).Errorf("fail to parse the descriptors of project %s: %s", project.ID.Hex(), err.Error())
+		failGRPC(w, grpcErrorf(grpcInternal, "invalid descriptors of the project"))
+		return
+	}
+	method := reg.method(r.URL.Path)
+	if method == nil {
+		failGRPC(w, grpcErrorf(grpcUnimplemented, "unknown method %s", r.URL.Path))
+		return
+	}
+
+	var dummies []dummyModel
+	err = observeStore(r.Context(), "find_project_dummies", func() error {
+		return db.C(collectionDummy).Find(bson.M{"project": project.ID, "path": r.URL.Path, "grpc": bson.M{"$exists": true}}).Sort("_id").All(&dummies)
+	})
+	if err != nil {
+		requestLog(r).Errorf("fail to find dummies of project %s: %s", project.ID.Hex(), err.Error())
+		failGRPC(w, grpcErrorf(grpcInternal, "fail to find dummies"))
+		return
+	}
+	serveGRPCMethod(w, r, method, dummies)
+}
+
+// serveGRPCMethod answers the call with the dummy whose matchers match the request message
+func serveGRPCMethod(w http.ResponseWriter, r *http.Request, method *protoMethod, dummies []dummyModel) {
+	body, err := readGRPCRequest(r, method)
+	if err != nil {
+		failGRPC(w, asGRPCStatus(err))
+		return
+	}
+	dummyOne := matchDummy(dummies, r, r.URL.Path, body)
+	if dummyOne == nil {
+		failGRPC(w, grpcErrorf(grpcUnimplemented, "no dummy of %s matches the request", r.URL.Path))
+		return
+	}
+
+	infoOf(r).dummyID = dummyOne.ID.Hex()
+	if !delayResponse(w, r, dummyOne.Delay) {
+		return
+	}
+	countDummyHit(dummyOne)
+	if err := setDummyHeaders(w, dummyOne); err != nil {
+		requestLog(r).Errorf("JSON marshal error: %s", err.Error())
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+
+	var messages [][]byte
+	if dummyOne.GRPC.Code == grpcOK {
+		if messages, err = grpcResponseMessages(method, dummyOne.GRPC.Response); err != nil {
+			requestLog(r).Errorf("invalid response of dummy %s: %s", dummyOne.ID.Hex(), err.Error())
+			failGRPC(w, grpcErrorf(grpcInternal, "invalid response of the dummy: %s", err.Error()))
+			return
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+	for _, msg := range messages {
+		if err := writeGRPCMessage(w, msg); err != nil {
+			return
+		}
+	}
+	writeGRPCStatus(w, &grpcStatus{dummyOne.GRPC.Code, dummyOne.GRPC.Message}, dummyOne.GRPC.Trailers)
+}
+
+// readGRPCRequest reads the request in JSON for matchers. Messages of client streaming are an array
+func readGRPCRequest(r *http.Request, method *protoMethod) ([]byte, error) {
+	values := []interface{}{}
+	for {
+		msg, err := readGRPCMessage(r.Body)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		v, err := method.input.decode(msg)
+		if err != nil {
+			return nil, grpcErrorf(grpcInternal, "fail to parse the request: %s", err.Error())
+		}
+		values = append(values, v)
+	}
+	if method.clientStreaming {
+		return json.Marshal(values)
+	}
+	if len(values) != 1 {
+		return nil, grpcErrorf(grpcInternal, "a call of %s should have one request message", r.URL.Path)
+	}
+	return json.Marshal(values[0])
+}
+
+// serveGRPCReflection answers server reflection so that clients like grpcurl can list and describe services.
+// The project in x-dummy-project is described, or every project which has descriptors
+func serveGRPCReflection(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasSuffix(r.URL.Path, "/ServerReflectionInfo") {
+		failGRPC(w, grpcErrorf(grpcUnimplemented, "unknown method %s", r.URL.Path))
+		return
+	}
+	query := bson.M{"services": bson.M{"$exists": true}}
+	if id := r.Header.Get(grpcProjectHeader); bson.IsObjectIdHex(id) {
+		query["_id"] = bson.ObjectIdHex(id)
+	}
+	var projects []projectModel
+	err := observeStore(r.Context(), "find_grpc_projects", func() error {
+		return db.C(collectionProject).Find(query).Sort("_id").All(&projects)
+	})
+	if err != nil {
+		requestLog(r).Errorf("fail to find projects with descriptors: %s", err.Error())
+		failGRPC(w, grpcErrorf(grpcInternal, "fail to find projects"))
+		return
+	}
+	var regs []*protoRegistry
+	for i := range projects {
+		reg, err := projectDescriptors(&projects[i])
+		if err != nil {
+			requestLog(r).Errorf("fail to parse the descriptors of project %s: %s", projects[i].ID.Hex(), err.Error())
+			continue
+		}
+		regs = append(regs, reg)
+	}
+	runGRPCReflection(w, r, regs)
+}
+
+// runGRPCReflection answers reflection requests on the stream until the client closes it
+func runGRPCReflection(w http.ResponseWriter, r *http.Request, regs []*protoRegistry) {
+	rc := http.NewResponseController(w)
+	rc.EnableFullDuplex()
+	// the stream is open as long as the client uses it
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+	for {
+		req, err := readGRPCMessage(r.Body)
+		if err == io.EOF {
+			writeGRPCStatus(w, &grpcStatus{code: grpcOK}, nil)
+			return
+		}
+		if err != nil {
+			writeGRPCStatus(w, asGRPCStatus(err), nil)
+			return
+		}
+		res, err := grpcReflectionResponse(regs, req)
+		if err != nil {
+			writeGRPCStatus(w, grpcErrorf(grpcInvalidArgument, "invalid reflection request: %s", err.Error()), nil)
+			return
+		}
+		if err := writeGRPCMessage(w, res); err != nil {
+			return
+		}
+		rc.Flush()
+	}
+}
+
+// grpcReflectionResponse answers a ServerReflectionRequest with a ServerReflectionResponse.
+// Extensions are not indexed, so they are never found
+func grpcReflectionResponse(regs []*protoRegistry, req []byte) ([]byte, error) {
+	wire, err := parseProtoWire(req)
+	if err != nil {
+		return nil, err
+	}
+	var res []byte
+	for _, f := range wire {
+		if f.num == 1 {
+			res = appendProtoBytes(res, 1, f.data) // valid_host
+		}
+	}
+	res = appendProtoBytes(res, 2, req) // original_request
+
+	for _, f := range wire {
+		switch f.num {
+		case 3: // file_by_filename
+			name := string(f.data)
+			if files := reflectGRPCFiles(regs, func(reg *protoRegistry) *protoFile { return reg.byFile[name] }); files != nil {
+				return appendProtoBytes(res, 4, files), nil
+			}
+			return appendGRPCReflectionError(res, "file %s is not found", name), nil
+		case 4: // file_containing_symbol
+			symbol := string(f.data)
+			if files := reflectGRPCFiles(regs, func(reg *protoRegistry) *protoFile { return reg.symbols[symbol] }); files != nil {
+				return appendProtoBytes(res, 4, files), nil
+			}
+			return appendGRPCReflectionError(res, "symbol %s is not found", symbol), nil
+		case 5: // file_containing_extension
+			return appendGRPCReflectionError(res, "extensions are not supported"), nil
+		case 6: // all_extension_numbers_of_type
+			name := string(f.data)
+			for _, reg := range regs {
+				if reg.messages[name] != nil {
+					return appendProtoBytes(res, 5, appendProtoString(nil, 1, name)), nil
+				}
+			}
+			return appendGRPCReflectionError(res, "type %s is not found", name), nil
+		case 7: // list_services
+			seen := map[string]bool{}
+			var services []byte
+			for _, reg := range regs {
+				for _, name := range reg.serviceNames() {
+					if !seen[name] {
+						seen[name] = true
+						services = appendProtoBytes(services, 1, appendProtoString(nil, 1, name))
+					}
+				}
+			}
+			return appendProtoBytes(res, 6, services), nil
+		}
+	}
+	return nil, errors.New("no request is given")
+}
+
+// reflectGRPCFiles returns a FileDescriptorResponse of the file and its dependencies in the first registry which has it
+func reflectGRPCFiles(regs []*protoRegistry, find func(reg *protoRegistry) *protoFile) []byte {
+	for _, reg := range regs {
+		file := find(reg)
+		if file == nil {
+			continue
+		}
+		res := []byte{}
+		seen := map[string]bool{}
+		var add func(file *protoFile)
+		add = func(file *protoFile) {
+			if file == nil || seen[file.name] {
+				return
+			}
+			seen[file.name] = true
+			res = appendProtoBytes(res, 1, file.raw)
+			for _, dep := range file.deps {
+				add(reg.byFile[dep])
+			}
+		}
+		add(file)
+		return res
+	}
+	return nil
+}
+
+func appendGRPCReflectionError(res []byte, format string, args ...interface{}) []byte {
+	errorResponse := appendProtoVarint(nil, 1, grpcNotFound)
+	errorResponse = appendProtoString(errorResponse, 2, fmt.Sprintf(format, args...))
+	return appendProtoBytes(res, 7, errorResponse)
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/globalsign/mgo/bson"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// testProtoField is a FieldDescriptorProto. label 1 is optional and 3 is repeated
+func testProtoField(name string, number, label, kind int, typeName string) []byte {
+	b := appendProtoString(nil, 1, name)
+	b = appendProtoVarint(b, 3, uint64(number))
+	b = appendProtoVarint(b, 4, uint64(label))
+	b = appendProtoVarint(b, 5, uint64(kind))
+	if typeName != "" {
+		b = appendProtoString(b, 6, typeName)
+	}
+	return b
+}
+
+func testProtoMethod(name, input, output string, clientStreaming, serverStreaming bool) []byte {
+	b := appendProtoString(nil, 1, name)
+	b = appendProtoString(b, 2, input)
+	b = appendProtoString(b, 3, output)
+	if clientStreaming {
+		b = appendProtoVarint(b, 5, 1)
+	}
+	if serverStreaming {
+		b = appendProtoVarint(b, 6, 1)
+	}
+	return b
+}
+
+// testDescriptorSet is what protoc --include_imports makes of
+//
+//	syntax = "proto3";
+//	package test.v1;
+//	import "google/protobuf/timestamp.proto";
+//
+//	enum Kind { KIND_UNSPECIFIED = 0; KIND_FRIEND = 1; }
+//	message HelloRequest { string name = 1; repeated string tags = 2; Kind kind = 3; int64 count = 4; }
+//	message HelloReply { string message = 1; map<string, int32> labels = 2; google.protobuf.Timestamp at = 3; repeated int32 scores = 4; }
+//	service Greeter {
+//	  rpc SayHello(HelloRequest) returns (HelloReply);
+//	  rpc ListHellos(HelloRequest) returns (stream HelloReply);
+//	  rpc Collect(stream HelloRequest) returns (HelloReply);
+//	}
+func testDescriptorSet(withImports bool) []byte {
+	timestamp := appendProtoString(nil, 1, "google/protobuf/timestamp.proto")
+	timestamp = appendProtoString(timestamp, 2, "google.protobuf")
+	message := appendProtoString(nil, 1, "Timestamp")
+	message = appendProtoBytes(message, 2, testProtoField("seconds", 1, 1, protoTypeInt64, ""))
+	message = appendProtoBytes(message, 2, testProtoField("nanos", 2, 1, protoTypeInt32, ""))
+	timestamp = appendProtoBytes(timestamp, 4, message)
+	timestamp = appendProtoString(timestamp, 12, "proto3")
+
+	greeter := appendProtoString(nil, 1, "test/v1/greeter.proto")
+	greeter = appendProtoString(greeter, 2, "test.v1")
+	greeter = appendProtoString(greeter, 3, "google/protobuf/timestamp.proto")
+
+	request := appendProtoString(nil, 1, "HelloRequest")
+	request = appendProtoBytes(request, 2, testProtoField("name", 1, 1, protoTypeString, ""))
+	request = appendProtoBytes(request, 2, testProtoField("tags", 2, 3, protoTypeString, ""))
+	request = appendProtoBytes(request, 2, testProtoField("kind", 3, 1, protoTypeEnum, ".test.v1.Kind"))
+	request = appendProtoBytes(request, 2, testProtoField("count", 4, 1, protoTypeInt64, ""))
+	greeter = appendProtoBytes(greeter, 4, request)
+
+	entry := appendProtoString(nil, 1, "LabelsEntry")
+	entry = appendProtoBytes(entry, 2, testProtoField("key", 1, 1, protoTypeString, ""))
+	entry = appendProtoBytes(entry, 2, testProtoField("value", 2, 1, protoTypeInt32, ""))
+	entry = appendProtoBytes(entry, 7, appendProtoVarint(nil, 7, 1)) // map_entry
+	reply := appendProtoString(nil, 1, "HelloReply")
+	reply = appendProtoBytes(reply, 2, testProtoField("message", 1, 1, protoTypeString, ""))
+	reply = appendProtoBytes(reply, 2, testProtoField("labels", 2, 3, protoTypeMessage, ".test.v1.HelloReply.LabelsEntry"))
+	reply = appendProtoBytes(reply, 2, testProtoField("at", 3, 1, protoTypeMessage, ".google.protobuf.Timestamp"))
+	reply = appendProtoBytes(reply, 2, testProtoField("scores", 4, 3, protoTypeInt32, ""))
+	reply = appendProtoBytes(reply, 3, entry)
+	greeter = appendProtoBytes(greeter, 4, reply)
+
+	kind := appendProtoString(nil, 1, "Kind")
+	kind = appendProtoBytes(kind, 2, appendProtoVarint(appendProtoString(nil, 1, "KIND_UNSPECIFIED"), 2, 0))
+	kind = appendProtoBytes(kind, 2, appendProtoVarint(appendProtoString(nil, 1, "KIND_FRIEND"), 2, 1))
+	greeter = appendProtoBytes(greeter, 5, kind)
+
+	service := appendProtoString(nil, 1, "Greeter")
+	service = appendProtoBytes(service, 2, testProtoMethod("SayHello", ".test.v1.HelloRequest", ".test.v1.HelloReply", false, false))
+	service = appendProtoBytes(service, 2, testProtoMethod("ListHellos", ".test.v1.HelloRequest", ".test.v1.HelloReply", false, true))
+	service = appendProtoBytes(service, 2, testProtoMethod("Collect", ".test.v1.HelloRequest", ".test.v1.HelloReply", true, false))
+	greeter = appendProtoBytes(greeter, 6, service)
+	greeter = appendProtoString(greeter, 12, "proto3")
+
+	var set []byte
+	if withImports {
+		set = appendProtoBytes(set, 1, timestamp)
+	}
+	return appendProtoBytes(set, 1, greeter)
+}
+
+// testGRPCFrame prefixes a message with its length
+func testGRPCFrame(msg []byte) []byte {
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	return append(frame, msg...)
+}
+
+func decodeTestJSON(s string) interface{} {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	var v interface{}
+	Expect(dec.Decode(&v)).To(Succeed())
+	return v
+}
+
+func newGRPCDummy(reg *protoRegistry, spec string) dummyModel {
+	var m grpcDummyRequestModel
+	Expect(json.Unmarshal([]byte(spec), &m)).To(Succeed())
+	Expect(m.validate(reg)).To(Succeed())
+	dummy, err := m.dummy()
+	Expect(err).NotTo(HaveOccurred())
+	dummy.ID = bson.NewObjectId()
+	return dummy
+}
+
+var _ = Describe("gRPC", func() {
+	var reg *protoRegistry
+
+	BeforeEach(func() {
+		var err error
+		reg, err = parseDescriptorSet(testDescriptorSet(true))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	Context("with a descriptor set", func() {
+		It("should index services and types", func() {
+			Expect(reg.serviceNames()).To(Equal([]string{"test.v1.Greeter"}))
+			Expect(reg.method("/test.v1.Greeter/ListHellos").serverStreaming).To(BeTrue())
+			Expect(reg.method("/test.v1.Greeter/Nope")).To(BeNil())
+			Expect(reg.symbols["test.v1.HelloReply.LabelsEntry"].name).To(Equal("test/v1/greeter.proto"))
+			Expect(grpcMethodPath("test.v1.Greeter.SayHello")).To(Equal("/test.v1.Greeter/SayHello"))
+		})
+
+		It("should reject a set without imports", func() {
+			_, err := parseDescriptorSet(testDescriptorSet(false))
+			Expect(err).To(MatchError(ContainSubstring("--include_imports")))
+			_, err = parseDescriptorSet([]byte("not a descriptor set"))
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should convert messages from and to JSON", func() {
+			reply := reg.messages["test.v1.HelloReply"]
+			in := `{"message": "hi", "labels": {"a": 1, "b": -2}, "at": "2024-01-02T03:04:05.5Z", "scores": [1, -2, 300]}`
+			b, err := reply.encode(decodeTestJSON(in), "response")
+			Expect(err).NotTo(HaveOccurred())
+			out, err := reply.decode(b)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(json.Marshal(out)).To(MatchJSON(in))
+
+			request := reg.messages["test.v1.HelloRequest"]
+			b, err = request.encode(decodeTestJSON(`{"name": "Bob", "tags": ["x", "y"], "kind": "KIND_FRIEND", "count": 12}`), "request")
+			Expect(err).NotTo(HaveOccurred())
+			out, err = request.decode(b)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(json.Marshal(out)).To(MatchJSON(`{"name": "Bob", "tags": ["x", "y"], "kind": "KIND_FRIEND", "count": "12"}`))
+		})
+
+		It("should locate invalid values", func() {
+			reply := reg.messages["test.v1.HelloReply"]
+			_, err := reply.encode(decodeTestJSON(`{"nope": 1}`), "response")
+			Expect(err).To(MatchError("response: test.v1.HelloReply has no field nope"))
+			_, err = reply.encode(decodeTestJSON(`{"scores": [1, "a"]}`), "response")
+			Expect(err).To(MatchError(ContainSubstring("response.scores[1]")))
+			_, err = reply.encode(decodeTestJSON(`{"at": "yesterday"}`), "response")
+			Expect(err).To(MatchError(ContainSubstring("RFC 3339")))
+			_, err = reg.messages["test.v1.HelloRequest"].encode(decodeTestJSON(`{"kind": "KIND_ENEMY"}`), "request")
+			Expect(err).To(MatchError(ContainSubstring("is not a value of test.v1.Kind")))
+		})
+	})
+
+	Context("with dummies", func() {
+		var server *httptest.Server
+		var client *http.Client
+		var dummies []dummyModel
+
+		BeforeEach(func() {
+			dummies = []dummyModel{
+				newGRPCDummy(reg, `{"method": "test.v1.Greeter/SayHello", "response": {"message": "hello"}}`),
+				newGRPCDummy(reg, `{"method": "test.v1.Greeter/SayHello", "headers": {"x-greeter": "bob"},
+					"matchers": [{"in": "body", "name": "name", "op": "equals", "value": "Bob"}],
+					"response": {"message": "hello Bob", "labels": {"vip": 1}}}`),
+				newGRPCDummy(reg, `{"method": "test.v1.Greeter/SayHello", "code": "NOT_FOUND", "message": "no greeting for café",
+					"trailers": {"x-reason": "missing"}, "matchers": [{"in": "body", "name": "kind", "op": "equals", "value": "KIND_FRIEND"}]}`),
+				newGRPCDummy(reg, `{"method": "test.v1.Greeter.ListHellos", "response": [{"message": "one"}, {"message": "two"}]}`),
+				newGRPCDummy(reg, `{"method": "test.v1.Greeter/Collect", "response": {"message": "collected"},
+					"matchers": [{"in": "body", "name": "1.name", "op": "equals", "value": "b"}]}`),
+			}
+			server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/grpc")
+				if strings.HasPrefix(r.URL.Path, "/"+grpcReflectionV1+"/") {
+					runGRPCReflection(w, r, []*protoRegistry{reg})
+					return
+				}
+				serveGRPCMethod(w, r, reg.method(r.URL.Path), dummies)
+			}))
+			server.Config.Protocols = new(http.Protocols)
+			server.Config.Protocols.SetUnencryptedHTTP2(true)
+			server.Start()
+			protocols := new(http.Protocols)
+			protocols.SetUnencryptedHTTP2(true)
+			client = &http.Client{Transport: &http.Transport{Protocols: protocols}}
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		// call sends the messages in JSON and returns the response with its messages in JSON
+		call := func(method string, body io.Reader) (*http.Response, []string) {
+			req, _ := http.NewRequest("POST", server.URL+method, body)
+			req.Header.Set("Content-Type", "application/grpc")
+			req.Header.Set("TE", "trailers")
+			res, err := client.Do(req)
+			Expect(err).NotTo(HaveOccurred())
+			defer res.Body.Close()
+			Expect(res.ProtoMajor).To(Equal(2))
+			var messages []string
+			for {
+				msg, err := readGRPCMessage(res.Body)
+				if err == io.EOF {
+					break
+				}
+				Expect(err).NotTo(HaveOccurred())
+				v, err := reg.method(method).output.decode(msg)
+				Expect(err).NotTo(HaveOccurred())
+				out, _ := json.Marshal(v)
+				messages = append(messages, string(out))
+			}
+			return res, messages
+		}
+		requestBody := func(messages ...string) io.Reader {
+			var body []byte
+			for _, m := range messages {
+				msg, err := reg.messages["test.v1.HelloRequest"].encode(decodeTestJSON(m), "request")
+				Expect(err).NotTo(HaveOccurred())
+				body = append(body, testGRPCFrame(msg)...)
+			}
+			return bytes.NewReader(body)
+		}
+
+		It("should answer with the dummy which matches fields of the request", func() {
+			res, messages := call("/test.v1.Greeter/SayHello", requestBody(`{"name": "Bob"}`))
+			Expect(res.Header.Get("Content-Type")).To(Equal("application/grpc"))
+			Expect(res.Header.Get("X-Greeter")).To(Equal("bob"))
+			Expect(res.Trailer.Get("Grpc-Status")).To(Equal("0"))
+			Expect(messages).To(HaveLen(1))
+			Expect(messages[0]).To(MatchJSON(`{"message": "hello Bob", "labels": {"vip": 1}}`))
+
+			_, messages = call("/test.v1.Greeter/SayHello", requestBody(`{"name": "Alice"}`))
+			Expect(messages).To(Equal([]string{`{"message":"hello"}`}))
+		})
+
+		It("should end calls with the status and trailers of the dummy", func() {
+			res, messages := call("/test.v1.Greeter/SayHello", requestBody(`{"kind": "KIND_FRIEND"}`))
+			Expect(messages).To(BeEmpty())
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(res.Trailer.Get("Grpc-Status")).To(Equal("5"))
+			Expect(res.Trailer.Get("Grpc-Message")).To(Equal("no greeting for caf%C3%A9"))
+			Expect(res.Trailer.Get("X-Reason")).To(Equal("missing"))
+		})
+
+		It("should stream messages", func() {
+			res, messages := call("/test.v1.Greeter/ListHellos", requestBody(`{}`))
+			Expect(res.Trailer.Get("Grpc-Status")).To(Equal("0"))
+			Expect(messages).To(Equal([]string{`{"message":"one"}`, `{"message":"two"}`}))
+
+			_, messages = call("/test.v1.Greeter/Collect", requestBody(`{"name": "a"}`, `{"name": "b"}`))
+			Expect(messages).To(Equal([]string{`{"message":"collected"}`}))
+		})
+
+		It("should fail calls which can not be answered", func() {
+			res, _ := call("/test.v1.Greeter/Collect", requestBody(`{"name": "a"}`))
+			Expect(res.Trailer.Get("Grpc-Status")).To(Equal("12"))
+
+			res, _ = call("/test.v1.Greeter/SayHello", requestBody(`{"name": "a"}`, `{"name": "b"}`))
+			Expect(res.Trailer.Get("Grpc-Status")).To(Equal("13"))
+
+			compressed := testGRPCFrame([]byte{0x0a, 0x01, 'a'})
+			compressed[0] = 1
+			res, _ = call("/test.v1.Greeter/SayHello", bytes.NewReader(compressed))
+			Expect(res.Trailer.Get("Grpc-Status")).To(Equal("12"))
+			Expect(res.Trailer.Get("Grpc-Message")).To(ContainSubstring("compressed"))
+		})
+
+		It("should answer server reflection", func() {
+			pr, pw := io.Pipe()
+			req, _ := http.NewRequest("POST", server.URL+"/"+grpcReflectionV1+"/ServerReflectionInfo", pr)
+			req.Header.Set("Content-Type", "application/grpc")
+			done := make(chan *http.Response, 1)
+			go func() {
+				defer GinkgoRecover()
+				res, err := client.Do(req)
+				Expect(err).NotTo(HaveOccurred())
+				done <- res
+			}()
+			exchange := func(request []byte) []protoWire {
+				_, err := pw.Write(testGRPCFrame(request))
+				Expect(err).NotTo(HaveOccurred())
+				var res *http.Response
+				Eventually(done).Should(Receive(&res))
+				done <- res
+				msg, err := readGRPCMessage(res.Body)
+				Expect(err).NotTo(HaveOccurred())
+				wire, err := parseProtoWire(msg)
+				Expect(err).NotTo(HaveOccurred())
+				return wire
+			}
+
+			wire := exchange(appendProtoString(nil, 7, "*"))
+			Expect(wire[len(wire)-1].num).To(Equal(6))
+			services, _ := parseProtoWire(wire[len(wire)-1].data)
+			Expect(services).To(HaveLen(1))
+			Expect(string(services[0].data)).To(ContainSubstring("test.v1.Greeter"))
+
+			wire = exchange(appendProtoString(nil, 4, "test.v1.Greeter.SayHello"))
+			Expect(wire[len(wire)-1].num).To(Equal(4))
+			files, _ := parseProtoWire(wire[len(wire)-1].data)
+			Expect(files).To(HaveLen(2), "the file and its import")
+
+			wire = exchange(appendProtoString(nil, 4, "test.v1.Nope"))
+			Expect(wire[len(wire)-1].num).To(Equal(7))
+
+			pw.Close()
+			res := <-done
+			ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			Expect(res.Trailer.Get("Grpc-Status")).To(Equal("0"))
+		})
+	})
+
+	It("should validate dummies against the descriptors", func() {
+		invalid := []string{
+			`{"method": "test.v1.Greeter/Nope"}`,
+			`{"method": "test.v1.Greeter/SayHello", "response": {"nope": 1}}`,
+			`{"method": "test.v1.Greeter/SayHello", "trailers": {"grpc-status": "0"}}`,
+			`{"method": "test.v1.Greeter/SayHello", "code": 17}`,
+			`{"method": "test.v1.Greeter/SayHello", "matchers": [{"in": "body", "op": "regex", "value": "("}]}`,
+		}
+		for _, spec := range invalid {
+			var m grpcDummyRequestModel
+			Expect(json.Unmarshal([]byte(spec), &m)).To(Succeed())
+			Expect(m.validate(reg)).To(HaveOccurred(), spec)
+		}
+		var m grpcDummyRequestModel
+		Expect(json.Unmarshal([]byte(`{"code": "BROKEN"}`), &m)).NotTo(Succeed())
+	})
+
+	It("should route gRPC calls", func() {
+		req, _ := http.NewRequest("POST", "/test.v1.Greeter/SayHello", nil)
+		for contentType, ok := range map[string]bool{"application/grpc": true, "application/grpc+proto": true, "application/grpc-web": false, "application/json": false} {
+			req.Header.Set("Content-Type", contentType)
+			Expect(isGRPCRequest(req)).To(Equal(ok), contentType)
+		}
+	})
+})
@@ -4,79 +4,182 @@ import (
 	"encoding/json"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/globalsign/mgo"
 	"github.com/globalsign/mgo/bson"
 	"github.com/julienschmidt/httprouter"
-	log "github.com/sirupsen/logrus"
 )
 
 // handler for /v1/:id
-// If the user specifies 'dummy-status', the status is overrided
+// If the user specifies 'dummy-status', the status is overrided.
+// With 'callback', JSON content is served as JSONP
 func handleV1Custom(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	// TODO jsonp will be supported
-	// param : dummy-status
+	// param : dummy-status, callback
 	dummyStatus := r.URL.Query().Get("dummy-status")
 	dummyID := ps.ByName("id")
 	if ok := bson.IsObjectIdHex(dummyID); !ok {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Header().Set("Content-Type", "application/json; charset=utf-8")
-		json.NewEncoder(w).Encode(errorInvalidID)
+		json.NewEncoder(w).Encode(requestError(r, errorInvalidID))
 		return
 	}
 
-	var convStatus int64
-	var err error
-	if dummyStatus == "" {
-		convStatus = 200
-	} else {
-		convStatus, err = strconv.ParseInt(dummyStatus, 0, 16)
-		if err != nil {
-			log.Warningf("converting %s, but error %s", dummyStatus, err.Error())
-			w.WriteHeader(http.StatusBadRequest)
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(errorInvalidStatus)
-			return
-		}
+	convStatus, err := parseDummyStatus(dummyStatus)
+	if err != nil {
+		requestLog(r).Warningf("converting %s, but error %s", dummyStatus, err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(requestError(r, errorInvalidStatus))
+		return
+	}
+	callback, err := jsonpCallback(r)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(requestError(r, errorInvalidCallback))
+		return
 	}
 
 	// get data from db
 	var dummyOne dummyModel
-	if err := db.C(collectionDummy).FindId(bson.ObjectIdHex(dummyID)).One(&dummyOne); err != nil {
+	err = observeStore(r.Context(), "find_dummy", func() error {
+		return db.C(collectionDummy).FindId(bson.ObjectIdHex(dummyID)).One(&dummyOne)
+	})
+	if err != nil {
 		if err == mgo.ErrNotFound {
 			w.WriteHeader(http.StatusNotFound)
-			json.NewEncoder(w).Encode(errorNotFound)
+			json.NewEncoder(w).Encode(requestError(r, errorNotFound))
 			return
 		}
+		requestLog(r).WithField("error_msg", err.Error()).Error("fail to find the dummy")
 		w.WriteHeader(http.StatusInternalServerError)
-		log.WithField("error_msg", err.Error())
 		return
 	}
 
-	if dummyOne.Headers != "" {
-		byt := []byte(dummyOne.Headers)
-		var dat map[string]string
-		if err := json.Unmarshal(byt, &dat); err != nil {
-			log.Errorf("JSON marshal error: %s", err.Error())
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-		// traverse it
-		for k, v := range dat {
-			w.Header().Set(k, v)
-		}
+	serveDummy(w, r, &dummyOne, convStatus, callback)
+}
+
+// serveDummy responds with the dummy which a handler found. Dummies of a protocol are served by its handler,
+// and the content is written otherwise
+func serveDummy(w http.ResponseWriter, r *http.Request, dummyOne *dummyModel, status int, callback string) {
+	infoOf(r).dummyID = dummyOne.ID.Hex()
+	if dummyOne.EchoTrace {
+		echoTraceHeaders(w, r)
+	}
+	if !delayResponse(w, r, dummyOne.Delay) {
+		return
 	}
+	switch {
+	case dummyOne.GraphQL != "":
+		serveGraphQL(w, r, dummyOne)
+	case dummyOne.SOAP != nil:
+		serveSOAP(w, r, dummyOne)
+	case dummyOne.JSONRPC != "":
+		serveJSONRPC(w, r, dummyOne)
+	case dummyOne.WebSocket != nil:
+		serveWebSocket(w, r, dummyOne)
+	case dummyOne.SSE != nil:
+		serveSSE(w, r, dummyOne)
+	case dummyOne.LongPoll != nil:
+		serveLongPoll(w, r, dummyOne, status, callback)
+	default:
+		writeContent(w, r, dummyOne, status, callback)
+	}
+}
 
-	// set content type and charset
-	w.Header().Set("Content-Type", dummyOne.ContentType+"; charset="+dummyOne.Charset)
+// writeContent writes the content of the dummy in the negotiated charset, as JSONP with the callback
+// and with its mutations
+func writeContent(w http.ResponseWriter, r *http.Request, dummyOne *dummyModel, status int, callback string) {
+	negotiateCharset(w, r, dummyOne)
+	applyJSONP(w, dummyOne, callback)
+	infoOf(r).mutations = dummyOne.Mutations
+	writeDummy(w, r, dummyOne, status)
+}
+
+// delayResponse waits for the delay in milliseconds up to the configured max delay.
+// The write deadline is pushed back by the delay so that slow dummies are not cut by the write timeout.
+// It returns false if the client has gone away while waiting
+func delayResponse(w http.ResponseWriter, r *http.Request, delay int) bool {
+	if delay <= 0 {
+		return true
+	}
+	wait := time.Duration(delay) * time.Millisecond
+	if cfg.Limits.MaxDelay > 0 && wait > cfg.Limits.MaxDelay {
+		wait = cfg.Limits.MaxDelay
+	}
+	if cfg.Server.WriteTimeout > 0 {
+		// not every writer supports deadlines. e.g. httptest.ResponseRecorder
+		http.NewResponseController(w).SetWriteDeadline(time.Now().Add(wait + cfg.Server.WriteTimeout))
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-r.Context().Done():
+		return false
+	}
+}
 
+// parseDummyStatus converts 'dummy-status' query value. 0 means no override
+func parseDummyStatus(dummyStatus string) (int, error) {
 	if dummyStatus == "" {
+		return 0, nil
+	}
+	convStatus, err := strconv.ParseInt(dummyStatus, 0, 16)
+	if err != nil {
+		return 0, err
+	}
+	return int(convStatus), nil
+}
+
+// writeDummy writes headers, status and content of the dummy.
+// status overrides the dummy's status if it is not 0
+func writeDummy(w http.ResponseWriter, r *http.Request, dummyOne *dummyModel, status int) {
+	if err := setDummyHeaders(w, dummyOne); err != nil {
+		requestLog(r).Errorf("JSON marshal error: %s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// set content type and charset. a dummy without content may have none
+	if dummyOne.ContentType != "" {
+		charset := dummyOne.Charset
+		if hasMutation(dummyOne.Mutations, mutationMislabelCharset) {
+			charset = mislabeledCharset(charset)
+		}
+		w.Header().Set("Content-Type", dummyOne.ContentType+"; charset="+charset)
+	}
+
+	if status == 0 {
 		w.WriteHeader(dummyOne.Status)
 	} else {
-		w.WriteHeader(int(convStatus))
+		w.WriteHeader(status)
 	}
 
-	w.Write([]byte(dummyOne.Content))
+	content := dummyOne.Content
+	if hasMutation(dummyOne.Mutations, mutationLineEndings) {
+		content = mixLineEndings(content)
+	}
+	w.Write(mutateBody(dummyContent(content, dummyOne.Charset), dummyOne.Charset, dummyOne.Mutations))
+	countDummyHit(dummyOne)
+}
+
+// setDummyHeaders sets headers of the dummy on the response
+func setDummyHeaders(w http.ResponseWriter, dummyOne *dummyModel) error {
+	if dummyOne.Headers == "" {
+		return nil
+	}
+	var dat map[string]string
+	if err := json.Unmarshal([]byte(dummyOne.Headers), &dat); err != nil {
+		return err
+	}
+	// traverse it
+	for k, v := range dat {
+		w.Header().Set(k, v)
+	}
+	return nil
 }
 
 // content-type and charset is defined
@@ -86,15 +189,15 @@ func handleV1CreateDummy(w http.ResponseWriter, r *http.Request, _ httprouter.Pa
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	var reqModel requestModel
 	if err := json.NewDecoder(r.Body).Decode(&reqModel); err != nil {
-		log.Warningf("fail to parse json %s ", err.Error())
+		requestLog(r).Warningf("fail to parse json %s ", err.Error())
 		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(errorResponse{"InvalidJSON", "fail to parse JSON"})
+		json.NewEncoder(w).Encode(requestError(r, &errorResponse{"InvalidJSON", "fail to parse JSON"}))
 		return
 	}
 
 	if err := reqModel.validate(); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(errorResponse{"InvalidData", err.Error()})
+		json.NewEncoder(w).Encode(requestError(r, &errorResponse{"InvalidData", err.Error()}))
 		return
 	}
 
@@ -103,9 +206,13 @@ func handleV1CreateDummy(w http.ResponseWriter, r *http.Request, _ httprouter.Pa
 
 	// save it to db
 	dummyToSave.ID = bson.NewObjectId()
-	if err := db.C(collectionDummy).Insert(&dummyToSave); err != nil {
-		log.Error("error on saving an entity", err.Error())
+	err := observeStore(r.Context(), "insert_dummy", func() error {
+		return db.C(collectionDummy).Insert(&dummyToSave)
+	})
+	if err != nil {
+		requestLog(r).Errorf("error on saving an entity: %s", err.Error())
 		w.WriteHeader(http.StatusInternalServerError)
+		return
 	}
 
 	w.WriteHeader(http.StatusOK)
@@ -118,6 +225,6 @@ func handleV1CreateDummy(w http.ResponseWriter, r *http.Request, _ httprouter.Pa
 	json.NewEncoder(w).Encode(
 		successResponse{
 			ID:  dummyToSave.ID.Hex(),
-			URL: "https://httpdummyresponser.herokuapp.com/" + apiVersion + "/" + dummyToSave.ID.Hex(),
+			URL: baseURL(r) + apiVersion + "/" + dummyToSave.ID.Hex(),
 		})
 }
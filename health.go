@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// build info. set by -ldflags "-X main.version=1.2.0 -X main.commit=abc123 -X main.buildDate=2018-01-01"
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+// draining is 1 while the server is shutting down
+var draining int32
+
+// pingStorage checks whether the storage is reachable in the timeout.
+// A broken session is refreshed so that requests recover when the storage comes back
+var pingStorage = func(timeout time.Duration) error {
+	session := db.Session.Copy()
+	defer session.Close()
+	session.SetSyncTimeout(timeout)
+	session.SetSocketTimeout(timeout)
+	err := session.Ping()
+	if err != nil {
+		db.Session.Refresh()
+	}
+	return err
+}
+
+type checkResult struct {
+	Status  string `json:"status"` // ok or down
+	Latency int64  `json:"latency_ms"`
+	Error   string `json:"error,omitempty"`
+}
+
+// handler for GET /healthz
+// It tells the process is alive. Dependencies are not checked
+func handleLiveness(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(struct {
+		Status string `json:"status"`
+	}{"ok"})
+}
+
+// handler for GET /readyz
+// It is 503 while the server is draining or the storage is unreachable
+func handleReadiness(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+
+	storage := checkResult{Status: "ok"}
+	started := time.Now()
+	if err := pingStorage(cfg.Storage.PingTimeout); err != nil {
+		storage.Status = "down"
+		storage.Error = err.Error()
+		requestLog(r).Warnf("storage is unreachable: %s", err.Error())
+	}
+	storage.Latency = int64(time.Since(started) / time.Millisecond)
+
+	status, code := "ready", http.StatusOK
+	if atomic.LoadInt32(&draining) == 1 {
+		status, code = "draining", http.StatusServiceUnavailable
+	} else if storage.Status != "ok" {
+		status, code = "degraded", http.StatusServiceUnavailable
+	}
+
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(struct {
+		Status string                 `json:"status"`
+		Checks map[string]checkResult `json:"checks"`
+	}{status, map[string]checkResult{"storage": storage}})
+}
+
+// handler for GET /version
+func handleVersion(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(struct {
+		Version    string `json:"version"`
+		APIVersion string `json:"api_version"`
+		Commit     string `json:"commit,omitempty"`
+		BuildDate  string `json:"build_date,omitempty"`
+		GoVersion  string `json:"go_version"`
+	}{version, apiVersion, commit, buildDate, runtime.Version()})
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Health", func() {
+	var savedPing func(time.Duration) error
+	var storageErr error
+
+	BeforeEach(func() {
+		savedPing = pingStorage
+		storageErr = nil
+		pingStorage = func(time.Duration) error { return storageErr }
+	})
+
+	AfterEach(func() {
+		pingStorage = savedPing
+		atomic.StoreInt32(&draining, 0)
+	})
+
+	get := func(path string) (int, map[string]interface{}) {
+		rec := httptest.NewRecorder()
+		createRoute().ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		var body map[string]interface{}
+		Expect(json.Unmarshal(rec.Body.Bytes(), &body)).To(Succeed())
+		return rec.Code, body
+	}
+
+	It("should be alive", func() {
+		code, body := get("/healthz")
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(body["status"]).To(Equal("ok"))
+	})
+
+	It("should follow the storage state", func() {
+		code, body := get("/readyz")
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(body["status"]).To(Equal("ready"))
+
+		storageErr = errors.New("no reachable servers")
+		code, body = get("/readyz")
+		Expect(code).To(Equal(http.StatusServiceUnavailable))
+		Expect(body["status"]).To(Equal("degraded"))
+		Expect(body["checks"]).To(HaveKeyWithValue("storage", HaveKeyWithValue("error", "no reachable servers")))
+
+		storageErr = nil
+		code, _ = get("/readyz")
+		Expect(code).To(Equal(http.StatusOK))
+	})
+
+	It("should not be ready while draining", func() {
+		atomic.StoreInt32(&draining, 1)
+		code, body := get("/readyz")
+		Expect(code).To(Equal(http.StatusServiceUnavailable))
+		Expect(body["status"]).To(Equal("draining"))
+	})
+
+	It("should show the build info", func() {
+		code, body := get("/version")
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(body["version"]).To(Equal(version))
+		Expect(body["api_version"]).To(Equal(apiVersion))
+		Expect(body).To(HaveKey("go_version"))
+	})
+})
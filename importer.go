@@ -0,0 +1,692 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// import formats. Each is also the source of imported dummies
+const (
+	sourcePostman  = "postman"
+	sourceWireMock = "wiremock"
+	sourceMockoon  = "mockoon"
+)
+
+// importResult collects dummies converted from mock definitions and
+// constructs which could not be translated
+type importResult struct {
+	dummies      []dummyModel
+	untranslated []string
+}
+
+// skip reports a construct which is not translated
+func (res *importResult) skip(where, format string, args ...interface{}) {
+	res.untranslated = append(res.untranslated, where+": "+fmt.Sprintf(format, args...))
+}
+
+// addMatcher appends the matcher to the dummy if it is valid
+func (res *importResult) addMatcher(dummy *dummyModel, where string, m matcherModel) {
+	if err := m.validate(); err != nil {
+		res.skip(where, "%s", err.Error())
+		return
+	}
+	dummy.Matchers = append(dummy.Matchers, m)
+}
+
+// newImportedDummy creates a dummy. Content-Type header is split into the content type and the charset
+func newImportedDummy(method, path string, status int, headers map[string]string, body string) dummyModel {
+	contentType, charset := "", ""
+	for name, v := range headers {
+		if strings.EqualFold(name, "Content-Type") {
+			if mediaType, params, err := mime.ParseMediaType(v); err == nil {
+				contentType, charset = mediaType, params["charset"]
+			}
+			delete(headers, name)
+		}
+	}
+	if contentType == "" {
+		contentType = "text/plain"
+		var v interface{}
+		if body != "" && json.Unmarshal([]byte(body), &v) == nil {
+			contentType = "application/json"
+		}
+	}
+	if charset == "" {
+		charset = "utf-8"
+	}
+	if status == 0 {
+		status = http.StatusOK
+	}
+	headersJSON, _ := json.Marshal(headers)
+	return dummyModel{
+		Method:      strings.ToUpper(method),
+		Path:        path,
+		Version:     apiVersion,
+		Content:     body,
+		Charset:     charset,
+		ContentType: contentType,
+		Headers:     string(headersJSON),
+		Status:      status,
+		CreatedAt:   time.Now(),
+	}
+}
+
+// detectImportFormat guesses the format of the document
+func detectImportFormat(doc map[string]interface{}) string {
+	if _, ok := doc["item"].([]interface{}); ok {
+		return sourcePostman
+	}
+	if _, ok := doc["mappings"]; ok {
+		return sourceWireMock
+	}
+	if _, ok := doc["request"]; ok {
+		if _, ok := doc["response"]; ok {
+			return sourceWireMock
+		}
+	}
+	if _, ok := doc["routes"].([]interface{}); ok {
+		return sourceMockoon
+	}
+	return ""
+}
+
+// importMocks converts a Postman collection, WireMock mappings or a Mockoon environment into dummies.
+// The format is detected if it is empty
+func importMocks(format string, raw []byte) (string, *importResult, error) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return "", nil, err
+	}
+	if format == "" {
+		format = detectImportFormat(doc)
+	}
+
+	res := &importResult{}
+	switch format {
+	case sourcePostman:
+		res.importPostman(doc)
+	case sourceWireMock:
+		res.importWireMock(doc)
+	case sourceMockoon:
+		res.importMockoon(doc)
+	default:
+		return "", nil, errors.New("format should be one of postman, wiremock and mockoon")
+	}
+	return format, res, nil
+}
+
+// templatePath converts path variables like :id or {{id}} into {id}
+func templatePath(segments []string) string {
+	converted := make([]string, 0, len(segments))
+	for _, seg := range segments {
+		if seg == "" {
+			continue
+		}
+		if strings.HasPrefix(seg, ":") {
+			seg = "{" + seg[1:] + "}"
+		} else if strings.HasPrefix(seg, "{{") && strings.HasSuffix(seg, "}}") {
+			seg = "{" + strings.TrimSpace(seg[2:len(seg)-2]) + "}"
+		}
+		converted = append(converted, seg)
+	}
+	return "/" + strings.Join(converted, "/")
+}
+
+// keyValues converts a list of {key, value, disabled} into a map
+func keyValues(v interface{}) map[string]string {
+	kv := map[string]string{}
+	list, _ := v.([]interface{})
+	for _, item := range list {
+		m, _ := item.(map[string]interface{})
+		key, _ := m["key"].(string)
+		if disabled, _ := m["disabled"].(bool); disabled || key == "" {
+			continue
+		}
+		kv[key] = fmt.Sprint(m["value"])
+	}
+	return kv
+}
+
+func toInt(v interface{}) int {
+	switch n := v.(type) {
+	case float64:
+		return int(n)
+	case string:
+		i, _ := strconv.Atoi(n)
+		return i
+	}
+	return 0
+}
+
+// importPostman converts saved example responses of a Postman collection
+func (res *importResult) importPostman(doc map[string]interface{}) {
+	if _, ok := doc["event"]; ok {
+		res.skip("collection", "pre-request and test scripts are not supported")
+	}
+	items, _ := doc["item"].([]interface{})
+	res.importPostmanItems(items, "")
+}
+
+func (res *importResult) importPostmanItems(items []interface{}, folder string) {
+	for _, v := range items {
+		item, _ := v.(map[string]interface{})
+		if item == nil {
+			continue
+		}
+		name, _ := item["name"].(string)
+		where := folder + "/" + name
+		if sub, ok := item["item"].([]interface{}); ok {
+			res.importPostmanItems(sub, where)
+			continue
+		}
+		if _, ok := item["event"]; ok {
+			res.skip(where, "pre-request and test scripts are not supported")
+		}
+
+		responses, _ := item["response"].([]interface{})
+		if len(responses) == 0 {
+			res.skip(where, "no saved example responses")
+			continue
+		}
+		for _, rv := range responses {
+			resp, _ := rv.(map[string]interface{})
+			if resp == nil {
+				continue
+			}
+			exampleName, _ := resp["name"].(string)
+			exampleWhere := where + " > " + exampleName
+
+			req := resp["originalRequest"]
+			if req == nil {
+				req = item["request"]
+			}
+			method, path, query := postmanRequest(req)
+
+			headers := keyValues(resp["header"])
+			if _, ok := headers["Content-Type"]; !ok {
+				switch resp["_postman_previewlanguage"] {
+				case "json":
+					headers["Content-Type"] = "application/json"
+				case "xml":
+					headers["Content-Type"] = "application/xml"
+				case "html":
+					headers["Content-Type"] = "text/html"
+				}
+			}
+			if cookies, ok := resp["cookie"].([]interface{}); ok && len(cookies) > 0 {
+				res.skip(exampleWhere, "cookies are not supported")
+			}
+			body, _ := resp["body"].(string)
+
+			dummy := newImportedDummy(method, path, toInt(resp["code"]), headers, body)
+			for _, name := range sortedKeys(query) {
+				m := matcherModel{In: "query", Name: name, Op: matchEquals, Value: query[name]}
+				if strings.Contains(m.Value, "{{") {
+					// variables can not be resolved, so the parameter only has to exist
+					m = matcherModel{In: "query", Name: name, Op: matchPresent}
+				}
+				res.addMatcher(&dummy, exampleWhere, m)
+			}
+			res.dummies = append(res.dummies, dummy)
+		}
+	}
+}
+
+// postmanRequest returns the method, the path template and query parameters of a Postman request
+func postmanRequest(v interface{}) (string, string, map[string]string) {
+	method := "GET"
+	var u interface{} = v
+	if req, ok := v.(map[string]interface{}); ok {
+		if m, ok := req["method"].(string); ok && m != "" {
+			method = m
+		}
+		u = req["url"]
+	}
+
+	switch t := u.(type) {
+	case string:
+		path, query := splitRawURL(t)
+		return method, path, query
+	case map[string]interface{}:
+		if segments, ok := t["path"].([]interface{}); ok {
+			strs := make([]string, len(segments))
+			for i, seg := range segments {
+				strs[i] = fmt.Sprint(seg)
+			}
+			return method, templatePath(strs), keyValues(t["query"])
+		}
+		raw, _ := t["raw"].(string)
+		path, query := splitRawURL(raw)
+		return method, path, query
+	}
+	return method, "/", map[string]string{}
+}
+
+// splitRawURL splits a URL like {{baseUrl}}/pets/:id?limit=1 into the path template and the query
+func splitRawURL(raw string) (string, map[string]string) {
+	query := map[string]string{}
+	if i := strings.Index(raw, "?"); i >= 0 {
+		if values, err := url.ParseQuery(raw[i+1:]); err == nil {
+			for k := range values {
+				query[k] = values.Get(k)
+			}
+		}
+		raw = raw[:i]
+	}
+	if i := strings.Index(raw, "://"); i >= 0 {
+		raw = raw[i+3:]
+	}
+	if !strings.HasPrefix(raw, "/") {
+		// drop the host which may be a variable like {{baseUrl}}
+		if i := strings.Index(raw, "/"); i >= 0 {
+			raw = raw[i:]
+		} else {
+			raw = "/"
+		}
+	}
+	return templatePath(strings.Split(raw, "/")), query
+}
+
+var regexMeta = regexp.MustCompile(`[\\.+*?()|\[\]{}^$]`)
+
+// importWireMock converts WireMock stub mappings. A single mapping or {"mappings": [...]} is accepted
+func (res *importResult) importWireMock(doc map[string]interface{}) {
+	var mappings []interface{}
+	if list, ok := doc["mappings"].([]interface{}); ok {
+		mappings = list
+	} else {
+		mappings = []interface{}{doc}
+	}
+
+	// WireMock serves the mapping with the lowest priority first. 5 is the default
+	sort.SliceStable(mappings, func(i, j int) bool {
+		pi, pj := 5, 5
+		if m, ok := mappings[i].(map[string]interface{}); ok && m["priority"] != nil {
+			pi = toInt(m["priority"])
+		}
+		if m, ok := mappings[j].(map[string]interface{}); ok && m["priority"] != nil {
+			pj = toInt(m["priority"])
+		}
+		return pi < pj
+	})
+
+	for i, v := range mappings {
+		mapping, _ := v.(map[string]interface{})
+		if mapping == nil {
+			continue
+		}
+		where := fmt.Sprintf("mapping #%d", i+1)
+		if name, ok := mapping["name"].(string); ok {
+			where = name
+		} else if id, ok := mapping["id"].(string); ok {
+			where = id
+		}
+		req, _ := mapping["request"].(map[string]interface{})
+		resp, _ := mapping["response"].(map[string]interface{})
+		if req == nil || resp == nil {
+			res.skip(where, "request or response is missing")
+			continue
+		}
+		if _, ok := mapping["scenarioName"]; ok {
+			res.skip(where, "scenarios are not supported")
+		}
+		res.importWireMockMapping(where, req, resp)
+	}
+}
+
+func (res *importResult) importWireMockMapping(where string, req, resp map[string]interface{}) {
+	var path string
+	var pathMatcher *matcherModel
+	query := map[string]string{}
+	if u, ok := req["url"].(string); ok {
+		path, query = splitRawURL(u)
+	} else if u, ok := req["urlPath"].(string); ok {
+		path = templatePath(strings.Split(u, "/"))
+	} else if pattern, ok := req["urlPathPattern"].(string); ok {
+		path, pathMatcher = patternPath(pattern)
+	} else if pattern, ok := req["urlPattern"].(string); ok {
+		if strings.Contains(pattern, `\?`) {
+			res.skip(where, "query in urlPattern is not supported")
+			pattern = pattern[:strings.Index(pattern, `\?`)]
+		}
+		path, pathMatcher = patternPath(pattern)
+	} else {
+		res.skip(where, "mapping without url is not supported")
+		return
+	}
+
+	headers := map[string]string{}
+	if hdrs, ok := resp["headers"].(map[string]interface{}); ok {
+		for name, v := range hdrs {
+			if list, ok := v.([]interface{}); ok {
+				values := make([]string, len(list))
+				for i, item := range list {
+					values[i] = fmt.Sprint(item)
+				}
+				headers[name] = strings.Join(values, ", ")
+			} else {
+				headers[name] = fmt.Sprint(v)
+			}
+		}
+	}
+
+	var body string
+	if v, ok := resp["body"].(string); ok {
+		body = v
+	} else if v, ok := resp["jsonBody"]; ok {
+		byt, _ := json.Marshal(v)
+		body = string(byt)
+		if _, ok := headers["Content-Type"]; !ok {
+			headers["Content-Type"] = "application/json"
+		}
+	} else if v, ok := resp["base64Body"].(string); ok {
+		byt, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			res.skip(where, "invalid base64Body")
+		}
+		body = string(byt)
+	} else if v, ok := resp["bodyFileName"].(string); ok {
+		res.skip(where, "bodyFileName '%s' is not supported", v)
+	}
+
+	for _, key := range []string{"delayDistribution", "chunkedDribbleDelay", "fault", "transformers", "proxyBaseUrl", "statusMessage"} {
+		if _, ok := resp[key]; ok {
+			res.skip(where, "%s is not supported", key)
+		}
+	}
+	if _, ok := req["cookies"]; ok {
+		res.skip(where, "cookie matchers are not supported")
+	}
+	if _, ok := req["multipartPatterns"]; ok {
+		res.skip(where, "multipart matchers are not supported")
+	}
+
+	method, _ := req["method"].(string)
+	methods := []string{method}
+	if method == "" || method == "ANY" {
+		methods = projectMethods
+	}
+	for _, method := range methods {
+		dummy := newImportedDummy(method, path, toInt(resp["status"]), copyHeaders(headers), body)
+		dummy.Delay = toInt(resp["fixedDelayMilliseconds"])
+		if pathMatcher != nil {
+			res.addMatcher(&dummy, where, *pathMatcher)
+		}
+		for _, name := range sortedKeys(query) {
+			res.addMatcher(&dummy, where, matcherModel{In: "query", Name: name, Op: matchEquals, Value: query[name]})
+		}
+		res.addWireMockMatchers(&dummy, where, "query", req["queryParameters"])
+		res.addWireMockMatchers(&dummy, where, "header", req["headers"])
+		if auth, ok := req["basicAuthCredentials"].(map[string]interface{}); ok {
+			credentials := fmt.Sprintf("%v:%v", auth["username"], auth["password"])
+			res.addMatcher(&dummy, where, matcherModel{
+				In:    "header",
+				Name:  "Authorization",
+				Op:    matchEquals,
+				Value: "Basic " + base64.StdEncoding.EncodeToString([]byte(credentials)),
+			})
+		}
+		if patterns, ok := req["bodyPatterns"].([]interface{}); ok {
+			for _, p := range patterns {
+				pattern, _ := p.(map[string]interface{})
+				if m, ok := res.wireMockMatcher(where, "body", "", pattern); ok {
+					res.addMatcher(&dummy, where, m)
+				}
+			}
+		}
+		res.dummies = append(res.dummies, dummy)
+	}
+}
+
+// patternPath converts a path regex into a path template. Segments with regex become
+// variables and the regex is kept as a path matcher
+func patternPath(pattern string) (string, *matcherModel) {
+	pattern = strings.TrimSuffix(strings.TrimPrefix(pattern, "^"), "$")
+	segments := strings.Split(pattern, "/")
+	for i, seg := range segments {
+		if regexMeta.MatchString(seg) {
+			segments[i] = fmt.Sprintf("{param%d}", i)
+		}
+	}
+	return templatePath(segments), &matcherModel{In: "path", Op: matchRegex, Value: "^" + pattern + "$"}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func copyHeaders(headers map[string]string) map[string]string {
+	copied := make(map[string]string, len(headers))
+	for k, v := range headers {
+		copied[k] = v
+	}
+	return copied
+}
+
+// addWireMockMatchers converts {"name": {"equalTo": "value"}} style matchers
+func (res *importResult) addWireMockMatchers(dummy *dummyModel, where, in string, v interface{}) {
+	patterns, _ := v.(map[string]interface{})
+	names := make([]string, 0, len(patterns))
+	for name := range patterns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		pattern, _ := patterns[name].(map[string]interface{})
+		if m, ok := res.wireMockMatcher(where, in, name, pattern); ok {
+			res.addMatcher(dummy, where, m)
+		}
+	}
+}
+
+// wireMockMatcher converts a WireMock value pattern into a matcher
+func (res *importResult) wireMockMatcher(where, in, name string, pattern map[string]interface{}) (matcherModel, bool) {
+	m := matcherModel{In: in, Name: name}
+	target := strings.TrimSpace(in + " " + name)
+	if caseInsensitive, _ := pattern["caseInsensitive"].(bool); caseInsensitive {
+		res.skip(where, "case insensitive matching on %s is not supported", target)
+	}
+	switch {
+	case pattern["equalTo"] != nil:
+		m.Op, m.Value = matchEquals, fmt.Sprint(pattern["equalTo"])
+	case pattern["contains"] != nil:
+		m.Op, m.Value = matchContains, fmt.Sprint(pattern["contains"])
+	case pattern["matches"] != nil:
+		m.Op, m.Value = matchRegex, fmt.Sprint(pattern["matches"])
+	case pattern["absent"] != nil:
+		if absent, _ := pattern["absent"].(bool); absent {
+			m.Op = matchAbsent
+		} else {
+			m.Op = matchPresent
+		}
+	case pattern["equalToJson"] != nil:
+		m.Op = matchJSON
+		if str, ok := pattern["equalToJson"].(string); ok {
+			m.Value = str
+		} else {
+			byt, _ := json.Marshal(pattern["equalToJson"])
+			m.Value = string(byt)
+		}
+	default:
+		keys := make([]string, 0, len(pattern))
+		for k := range pattern {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		res.skip(where, "matcher %v on %s is not supported", keys, target)
+		return m, false
+	}
+	return m, true
+}
+
+// importMockoon converts routes of a Mockoon environment
+func (res *importResult) importMockoon(doc map[string]interface{}) {
+	prefix, _ := doc["endpointPrefix"].(string)
+	envLatency := toInt(doc["latency"])
+	envHeaders := keyValues(doc["headers"])
+	routes, _ := doc["routes"].([]interface{})
+
+	for _, v := range routes {
+		route, _ := v.(map[string]interface{})
+		if route == nil {
+			continue
+		}
+		method, _ := route["method"].(string)
+		endpoint, _ := route["endpoint"].(string)
+		where := strings.ToUpper(method) + " /" + endpoint
+		if strings.ContainsAny(endpoint, "*()?") {
+			res.skip(where, "wildcard and regex endpoints are not supported")
+			continue
+		}
+		if mode, ok := route["responseMode"].(string); ok && mode != "" {
+			res.skip(where, "response mode %s is not supported", mode)
+		}
+		if sequential, _ := route["sequentialResponse"].(bool); sequential {
+			res.skip(where, "sequential responses are not supported")
+		}
+		if random, _ := route["randomResponse"].(bool); random {
+			res.skip(where, "random responses are not supported")
+		}
+		path := templatePath(strings.Split(prefix+"/"+endpoint, "/"))
+
+		responses, _ := route["responses"].([]interface{})
+		for i, rv := range responses {
+			resp, _ := rv.(map[string]interface{})
+			if resp == nil {
+				continue
+			}
+			respWhere := fmt.Sprintf("%s > response #%d", where, i+1)
+			if label, ok := resp["label"].(string); ok && label != "" {
+				respWhere = where + " > " + label
+			}
+			if bodyType, ok := resp["bodyType"].(string); ok && bodyType != "INLINE" {
+				res.skip(respWhere, "body type %s is not supported", bodyType)
+			} else if filePath, ok := resp["filePath"].(string); ok && filePath != "" {
+				res.skip(respWhere, "file body '%s' is not supported", filePath)
+			}
+			body, _ := resp["body"].(string)
+			if strings.Contains(body, "{{") {
+				res.skip(respWhere, "templating helpers are sent as is")
+			}
+			rules, _ := resp["rules"].([]interface{})
+			if operator, _ := resp["rulesOperator"].(string); operator == "OR" && len(rules) > 1 {
+				res.skip(respWhere, "rules combined with OR are not supported")
+				continue
+			}
+
+			headers := copyHeaders(envHeaders)
+			for k, v := range keyValues(resp["headers"]) {
+				headers[k] = v
+			}
+			dummy := newImportedDummy(method, path, toInt(resp["statusCode"]), headers, body)
+			dummy.Delay = envLatency + toInt(resp["latency"])
+			for _, rule := range rules {
+				res.addMockoonRule(&dummy, respWhere, rule)
+			}
+			res.dummies = append(res.dummies, dummy)
+		}
+	}
+}
+
+func (res *importResult) addMockoonRule(dummy *dummyModel, where string, v interface{}) {
+	rule, _ := v.(map[string]interface{})
+	target, _ := rule["target"].(string)
+	modifier, _ := rule["modifier"].(string)
+	operator, _ := rule["operator"].(string)
+	value := fmt.Sprint(rule["value"])
+	if operator == "" {
+		operator = "equals"
+	}
+
+	if invert, _ := rule["invert"].(bool); invert {
+		res.skip(where, "inverted rule on %s %s is not supported", target, modifier)
+		return
+	}
+	m := matcherModel{In: target, Name: modifier}
+	switch target {
+	case "query", "header":
+	case "body":
+		if modifier != "" {
+			res.skip(where, "rule on body path '%s' is not supported", modifier)
+			return
+		}
+	default:
+		res.skip(where, "rule on %s is not supported", target)
+		return
+	}
+	switch operator {
+	case "equals":
+		m.Op, m.Value = matchEquals, value
+	case "regex":
+		m.Op, m.Value = matchRegex, value
+	case "null":
+		m.Op = matchAbsent
+	default:
+		res.skip(where, "rule operator %s is not supported", operator)
+		return
+	}
+	res.addMatcher(dummy, where, m)
+}
+
+// handler for POST /projects/:id/import
+// 'format' query is postman, wiremock or mockoon. It is detected from the body if not specified.
+// Dummies which were imported from the same format before are replaced
+func handleImportMocks(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	project := findWritableProject(w, r, ps)
+	if project == nil {
+		return
+	}
+
+	raw, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(requestError(r, errorInvalidData))
+		return
+	}
+	format, res, err := importMocks(r.URL.Query().Get("format"), raw)
+	if err != nil {
+		requestLog(r).Warningf("fail to import mocks %s", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(requestError(r, &errorResponse{"InvalidData", err.Error()}))
+		return
+	}
+
+	imported, err := replaceProjectDummies(r.Context(), project, format, res.dummies)
+	if err != nil {
+		requestLog(r).Errorf("error on saving imported dummies: %s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if res.untranslated == nil {
+		res.untranslated = []string{}
+	}
+	json.NewEncoder(w).Encode(struct {
+		Format       string          `json:"format"`
+		Dummies      []importedDummy `json:"dummies"`
+		Untranslated []string        `json:"untranslated"`
+	}{
+		Format:       format,
+		Dummies:      imported,
+		Untranslated: res.untranslated,
+	})
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const testPostmanCollection = `{
+	"info": {"name": "pets", "schema": "https://schema.getpostman.com/json/collection/v2.1.0/collection.json"},
+	"item": [{
+		"name": "pets",
+		"item": [{
+			"name": "get pet",
+			"event": [{"listen": "test"}],
+			"request": {"method": "GET", "url": "{{baseUrl}}/pets/:id"},
+			"response": [{
+				"name": "found",
+				"originalRequest": {
+					"method": "GET",
+					"url": {"raw": "{{baseUrl}}/pets/:id?fields=name", "path": ["pets", ":id"], "query": [{"key": "fields", "value": "name"}]}
+				},
+				"code": 200,
+				"header": [{"key": "Content-Type", "value": "application/json; charset=utf-8"}, {"key": "X-Rate-Limit", "value": "100"}],
+				"body": "{\"name\": \"doggie\"}"
+			}]
+		}, {
+			"name": "no examples",
+			"request": {"method": "DELETE", "url": "{{baseUrl}}/pets/:id"}
+		}]
+	}]
+}`
+
+const testWireMockMappings = `{
+	"mappings": [{
+		"name": "create pet",
+		"request": {
+			"method": "POST",
+			"urlPathPattern": "/pets/[0-9]+/photos",
+			"headers": {"Authorization": {"matches": "Bearer .+"}},
+			"cookies": {"session": {"equalTo": "1"}},
+			"bodyPatterns": [{"equalToJson": {"name": "doggie"}}, {"matchesJsonPath": "$.name"}]
+		},
+		"response": {
+			"status": 201,
+			"jsonBody": {"id": 1},
+			"headers": {"Location": "/pets/1"},
+			"fixedDelayMilliseconds": 300
+		}
+	}]
+}`
+
+const testMockoonEnvironment = `{
+	"name": "pets",
+	"endpointPrefix": "api",
+	"latency": 100,
+	"headers": [{"key": "Content-Type", "value": "application/json"}],
+	"routes": [{
+		"method": "get",
+		"endpoint": "pets/:id",
+		"responses": [{
+			"label": "dog",
+			"statusCode": 200,
+			"body": "{\"kind\": \"dog\"}",
+			"latency": 50,
+			"headers": [],
+			"rules": [
+				{"target": "query", "modifier": "kind", "value": "dog", "operator": "equals"},
+				{"target": "params", "modifier": "id", "value": "1", "operator": "equals"}
+			],
+			"rulesOperator": "AND"
+		}, {
+			"label": "missing",
+			"statusCode": 404,
+			"body": "{}",
+			"headers": [],
+			"rules": []
+		}]
+	}, {
+		"method": "get",
+		"endpoint": "files/*",
+		"responses": []
+	}]
+}`
+
+var _ = Describe("Importer", func() {
+	Context("with Postman collection", func() {
+		It("should convert saved examples", func() {
+			format, res, err := importMocks("", []byte(testPostmanCollection))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(format).To(Equal(sourcePostman))
+			Expect(res.dummies).To(HaveLen(1))
+
+			dummy := res.dummies[0]
+			Expect(dummy.Method).To(Equal("GET"))
+			Expect(dummy.Path).To(Equal("/pets/{id}"))
+			Expect(dummy.ContentType).To(Equal("application/json"))
+			Expect(dummy.Headers).To(Equal(`{"X-Rate-Limit":"100"}`))
+			Expect(dummy.Matchers).To(Equal([]matcherModel{{In: "query", Name: "fields", Op: matchEquals, Value: "name"}}))
+
+			Expect(res.untranslated).To(ConsistOf(
+				"/pets/get pet: pre-request and test scripts are not supported",
+				"/pets/no examples: no saved example responses",
+			))
+		})
+	})
+
+	Context("with WireMock mappings", func() {
+		It("should convert matchers, body and delay", func() {
+			format, res, err := importMocks("", []byte(testWireMockMappings))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(format).To(Equal(sourceWireMock))
+			Expect(res.dummies).To(HaveLen(1))
+
+			dummy := res.dummies[0]
+			Expect(dummy.Path).To(Equal("/pets/{param2}/photos"))
+			Expect(dummy.Status).To(Equal(201))
+			Expect(dummy.Content).To(Equal(`{"id":1}`))
+			Expect(dummy.ContentType).To(Equal("application/json"))
+			Expect(dummy.Delay).To(Equal(300))
+			Expect(dummy.Matchers).To(Equal([]matcherModel{
+				{In: "path", Op: matchRegex, Value: "^/pets/[0-9]+/photos$"},
+				{In: "header", Name: "Authorization", Op: matchRegex, Value: "Bearer .+"},
+				{In: "body", Op: matchJSON, Value: `{"name":"doggie"}`},
+			}))
+			Expect(res.untranslated).To(ConsistOf(
+				"create pet: cookie matchers are not supported",
+				"create pet: matcher [matchesJsonPath] on body is not supported",
+			))
+		})
+	})
+
+	Context("with Mockoon environment", func() {
+		It("should convert routes and rules", func() {
+			format, res, err := importMocks("", []byte(testMockoonEnvironment))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(format).To(Equal(sourceMockoon))
+			Expect(res.dummies).To(HaveLen(2))
+
+			Expect(res.dummies[0].Path).To(Equal("/api/pets/{id}"))
+			Expect(res.dummies[0].Delay).To(Equal(150))
+			Expect(res.dummies[0].ContentType).To(Equal("application/json"))
+			Expect(res.dummies[0].Matchers).To(Equal([]matcherModel{{In: "query", Name: "kind", Op: matchEquals, Value: "dog"}}))
+			Expect(res.dummies[1].Status).To(Equal(404))
+			Expect(res.dummies[1].Matchers).To(BeEmpty())
+
+			Expect(res.untranslated).To(ConsistOf(
+				"GET /pets/:id > dog: rule on params is not supported",
+				"GET /files/*: wildcard and regex endpoints are not supported",
+			))
+		})
+	})
+
+	Context("with unknown format", func() {
+		It("should fail", func() {
+			_, _, err := importMocks("", []byte(`{"foo": "bar"}`))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// jsonpIdentifier matches a JavaScript identifier. Anything else in a callback could inject script
+var jsonpIdentifier = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$]{0,127}$`)
+
+var errorInvalidCallback = &errorResponse{"InvalidCallback", "callback should be a JavaScript identifier"}
+
+// jsonpCallback returns the callback of a JSONP request. It is empty if the request is not JSONP
+func jsonpCallback(r *http.Request) (string, error) {
+	callback := r.URL.Query().Get("callback")
+	if callback != "" && !jsonpIdentifier.MatchString(callback) {
+		return "", errorInvalidCallback
+	}
+	return callback, nil
+}
+
+// isJSONContentType reports whether the content type is JSON. e.g. application/json or application/problem+json
+func isJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || strings.HasPrefix(mediaType, "application/") && strings.HasSuffix(mediaType, "+json")
+}
+
+// jsonpLineTerminators escapes U+2028 and U+2029. They are valid in JSON strings but end lines in
+// JavaScript before ES2019
+var jsonpLineTerminators = strings.NewReplacer("\u2028", `\u2028`, "\u2029", `\u2029`)
+
+// wrapJSONP wraps JSON in a call of the callback. The leading comment keeps the script from being
+// taken as another type by plugins
+func wrapJSONP(callback string, body []byte) []byte {
+	return []byte("/**/" + callback + "(" + jsonpLineTerminators.Replace(string(body)) + ");")
+}
+
+// applyJSONP turns JSON content of the dummy into a script which calls the callback.
+// Dummies with no_jsonp or content other than JSON are left as they are
+func applyJSONP(w http.ResponseWriter, dummyOne *dummyModel, callback string) {
+	if callback == "" || dummyOne.NoJSONP || !isJSONContentType(dummyOne.ContentType) {
+		return
+	}
+	dummyOne.Content = string(wrapJSONP(callback, []byte(dummyOne.Content)))
+	dummyOne.ContentType = "application/javascript"
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+}
+
+// jsonpArgument is the echoed body as a JSON value. A body other than JSON is a string, and no body is null
+func jsonpArgument(body []byte) []byte {
+	trimmed := strings.TrimSpace(string(body))
+	if trimmed == "" {
+		return []byte("null")
+	}
+	if json.Valid([]byte(trimmed)) {
+		return []byte(trimmed)
+	}
+	quoted, _ := json.Marshal(string(body))
+	return quoted
+}
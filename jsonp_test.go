@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("JSONP", func() {
+	serve := func(dummy dummyModel, callback string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		applyJSONP(w, &dummy, callback)
+		writeDummy(w, httptest.NewRequest("GET", "/v1/id?callback="+callback, nil), &dummy, 0)
+		return w
+	}
+
+	It("should wrap JSON content of dummies", func() {
+		dummy := dummyModel{Status: 200, ContentType: "application/json", Charset: "utf-8", Content: `{"ok": true}`}
+		w := serve(dummy, "handle")
+		Expect(w.Header().Get("Content-Type")).To(Equal("application/javascript; charset=utf-8"))
+		Expect(w.Header().Get("X-Content-Type-Options")).To(Equal("nosniff"))
+		Expect(w.Body.String()).To(Equal(`/**/handle({"ok": true});`))
+
+		dummy.ContentType = "application/problem+json"
+		Expect(serve(dummy, "handle").Body.String()).To(Equal(`/**/handle({"ok": true});`))
+
+		dummy.Content = "{\"text\": \"a\u2028b\u2029c\"}"
+		Expect(serve(dummy, "handle").Body.String()).To(Equal(`/**/handle({"text": "a\u2028b\u2029c"});`))
+	})
+
+	It("should leave dummies other than JSON or with no_jsonp as they are", func() {
+		dummy := dummyModel{Status: 200, ContentType: "application/json", Charset: "utf-8", Content: `{"ok": true}`, NoJSONP: true}
+		w := serve(dummy, "handle")
+		Expect(w.Header().Get("Content-Type")).To(Equal("application/json; charset=utf-8"))
+		Expect(w.Body.String()).To(Equal(`{"ok": true}`))
+
+		dummy = dummyModel{Status: 200, ContentType: "text/html", Charset: "utf-8", Content: "<p>"}
+		Expect(serve(dummy, "handle").Body.String()).To(Equal("<p>"))
+		dummy.ContentType = "application/json"
+		Expect(serve(dummy, "").Body.String()).To(Equal("<p>"))
+	})
+
+	It("should accept identifiers only", func() {
+		for callback, ok := range map[string]bool{"cb": true, "$_jQuery3_1": true, "": true, "a.b": false, "cb()": false, "x ": false} {
+			req, _ := http.NewRequest("GET", "/v1/id", nil)
+			req.URL.RawQuery = "callback=" + callback
+			_, err := jsonpCallback(req)
+			Expect(err == nil).To(Equal(ok), callback)
+		}
+	})
+})
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// standard error codes of JSON-RPC 2.0
+const (
+	jsonRPCParseError     = -32700
+	jsonRPCInvalidRequest = -32600
+	jsonRPCMethodNotFound = -32601
+	jsonRPCInvalidParams  = -32602
+)
+
+// jsonRPCModel makes a dummy a JSON-RPC 2.0 endpoint. A call is answered by the method whose matchers
+// match its params. The one with more matchers wins, and the first one wins on a tie
+//
+//	"jsonrpc": {"methods": [
+//	  {"method": "eth_blockNumber", "result": "0x10"},
+//	  {"method": "eth_getBalance", "matchers": [{"in": "body", "name": "0", "op": "equals", "value": "0xabc"}], "result": "0x0"},
+//	  {"method": "eth_call", "error": {"code": -32000, "message": "execution reverted"}}
+//	]}
+type jsonRPCModel struct {
+	Methods []jsonRPCMethod `json:"methods"`
+}
+
+type jsonRPCMethod struct {
+	Method   string          `json:"method"`
+	Matchers []matcherModel  `json:"matchers"` // params are matched as a JSON body. e.g. name 0 or user.id
+	Result   json.RawMessage `json:"result,omitempty"`
+	Error    *jsonRPCError   `json:"error,omitempty"` // sent instead of the result
+}
+
+type jsonRPCError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+type jsonRPCResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *jsonRPCError   `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+var jsonRPCNull = json.RawMessage("null")
+
+// validate jsonRPCModel. do not trust any input
+func (m *jsonRPCModel) validate() error {
+	if len(m.Methods) == 0 {
+		return errors.New("jsonrpc has no method")
+	}
+	for i, method := range m.Methods {
+		if method.Method == "" {
+			return fmt.Errorf("methods[%d]: method is empty", i)
+		}
+		if method.Error != nil {
+			if len(method.Result) > 0 {
+				return fmt.Errorf("methods[%d]: set either result or error", i)
+			}
+			if method.Error.Message == "" {
+				return fmt.Errorf("methods[%d]: message of the error is empty", i)
+			}
+		}
+		for j := range method.Matchers {
+			if err := method.Matchers[j].validate(); err != nil {
+				return fmt.Errorf("methods[%d]: %s", i, err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+// call answers a request. It returns nil for notifications
+func (m *jsonRPCModel) call(r *http.Request, raw json.RawMessage) *jsonRPCResponse {
+	var members map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &members); err != nil || members == nil {
+		return jsonRPCErrorResponse(jsonRPCNull, jsonRPCInvalidRequest, "Invalid Request")
+	}
+	id, hasID := members["id"]
+	if hasID && !isJSONRPCID(id) {
+		return jsonRPCErrorResponse(jsonRPCNull, jsonRPCInvalidRequest, "Invalid Request")
+	}
+	var version, method string
+	if json.Unmarshal(members["jsonrpc"], &version) != nil || version != "2.0" || json.Unmarshal(members["method"], &method) != nil {
+		if !hasID {
+			id = jsonRPCNull
+		}
+		return jsonRPCErrorResponse(id, jsonRPCInvalidRequest, "Invalid Request")
+	}
+	params := bytes.TrimSpace(members["params"])
+	if len(params) > 0 && params[0] != '[' && params[0] != '{' {
+		if !hasID {
+			return nil
+		}
+		return jsonRPCErrorResponse(id, jsonRPCInvalidParams, "Invalid params")
+	}
+
+	res := m.answer(r, method, params)
+	if !hasID {
+		return nil
+	}
+	res.ID = id
+	return res
+}
+
+// answer returns the response of the method whose matchers match the params
+func (m *jsonRPCModel) answer(r *http.Request, method string, params []byte) *jsonRPCResponse {
+	var found *jsonRPCMethod
+	known := false
+	bestMatchers := -1
+	for i := range m.Methods {
+		candidate := &m.Methods[i]
+		if candidate.Method != method {
+			continue
+		}
+		known = true
+		if len(candidate.Matchers) <= bestMatchers {
+			continue
+		}
+		matched := true
+		for j := range candidate.Matchers {
+			if !candidate.Matchers[j].match(r, r.URL.Path, params) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			found, bestMatchers = candidate, len(candidate.Matchers)
+		}
+	}
+	switch {
+	case !known:
+		return jsonRPCErrorResponse(nil, jsonRPCMethodNotFound, "Method not found")
+	case found == nil:
+		return jsonRPCErrorResponse(nil, jsonRPCInvalidParams, "Invalid params")
+	case found.Error != nil:
+		return &jsonRPCResponse{JSONRPC: "2.0", Error: found.Error}
+	case len(found.Result) == 0:
+		return &jsonRPCResponse{JSONRPC: "2.0", Result: jsonRPCNull}
+	}
+	return &jsonRPCResponse{JSONRPC: "2.0", Result: found.Result}
+}
+
+func jsonRPCErrorResponse(id json.RawMessage, code int, message string) *jsonRPCResponse {
+	return &jsonRPCResponse{JSONRPC: "2.0", Error: &jsonRPCError{Code: code, Message: message}, ID: id}
+}
+
+// isJSONRPCID reports whether the id is a string, a number or null
+func isJSONRPCID(id json.RawMessage) bool {
+	var v interface{}
+	if json.Unmarshal(id, &v) != nil {
+		return false
+	}
+	switch v.(type) {
+	case string, float64, nil:
+		return true
+	}
+	return false
+}
+
+// dummyJSONRPC returns the JSON-RPC config of the dummy
+func dummyJSONRPC(dummyOne *dummyModel) (*jsonRPCModel, error) {
+	var m jsonRPCModel
+	if err := json.Unmarshal([]byte(dummyOne.JSONRPC), &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// serveJSONRPC answers a call or a batch of calls with the dummy. Notifications get no response,
+// and a request of notifications only is answered with 204
+func serveJSONRPC(w http.ResponseWriter, r *http.Request, dummyOne *dummyModel) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSONRPC(w, http.StatusMethodNotAllowed, jsonRPCErrorResponse(jsonRPCNull, jsonRPCInvalidRequest, "JSON-RPC is served on POST"))
+		return
+	}
+	m, err := dummyJSONRPC(dummyOne)
+	if err != nil {
+		requestLog(r).Errorf("fail to parse JSON-RPC methods of dummy %s: %s", dummyOne.ID.Hex(), err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if err := setDummyHeaders(w, dummyOne); err != nil {
+		requestLog(r).Errorf("JSON marshal error: %s", err.Error())
+	}
+	countDummyHit(dummyOne)
+
+	body := bytes.TrimSpace(readBody(r))
+	if !json.Valid(body) {
+		writeJSONRPC(w, http.StatusOK, jsonRPCErrorResponse(jsonRPCNull, jsonRPCParseError, "Parse error"))
+		return
+	}
+	if body[0] != '[' {
+		if res := m.call(r, body); res != nil {
+			writeJSONRPC(w, http.StatusOK, res)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var batch []json.RawMessage
+	json.Unmarshal(body, &batch)
+	if len(batch) == 0 {
+		writeJSONRPC(w, http.StatusOK, jsonRPCErrorResponse(jsonRPCNull, jsonRPCInvalidRequest, "Invalid Request"))
+		return
+	}
+	responses := []*jsonRPCResponse{}
+	for _, raw := range batch {
+		if res := m.call(r, raw); res != nil {
+			responses = append(responses, res)
+		}
+	}
+	if len(responses) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSONRPC(w, http.StatusOK, responses)
+}
+
+func writeJSONRPC(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/globalsign/mgo/bson"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("JSON-RPC", func() {
+	var dummy dummyModel
+
+	BeforeEach(func() {
+		dummy = dummyModel{ID: bson.NewObjectId()}
+		var reqModel requestModel
+		Expect(json.Unmarshal([]byte(`{"jsonrpc": {"methods": [
+			{"method": "eth_blockNumber", "result": "0x10"},
+			{"method": "eth_getBalance", "result": "0x0"},
+			{"method": "eth_getBalance", "matchers": [{"in": "body", "name": "0", "op": "equals", "value": "0xabc"}], "result": {"wei": "0x64", "a.b": 1}},
+			{"method": "eth_call", "error": {"code": -32000, "message": "execution reverted", "data": "0x08c379a0"}},
+			{"method": "notify"}
+		]}}`), &reqModel)).To(Succeed())
+		Expect(reqModel.validate()).To(Succeed())
+		Expect(dummy.updateWithRequestData(&reqModel)).To(Succeed())
+		Expect(dummy.ContentType).To(Equal("application/json"))
+	})
+
+	post := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/rpc", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		serveJSONRPC(w, req, &dummy)
+		return w
+	}
+
+	It("should answer by method and echo the id", func() {
+		w := post(`{"jsonrpc": "2.0", "method": "eth_blockNumber", "id": "a1"}`)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Header().Get("Content-Type")).To(Equal("application/json; charset=utf-8"))
+		Expect(w.Body.String()).To(MatchJSON(`{"jsonrpc": "2.0", "result": "0x10", "id": "a1"}`))
+
+		w = post(`{"jsonrpc": "2.0", "method": "eth_getBalance", "params": ["0xabc", "latest"], "id": 7}`)
+		Expect(w.Body.String()).To(MatchJSON(`{"jsonrpc": "2.0", "result": {"wei": "0x64", "a.b": 1}, "id": 7}`))
+		w = post(`{"jsonrpc": "2.0", "method": "eth_getBalance", "params": ["0xdef"], "id": 8}`)
+		Expect(w.Body.String()).To(MatchJSON(`{"jsonrpc": "2.0", "result": "0x0", "id": 8}`))
+
+		w = post(`{"jsonrpc": "2.0", "method": "eth_call", "id": null}`)
+		Expect(w.Body.String()).To(MatchJSON(`{"jsonrpc": "2.0", "error": {"code": -32000, "message": "execution reverted", "data": "0x08c379a0"}, "id": null}`))
+	})
+
+	It("should return standard errors", func() {
+		Expect(post(`{"jsonrpc": "2.0", "method": "nope", "id": 1}`).Body.String()).To(
+			MatchJSON(`{"jsonrpc": "2.0", "error": {"code": -32601, "message": "Method not found"}, "id": 1}`))
+		Expect(post(`{"jsonrpc": "2.0", "method": "eth_call", "params": 1, "id": 1}`).Body.String()).To(
+			MatchJSON(`{"jsonrpc": "2.0", "error": {"code": -32602, "message": "Invalid params"}, "id": 1}`))
+		Expect(post(`{"jsonrpc": "1.0", "method": "eth_call", "id": 1}`).Body.String()).To(
+			MatchJSON(`{"jsonrpc": "2.0", "error": {"code": -32600, "message": "Invalid Request"}, "id": 1}`))
+		Expect(post(`{"jsonrpc": "2.0", "method": "eth_call", "id": {}}`).Body.String()).To(
+			MatchJSON(`{"jsonrpc": "2.0", "error": {"code": -32600, "message": "Invalid Request"}, "id": null}`))
+		Expect(post(`{"jsonrpc": "2.0", "method"`).Body.String()).To(
+			MatchJSON(`{"jsonrpc": "2.0", "error": {"code": -32700, "message": "Parse error"}, "id": null}`))
+		Expect(post(`[]`).Body.String()).To(
+			MatchJSON(`{"jsonrpc": "2.0", "error": {"code": -32600, "message": "Invalid Request"}, "id": null}`))
+	})
+
+	It("should answer batches without notifications", func() {
+		w := post(`[
+			{"jsonrpc": "2.0", "method": "eth_blockNumber", "id": 1},
+			{"jsonrpc": "2.0", "method": "notify", "params": {"a": 1}},
+			{"jsonrpc": "2.0", "method": "nope"},
+			1,
+			{"jsonrpc": "2.0", "method": "nope", "id": 2}
+		]`)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(MatchJSON(`[
+			{"jsonrpc": "2.0", "result": "0x10", "id": 1},
+			{"jsonrpc": "2.0", "error": {"code": -32600, "message": "Invalid Request"}, "id": null},
+			{"jsonrpc": "2.0", "error": {"code": -32601, "message": "Method not found"}, "id": 2}
+		]`))
+
+		w = post(`[{"jsonrpc": "2.0", "method": "notify"}, {"jsonrpc": "2.0", "method": "eth_call"}]`)
+		Expect(w.Code).To(Equal(http.StatusNoContent))
+		Expect(w.Body.Len()).To(BeZero())
+		Expect(post(`{"jsonrpc": "2.0", "method": "notify"}`).Code).To(Equal(http.StatusNoContent))
+		Expect(post(`{"jsonrpc": "2.0", "method": "notify", "id": 3}`).Body.String()).To(MatchJSON(`{"jsonrpc": "2.0", "result": null, "id": 3}`))
+	})
+
+	It("should validate methods", func() {
+		Expect((&jsonRPCModel{}).validate()).NotTo(Succeed())
+		Expect((&jsonRPCModel{Methods: []jsonRPCMethod{{}}}).validate()).NotTo(Succeed())
+		Expect((&jsonRPCModel{Methods: []jsonRPCMethod{{Method: "a", Result: json.RawMessage(`1`), Error: &jsonRPCError{Message: "x"}}}}).validate()).NotTo(Succeed())
+		Expect((&jsonRPCModel{Methods: []jsonRPCMethod{{Method: "a", Error: &jsonRPCError{Code: 1}}}}).validate()).NotTo(Succeed())
+		Expect((&jsonRPCModel{Methods: []jsonRPCMethod{{Method: "a", Matchers: []matcherModel{{In: "body", Op: "nope"}}}}}).validate()).NotTo(Succeed())
+		Expect((&requestModel{JSONRPC: &jsonRPCModel{Methods: []jsonRPCMethod{{Method: "a"}}}, SSE: &sseModel{}}).validate()).NotTo(Succeed())
+	})
+})
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/julienschmidt/httprouter"
+)
+
+// longPollModel holds requests to a dummy until it is triggered or the timeout expires.
+// The dummy itself is served on timeout, and the response given to the trigger on release
+type longPollModel struct {
+	Timeout int `json:"timeout"` // milliseconds to hold a request
+}
+
+// validate longPollModel. do not trust any input
+func (m *longPollModel) validate() error {
+	if m.Timeout <= 0 {
+		return errors.New("timeout of long_poll should be positive")
+	}
+	return nil
+}
+
+var errorNotLongPoll = &errorResponse{"NotFound", "No long poll dummy. Check your URL again"}
+
+// pollWaiters are requests held by long poll dummies. They are keyed by the dummy ID,
+// and each is the channel to release it with the context of the request
+type pollWaiters struct {
+	mu      sync.Mutex
+	waiting map[string]map[chan *dummyModel]context.Context
+}
+
+var longPolls = &pollWaiters{waiting: map[string]map[chan *dummyModel]context.Context{}}
+
+// wait holds until the dummy is released, the timeout expires or the request is canceled.
+// It returns the released response, or nil if it is not released
+func (p *pollWaiters) wait(r *http.Request, dummyID string, timeout time.Duration) *dummyModel {
+	ch := make(chan *dummyModel, 1)
+	p.mu.Lock()
+	if p.waiting[dummyID] == nil {
+		p.waiting[dummyID] = map[chan *dummyModel]context.Context{}
+	}
+	p.waiting[dummyID][ch] = r.Context()
+	p.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case released := <-ch:
+		return released
+	case <-timer.C:
+	case <-r.Context().Done():
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if waiters := p.waiting[dummyID]; waiters != nil {
+		delete(waiters, ch)
+		if len(waiters) == 0 {
+			delete(p.waiting, dummyID)
+		}
+	}
+	// the response may have been sent after the timer fired. It is counted as released, so serve it
+	select {
+	case released := <-ch:
+		return released
+	default:
+		return nil
+	}
+}
+
+// release answers all requests held by the dummy with the response. It returns how many received it.
+// Requests whose client has gone away are not counted
+func (p *pollWaiters) release(dummyID string, response *dummyModel) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	released := 0
+	for ch, ctx := range p.waiting[dummyID] {
+		if ctx.Err() != nil {
+			continue
+		}
+		ch <- response
+		released++
+	}
+	delete(p.waiting, dummyID)
+	return released
+}
+
+// count returns how many requests are held by the dummy
+func (p *pollWaiters) count(dummyID string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.waiting[dummyID])
+}
+
+// serveLongPoll holds the request and serves the released response, or the dummy on timeout.
+// JSON of either is served as JSONP with the callback
+func serveLongPoll(w http.ResponseWriter, r *http.Request, dummyOne *dummyModel, status int, callback string) {
+	hold := time.Duration(dummyOne.LongPoll.Timeout) * time.Millisecond
+	if cfg.Limits.MaxDelay > 0 && hold > cfg.Limits.MaxDelay {
+		hold = cfg.Limits.MaxDelay
+	}
+	if cfg.Server.WriteTimeout > 0 {
+		http.NewResponseController(w).SetWriteDeadline(time.Now().Add(hold + cfg.Server.WriteTimeout))
+	}
+
+	released := longPolls.wait(r, dummyOne.ID.Hex(), hold)
+	if r.Context().Err() != nil {
+		requestLog(r).Debug("client has gone away while polling")
+		return
+	}
+	if released != nil {
+		// the released response is shared by every held request
+		response := *released
+		writeContent(w, r, &response, 0, callback)
+		return
+	}
+	writeContent(w, r, dummyOne, status, callback)
+}
+
+// handler for POST /v1/:id/trigger
+// It releases requests held by the long poll dummy with the response in the body
+func handleV1Trigger(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	dummyID := ps.ByName("id")
+	if !bson.IsObjectIdHex(dummyID) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(requestError(r, errorInvalidID))
+		return
+	}
+
+	var dummyOne dummyModel
+	err := observeStore(r.Context(), "find_dummy", func() error {
+		return db.C(collectionDummy).FindId(bson.ObjectIdHex(dummyID)).One(&dummyOne)
+	})
+	if err == mgo.ErrNotFound || err == nil && dummyOne.LongPoll == nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(requestError(r, errorNotLongPoll))
+		return
+	}
+	if err != nil {
+		requestLog(r).WithField("error_msg", err.Error()).Error("fail to find the dummy")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var reqModel requestModel
+	if err := json.NewDecoder(r.Body).Decode(&reqModel); err != nil {
+		requestLog(r).Warningf("fail to parse json %s ", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(requestError(r, &errorResponse{"InvalidJSON", "fail to parse JSON"}))
+		return
+	}
+	if reqModel.WebSocket != nil || reqModel.SSE != nil || reqModel.LongPoll != nil || reqModel.GraphQL != nil || reqModel.SOAP != nil || reqModel.JSONRPC != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(requestError(r, &errorResponse{"InvalidData", "the response should be a plain body"}))
+		return
+	}
+	if err := reqModel.validate(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(requestError(r, &errorResponse{"InvalidData", err.Error()}))
+		return
+	}
+
+	var response dummyModel
+	if err := response.updateWithRequestData(&reqModel); err != nil {
+		requestLog(r).Errorf("fail to encode headers: %s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	response.ID = bson.ObjectIdHex(dummyID)
+	released := longPolls.release(dummyID, &response)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(struct {
+		Released int `json:"released"`
+	}{released})
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Long polling", func() {
+	var server *httptest.Server
+	var dummy dummyModel
+
+	BeforeEach(func() {
+		dummy = dummyModel{ID: bson.NewObjectId(), Status: http.StatusNoContent, LongPoll: &longPollModel{Timeout: 5000}}
+		if err := testDB.C(collectionDummy).Insert(&dummy); err != nil {
+			Fail(err.Error())
+		}
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			callback, _ := jsonpCallback(r)
+			serveLongPoll(w, r, &dummy, 0, callback)
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+		testDB.C(collectionDummy).RemoveId(dummy.ID)
+	})
+
+	trigger := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/v1/"+dummy.ID.Hex()+"/trigger", bytes.NewBufferString(body))
+		w := httptest.NewRecorder()
+		createRoute().ServeHTTP(w, req)
+		return w
+	}
+
+	It("should release all waiting requests with the triggered response", func() {
+		results := make(chan *http.Response, 2)
+		for i := 0; i < 2; i++ {
+			go func() {
+				defer GinkgoRecover()
+				res, err := http.Get(server.URL + "/jobs/1")
+				Expect(err).NotTo(HaveOccurred())
+				results <- res
+			}()
+		}
+		Eventually(func() int { return longPolls.count(dummy.ID.Hex()) }).Should(Equal(2))
+
+		w := trigger(`{"status": 200, "content_type": "application/json", "charset": "utf-8", "content": "{\"state\":\"done\"}", "headers": {"X-Job": "1"}}`)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		var body map[string]int
+		Expect(json.Unmarshal(w.Body.Bytes(), &body)).To(Succeed())
+		Expect(body["released"]).To(Equal(2))
+
+		for i := 0; i < 2; i++ {
+			res := <-results
+			content, _ := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			Expect(res.StatusCode).To(Equal(http.StatusOK))
+			Expect(res.Header.Get("X-Job")).To(Equal("1"))
+			Expect(string(content)).To(Equal(`{"state":"done"}`))
+		}
+		Expect(longPolls.count(dummy.ID.Hex())).To(BeZero())
+	})
+
+	It("should serve the dummy on timeout", func() {
+		dummy.LongPoll.Timeout = 20
+		res, err := http.Get(server.URL + "/jobs/1")
+		Expect(err).NotTo(HaveOccurred())
+		res.Body.Close()
+		Expect(res.StatusCode).To(Equal(http.StatusNoContent))
+		Expect(res.Header.Get("Content-Type")).To(BeEmpty())
+	})
+
+	It("should serve JSONP on release and on timeout", func() {
+		get := func() string {
+			res, err := http.Get(server.URL + "/jobs/1?callback=cb")
+			Expect(err).NotTo(HaveOccurred())
+			defer res.Body.Close()
+			Expect(res.Header.Get("Content-Type")).To(Equal("application/javascript; charset=utf-8"))
+			content, _ := ioutil.ReadAll(res.Body)
+			return string(content)
+		}
+		result := make(chan string, 1)
+		go func() {
+			defer GinkgoRecover()
+			result <- get()
+		}()
+		Eventually(func() int { return longPolls.count(dummy.ID.Hex()) }).Should(Equal(1))
+		released := dummyModel{Status: 200, ContentType: "application/json", Charset: "utf-8", Content: `{"state":"done"}`}
+		Expect(longPolls.release(dummy.ID.Hex(), &released)).To(Equal(1))
+		Eventually(result).Should(Receive(Equal(`/**/cb({"state":"done"});`)))
+		Expect(released.Content).To(Equal(`{"state":"done"}`), "the released response is shared")
+
+		dummy.Status, dummy.ContentType, dummy.Charset, dummy.Content = 200, "application/json", "utf-8", `{"state":"pending"}`
+		dummy.LongPoll.Timeout = 20
+		Expect(get()).To(Equal(`/**/cb({"state":"pending"});`))
+	})
+
+	It("should stop waiting when the client goes away", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		req, _ := http.NewRequest("GET", server.URL+"/jobs/1", nil)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			http.DefaultClient.Do(req.WithContext(ctx))
+		}()
+		Eventually(func() int { return longPolls.count(dummy.ID.Hex()) }).Should(Equal(1))
+		cancel()
+		<-done
+		Eventually(func() int { return longPolls.count(dummy.ID.Hex()) }).Should(BeZero())
+		Expect(trigger(`{"status": 200, "content_type": "text/plain", "charset": "utf-8"}`).Body.String()).To(ContainSubstring(`"released":0`))
+	})
+
+	It("should count only requests which receive the response", func() {
+		held := func(ctx context.Context, timeout time.Duration) chan *dummyModel {
+			req, _ := http.NewRequest("GET", "/jobs/1", nil)
+			result := make(chan *dummyModel, 1)
+			go func() { result <- longPolls.wait(req.WithContext(ctx), dummy.ID.Hex(), timeout) }()
+			return result
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		gone := held(ctx, time.Minute)
+		waiting := held(context.Background(), time.Minute)
+		Eventually(func() int { return longPolls.count(dummy.ID.Hex()) }).Should(Equal(2))
+
+		cancel()
+		released := dummyModel{Status: 200}
+		Expect(longPolls.release(dummy.ID.Hex(), &released)).To(Equal(1), "the canceled request is not counted")
+		Eventually(waiting).Should(Receive(Equal(&released)))
+		Eventually(gone).Should(Receive(BeNil()))
+		Expect(longPolls.count(dummy.ID.Hex())).To(BeZero())
+	})
+
+	It("should not release dummies which are not long polls", func() {
+		other := dummyModel{ID: bson.NewObjectId(), Status: 200}
+		req, _ := http.NewRequest("POST", "/v1/"+other.ID.Hex()+"/trigger", bytes.NewBufferString(`{"status": 200}`))
+		w := httptest.NewRecorder()
+		createRoute().ServeHTTP(w, req)
+		Expect(w.Code).To(Equal(http.StatusNotFound), "unknown dummy")
+
+		if err := testDB.C(collectionDummy).Insert(&other); err != nil {
+			Fail(err.Error())
+		}
+		defer testDB.C(collectionDummy).RemoveId(other.ID)
+		req, _ = http.NewRequest("POST", "/v1/"+other.ID.Hex()+"/trigger", bytes.NewBufferString(`{"status": 200}`))
+		w = httptest.NewRecorder()
+		createRoute().ServeHTTP(w, req)
+		Expect(w.Code).To(Equal(http.StatusNotFound))
+		Expect(w.Body.String()).To(ContainSubstring("No long poll dummy"))
+	})
+
+	It("should reject an invalid trigger", func() {
+		Expect(trigger(`{"status": 200}`).Code).To(Equal(http.StatusBadRequest))
+		Expect(trigger(`{"status": 200, "content_type": "text/plain", "charset": "utf-8", "long_poll": {"timeout": 1}}`).Code).To(Equal(http.StatusBadRequest))
+	})
+})
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"net/http"
 	"os"
 
@@ -14,45 +16,109 @@ var db *mgo.Database
 
 const apiVersion = "v1"
 
-func init() {
-	// connect database
-	mongoDBURI := os.Getenv("MONGODB_URI")
-	if mongoDBURI == "" {
-		panic("mongoDB URI is empty")
+func main() {
+	var err error
+	var args []string
+	cfg, args, err = loadConfig(os.Args[1:], os.Getenv)
+	if err == flag.ErrHelp {
+		return
 	}
-	session, err := mgo.Dial(mongoDBURI)
-
 	if err != nil {
-		log.Fatal(err)
+		fmt.Fprintln(os.Stderr, "invalid configuration:")
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(2)
+	}
+	if len(args) > 0 {
+		switch args[0] {
+		case "print-config":
+			fmt.Print(cfg.printable())
+			return
+		default:
+			fmt.Fprintf(os.Stderr, "unknown command '%s'\n", args[0])
+			os.Exit(2)
+		}
 	}
-	dbName := os.Getenv("MONGODB_DATABASE")
-	db = session.DB(dbName)
-	// Log as JSON instead of the default ASCII formatter.
-	log.SetFormatter(&log.JSONFormatter{})
 
-	// Output to stdout instead of the default stderr
-	// Can be any io.Writer, see below for File example
-	log.SetOutput(os.Stdout)
+	setupLogger(cfg.Log)
 
-	// Only log the warning severity or above.
-	log.SetLevel(log.InfoLevel)
-}
+	// connect database
+	session, err := mgo.Dial(cfg.Storage.MongoDBURI)
+	if err != nil {
+		log.Fatal(err)
+	}
+	db = session.DB(cfg.Storage.MongoDBDatabase)
+
+	stop := make(chan struct{})
+
+	// load stub files and apply changes of them
+	if cfg.Stubs.Dir != "" {
+		loader := newStubLoader(cfg.Stubs.Dir)
+		if err := loader.reset(); err != nil {
+			log.Fatal(err)
+		}
+		loader.sync()
+		go loader.watch(cfg.Stubs.PollInterval, stop)
+	}
 
-func main() {
-	// TODO load env
+	// export spans in the background
+	exported := make(chan struct{})
+	exporter = newSpanExporter(cfg.Tracing)
+	go func() {
+		exporter.run(stop)
+		close(exported)
+	}()
 
 	router := createRoute()
-	// to support for CORS
-	handler := cors.Default().Handler(router)
-
-	// Start the server with port. default port is 3000 for Heroku
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "3000"
+	// to support for CORS. the access log wraps everything to log preflight requests too.
+	// gRPC calls are served at the root regardless of the path prefix
+	handler := accessLog(cfg.AccessLog, tracing(cfg.Tracing, routeGRPC(cors.New(cors.Options{
+		AllowedOrigins:   cfg.CORS.AllowedOrigins,
+		AllowedMethods:   cfg.CORS.AllowedMethods,
+		AllowedHeaders:   cfg.CORS.AllowedHeaders,
+		AllowCredentials: cfg.CORS.AllowCredentials,
+		MaxAge:           cfg.CORS.MaxAge,
+	}).Handler(mountPrefix(instrument(limitBody(router, cfg.Limits.MaxBodyBytes)), cfg.PathPrefix)))))
+
+	log.Println("Starting Dummy Http Responser on", cfg.Listen)
+	servers := []*http.Server{newServer(cfg.Server, cfg.Listen, handler)}
+	if cfg.TLS.Listen != "" {
+		tlsConfig, err := loadTLSConfig(cfg.TLS)
+		if err != nil {
+			log.Fatal(err)
+		}
+		server := newServer(cfg.Server, cfg.TLS.Listen, handler)
+		server.TLSConfig = tlsConfig
+		servers = append(servers, server)
+		log.Println("Starting Dummy Http Responser with TLS on", cfg.TLS.Listen)
+	}
+	if cfg.GRPC.Listen != "" {
+		grpcServer := cfg.Server
+		grpcServer.H2C = true
+		servers = append(servers, newServer(grpcServer, cfg.GRPC.Listen,
+			accessLog(cfg.AccessLog, tracing(cfg.Tracing, routeGRPC(http.HandlerFunc(handleNotGRPC))))))
+		log.Println("Starting gRPC on", cfg.GRPC.Listen)
+	}
+	err = serve(servers, cfg.Server)
+	close(stop)
+	<-exported
+	session.Close()
+	if err != nil && err != http.ErrServerClosed {
+		os.Exit(1)
 	}
+	log.Println("Stopped Dummy Http Responser")
+}
 
-	log.Println("Starting Dummy Http Responser on port:", port)
-	log.Fatal(http.ListenAndServe(":"+port, handler))
+// limitBody limits the size of request bodies. 0 means unlimited
+func limitBody(next http.Handler, maxBytes int64) http.Handler {
+	if maxBytes <= 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+		}
+		next.ServeHTTP(w, r)
+	})
 }
 
 func createRoute() *httprouter.Router {
@@ -62,6 +128,15 @@ func createRoute() *httprouter.Router {
 	router.POST("/echo", handleEcho)
 	router.PUT("/echo", handleEcho)
 	router.DELETE("/echo", handleEcho)
+	router.GET("/echo/ws", handleEchoWebSocket)
+
+	router.GET("/healthz", handleLiveness)
+	router.GET("/readyz", handleReadiness)
+	router.GET("/version", handleVersion)
+	router.GET("/tls/ca.pem", handleDownloadCA)
+	if cfg.Metrics.Enabled {
+		router.GET("/metrics", handleMetrics)
+	}
 
 	router.POST("/create", handleV1CreateDummy)
 
@@ -70,6 +145,20 @@ func createRoute() *httprouter.Router {
 	router.POST("/v1/:id", handleV1Custom)
 	router.PUT("/v1/:id", handleV1Custom)
 	router.DELETE("/v1/:id", handleV1Custom)
+	router.POST("/v1/:id/trigger", handleV1Trigger)
+
+	router.POST("/projects", handleCreateProject)
+	router.PUT("/projects/:id", handleUpdateProject)
+	router.POST("/projects/:id/openapi", handleImportOpenAPI)
+	router.GET("/projects/:id/openapi", handleExportOpenAPI)
+	router.POST("/projects/:id/import", handleImportMocks)
+	router.POST("/projects/:id/grpc/descriptors", handleUploadDescriptors)
+	router.POST("/projects/:id/grpc/dummies", handleGRPCDummies)
+
+	// dummies in a project are matched by method and path
+	for _, method := range projectMethods {
+		router.Handle(method, "/mock/:project/*path", handleProjectMock)
+	}
 
 	return router
 }
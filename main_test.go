@@ -32,6 +32,7 @@ var _ = BeforeSuite(func() {
 
 	databaseName := os.Getenv("MONGODB_DATABASE")
 	testDB = session.DB(databaseName)
+	db = testDB
 
 	for i := range testData {
 		testData[i] = dummyModel{
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// matcher operators
+const (
+	matchEquals   = "equals"   // value is equal
+	matchContains = "contains" // value contains
+	matchRegex    = "regex"    // value matches the regular expression
+	matchPresent  = "present"  // value exists
+	matchAbsent   = "absent"   // value does not exist
+	matchJSON     = "json"     // value is JSON which is equal regardless of formatting and key order
+)
+
+// matcherModel is a condition on the request. A dummy is served only if all of its matchers match
+type matcherModel struct {
+	In    string `json:"in"`              // path, query, header, body, xpath or cert
+	Name  string `json:"name,omitempty"`  // query or header name, field of a JSON body like user.id, XPath in an XML body, or subject, issuer, san or fingerprint of the client certificate
+	Op    string `json:"op"`              // equals, contains, regex, present, absent or json
+	Value string `json:"value,omitempty"` // value to compare
+}
+
+// validate matcherModel. do not trust any input
+func (m *matcherModel) validate() error {
+	switch m.In {
+	case "query", "header":
+		if m.Name == "" {
+			return fmt.Errorf("name of %s matcher is empty", m.In)
+		}
+	case "xpath":
+		if _, err := parseXPath(m.Name); err != nil {
+			return fmt.Errorf("invalid XPath '%s': %s", m.Name, err.Error())
+		}
+	case "cert":
+		switch m.Name {
+		case "subject", "issuer", "san", "fingerprint":
+		default:
+			return fmt.Errorf("cert matcher on '%s' is not supported. use subject, issuer, san or fingerprint", m.Name)
+		}
+	case "path", "body":
+	default:
+		return fmt.Errorf("matcher on '%s' is not supported", m.In)
+	}
+	switch m.Op {
+	case matchEquals, matchContains, matchPresent, matchAbsent:
+	case matchRegex:
+		if _, err := regexp.Compile(m.Value); err != nil {
+			return fmt.Errorf("invalid regex '%s'", m.Value)
+		}
+	case matchJSON:
+		var v interface{}
+		if err := json.Unmarshal([]byte(m.Value), &v); err != nil {
+			return fmt.Errorf("invalid JSON '%s'", m.Value)
+		}
+	default:
+		return fmt.Errorf("matcher op '%s' is not supported", m.Op)
+	}
+	return nil
+}
+
+// match checks the matcher against the request. path is relative to the project
+func (m *matcherModel) match(r *http.Request, path string, body []byte) bool {
+	var values []string
+	switch m.In {
+	case "path":
+		values = []string{path}
+	case "query":
+		values = r.URL.Query()[m.Name]
+	case "header":
+		values = r.Header[http.CanonicalHeaderKey(m.Name)]
+	case "body":
+		if m.Name != "" {
+			values = jsonFieldValues(body, m.Name, m.Op == matchJSON)
+		} else if len(body) > 0 {
+			values = []string{string(body)}
+		}
+	case "xpath":
+		values = xpathValues(body, m.Name)
+	case "cert":
+		// only verified client certificates. an unknown certificate is the same as none
+		values = clientCert(r).values(m.Name)
+	}
+
+	switch m.Op {
+	case matchPresent:
+		return len(values) > 0
+	case matchAbsent:
+		return len(values) == 0
+	}
+	for _, v := range values {
+		if m.matchValue(v) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *matcherModel) matchValue(v string) bool {
+	switch m.Op {
+	case matchEquals:
+		return v == m.Value
+	case matchContains:
+		return strings.Contains(v, m.Value)
+	case matchRegex:
+		re, err := regexp.Compile(m.Value)
+		return err == nil && re.MatchString(v)
+	case matchJSON:
+		var got, want interface{}
+		if json.Unmarshal([]byte(v), &got) != nil || json.Unmarshal([]byte(m.Value), &want) != nil {
+			return false
+		}
+		return reflect.DeepEqual(got, want)
+	}
+	return false
+}
+
+// jsonFieldValues returns the field of a JSON body by a path like items.0.id. Strings are compared as they are,
+// and other values in JSON. A null field is absent
+func jsonFieldValues(body []byte, path string, raw bool) []string {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var v interface{}
+	if dec.Decode(&v) != nil {
+		return nil
+	}
+	for _, key := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			v = node[key]
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil
+			}
+			v = node[i]
+		default:
+			return nil
+		}
+	}
+	if v == nil {
+		return nil
+	}
+	if s, ok := v.(string); ok && !raw {
+		return []string{s}
+	}
+	byt, _ := json.Marshal(v)
+	return []string{string(byt)}
+}
+
+// matchDummy returns the dummy to serve for the request. The path template with more literal
+// segments wins, then the dummy with more matchers. The first dummy wins on a tie
+func matchDummy(dummies []dummyModel, r *http.Request, path string, body []byte) *dummyModel {
+	var found *dummyModel
+	bestScore, bestMatchers := -1, -1
+	for i := range dummies {
+		score, ok := matchPathTemplate(dummies[i].Path, path)
+		if !ok || score < bestScore || score == bestScore && len(dummies[i].Matchers) <= bestMatchers {
+			continue
+		}
+		matched := true
+		for j := range dummies[i].Matchers {
+			if !dummies[i].Matchers[j].match(r, path, body) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			found = &dummies[i]
+			bestScore, bestMatchers = score, len(dummies[i].Matchers)
+		}
+	}
+	return found
+}
+
+// readBody reads the request body and puts it back so that it can be read again
+func readBody(r *http.Request) []byte {
+	if r.Body == nil {
+		return nil
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return nil
+	}
+	return body
+}
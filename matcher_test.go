@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Matcher", func() {
+	Context("with a request", func() {
+		req, _ := http.NewRequest("POST", "/pets?kind=dog", nil)
+		req.Header.Set("X-API-KEY", "secret")
+
+		It("should match query and header", func() {
+			Expect((&matcherModel{In: "query", Name: "kind", Op: matchEquals, Value: "dog"}).match(req, "/pets", nil)).To(Equal(true))
+			Expect((&matcherModel{In: "query", Name: "kind", Op: matchEquals, Value: "cat"}).match(req, "/pets", nil)).To(Equal(false))
+			Expect((&matcherModel{In: "header", Name: "x-api-key", Op: matchRegex, Value: "^sec"}).match(req, "/pets", nil)).To(Equal(true))
+			Expect((&matcherModel{In: "header", Name: "X-Other", Op: matchAbsent}).match(req, "/pets", nil)).To(Equal(true))
+		})
+
+		It("should match JSON body regardless of formatting", func() {
+			m := &matcherModel{In: "body", Op: matchJSON, Value: `{"a": 1, "b": [true]}`}
+			Expect(m.match(req, "/pets", []byte(`{"b":[true],"a":1}`))).To(Equal(true))
+			Expect(m.match(req, "/pets", []byte(`{"a":2}`))).To(Equal(false))
+		})
+
+		It("should match fields of JSON body by path", func() {
+			body := []byte(`{"user": {"id": 7, "name": "Bob"}, "items": [{"sku": "a1"}], "note": null}`)
+			Expect((&matcherModel{In: "body", Name: "user.name", Op: matchEquals, Value: "Bob"}).match(req, "/pets", body)).To(Equal(true))
+			Expect((&matcherModel{In: "body", Name: "user.id", Op: matchEquals, Value: "7"}).match(req, "/pets", body)).To(Equal(true))
+			Expect((&matcherModel{In: "body", Name: "items.0.sku", Op: matchRegex, Value: "^a"}).match(req, "/pets", body)).To(Equal(true))
+			Expect((&matcherModel{In: "body", Name: "user", Op: matchJSON, Value: `{"name": "Bob", "id": 7}`}).match(req, "/pets", body)).To(Equal(true))
+			Expect((&matcherModel{In: "body", Name: "note", Op: matchAbsent}).match(req, "/pets", body)).To(Equal(true))
+			Expect((&matcherModel{In: "body", Name: "items.1.sku", Op: matchPresent}).match(req, "/pets", body)).To(Equal(false))
+		})
+
+		It("should prefer the dummy with more matchers", func() {
+			dummies := []dummyModel{
+				{Path: "/pets", Status: 200},
+				{Path: "/pets", Status: 201, Matchers: []matcherModel{{In: "query", Name: "kind", Op: matchEquals, Value: "dog"}}},
+				{Path: "/pets", Status: 202, Matchers: []matcherModel{{In: "query", Name: "kind", Op: matchEquals, Value: "cat"}}},
+			}
+			Expect(matchDummy(dummies, req, "/pets", nil).Status).To(Equal(201))
+		})
+	})
+
+	Context("with invalid matchers", func() {
+		It("should fail to validate", func() {
+			Expect((&matcherModel{In: "cookie", Name: "a", Op: matchEquals}).validate()).To(HaveOccurred())
+			Expect((&matcherModel{In: "query", Op: matchEquals}).validate()).To(HaveOccurred())
+			Expect((&matcherModel{In: "body", Op: matchRegex, Value: "("}).validate()).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/julienschmidt/httprouter"
+)
+
+// metrics are exposed in the Prometheus text format. Values are updated with atomic operations
+// and label sets are kept in sync.Map, so the request path does not take locks once a label set exists
+var (
+	durationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	sizeBuckets     = []float64{100, 1000, 10000, 100000, 1000000}
+
+	requestsTotal    = &counterVec{}
+	requestDuration  = &histogramVec{buckets: durationBuckets}
+	responseSize     = &histogramVec{buckets: sizeBuckets}
+	requestsInFlight int64
+	storeDuration    = &histogramVec{buckets: durationBuckets}
+	storeErrorsTotal = &counterVec{}
+	dummyHitsTotal   = &counterVec{}
+	dummyCount       = countDummies
+)
+
+// counterVec is counters by label set
+type counterVec struct {
+	values sync.Map // labels to *uint64
+}
+
+func (c *counterVec) inc(labels string) {
+	if v, ok := c.values.Load(labels); ok {
+		atomic.AddUint64(v.(*uint64), 1)
+		return
+	}
+	v, _ := c.values.LoadOrStore(labels, new(uint64))
+	atomic.AddUint64(v.(*uint64), 1)
+}
+
+// histogram counts observations in buckets. counts are not cumulative until written
+type histogram struct {
+	counts  []uint64 // the last one is +Inf
+	sumBits uint64   // float64 bits
+}
+
+func (h *histogram) observe(buckets []float64, v float64) {
+	i := sort.SearchFloat64s(buckets, v)
+	atomic.AddUint64(&h.counts[i], 1)
+	for {
+		old := atomic.LoadUint64(&h.sumBits)
+		sum := math.Float64bits(math.Float64frombits(old) + v)
+		if atomic.CompareAndSwapUint64(&h.sumBits, old, sum) {
+			return
+		}
+	}
+}
+
+// histogramVec is histograms by label set
+type histogramVec struct {
+	buckets []float64
+	values  sync.Map // labels to *histogram
+}
+
+func (h *histogramVec) observe(labels string, v float64) {
+	if hist, ok := h.values.Load(labels); ok {
+		hist.(*histogram).observe(h.buckets, v)
+		return
+	}
+	hist, _ := h.values.LoadOrStore(labels, &histogram{counts: make([]uint64, len(h.buckets)+1)})
+	hist.(*histogram).observe(h.buckets, v)
+}
+
+// labelSet formats pairs of names and values as Prometheus labels. e.g. route="echo",method="GET"
+func labelSet(pairs ...string) string {
+	parts := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		parts = append(parts, pairs[i]+`="`+escapeLabel(pairs[i+1])+`"`)
+	}
+	return strings.Join(parts, ",")
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+// routeOf names the route of the path to keep the cardinality of labels low
+func routeOf(path string) string {
+	switch {
+	case path == "/echo" || path == "/echo/ws":
+		return "echo"
+	case path == "/create":
+		return "create"
+	case strings.HasPrefix(path, "/v1/"):
+		return "dummy"
+	case strings.HasPrefix(path, "/mock/"):
+		return "mock"
+	case path == "/projects" || strings.HasPrefix(path, "/projects/"):
+		return "projects"
+	case path == "/healthz" || path == "/readyz" || path == "/version":
+		return "health"
+	case path == "/metrics":
+		return "metrics"
+	}
+	return "other"
+}
+
+// methodLabel keeps unknown methods from adding label values
+func methodLabel(method string) string {
+	if isProjectMethod(method) {
+		return method
+	}
+	return "OTHER"
+}
+
+// statusWriter records the status and the size of the response
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush sends buffered data to the client so that streamed responses are not held by middlewares
+func (w *statusWriter) Flush() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Hijack takes over the connection for protocols like WebSocket. It is recorded as 101
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil && w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return conn, brw, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// instrument records request metrics
+func instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requestsInFlight, 1)
+		defer atomic.AddInt64(&requestsInFlight, -1)
+
+		started := time.Now()
+		mw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(mw, r)
+
+		if mw.status == 0 {
+			mw.status = http.StatusOK
+		}
+		route := routeOf(r.URL.Path)
+		if isGRPCRequest(r) {
+			route = "grpc"
+		}
+		labels := labelSet("route", route, "method", methodLabel(r.Method), "status", strconv.Itoa(mw.status))
+		requestsTotal.inc(labels)
+		requestDuration.observe(labels, time.Since(started).Seconds())
+		responseSize.observe(labels, float64(mw.bytes))
+	})
+}
+
+// observeStore runs the store operation and records its latency and errors in metrics and a span.
+// Not found is not an error
+func observeStore(ctx context.Context, op string, fn func() error) error {
+	s := startSpan(ctx, "store "+op)
+	started := time.Now()
+	err := fn()
+	labels := labelSet("op", op)
+	storeDuration.observe(labels, time.Since(started).Seconds())
+	if err == mgo.ErrNotFound {
+		s.finish(nil)
+		return err
+	}
+	if err != nil {
+		storeErrorsTotal.inc(labels)
+	}
+	if s != nil {
+		s.Attributes["db.system"] = "mongodb"
+		s.Attributes["db.operation"] = op
+	}
+	s.finish(err)
+	return err
+}
+
+// countDummyHit counts the served dummy if per-dummy counters are enabled
+func countDummyHit(dummy *dummyModel) {
+	if cfg.Metrics.DummyHits && dummy.ID != "" {
+		dummyHitsTotal.inc(labelSet("dummy", dummy.ID.Hex()))
+	}
+}
+
+// countDummies counts stored dummies in the timeout
+func countDummies() (int, error) {
+	session := db.Session.Copy()
+	defer session.Close()
+	session.SetSyncTimeout(cfg.Storage.PingTimeout)
+	session.SetSocketTimeout(cfg.Storage.PingTimeout)
+	var n int
+	err := observeStore(context.Background(), "count_dummies", func() (err error) {
+		n, err = session.DB(db.Name).C(collectionDummy).Count()
+		return
+	})
+	return n, err
+}
+
+// handler for GET /metrics
+func handleMetrics(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+
+	writeCounters(w, "dummy_http_requests_total", "Number of HTTP requests by route, method and status.", requestsTotal)
+	writeHistograms(w, "dummy_http_request_duration_seconds", "Latency of HTTP requests by route, method and status.", requestDuration)
+	writeHistograms(w, "dummy_http_response_size_bytes", "Size of HTTP responses by route, method and status.", responseSize)
+	fmt.Fprintf(w, "# HELP dummy_http_requests_in_flight Number of HTTP requests being served.\n")
+	fmt.Fprintf(w, "# TYPE dummy_http_requests_in_flight gauge\n")
+	fmt.Fprintf(w, "dummy_http_requests_in_flight %d\n", atomic.LoadInt64(&requestsInFlight))
+	writeHistograms(w, "dummy_http_store_operation_duration_seconds", "Latency of store operations.", storeDuration)
+	writeCounters(w, "dummy_http_store_errors_total", "Number of failed store operations.", storeErrorsTotal)
+
+	if n, err := dummyCount(); err != nil {
+		requestLog(r).Warnf("fail to count dummies: %s", err.Error())
+	} else {
+		fmt.Fprintf(w, "# HELP dummy_http_dummies Number of stored dummies.\n")
+		fmt.Fprintf(w, "# TYPE dummy_http_dummies gauge\n")
+		fmt.Fprintf(w, "dummy_http_dummies %d\n", n)
+	}
+
+	if cfg.Metrics.DummyHits {
+		writeCounters(w, "dummy_http_dummy_hits_total", "Number of responses by dummy.", dummyHitsTotal)
+	}
+}
+
+// sortedLabels returns label sets in the map in order so that the output is stable
+func sortedLabels(values *sync.Map) []string {
+	var labels []string
+	values.Range(func(k, _ interface{}) bool {
+		labels = append(labels, k.(string))
+		return true
+	})
+	sort.Strings(labels)
+	return labels
+}
+
+func writeCounters(w io.Writer, name, help string, c *counterVec) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	for _, labels := range sortedLabels(&c.values) {
+		v, _ := c.values.Load(labels)
+		fmt.Fprintf(w, "%s{%s} %d\n", name, labels, atomic.LoadUint64(v.(*uint64)))
+	}
+}
+
+func writeHistograms(w io.Writer, name, help string, h *histogramVec) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	for _, labels := range sortedLabels(&h.values) {
+		v, _ := h.values.Load(labels)
+		hist := v.(*histogram)
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += atomic.LoadUint64(&hist.counts[i])
+			fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+		}
+		cumulative += atomic.LoadUint64(&hist.counts[len(h.buckets)])
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, cumulative)
+		sum := math.Float64frombits(atomic.LoadUint64(&hist.sumBits))
+		fmt.Fprintf(w, "%s_sum{%s} %s\n", name, labels, strconv.FormatFloat(sum, 'g', -1, 64))
+		fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, cumulative)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/globalsign/mgo/bson"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Metrics", func() {
+	var saved config
+	var savedCount func() (int, error)
+
+	BeforeEach(func() {
+		saved = cfg
+		savedCount = dummyCount
+		cfg = defaultConfig()
+		dummyCount = func() (int, error) { return 7, nil }
+	})
+
+	AfterEach(func() {
+		cfg = saved
+		dummyCount = savedCount
+	})
+
+	scrape := func() string {
+		rec := httptest.NewRecorder()
+		handleMetrics(rec, httptest.NewRequest("GET", "/metrics", nil), nil)
+		Expect(rec.Header().Get("Content-Type")).To(HavePrefix("text/plain; version=0.0.4"))
+		return rec.Body.String()
+	}
+
+	It("should count requests by route, method and status", func() {
+		handler := instrument(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+			w.Write([]byte("short and stout"))
+		}))
+		for i := 0; i < 2; i++ {
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("BREW", "/echo", nil))
+		}
+
+		body := scrape()
+		Expect(body).To(ContainSubstring(`dummy_http_requests_total{route="echo",method="OTHER",status="418"} 2`))
+		Expect(body).To(ContainSubstring(`dummy_http_request_duration_seconds_count{route="echo",method="OTHER",status="418"} 2`))
+		Expect(body).To(ContainSubstring(`dummy_http_response_size_bytes_bucket{route="echo",method="OTHER",status="418",le="100"} 2`))
+		Expect(body).To(ContainSubstring(`dummy_http_response_size_bytes_sum{route="echo",method="OTHER",status="418"} 30`))
+		Expect(body).To(ContainSubstring("dummy_http_requests_in_flight 0"))
+		Expect(body).To(ContainSubstring("dummy_http_dummies 7"))
+	})
+
+	It("should record store errors", func() {
+		observeStore(context.Background(), "test_op", func() error { return errors.New("no reachable servers") })
+		body := scrape()
+		Expect(body).To(ContainSubstring(`dummy_http_store_errors_total{op="test_op"} 1`))
+		Expect(body).To(ContainSubstring(`dummy_http_store_operation_duration_seconds_count{op="test_op"} 1`))
+	})
+
+	It("should count dummy hits only if enabled", func() {
+		dummy := &dummyModel{ID: bson.NewObjectId(), Status: 200, ContentType: "text/plain", Charset: "utf-8"}
+		writeDummy(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/"+dummy.ID.Hex(), nil), dummy, 0)
+		Expect(scrape()).NotTo(ContainSubstring("dummy_http_dummy_hits_total"))
+
+		cfg.Metrics.DummyHits = true
+		writeDummy(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/"+dummy.ID.Hex(), nil), dummy, 0)
+		line := `dummy_http_dummy_hits_total{dummy="` + dummy.ID.Hex() + `"} 1`
+		Expect(strings.Split(scrape(), "\n")).To(ContainElement(line))
+	})
+})
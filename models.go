@@ -3,13 +3,15 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"net/http"
 	"time"
 
 	"github.com/globalsign/mgo/bson"
 )
 
 var (
-	collectionDummy = "dummy"
+	collectionDummy   = "dummy"
+	collectionProject = "project"
 )
 
 type requestModel struct {
@@ -18,10 +20,69 @@ type requestModel struct {
 	ContentType string            `json:"content_type"` // http 'Content-Type'
 	Status      int               `json:"status"`       // http status
 	Headers     map[string]string `json:"headers"`
+	EchoTrace   bool              `json:"echo_trace"` // reflect traceparent and tracestate in the response
+	NoJSONP     bool              `json:"no_jsonp"`   // serve JSON as it is even with a callback
+	Mutations   []string          `json:"mutations"`  // break the response on purpose. e.g. bom or invalid_utf8
+	WebSocket   *webSocketModel   `json:"websocket"`  // serve a WebSocket conversation instead of the body
+	SSE         *sseModel         `json:"sse"`        // stream Server-Sent Events instead of the body
+	LongPoll    *longPollModel    `json:"long_poll"`  // hold requests until triggered. the body is served on timeout
+	GraphQL     *graphQLModel     `json:"graphql"`    // answer GraphQL requests from the schema instead of the body
+	SOAP        *soapModel        `json:"soap"`       // answer SOAP requests by operation instead of the body
+	JSONRPC     *jsonRPCModel     `json:"jsonrpc"`    // answer JSON-RPC 2.0 calls by method instead of the body
 }
 
 // validate requestModel. do not trust any input
 func (m *requestModel) validate() error {
+	if m.WebSocket != nil && m.SSE != nil {
+		return errors.New("set either websocket or sse")
+	}
+	if len(m.Mutations) > 0 {
+		if m.WebSocket != nil || m.SSE != nil || m.GraphQL != nil || m.SOAP != nil || m.JSONRPC != nil {
+			return errors.New("mutations can not be combined with websocket, sse, graphql, soap or jsonrpc")
+		}
+		if err := validateMutations(m.Mutations); err != nil {
+			return err
+		}
+	}
+	// a charset is checked even if the protocol below does not need one, not to fail when it is served
+	if m.Charset != "" {
+		if err := validateCharset(m.Charset, m.Content); err != nil {
+			return err
+		}
+	}
+	if m.JSONRPC != nil {
+		if m.WebSocket != nil || m.SSE != nil || m.LongPoll != nil || m.GraphQL != nil || m.SOAP != nil {
+			return errors.New("jsonrpc can not be combined with websocket, sse, long_poll, graphql or soap")
+		}
+		return m.JSONRPC.validate()
+	}
+	if m.SOAP != nil {
+		if m.WebSocket != nil || m.SSE != nil || m.LongPoll != nil || m.GraphQL != nil {
+			return errors.New("soap can not be combined with websocket, sse, long_poll or graphql")
+		}
+		return m.SOAP.validate()
+	}
+	if m.GraphQL != nil {
+		if m.WebSocket != nil || m.SSE != nil || m.LongPoll != nil {
+			return errors.New("graphql can not be combined with websocket, sse or long_poll")
+		}
+		return m.GraphQL.validate()
+	}
+	if m.WebSocket != nil {
+		return m.WebSocket.validate()
+	}
+	if m.SSE != nil {
+		return m.SSE.validate()
+	}
+	if m.LongPoll != nil {
+		if err := m.LongPoll.validate(); err != nil {
+			return err
+		}
+		if m.Status == 0 || m.Status == http.StatusNoContent {
+			// no content on timeout
+			return nil
+		}
+	}
 	var err error
 	if m.Status == 0 {
 		err = errors.New("status is not set")
@@ -35,14 +96,30 @@ func (m *requestModel) validate() error {
 
 // dummyModel is a model for manipulating databases' data
 type dummyModel struct {
-	ID          bson.ObjectId `bson:"_id"`
-	Version     string        // API version. v1, v2 ... vn
-	Content     string        // body to response
-	Charset     string        // charset
-	ContentType string        // http 'Content-Type'
-	Headers     string        // stringify JSON
-	Status      int           // http status
-	CreatedAt   time.Time     // Time to created this record
+	ID          bson.ObjectId   `bson:"_id"`
+	Project     bson.ObjectId   `bson:",omitempty"` // project which the dummy belongs to
+	Method      string          `bson:",omitempty"` // http method to match in the project
+	Path        string          `bson:",omitempty"` // path template to match in the project. e.g. /pets/{id}
+	Source      string          `bson:",omitempty"` // where the dummy came from. e.g. openapi
+	Matchers    []matcherModel  `bson:",omitempty"` // conditions on the request to serve this dummy
+	Delay       int             `bson:",omitempty"` // milliseconds to wait before responding
+	EchoTrace   bool            `bson:",omitempty"` // reflect traceparent and tracestate in the response
+	NoJSONP     bool            `bson:",omitempty"` // JSON is not wrapped for JSONP requests
+	Mutations   []string        `bson:",omitempty"` // how the response is broken on purpose
+	WebSocket   *webSocketModel `bson:",omitempty"` // WebSocket conversation served instead of the body
+	SSE         *sseModel       `bson:",omitempty"` // Server-Sent Events streamed instead of the body
+	LongPoll    *longPollModel  `bson:",omitempty"` // requests are held until triggered or the timeout
+	GraphQL     string          `bson:",omitempty"` // stringify JSON of graphQLModel. keys of values contain dots
+	JSONRPC     string          `bson:",omitempty"` // stringify JSON of jsonRPCModel. results may have keys with dots
+	SOAP        *soapModel      `bson:",omitempty"` // operations of a SOAP endpoint served instead of the body
+	GRPC        *grpcModel      `bson:",omitempty"` // response of a gRPC method. Path is the method. e.g. /helloworld.Greeter/SayHello
+	Version     string          // API version. v1, v2 ... vn
+	Content     string          // body to response
+	Charset     string          // charset
+	ContentType string          // http 'Content-Type'
+	Headers     string          // stringify JSON
+	Status      int             // http status
+	CreatedAt   time.Time       // Time to created this record
 }
 
 func (d *dummyModel) updateWithRequestData(m *requestModel) error {
@@ -50,6 +127,50 @@ func (d *dummyModel) updateWithRequestData(m *requestModel) error {
 	d.Charset = m.Charset
 	d.ContentType = m.ContentType
 	d.Status = m.Status
+	d.EchoTrace = m.EchoTrace
+	d.NoJSONP = m.NoJSONP
+	d.Mutations = m.Mutations
+	d.WebSocket = m.WebSocket
+	d.SSE = m.SSE
+	if d.WebSocket != nil && d.Status == 0 {
+		d.Status = http.StatusSwitchingProtocols
+	}
+	d.LongPoll = m.LongPoll
+	if d.LongPoll != nil && d.Status == 0 {
+		d.Status = http.StatusNoContent
+	}
+	if d.SSE != nil {
+		d.Status = http.StatusOK
+		d.ContentType, d.Charset = "text/event-stream", "utf-8"
+	}
+	d.GraphQL = ""
+	if m.GraphQL != nil {
+		graphQLBytes, err := json.Marshal(m.GraphQL)
+		if err != nil {
+			return err
+		}
+		d.GraphQL = string(graphQLBytes)
+		d.Status = http.StatusOK
+		d.ContentType, d.Charset = "application/json", "utf-8"
+	}
+	d.JSONRPC = ""
+	if m.JSONRPC != nil {
+		jsonRPCBytes, err := json.Marshal(m.JSONRPC)
+		if err != nil {
+			return err
+		}
+		d.JSONRPC = string(jsonRPCBytes)
+		d.Status = http.StatusOK
+		d.ContentType, d.Charset = "application/json", "utf-8"
+	}
+	d.SOAP = m.SOAP
+	if d.SOAP != nil {
+		d.Status = http.StatusOK
+		d.ContentType, d.Charset = "text/xml", "utf-8"
+		if d.SOAP.version() == "1.2" {
+			d.ContentType = "application/soap+xml"
+		}
+	}
 	d.CreatedAt = time.Now()
 	d.Version = apiVersion
 	// convert map to JSON
@@ -61,3 +182,15 @@ func (d *dummyModel) updateWithRequestData(m *requestModel) error {
 	d.Headers = string(jsonBytes)
 	return nil
 }
+
+// projectModel groups dummies which are served by method and path
+type projectModel struct {
+	ID          bson.ObjectId `bson:"_id"`
+	Name        string        // name of the project
+	Spec        string        // attached API spec(OpenAPI or Swagger) as uploaded
+	Validation  string        // how requests are validated against the spec. off, enforce or report
+	ReadOnly    bool          `bson:",omitempty"` // managed by stub files. It can not be modified through the API
+	Descriptors []byte        `bson:",omitempty"` // FileDescriptorSet of gRPC services as uploaded
+	Services    []string      `bson:",omitempty"` // fully-qualified names of the gRPC services in Descriptors
+	CreatedAt   time.Time     // Time to created this record
+}
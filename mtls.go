@@ -0,0 +1,125 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+)
+
+// client certificate modes of the TLS listener
+const (
+	clientAuthNone    = "none"    // client certificates are not asked
+	clientAuthRequest = "request" // a certificate is asked and verified if sent. unknown ones are let through unverified
+	clientAuthRequire = "require" // a certificate signed by the client CA is required in the handshake
+)
+
+// clientCAs verifies client certificates. nil if client certificates are not asked
+var clientCAs *x509.CertPool
+
+// clientCertInfo is the verified client certificate of the request
+type clientCertInfo struct {
+	Subject     string   `json:"subject"`
+	Issuer      string   `json:"issuer"`
+	SANs        []string `json:"sans"`
+	Fingerprint string   `json:"fingerprint"` // SHA-256 of the certificate in hex
+}
+
+// applyClientAuth asks client certificates on the TLS config as configured
+func applyClientAuth(tlsConfig *tls.Config, c tlsConfig) error {
+	if c.ClientAuth == "" || c.ClientAuth == clientAuthNone {
+		return nil
+	}
+	raw, err := ioutil.ReadFile(c.ClientCAFile)
+	if err != nil {
+		return err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(raw) {
+		return errors.New("no certificate in " + c.ClientCAFile)
+	}
+	clientCAs = pool
+	tlsConfig.ClientCAs = pool
+	if c.ClientAuth == clientAuthRequire {
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	} else {
+		// verified by clientCert so that unknown certificates can be answered by dummies
+		tlsConfig.ClientAuth = tls.RequestClientCert
+	}
+	return nil
+}
+
+// clientCert returns the client certificate of the request if it is verified by the client CA.
+// It returns nil for plain HTTP, no certificate and unknown certificates
+func clientCert(r *http.Request) *clientCertInfo {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+	info := infoOf(r)
+	if info.certChecked {
+		return info.cert
+	}
+	info.certChecked = true
+
+	leaf := r.TLS.PeerCertificates[0]
+	verified := len(r.TLS.VerifiedChains) > 0
+	if !verified && clientCAs != nil {
+		intermediates := x509.NewCertPool()
+		for _, cert := range r.TLS.PeerCertificates[1:] {
+			intermediates.AddCert(cert)
+		}
+		_, err := leaf.Verify(x509.VerifyOptions{
+			Roots:         clientCAs,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		})
+		verified = err == nil
+	}
+	if !verified {
+		return nil
+	}
+
+	sum := sha256.Sum256(leaf.Raw)
+	info.cert = &clientCertInfo{
+		Subject:     leaf.Subject.String(),
+		Issuer:      leaf.Issuer.String(),
+		SANs:        certSANs(leaf),
+		Fingerprint: hex.EncodeToString(sum[:]),
+	}
+	return info.cert
+}
+
+// certSANs lists DNS names, emails, IPs and URIs of the certificate
+func certSANs(cert *x509.Certificate) []string {
+	sans := []string{}
+	sans = append(sans, cert.DNSNames...)
+	sans = append(sans, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+	return sans
+}
+
+// values returns the values of the field for matchers. subject, issuer, san or fingerprint
+func (c *clientCertInfo) values(field string) []string {
+	if c == nil {
+		return nil
+	}
+	switch field {
+	case "subject":
+		return []string{c.Subject}
+	case "issuer":
+		return []string{c.Issuer}
+	case "san":
+		return c.SANs
+	case "fingerprint":
+		return []string{c.Fingerprint}
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// issueClientCert issues a client certificate by the CA
+func issueClientCert(ca *x509.Certificate, caKey *ecdsa.PrivateKey, name string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber:   newSerial(),
+		Subject:        pkix.Name{CommonName: name, Organization: []string{"Partner"}},
+		EmailAddresses: []string{name + "@partner.test"},
+		NotBefore:      time.Now().Add(-time.Hour),
+		NotAfter:       time.Now().Add(time.Hour),
+		KeyUsage:       x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:    []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	Expect(err).NotTo(HaveOccurred())
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func fingerprint(cert tls.Certificate) string {
+	sum := sha256.Sum256(cert.Certificate[0])
+	return hex.EncodeToString(sum[:])
+}
+
+var _ = Describe("Mutual TLS", func() {
+	var dir string
+	var c tlsConfig
+	var partnerA, unknown tls.Certificate
+	var roots *x509.CertPool
+	var server *http.Server
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "mtls")
+		Expect(err).NotTo(HaveOccurred())
+
+		// client certificates are issued by a CA other than the server's
+		Expect(os.MkdirAll(filepath.Join(dir, "clients"), 0700)).To(Succeed())
+		clientCA, clientKey, clientCAPEM, err := loadOrCreateCA(filepath.Join(dir, "clients"))
+		Expect(err).NotTo(HaveOccurred())
+		partnerA = issueClientCert(clientCA, clientKey, "partner-a")
+
+		Expect(os.MkdirAll(filepath.Join(dir, "others"), 0700)).To(Succeed())
+		otherCA, otherKey, _, err := loadOrCreateCA(filepath.Join(dir, "others"))
+		Expect(err).NotTo(HaveOccurred())
+		unknown = issueClientCert(otherCA, otherKey, "stranger")
+
+		c = defaultConfig().TLS
+		c.AutoCert.Dir = filepath.Join(dir, "server")
+		c.ClientCAFile = filepath.Join(dir, "client-ca.pem")
+		Expect(ioutil.WriteFile(c.ClientCAFile, clientCAPEM, 0644)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		if server != nil {
+			server.Close()
+			server = nil
+		}
+		os.RemoveAll(dir)
+		caCertPEM = nil
+		clientCAs = nil
+	})
+
+	start := func(handler http.Handler) string {
+		tlsConfig, err := loadTLSConfig(c)
+		Expect(err).NotTo(HaveOccurred())
+		roots = x509.NewCertPool()
+		roots.AppendCertsFromPEM(caCertPEM)
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		server = newServer(defaultConfig().Server, listener.Addr().String(), handler)
+		server.TLSConfig = tlsConfig
+		go server.ServeTLS(listener, "", "")
+		return "https://" + listener.Addr().String()
+	}
+
+	get := func(url string, certs ...tls.Certificate) (*http.Response, error) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      roots,
+			Certificates: certs,
+		}}}
+		return client.Get(url)
+	}
+
+	It("should serve dummies by the client certificate", func() {
+		c.ClientAuth = clientAuthRequest
+		dummies := []dummyModel{
+			{Path: "/orders", Status: 200, Matchers: []matcherModel{{In: "cert", Name: "fingerprint", Op: matchEquals, Value: fingerprint(partnerA)}}},
+			{Path: "/orders", Status: 403},
+		}
+		url := start(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(matchDummy(dummies, r, r.URL.Path, nil).Status)
+		}))
+
+		for cert, status := range map[*tls.Certificate]int{&partnerA: 200, &unknown: 403, nil: 403} {
+			var certs []tls.Certificate
+			if cert != nil {
+				certs = append(certs, *cert)
+			}
+			res, err := get(url+"/orders", certs...)
+			Expect(err).NotTo(HaveOccurred())
+			res.Body.Close()
+			Expect(res.StatusCode).To(Equal(status))
+		}
+	})
+
+	It("should reflect the verified client certificate in echo", func() {
+		c.ClientAuth = clientAuthRequest
+		url := start(createRoute())
+
+		res, err := get(url+"/echo", partnerA)
+		Expect(err).NotTo(HaveOccurred())
+		res.Body.Close()
+		Expect(res.Header.Get("X-Echo-Client-Cert-Subject")).To(Equal("CN=partner-a,O=Partner"))
+		Expect(res.Header.Get("X-Echo-Client-Cert-Issuer")).To(ContainSubstring("CN=Dummy HTTP Responser CA"))
+		Expect(res.Header.Get("X-Echo-Client-Cert-Fingerprint")).To(Equal(fingerprint(partnerA)))
+		Expect(res.Header["X-Echo-Client-Cert-San"]).To(Equal([]string{"partner-a@partner.test"}))
+
+		res, err = get(url+"/echo", unknown)
+		Expect(err).NotTo(HaveOccurred())
+		res.Body.Close()
+		Expect(res.Header.Get("X-Echo-Client-Cert-Subject")).To(BeEmpty())
+	})
+
+	It("should expose the verified client certificate to SOAP templates", func() {
+		c.ClientAuth = clientAuthRequest
+		dummy := dummyModel{ID: bson.NewObjectId()}
+		reqModel := requestModel{SOAP: &soapModel{Operations: []soapOperation{
+			{Response: `<Who><Subject>{{certSubject}}</Subject><Fingerprint>{{certFingerprint}}</Fingerprint>{{range certSANs}}<San>{{.}}</San>{{end}}</Who>`},
+		}}}
+		Expect(reqModel.validate()).To(Succeed())
+		Expect(dummy.updateWithRequestData(&reqModel)).To(Succeed())
+		url := start(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { serveSOAP(w, r, &dummy) }))
+
+		post := func(certs ...tls.Certificate) []byte {
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs}}}
+			res, err := client.Post(url+"/billing", "text/xml", strings.NewReader(testSOAPRequest))
+			Expect(err).NotTo(HaveOccurred())
+			defer res.Body.Close()
+			body, _ := ioutil.ReadAll(res.Body)
+			return body
+		}
+		body := post(partnerA)
+		Expect(xpathValues(body, "//Subject")).To(Equal([]string{"CN=partner-a,O=Partner"}))
+		Expect(xpathValues(body, "//Fingerprint")).To(Equal([]string{fingerprint(partnerA)}))
+		Expect(xpathValues(body, "//San")).To(Equal([]string{"partner-a@partner.test"}))
+
+		body = post(unknown)
+		Expect(xpathValues(body, "//Subject")).To(Equal([]string{""}))
+		Expect(xpathValues(body, "//San")).To(BeEmpty())
+	})
+
+	It("should reject clients without a trusted certificate in require mode", func() {
+		c.ClientAuth = clientAuthRequire
+		url := start(createRoute())
+
+		res, err := get(url+"/echo", partnerA)
+		Expect(err).NotTo(HaveOccurred())
+		res.Body.Close()
+		Expect(res.Header.Get("X-Echo-Client-Cert-Fingerprint")).To(Equal(fingerprint(partnerA)))
+
+		_, err = get(url+"/echo", unknown)
+		Expect(err).To(HaveOccurred())
+		_, err = get(url + "/echo")
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"strings"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// mutations break responses on purpose to test how clients handle bad input
+const (
+	mutationBOM             = "bom"                // prepend the byte order mark of the charset
+	mutationInvalidUTF8     = "invalid_utf8"       // insert invalid UTF-8 sequences after the first double quote
+	mutationMislabelCharset = "mislabel_charset"   // declare another charset than the body is in
+	mutationLineEndings     = "mixed_line_endings" // end lines with CRLF, LF and CR in turn
+	mutationNULBytes        = "nul_bytes"          // append NUL bytes
+	mutationTrailingGarbage = "trailing_garbage"   // append bytes which are not JSON after the body
+)
+
+var supportedMutations = []string{
+	mutationBOM, mutationInvalidUTF8, mutationMislabelCharset, mutationLineEndings, mutationNULBytes, mutationTrailingGarbage,
+}
+
+// invalidUTF8 is a stray byte, a truncated sequence, an overlong encoding and a surrogate
+var invalidUTF8 = []byte("\xff\xc3\x28\xc0\xaf\xed\xa0\x80")
+
+var errorInvalidMutation = &errorResponse{"InvalidMutation", "mutate should be some of " + strings.Join(supportedMutations, ", ")}
+
+// validateMutations checks every mutation is supported
+func validateMutations(mutations []string) error {
+	for _, mutation := range mutations {
+		if !hasMutation(supportedMutations, mutation) {
+			return fmt.Errorf("mutation %s is not supported", mutation)
+		}
+	}
+	return nil
+}
+
+func hasMutation(mutations []string, mutation string) bool {
+	for _, m := range mutations {
+		if m == mutation {
+			return true
+		}
+	}
+	return false
+}
+
+// mixLineEndings ends lines with CRLF, LF and CR in turn
+func mixLineEndings(content string) string {
+	lines := strings.Split(strings.Replace(content, "\r\n", "\n", -1), "\n")
+	endings := []string{"\r\n", "\n", "\r"}
+	var b strings.Builder
+	for i, line := range lines {
+		if i > 0 {
+			b.WriteString(endings[(i-1)%len(endings)])
+		}
+		b.WriteString(line)
+	}
+	return b.String()
+}
+
+// mutateBody applies mutations on the encoded body. Line endings are mixed before the body is encoded
+func mutateBody(body []byte, charset string, mutations []string) []byte {
+	if hasMutation(mutations, mutationInvalidUTF8) {
+		at := bytes.IndexByte(body, '"') + 1
+		body = append(body[:at:at], append(append([]byte{}, invalidUTF8...), body[at:]...)...)
+	}
+	if hasMutation(mutations, mutationBOM) {
+		body = append(byteOrderMark(charset), body...)
+	}
+	if hasMutation(mutations, mutationNULBytes) {
+		body = append(body, 0, 0, 0, 0)
+	}
+	if hasMutation(mutations, mutationTrailingGarbage) {
+		body = append(body, "\n}]trailing garbage"...)
+	}
+	return body
+}
+
+// byteOrderMark is the BOM of UTF-16 charsets, and the one of UTF-8 for others
+func byteOrderMark(charset string) []byte {
+	switch charsetName(charset) {
+	case "utf-16le":
+		return []byte{0xff, 0xfe}
+	case "utf-16be":
+		return []byte{0xfe, 0xff}
+	}
+	return []byte{0xef, 0xbb, 0xbf}
+}
+
+// mislabeledCharset is a charset other than the one the body is in
+func mislabeledCharset(charset string) string {
+	if charsetName(charset) == "utf-8" {
+		return "iso-8859-1"
+	}
+	return "utf-8"
+}
+
+// charsetName is the canonical name of the charset. Unknown charsets are taken as UTF-8
+func charsetName(charset string) string {
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return "utf-8"
+	}
+	name, _ := htmlindex.Name(enc)
+	return name
+}
+
+// mutateEcho applies mutations on an echoed body and its content type
+func mutateEcho(contentType string, body []byte, mutations []string) (string, []byte) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+	charset := params["charset"]
+	if hasMutation(mutations, mutationLineEndings) {
+		body = []byte(mixLineEndings(string(body)))
+	}
+	if hasMutation(mutations, mutationMislabelCharset) {
+		params["charset"] = mislabeledCharset(charset)
+		contentType = mime.FormatMediaType(mediaType, params)
+	}
+	return contentType, mutateBody(body, charset, mutations)
+}
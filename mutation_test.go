@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Mutations", func() {
+	serve := func(dummy dummyModel) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		writeDummy(w, httptest.NewRequest("GET", "/v1/id", nil), &dummy, 0)
+		return w
+	}
+
+	It("should break content of dummies on purpose", func() {
+		dummy := dummyModel{Status: 200, ContentType: "application/json", Charset: "utf-8", Content: `{"name": "a"}`}
+		dummy.Mutations = []string{mutationInvalidUTF8, mutationNULBytes}
+		Expect(serve(dummy).Body.String()).To(Equal("{\"\xff\xc3\x28\xc0\xaf\xed\xa0\x80name\": \"a\"}\x00\x00\x00\x00"))
+
+		dummy.Mutations = []string{mutationMislabelCharset}
+		w := serve(dummy)
+		Expect(w.Header().Get("Content-Type")).To(Equal("application/json; charset=iso-8859-1"))
+		Expect(w.Body.String()).To(Equal(`{"name": "a"}`))
+	})
+
+	It("should mix line endings before encoding and take the BOM of the charset", func() {
+		dummy := dummyModel{Status: 200, ContentType: "text/plain", Charset: "utf-16", Content: "a\nb\r\nc\nd",
+			Mutations: []string{mutationLineEndings, mutationBOM}}
+		Expect(serve(dummy).Body.Bytes()).To(Equal([]byte{0xff, 0xfe, 'a', 0, '\r', 0, '\n', 0, 'b', 0, '\n', 0, 'c', 0, '\r', 0, 'd', 0}))
+		Expect(mixLineEndings("no line")).To(Equal("no line"))
+	})
+
+	It("should validate mutations", func() {
+		reqModel := requestModel{Status: 200, ContentType: "text/plain", Charset: "utf-8", Mutations: []string{mutationBOM}}
+		Expect(reqModel.validate()).To(Succeed())
+		reqModel.Mutations = []string{"nope"}
+		Expect(reqModel.validate()).To(MatchError("mutation nope is not supported"))
+		reqModel.Mutations, reqModel.SSE = []string{mutationBOM}, &sseModel{}
+		Expect(reqModel.validate()).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,590 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/julienschmidt/httprouter"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const sourceOpenAPI = "openapi"
+
+// maxSchemaDepth limits recursion on synthesizing values from recursive schemas
+const maxSchemaDepth = 8
+
+// operation methods in a path item
+var specMethods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+// apiSpec is a parsed OpenAPI 3 or Swagger 2 document
+type apiSpec struct {
+	doc      map[string]interface{}
+	version  int    // 2 for Swagger 2.0, 3 for OpenAPI 3.x
+	basePath string // prefix for all paths. e.g. /v1
+	// compiled 'pattern' of schemas. nil for invalid ones
+	patterns map[string]*regexp.Regexp
+}
+
+// apiOperation is an operation in the spec
+type apiOperation struct {
+	Method   string // upper case http method
+	Path     string // path template including base path
+	op       map[string]interface{}
+	pathItem map[string]interface{}
+}
+
+// parseAPISpec parses a YAML or JSON document
+func parseAPISpec(raw []byte) (*apiSpec, error) {
+	var parsed interface{}
+	trimmed := bytes.TrimSpace(raw)
+	if bytes.HasPrefix(trimmed, []byte("{")) {
+		if err := json.Unmarshal(trimmed, &parsed); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := yaml.Unmarshal(raw, &parsed); err != nil {
+			return nil, err
+		}
+		parsed = normalizeYAML(parsed)
+	}
+
+	doc, ok := parsed.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("spec is not an object")
+	}
+
+	spec := &apiSpec{doc: doc}
+	if v, ok := doc["openapi"].(string); ok && strings.HasPrefix(v, "3.") {
+		spec.version = 3
+		if servers, ok := doc["servers"].([]interface{}); ok && len(servers) > 0 {
+			if server, ok := servers[0].(map[string]interface{}); ok {
+				serverURL, _ := server["url"].(string)
+				if u, err := url.Parse(serverURL); err == nil {
+					spec.basePath = u.Path
+				}
+			}
+		}
+	} else if v := fmt.Sprint(doc["swagger"]); v == "2.0" || v == "2" {
+		spec.version = 2
+		spec.basePath, _ = doc["basePath"].(string)
+	} else {
+		return nil, errors.New("neither 'openapi: 3.x' nor 'swagger: 2.0' is specified")
+	}
+	spec.basePath = strings.TrimRight(spec.basePath, "/")
+
+	if _, ok := doc["paths"].(map[string]interface{}); !ok {
+		return nil, errors.New("paths is empty")
+	}
+	spec.patterns = map[string]*regexp.Regexp{}
+	spec.compilePatterns(doc)
+	return spec, nil
+}
+
+// compilePatterns compiles every 'pattern' in the document once
+// so that validating requests does not compile them again
+func (s *apiSpec) compilePatterns(v interface{}) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, val := range t {
+			if pattern, ok := val.(string); ok && k == "pattern" {
+				if _, ok := s.patterns[pattern]; !ok {
+					s.patterns[pattern], _ = regexp.Compile(pattern)
+				}
+				continue
+			}
+			s.compilePatterns(val)
+		}
+	case []interface{}:
+		for _, val := range t {
+			s.compilePatterns(val)
+		}
+	}
+}
+
+// normalizeYAML converts map[interface{}]interface{} from yaml into map[string]interface{}
+// so that the document can be handled like JSON
+func normalizeYAML(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(t))
+		for k, val := range t {
+			m[fmt.Sprint(k)] = normalizeYAML(val)
+		}
+		return m
+	case []interface{}:
+		for i := range t {
+			t[i] = normalizeYAML(t[i])
+		}
+		return t
+	case time.Time:
+		return t.Format(time.RFC3339)
+	}
+	return v
+}
+
+// operations returns all operations sorted by path and method
+func (s *apiSpec) operations() []apiOperation {
+	paths := s.doc["paths"].(map[string]interface{})
+	keys := make([]string, 0, len(paths))
+	for k := range paths {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var ops []apiOperation
+	for _, path := range keys {
+		pathItem := s.resolve(paths[path])
+		if pathItem == nil {
+			continue
+		}
+		for _, method := range specMethods {
+			op, ok := pathItem[method].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			ops = append(ops, apiOperation{
+				Method:   strings.ToUpper(method),
+				Path:     s.basePath + path,
+				op:       op,
+				pathItem: pathItem,
+			})
+		}
+	}
+	return ops
+}
+
+// resolve follows local $ref like '#/components/schemas/Pet'.
+// It returns nil if v is not an object or the reference is not found
+func (s *apiSpec) resolve(v interface{}) map[string]interface{} {
+	m, _ := v.(map[string]interface{})
+	for i := 0; m != nil && i < maxSchemaDepth; i++ {
+		ref, ok := m["$ref"].(string)
+		if !ok {
+			return m
+		}
+		m, _ = s.lookup(ref).(map[string]interface{})
+	}
+	return m
+}
+
+// lookup finds the value of the JSON pointer in the document
+func (s *apiSpec) lookup(ref string) interface{} {
+	if !strings.HasPrefix(ref, "#/") {
+		return nil
+	}
+	var cur interface{} = s.doc
+	for _, token := range strings.Split(ref[2:], "/") {
+		token = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		cur = m[token]
+	}
+	return cur
+}
+
+// dummies creates a dummy for every operation in the spec.
+// Operations which can not be converted are reported as warnings
+func (s *apiSpec) dummies() ([]dummyModel, []string) {
+	var dummies []dummyModel
+	var warnings []string
+	for _, op := range s.operations() {
+		dummy, err := s.dummyForOperation(op)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s %s: %s", op.Method, op.Path, err.Error()))
+			continue
+		}
+		dummies = append(dummies, *dummy)
+	}
+	return dummies, warnings
+}
+
+func (s *apiSpec) dummyForOperation(op apiOperation) (*dummyModel, error) {
+	responses, ok := op.op["responses"].(map[string]interface{})
+	if !ok || len(responses) == 0 {
+		return nil, errors.New("no responses")
+	}
+	code, status := pickResponse(responses)
+	response := s.resolve(responses[code])
+	if response == nil {
+		return nil, fmt.Errorf("response %s can not be resolved", code)
+	}
+
+	var contentType string
+	var example interface{}
+	if s.version == 3 {
+		contentType, example = s.exampleV3(response)
+	} else {
+		contentType, example = s.exampleV2(op, response)
+	}
+
+	headers := s.responseHeaders(response)
+	headersJSON, err := json.Marshal(headers)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := encodeExample(example, contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dummyModel{
+		Method:      op.Method,
+		Path:        op.Path,
+		Source:      sourceOpenAPI,
+		Version:     apiVersion,
+		Content:     content,
+		Charset:     "utf-8",
+		ContentType: contentType,
+		Headers:     string(headersJSON),
+		Status:      status,
+		CreatedAt:   time.Now(),
+	}, nil
+}
+
+// pickResponse chooses the response to serve. The lowest 2xx status is preferred,
+// then 'default' and then the lowest status in the spec
+func pickResponse(responses map[string]interface{}) (string, int) {
+	codes := make([]string, 0, len(responses))
+	for code := range responses {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+
+	for _, code := range codes {
+		if strings.HasPrefix(code, "2") {
+			return code, responseStatus(code)
+		}
+	}
+	if _, ok := responses["default"]; ok {
+		return "default", http.StatusOK
+	}
+	return codes[0], responseStatus(codes[0])
+}
+
+// responseStatus converts a response key like 200 or 2XX into a status code
+func responseStatus(code string) int {
+	if status, err := strconv.Atoi(code); err == nil {
+		return status
+	}
+	if len(code) == 3 && strings.ToUpper(code[1:]) == "XX" && code[0] >= '1' && code[0] <= '5' {
+		return int(code[0]-'0') * 100
+	}
+	return http.StatusOK
+}
+
+// exampleV3 returns the content type and the example of an OpenAPI 3 response
+func (s *apiSpec) exampleV3(response map[string]interface{}) (string, interface{}) {
+	content, _ := response["content"].(map[string]interface{})
+	if len(content) == 0 {
+		return "text/plain", nil
+	}
+	contentType := preferredContentType(content)
+	media := s.resolve(content[contentType])
+	if media == nil {
+		return contentType, nil
+	}
+	if v, ok := media["example"]; ok {
+		return contentType, v
+	}
+	if examples, ok := media["examples"].(map[string]interface{}); ok && len(examples) > 0 {
+		names := make([]string, 0, len(examples))
+		for name := range examples {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if ex := s.resolve(examples[names[0]]); ex != nil {
+			if v, ok := ex["value"]; ok {
+				return contentType, v
+			}
+		}
+	}
+	return contentType, s.synthesize(media["schema"])
+}
+
+// exampleV2 returns the content type and the example of a Swagger 2 response
+func (s *apiSpec) exampleV2(op apiOperation, response map[string]interface{}) (string, interface{}) {
+	produces, ok := op.op["produces"].([]interface{})
+	if !ok {
+		produces, _ = s.doc["produces"].([]interface{})
+	}
+	contentType := "application/json"
+	if len(produces) > 0 {
+		mimes := map[string]interface{}{}
+		for _, p := range produces {
+			mimes[fmt.Sprint(p)] = nil
+		}
+		contentType = preferredContentType(mimes)
+	}
+
+	if examples, ok := response["examples"].(map[string]interface{}); ok && len(examples) > 0 {
+		if v, ok := examples[contentType]; ok {
+			return contentType, v
+		}
+		mime := preferredContentType(examples)
+		return mime, examples[mime]
+	}
+	if response["schema"] == nil {
+		return contentType, nil
+	}
+	return contentType, s.synthesize(response["schema"])
+}
+
+// preferredContentType picks a JSON media type if exists, or the first one
+func preferredContentType(media map[string]interface{}) string {
+	types := make([]string, 0, len(media))
+	for t := range media {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	for _, t := range types {
+		if strings.Contains(t, "json") {
+			return t
+		}
+	}
+	return types[0]
+}
+
+// responseHeaders returns example values of headers in a response
+func (s *apiSpec) responseHeaders(response map[string]interface{}) map[string]string {
+	headers := map[string]string{}
+	defs, _ := response["headers"].(map[string]interface{})
+	for name, def := range defs {
+		if strings.EqualFold(name, "Content-Type") {
+			continue
+		}
+		hdr := s.resolve(def)
+		if hdr == nil {
+			continue
+		}
+		var v interface{}
+		if ex, ok := hdr["example"]; ok {
+			v = ex
+		} else if ex, ok := hdr["x-example"]; ok {
+			v = ex
+		} else if hdr["schema"] != nil {
+			v = s.synthesize(hdr["schema"])
+		} else {
+			// Swagger 2 header object is a schema itself
+			v = s.synthesize(hdr)
+		}
+		if v != nil {
+			headers[name] = fmt.Sprint(v)
+		}
+	}
+	return headers
+}
+
+// synthesize generates a value which conforms to the schema
+func (s *apiSpec) synthesize(schemaRef interface{}) interface{} {
+	return s.synthesizeValue(schemaRef, 0, nil)
+}
+
+// synthesizeValue generates a value for the schema. refs is the chain of $ref being expanded
+// so that a recursive schema like a tree node stops at the first recursion
+func (s *apiSpec) synthesizeValue(schemaRef interface{}, depth int, refs []string) interface{} {
+	if m, ok := schemaRef.(map[string]interface{}); ok {
+		if ref, ok := m["$ref"].(string); ok {
+			for _, seen := range refs {
+				if seen == ref {
+					return nil
+				}
+			}
+			refs = append(refs[:len(refs):len(refs)], ref)
+		}
+	}
+	schema := s.resolve(schemaRef)
+	if schema == nil || depth > maxSchemaDepth {
+		return nil
+	}
+	if v, ok := schema["example"]; ok {
+		return v
+	}
+	if v, ok := schema["default"]; ok {
+		return v
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok && len(enum) > 0 {
+		return enum[0]
+	}
+	if allOf, ok := schema["allOf"].([]interface{}); ok && len(allOf) > 0 {
+		merged := map[string]interface{}{}
+		for _, sub := range allOf {
+			if obj, ok := s.synthesizeValue(sub, depth+1, refs).(map[string]interface{}); ok {
+				for k, v := range obj {
+					merged[k] = v
+				}
+			}
+		}
+		return merged
+	}
+	for _, key := range []string{"oneOf", "anyOf"} {
+		if list, ok := schema[key].([]interface{}); ok && len(list) > 0 {
+			return s.synthesizeValue(list[0], depth+1, refs)
+		}
+	}
+
+	switch schemaType(schema) {
+	case "object":
+		obj := map[string]interface{}{}
+		props, _ := schema["properties"].(map[string]interface{})
+		for name, prop := range props {
+			if v := s.synthesizeValue(prop, depth+1, refs); v != nil {
+				obj[name] = v
+			}
+		}
+		return obj
+	case "array":
+		if item := s.synthesizeValue(schema["items"], depth+1, refs); item != nil {
+			return []interface{}{item}
+		}
+		return []interface{}{}
+	case "string":
+		return exampleString(schema)
+	case "integer":
+		if v, ok := schema["minimum"]; ok {
+			return v
+		}
+		return 0
+	case "number":
+		if v, ok := schema["minimum"]; ok {
+			return v
+		}
+		return 0.0
+	case "boolean":
+		return true
+	}
+	return nil
+}
+
+// schemaType returns the type of schema. The type is guessed if not specified
+func schemaType(schema map[string]interface{}) string {
+	switch t := schema["type"].(type) {
+	case string:
+		return t
+	case []interface{}:
+		// OpenAPI 3.1 allows type arrays like [string, "null"]
+		for _, v := range t {
+			if name := fmt.Sprint(v); name != "null" {
+				return name
+			}
+		}
+	}
+	if _, ok := schema["properties"]; ok {
+		return "object"
+	}
+	if _, ok := schema["items"]; ok {
+		return "array"
+	}
+	return ""
+}
+
+func exampleString(schema map[string]interface{}) string {
+	format, _ := schema["format"].(string)
+	switch format {
+	case "date-time":
+		return "2018-03-30T09:00:00Z"
+	case "date":
+		return "2018-03-30"
+	case "time":
+		return "09:00:00"
+	case "email":
+		return "user@example.com"
+	case "uuid":
+		return "3fa85f64-5717-4562-b3fc-2c963f66afa6"
+	case "uri", "url":
+		return "https://example.com"
+	case "hostname":
+		return "example.com"
+	case "ipv4":
+		return "192.0.2.1"
+	case "ipv6":
+		return "2001:db8::1"
+	case "byte":
+		return "ZHVtbXk="
+	}
+	return "string"
+}
+
+// encodeExample converts an example into the content of a dummy
+func encodeExample(example interface{}, contentType string) (string, error) {
+	if example == nil {
+		return "", nil
+	}
+	if str, ok := example.(string); ok && !strings.Contains(contentType, "json") {
+		return str, nil
+	}
+	byt, err := json.MarshalIndent(example, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(byt), nil
+}
+
+// handler for POST /projects/:id/openapi
+// Body is an OpenAPI 3 or Swagger 2 document in YAML or JSON.
+// Dummies which were imported before are replaced
+func handleImportOpenAPI(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	project := findWritableProject(w, r, ps)
+	if project == nil {
+		return
+	}
+
+	raw, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(requestError(r, errorInvalidData))
+		return
+	}
+	spec, err := parseAPISpec(raw)
+	if err != nil {
+		requestLog(r).Warningf("fail to parse spec %s", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(requestError(r, &errorResponse{"InvalidSpec", err.Error()}))
+		return
+	}
+
+	dummies, warnings := spec.dummies()
+
+	imported, err := replaceProjectDummies(r.Context(), project, sourceOpenAPI, dummies)
+	if err != nil {
+		requestLog(r).Errorf("error on saving imported dummies: %s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = observeStore(r.Context(), "update_project", func() error {
+		return db.C(collectionProject).UpdateId(project.ID, bson.M{"$set": bson.M{"spec": string(raw)}})
+	})
+	if err != nil {
+		requestLog(r).Errorf("error on attaching the spec: %s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if warnings == nil {
+		warnings = []string{}
+	}
+	json.NewEncoder(w).Encode(struct {
+		URL      string          `json:"url"`
+		Dummies  []importedDummy `json:"dummies"`
+		Warnings []string        `json:"warnings"`
+	}{
+		URL:      baseURL(r) + "mock/" + project.ID.Hex(),
+		Dummies:  imported,
+		Warnings: warnings,
+	})
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/julienschmidt/httprouter"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// openAPIDocument is an OpenAPI 3 document exported from dummies of a project
+type openAPIDocument struct {
+	OpenAPI string                                  `json:"openapi" yaml:"openapi"`
+	Info    openAPIInfo                             `json:"info" yaml:"info"`
+	Paths   map[string]map[string]*openAPIOperation `json:"paths" yaml:"paths"`
+}
+
+type openAPIInfo struct {
+	Title   string `json:"title" yaml:"title"`
+	Version string `json:"version" yaml:"version"`
+}
+
+type openAPIOperation struct {
+	Parameters []openAPIParameter          `json:"parameters,omitempty" yaml:"parameters,omitempty"`
+	Responses  map[string]*openAPIResponse `json:"responses" yaml:"responses"`
+}
+
+type openAPIParameter struct {
+	Name     string                 `json:"name" yaml:"name"`
+	In       string                 `json:"in" yaml:"in"`
+	Required bool                   `json:"required" yaml:"required"`
+	Schema   map[string]interface{} `json:"schema" yaml:"schema"`
+}
+
+type openAPIResponse struct {
+	Description string                       `json:"description" yaml:"description"`
+	Headers     map[string]openAPIHeader     `json:"headers,omitempty" yaml:"headers,omitempty"`
+	Content     map[string]*openAPIMediaType `json:"content,omitempty" yaml:"content,omitempty"`
+}
+
+type openAPIHeader struct {
+	Schema  map[string]interface{} `json:"schema" yaml:"schema"`
+	Example string                 `json:"example" yaml:"example"`
+}
+
+type openAPIMediaType struct {
+	Schema  map[string]interface{} `json:"schema,omitempty" yaml:"schema,omitempty"`
+	Example interface{}            `json:"example,omitempty" yaml:"example,omitempty"`
+}
+
+// exportOpenAPI builds an OpenAPI 3 document from the dummies.
+// Dummies with the same method and path become responses of one operation
+func exportOpenAPI(project *projectModel, dummies []dummyModel) *openAPIDocument {
+	doc := &openAPIDocument{
+		OpenAPI: "3.0.3",
+		Info:    openAPIInfo{Title: project.Name, Version: "1.0.0"},
+		Paths:   map[string]map[string]*openAPIOperation{},
+	}
+
+	for i := range dummies {
+		dummy := &dummies[i]
+		if dummy.Path == "" || dummy.Method == "" {
+			continue
+		}
+		path := "/" + strings.TrimLeft(dummy.Path, "/")
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = map[string]*openAPIOperation{}
+		}
+		method := strings.ToLower(dummy.Method)
+		op := doc.Paths[path][method]
+		if op == nil {
+			op = &openAPIOperation{
+				Parameters: pathParameters(path),
+				Responses:  map[string]*openAPIResponse{},
+			}
+			doc.Paths[path][method] = op
+		}
+
+		code := strconv.Itoa(dummy.Status)
+		response := op.Responses[code]
+		if response == nil {
+			response = &openAPIResponse{Description: http.StatusText(dummy.Status)}
+			if response.Description == "" {
+				response.Description = "Response " + code
+			}
+			response.Headers = exportHeaders(dummy.Headers)
+			op.Responses[code] = response
+		}
+
+		if dummy.Content == "" {
+			continue
+		}
+		if response.Content == nil {
+			response.Content = map[string]*openAPIMediaType{}
+		}
+		if _, ok := response.Content[dummy.ContentType]; ok {
+			// the first dummy wins for the same status and content type
+			continue
+		}
+		response.Content[dummy.ContentType] = exportMediaType(dummy)
+	}
+	return doc
+}
+
+// pathParameters declares template variables of the path as string parameters
+func pathParameters(path string) []openAPIParameter {
+	var params []openAPIParameter
+	for _, seg := range splitPath(path) {
+		if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+			params = append(params, openAPIParameter{
+				Name:     seg[1 : len(seg)-1],
+				In:       "path",
+				Required: true,
+				Schema:   map[string]interface{}{"type": "string"},
+			})
+		}
+	}
+	return params
+}
+
+func exportHeaders(headersJSON string) map[string]openAPIHeader {
+	var headers map[string]string
+	if err := json.Unmarshal([]byte(headersJSON), &headers); err != nil || len(headers) == 0 {
+		return nil
+	}
+	exported := map[string]openAPIHeader{}
+	for name, value := range headers {
+		if strings.EqualFold(name, "Content-Type") {
+			continue
+		}
+		exported[name] = openAPIHeader{
+			Schema:  map[string]interface{}{"type": "string"},
+			Example: value,
+		}
+	}
+	return exported
+}
+
+// exportMediaType uses the content as the example. The schema is inferred from JSON content
+func exportMediaType(dummy *dummyModel) *openAPIMediaType {
+	if strings.Contains(dummy.ContentType, "json") {
+		var v interface{}
+		if err := json.Unmarshal([]byte(dummy.Content), &v); err == nil {
+			return &openAPIMediaType{Schema: inferSchema(v), Example: v}
+		}
+	}
+	return &openAPIMediaType{
+		Schema:  map[string]interface{}{"type": "string"},
+		Example: dummy.Content,
+	}
+}
+
+// inferSchema infers a JSON schema from a decoded JSON value
+func inferSchema(v interface{}) map[string]interface{} {
+	switch t := v.(type) {
+	case nil:
+		return map[string]interface{}{"nullable": true}
+	case bool:
+		return map[string]interface{}{"type": "boolean"}
+	case float64:
+		if t == float64(int64(t)) {
+			return map[string]interface{}{"type": "integer"}
+		}
+		return map[string]interface{}{"type": "number"}
+	case string:
+		schema := map[string]interface{}{"type": "string"}
+		if _, err := time.Parse(time.RFC3339, t); err == nil {
+			schema["format"] = "date-time"
+		} else if uuidPattern.MatchString(t) {
+			schema["format"] = "uuid"
+		}
+		return schema
+	case []interface{}:
+		schema := map[string]interface{}{"type": "array", "items": map[string]interface{}{}}
+		if len(t) > 0 {
+			items := inferSchema(t[0])
+			for _, item := range t[1:] {
+				items = mergeSchema(items, inferSchema(item))
+			}
+			schema["items"] = items
+		}
+		return schema
+	case map[string]interface{}:
+		props := map[string]interface{}{}
+		required := make([]string, 0, len(t))
+		for name, val := range t {
+			props[name] = inferSchema(val)
+			required = append(required, name)
+		}
+		sort.Strings(required)
+		schema := map[string]interface{}{"type": "object", "properties": props}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		return schema
+	}
+	return map[string]interface{}{}
+}
+
+// mergeSchema merges schemas of array items. Properties of objects are merged and
+// only properties in every item remain required
+func mergeSchema(a, b map[string]interface{}) map[string]interface{} {
+	if a["type"] != b["type"] {
+		if a["type"] == "integer" && b["type"] == "number" {
+			return b
+		}
+		return a
+	}
+	if a["type"] != "object" {
+		return a
+	}
+	propsA := a["properties"].(map[string]interface{})
+	for name, prop := range b["properties"].(map[string]interface{}) {
+		if _, ok := propsA[name]; !ok {
+			propsA[name] = prop
+		}
+	}
+	requiredB := map[string]bool{}
+	if list, ok := b["required"].([]string); ok {
+		for _, name := range list {
+			requiredB[name] = true
+		}
+	}
+	var required []string
+	if list, ok := a["required"].([]string); ok {
+		for _, name := range list {
+			if requiredB[name] {
+				required = append(required, name)
+			}
+		}
+	}
+	if len(required) > 0 {
+		a["required"] = required
+	} else {
+		delete(a, "required")
+	}
+	return a
+}
+
+// handler for GET /projects/:id/openapi
+// 'format' query is yaml or json. YAML is the default unless JSON is accepted
+func handleExportOpenAPI(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	project := findProject(w, r, ps)
+	if project == nil {
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "yaml"
+		if strings.Contains(r.Header.Get("Accept"), "application/json") {
+			format = "json"
+		}
+	}
+	if format != "yaml" && format != "json" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(requestError(r, &errorResponse{"InvalidFormat", "format should be yaml or json"}))
+		return
+	}
+
+	var dummies []dummyModel
+	err := observeStore(r.Context(), "find_project_dummies", func() error {
+		return db.C(collectionDummy).Find(bson.M{"project": project.ID, "grpc": bson.M{"$exists": false}}).Sort("_id").All(&dummies)
+	})
+	if err != nil {
+		requestLog(r).Errorf("fail to find dummies of project %s: %s", project.ID.Hex(), err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	doc := exportOpenAPI(project, dummies)
+	if format == "json" {
+		w.WriteHeader(http.StatusOK)
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(doc)
+		return
+	}
+
+	byt, err := yaml.Marshal(doc)
+	if err != nil {
+		requestLog(r).Errorf("fail to marshal yaml: %s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/yaml; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(byt)
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/globalsign/mgo/bson"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const testOpenAPISpec = `
+openapi: 3.0.0
+servers:
+  - url: https://api.example.com/v1
+paths:
+  /pets:
+    get:
+      responses:
+        '200':
+          description: list of pets
+          headers:
+            X-Rate-Limit:
+              schema:
+                type: integer
+                example: 100
+          content:
+            application/json:
+              schema:
+                type: array
+                items:
+                  $ref: '#/components/schemas/Pet'
+    post:
+      responses:
+        '201':
+          description: created
+          content:
+            application/json:
+              example: {"id": 1, "name": "doggie"}
+  /pets/{petId}:
+    get:
+      responses:
+        default:
+          description: a pet
+          content:
+            application/xml:
+              example: <pet/>
+components:
+  schemas:
+    Pet:
+      type: object
+      properties:
+        id:
+          type: integer
+        name:
+          type: string
+        kind:
+          type: string
+          enum: [dog, cat]
+        parent:
+          $ref: '#/components/schemas/Pet'
+`
+
+const testSwaggerSpec = `{
+	"swagger": "2.0",
+	"basePath": "/api",
+	"produces": ["application/json"],
+	"paths": {
+		"/users": {
+			"get": {
+				"responses": {
+					"404": {"description": "not found"},
+					"200": {
+						"description": "users",
+						"schema": {"type": "array", "items": {"$ref": "#/definitions/User"}}
+					}
+				}
+			}
+		}
+	},
+	"definitions": {
+		"User": {"properties": {"email": {"type": "string", "format": "email"}}}
+	}
+}`
+
+var _ = Describe("OpenAPI import", func() {
+	Context("with OpenAPI 3 spec", func() {
+		It("should create a dummy per operation", func() {
+			spec, err := parseAPISpec([]byte(testOpenAPISpec))
+			Expect(err).NotTo(HaveOccurred())
+			dummies, warnings := spec.dummies()
+			Expect(warnings).To(BeEmpty())
+			Expect(dummies).To(HaveLen(3))
+
+			Expect(dummies[0].Method).To(Equal("GET"))
+			Expect(dummies[0].Path).To(Equal("/v1/pets"))
+			Expect(dummies[0].Status).To(Equal(200))
+			Expect(dummies[0].Headers).To(Equal(`{"X-Rate-Limit":"100"}`))
+			Expect(dummies[0].Content).To(MatchJSON(`[{"id": 0, "name": "string", "kind": "dog"}]`))
+
+			Expect(dummies[1].Method).To(Equal("POST"))
+			Expect(dummies[1].Status).To(Equal(201))
+			Expect(dummies[1].Content).To(MatchJSON(`{"id": 1, "name": "doggie"}`))
+
+			Expect(dummies[2].Path).To(Equal("/v1/pets/{petId}"))
+			Expect(dummies[2].ContentType).To(Equal("application/xml"))
+			Expect(dummies[2].Content).To(Equal("<pet/>"))
+		})
+	})
+
+	Context("with Swagger 2 spec", func() {
+		It("should synthesize content from the schema", func() {
+			spec, err := parseAPISpec([]byte(testSwaggerSpec))
+			Expect(err).NotTo(HaveOccurred())
+			dummies, _ := spec.dummies()
+			Expect(dummies).To(HaveLen(1))
+			Expect(dummies[0].Path).To(Equal("/api/users"))
+			Expect(dummies[0].Status).To(Equal(200))
+			Expect(dummies[0].ContentType).To(Equal("application/json"))
+			Expect(dummies[0].Content).To(MatchJSON(`[{"email": "user@example.com"}]`))
+		})
+	})
+
+	Context("with invalid spec", func() {
+		It("should fail without version", func() {
+			_, err := parseAPISpec([]byte(`paths: {}`))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Context("with importing url", func() {
+		It("should serve imported dummies", func() {
+			project := projectModel{ID: bson.NewObjectId(), Name: "pet store"}
+			if err := testDB.C(collectionProject).Insert(&project); err != nil {
+				panic(err.Error())
+			}
+
+			req, _ := http.NewRequest("POST", "/projects/"+project.ID.Hex()+"/openapi", bytes.NewBufferString(testOpenAPISpec))
+			w := httptest.NewRecorder()
+			createRoute().ServeHTTP(w, req)
+			Expect(w.Code).To(Equal(http.StatusOK))
+
+			var resp struct {
+				Dummies []map[string]interface{} `json:"dummies"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				panic(err.Error())
+			}
+			Expect(resp.Dummies).To(HaveLen(3))
+
+			req, _ = http.NewRequest("GET", "/mock/"+project.ID.Hex()+"/v1/pets/3", nil)
+			w = httptest.NewRecorder()
+			createRoute().ServeHTTP(w, req)
+			Expect(w.Code).To(Equal(http.StatusOK))
+			Expect(w.Header().Get("Content-Type")).To(Equal("application/xml; charset=utf-8"))
+			Expect(w.Body.String()).To(Equal("<pet/>"))
+
+			// should delete test data
+			testDB.C(collectionDummy).RemoveAll(bson.M{"project": project.ID})
+			testDB.C(collectionProject).RemoveId(project.ID)
+		})
+	})
+})
+
+var _ = Describe("OpenAPI export", func() {
+	Context("with dummies of a project", func() {
+		It("should build operations and infer schemas", func() {
+			project := &projectModel{ID: bson.NewObjectId(), Name: "pet store"}
+			dummies := []dummyModel{
+				{Method: "GET", Path: "/pets/{id}", Status: 200, ContentType: "application/json",
+					Content: `{"id": 1, "name": "doggie", "tags": [{"a": 1}, {"a": 2, "b": true}]}`,
+					Headers: `{"X-Rate-Limit": "100"}`},
+				{Method: "GET", Path: "/pets/{id}", Status: 404, ContentType: "text/plain", Content: "not found"},
+			}
+
+			doc := exportOpenAPI(project, dummies)
+			op := doc.Paths["/pets/{id}"]["get"]
+			Expect(op).NotTo(BeNil())
+			Expect(op.Parameters).To(HaveLen(1))
+			Expect(op.Parameters[0].Name).To(Equal("id"))
+			Expect(op.Responses).To(HaveKey("200"))
+			Expect(op.Responses).To(HaveKey("404"))
+			Expect(op.Responses["200"].Headers["X-Rate-Limit"].Example).To(Equal("100"))
+			Expect(op.Responses["404"].Content["text/plain"].Example).To(Equal("not found"))
+
+			schema, _ := json.Marshal(op.Responses["200"].Content["application/json"].Schema)
+			Expect(schema).To(MatchJSON(`{
+				"type": "object",
+				"required": ["id", "name", "tags"],
+				"properties": {
+					"id": {"type": "integer"},
+					"name": {"type": "string"},
+					"tags": {"type": "array", "items": {
+						"type": "object",
+						"required": ["a"],
+						"properties": {"a": {"type": "integer"}, "b": {"type": "boolean"}}
+					}}
+				}
+			}`))
+		})
+
+		It("should be imported again", func() {
+			project := &projectModel{ID: bson.NewObjectId(), Name: "pet store"}
+			dummies := []dummyModel{
+				{Method: "POST", Path: "/pets", Status: 201, ContentType: "application/json", Content: `{"id": 1}`},
+			}
+			byt, err := json.Marshal(exportOpenAPI(project, dummies))
+			Expect(err).NotTo(HaveOccurred())
+
+			spec, err := parseAPISpec(byt)
+			Expect(err).NotTo(HaveOccurred())
+			imported, warnings := spec.dummies()
+			Expect(warnings).To(BeEmpty())
+			Expect(imported).To(HaveLen(1))
+			Expect(imported[0].Path).To(Equal("/pets"))
+			Expect(imported[0].Status).To(Equal(201))
+			Expect(imported[0].Content).To(MatchJSON(`{"id": 1}`))
+		})
+	})
+})
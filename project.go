@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/julienschmidt/httprouter"
+	log "github.com/sirupsen/logrus"
+)
+
+// projectMethods are http methods which dummies in a project can respond to
+var projectMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "HEAD", "OPTIONS"}
+
+var errorProjectNotFound = &errorResponse{"ProjectNotFound", "Check your project ID again"}
+
+// projectRequestModel is the body to create or update a project
+type projectRequestModel struct {
+	Name       string `json:"name"`
+	Validation string `json:"validation"` // off, enforce or report. off by default
+}
+
+func (m *projectRequestModel) validate() *errorResponse {
+	if m.Name == "" {
+		return &errorResponse{"InvalidData", "name is empty"}
+	}
+	if !isValidationMode(m.Validation) {
+		return errorValidationMode
+	}
+	return nil
+}
+
+// handleCreateProject creates an empty project. Dummies are added by importing a spec
+func handleCreateProject(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	var reqModel projectRequestModel
+	if err := json.NewDecoder(r.Body).Decode(&reqModel); err != nil {
+		requestLog(r).Warningf("fail to parse json %s ", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(requestError(r, &errorResponse{"InvalidJSON", "fail to parse JSON"}))
+		return
+	}
+	if err := reqModel.validate(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(requestError(r, err))
+		return
+	}
+
+	project := projectModel{
+		ID:         bson.NewObjectId(),
+		Name:       reqModel.Name,
+		Validation: reqModel.Validation,
+		CreatedAt:  time.Now(),
+	}
+	err := observeStore(r.Context(), "insert_project", func() error {
+		return db.C(collectionProject).Insert(&project)
+	})
+	if err != nil {
+		requestLog(r).Errorf("error on saving a project: %s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	type successResponse struct {
+		ID  string `json:"id"`
+		URL string `json:"url"`
+	}
+
+	json.NewEncoder(w).Encode(
+		successResponse{
+			ID:  project.ID.Hex(),
+			URL: baseURL(r) + "mock/" + project.ID.Hex(),
+		})
+}
+
+// handler for PUT /projects/:id
+// It updates the name and the validation mode of the project
+func handleUpdateProject(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	project := findWritableProject(w, r, ps)
+	if project == nil {
+		return
+	}
+
+	var reqModel projectRequestModel
+	if err := json.NewDecoder(r.Body).Decode(&reqModel); err != nil {
+		requestLog(r).Warningf("fail to parse json %s ", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(requestError(r, &errorResponse{"InvalidJSON", "fail to parse JSON"}))
+		return
+	}
+	if err := reqModel.validate(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(requestError(r, err))
+		return
+	}
+
+	update := bson.M{"name": reqModel.Name, "validation": reqModel.Validation}
+	err := observeStore(r.Context(), "update_project", func() error {
+		return db.C(collectionProject).UpdateId(project.ID, bson.M{"$set": update})
+	})
+	if err != nil {
+		requestLog(r).Errorf("error on updating a project: %s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	evictProjectCaches(project.ID)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(reqModel)
+}
+
+// findProject loads the project in the URL. It writes an error response and returns nil on failure
+func findProject(w http.ResponseWriter, r *http.Request, ps httprouter.Params) *projectModel {
+	projectID := ps.ByName("id")
+	if !bson.IsObjectIdHex(projectID) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(requestError(r, errorInvalidID))
+		return nil
+	}
+	var project projectModel
+	err := observeStore(r.Context(), "find_project", func() error {
+		return db.C(collectionProject).FindId(bson.ObjectIdHex(projectID)).One(&project)
+	})
+	if err == mgo.ErrNotFound {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(requestError(r, errorProjectNotFound))
+		return nil
+	}
+	if err != nil {
+		requestLog(r).WithField("error_msg", err.Error()).Error("fail to find the project")
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil
+	}
+	return &project
+}
+
+// importedDummy is a summary of a dummy created by importing
+type importedDummy struct {
+	ID     string `json:"id"`
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	Status int    `json:"status"`
+}
+
+// replaceProjectDummies removes dummies imported from the source before and saves new ones
+func replaceProjectDummies(ctx context.Context, project *projectModel, source string, dummies []dummyModel) ([]importedDummy, error) {
+	err := observeStore(ctx, "remove_project_dummies", func() error {
+		_, err := db.C(collectionDummy).RemoveAll(bson.M{"project": project.ID, "source": source})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	evictProjectCaches(project.ID)
+
+	imported := []importedDummy{}
+	for i := range dummies {
+		dummies[i].ID = bson.NewObjectId()
+		dummies[i].Project = project.ID
+		dummies[i].Source = source
+		err := observeStore(ctx, "insert_dummy", func() error {
+			return db.C(collectionDummy).Insert(&dummies[i])
+		})
+		if err != nil {
+			return nil, err
+		}
+		imported = append(imported, importedDummy{
+			ID:     dummies[i].ID.Hex(),
+			Method: dummies[i].Method,
+			Path:   dummies[i].Path,
+			Status: dummies[i].Status,
+		})
+	}
+	return imported, nil
+}
+
+// evictProjectCaches drops the parsed spec and GraphQL configs of the project
+// so that the caches do not keep removed or replaced ones
+func evictProjectCaches(id bson.ObjectId) {
+	evictProjectSpec(id)
+	evictProjectGraphQL(id)
+}
+
+// findWritableProject loads the project in the URL and checks if it can be modified through the API
+func findWritableProject(w http.ResponseWriter, r *http.Request, ps httprouter.Params) *projectModel {
+	project := findProject(w, r, ps)
+	if project != nil && project.ReadOnly {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(requestError(r, errorReadOnly))
+		return nil
+	}
+	return project
+}
+
+// handler for /mock/:project/*path
+// The dummy whose method, path template and matchers match the request is served
+func handleProjectMock(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	dummyStatus := r.URL.Query().Get("dummy-status")
+	projectID := ps.ByName("project")
+	if !bson.IsObjectIdHex(projectID) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(requestError(r, errorInvalidID))
+		return
+	}
+
+	convStatus, err := parseDummyStatus(dummyStatus)
+	if err != nil {
+		requestLog(r).Warningf("converting %s, but error %s", dummyStatus, err.Error())
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(requestError(r, errorInvalidStatus))
+		return
+	}
+	callback, err := jsonpCallback(r)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(requestError(r, errorInvalidCallback))
+		return
+	}
+
+	var project projectModel
+	err = observeStore(r.Context(), "find_project", func() error {
+		return db.C(collectionProject).FindId(bson.ObjectIdHex(projectID)).One(&project)
+	})
+	if err == mgo.ErrNotFound {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(requestError(r, errorProjectNotFound))
+		return
+	}
+	if err != nil {
+		requestLog(r).WithField("error_msg", err.Error()).Error("fail to find the project")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	body := readBody(r)
+	if !validateProjectRequest(w, r, &project, ps.ByName("path")) {
+		return
+	}
+
+	var dummies []dummyModel
+	query := bson.M{"project": project.ID, "method": r.Method, "grpc": bson.M{"$exists": false}}
+	if _, ok := r.URL.Query()["wsdl"]; ok && r.Method == http.MethodGet {
+		// SOAP dummies serve their WSDL on GET regardless of their method
+		delete(query, "method")
+		query["$or"] = []bson.M{{"method": r.Method}, {"soap.wsdl": bson.M{"$gt": ""}}}
+	}
+	err = observeStore(r.Context(), "find_project_dummies", func() error {
+		return db.C(collectionDummy).Find(query).Sort("_id").All(&dummies)
+	})
+	if err != nil {
+		requestLog(r).Errorf("fail to find dummies of project %s: %s", projectID, err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	dummyOne := matchDummy(dummies, r, ps.ByName("path"), body)
+	if dummyOne == nil {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(requestError(r, errorNotFound))
+		return
+	}
+
+	serveDummy(w, r, dummyOne, convStatus, callback)
+}
+
+// validateProjectRequest validates the request against the spec attached to the project.
+// In enforce mode, it writes 400 with violations and returns false
+func validateProjectRequest(w http.ResponseWriter, r *http.Request, project *projectModel, path string) bool {
+	if project.Spec == "" || project.Validation == "" || project.Validation == validationOff {
+		return true
+	}
+	spec, err := projectSpec(project)
+	if err != nil {
+		requestLog(r).Errorf("fail to parse the spec of project %s: %s", project.ID.Hex(), err.Error())
+		return true
+	}
+
+	violations := spec.validateRequest(r, path)
+	if len(violations) == 0 {
+		return true
+	}
+
+	if project.Validation == validationEnforce {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(validationErrorResponse{
+			errorResponse: errorResponse{"ValidationFailed", "request does not conform to the spec"},
+			Violations:    violations,
+			RequestID:     infoOf(r).id,
+		})
+		return false
+	}
+
+	messages := make([]string, len(violations))
+	for i, violation := range violations {
+		messages[i] = violation.String()
+		w.Header().Add("Warning", fmt.Sprintf("199 - %q", messages[i]))
+	}
+	requestLog(r).WithFields(log.Fields{
+		"project":    project.ID.Hex(),
+		"method":     r.Method,
+		"path":       path,
+		"violations": messages,
+	}).Warn("request does not conform to the spec")
+	return true
+}
+
+// matchPathTemplate matches the path against a template like /pets/{id}.
+// It returns the number of literal segments matched
+func matchPathTemplate(template, path string) (int, bool) {
+	tmplSegs := splitPath(template)
+	pathSegs := splitPath(path)
+	if len(tmplSegs) != len(pathSegs) {
+		return 0, false
+	}
+	score := 0
+	for i, seg := range tmplSegs {
+		if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+			if pathSegs[i] == "" {
+				return 0, false
+			}
+			continue
+		}
+		if seg != pathSegs[i] {
+			return 0, false
+		}
+		score++
+	}
+	return score, true
+}
+
+func splitPath(path string) []string {
+	return strings.Split(strings.Trim(path, "/"), "/")
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/globalsign/mgo/bson"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Project", func() {
+	Context("with path templates", func() {
+		It("should match a path with variables", func() {
+			score, ok := matchPathTemplate("/pets/{id}", "/pets/3")
+			Expect(ok).To(Equal(true))
+			Expect(score).To(Equal(1))
+		})
+
+		It("should not match a path with different segments", func() {
+			_, ok := matchPathTemplate("/pets/{id}", "/pets/3/owner")
+			Expect(ok).To(Equal(false))
+			_, ok = matchPathTemplate("/pets/{id}", "/users/3")
+			Expect(ok).To(Equal(false))
+		})
+
+		It("should prefer the template with more literal segments", func() {
+			dummies := []dummyModel{{Path: "/pets/{id}"}, {Path: "/pets/mine"}}
+			req, _ := http.NewRequest("GET", "/pets/mine", nil)
+			Expect(matchDummy(dummies, req, "/pets/mine", nil).Path).To(Equal("/pets/mine"))
+			Expect(matchDummy(dummies, req, "/pets/3", nil).Path).To(Equal("/pets/{id}"))
+			Expect(matchDummy(dummies, req, "/users", nil)).To(BeNil())
+		})
+	})
+
+	Context("with creating project url", func() {
+		It("should response 400 with no name", func() {
+			req, _ := http.NewRequest("POST", "/projects", bytes.NewBufferString(`{}`))
+			w := httptest.NewRecorder()
+			createRoute().ServeHTTP(w, req)
+			Expect(w.Code).To(Equal(http.StatusBadRequest))
+		})
+
+		It("should response 200", func() {
+			req, _ := http.NewRequest("POST", "/projects", bytes.NewBufferString(`{"name": "pet store"}`))
+			w := httptest.NewRecorder()
+			createRoute().ServeHTTP(w, req)
+			Expect(w.Code).To(Equal(http.StatusOK))
+
+			var resp map[string]string
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				panic(err.Error())
+			}
+			Expect(resp["url"]).To(HaveSuffix("/mock/" + resp["id"]))
+
+			metrics := httptest.NewRecorder()
+			handleMetrics(metrics, httptest.NewRequest("GET", "/metrics", nil), nil)
+			Expect(metrics.Body.String()).To(ContainSubstring(`dummy_http_store_operation_duration_seconds_count{op="insert_project"}`))
+
+			// should delete test data
+			if err := testDB.C(collectionProject).RemoveId(bson.ObjectIdHex(resp["id"])); err != nil {
+				panic(err.Error())
+			}
+		})
+	})
+
+	Context("with mock url", func() {
+		It("should response 400 with invalid project ID", func() {
+			req, _ := http.NewRequest("GET", "/mock/blahblah/pets", nil)
+			w := httptest.NewRecorder()
+			createRoute().ServeHTTP(w, req)
+			Expect(w.Code).To(Equal(http.StatusBadRequest))
+		})
+
+		It("should response 404 when no dummy matches", func() {
+			req, _ := http.NewRequest("GET", "/mock/"+bson.NewObjectId().Hex()+"/pets", nil)
+			w := httptest.NewRecorder()
+			createRoute().ServeHTTP(w, req)
+			Expect(w.Code).To(Equal(http.StatusNotFound))
+		})
+	})
+})
@@ -0,0 +1,1284 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Protocol buffers are encoded and decoded by hand since no protobuf package is vendored.
+// Messages are described by a compiled FileDescriptorSet, and converted from and to the proto3 JSON mapping
+
+// wire types
+const (
+	protoVarint  = 0
+	protoFixed64 = 1
+	protoBytes   = 2
+	protoFixed32 = 5
+)
+
+// field types of FieldDescriptorProto
+const (
+	protoTypeDouble   = 1
+	protoTypeFloat    = 2
+	protoTypeInt64    = 3
+	protoTypeUint64   = 4
+	protoTypeInt32    = 5
+	protoTypeFixed64  = 6
+	protoTypeFixed32  = 7
+	protoTypeBool     = 8
+	protoTypeString   = 9
+	protoTypeGroup    = 10
+	protoTypeMessage  = 11
+	protoTypeBytes    = 12
+	protoTypeUint32   = 13
+	protoTypeEnum     = 14
+	protoTypeSfixed32 = 15
+	protoTypeSfixed64 = 16
+	protoTypeSint32   = 17
+	protoTypeSint64   = 18
+)
+
+var errProtoTruncated = errors.New("truncated message")
+
+// protoWire is a field on the wire. n holds varint and fixed values, and data holds length-delimited ones
+type protoWire struct {
+	num  int
+	typ  int
+	n    uint64
+	data []byte
+}
+
+// parseProtoWire splits a message into fields in the order on the wire
+func parseProtoWire(b []byte) ([]protoWire, error) {
+	var fields []protoWire
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, errProtoTruncated
+		}
+		b = b[n:]
+		f := protoWire{num: int(key >> 3), typ: int(key & 7)}
+		if f.num <= 0 {
+			return nil, fmt.Errorf("invalid field number %d", f.num)
+		}
+		switch f.typ {
+		case protoVarint:
+			if f.n, n = binary.Uvarint(b); n <= 0 {
+				return nil, errProtoTruncated
+			}
+			b = b[n:]
+		case protoFixed64:
+			if len(b) < 8 {
+				return nil, errProtoTruncated
+			}
+			f.n, b = binary.LittleEndian.Uint64(b), b[8:]
+		case protoFixed32:
+			if len(b) < 4 {
+				return nil, errProtoTruncated
+			}
+			f.n, b = uint64(binary.LittleEndian.Uint32(b)), b[4:]
+		case protoBytes:
+			size, n := binary.Uvarint(b)
+			if n <= 0 || size > uint64(len(b)-n) {
+				return nil, errProtoTruncated
+			}
+			f.data, b = b[n:n+int(size)], b[n+int(size):]
+		default:
+			return nil, fmt.Errorf("wire type %d of field %d is not supported", f.typ, f.num)
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+func appendProtoKey(b []byte, num, typ int) []byte {
+	return binary.AppendUvarint(b, uint64(num)<<3|uint64(typ))
+}
+
+func appendProtoVarint(b []byte, num int, v uint64) []byte {
+	return binary.AppendUvarint(appendProtoKey(b, num, protoVarint), v)
+}
+
+func appendProtoBytes(b []byte, num int, data []byte) []byte {
+	b = binary.AppendUvarint(appendProtoKey(b, num, protoBytes), uint64(len(data)))
+	return append(b, data...)
+}
+
+func appendProtoString(b []byte, num int, s string) []byte {
+	return appendProtoBytes(b, num, []byte(s))
+}
+
+// protoField is a field of a message
+type protoField struct {
+	name     string
+	jsonName string
+	number   int
+	kind     int // field type
+	repeated bool
+	packed   bool
+	typeName string // message or enum type
+	message  *protoMessage
+	enum     *protoEnum
+}
+
+// protoMessage is a message type. Fields are looked up by number, or by JSON or proto name
+type protoMessage struct {
+	fullName string
+	fields   []*protoField
+	byNumber map[int]*protoField
+	byName   map[string]*protoField
+	mapEntry bool
+}
+
+type protoEnum struct {
+	fullName string
+	values   []protoEnumValue
+}
+
+type protoEnumValue struct {
+	name   string
+	number int32
+}
+
+type protoMethod struct {
+	name            string
+	inputType       string
+	outputType      string
+	input           *protoMessage
+	output          *protoMessage
+	clientStreaming bool
+	serverStreaming bool
+}
+
+type protoService struct {
+	fullName string
+	methods  map[string]*protoMethod
+}
+
+// protoFile is a file in the set. raw is FileDescriptorProto as it is, which is served by reflection
+type protoFile struct {
+	name string
+	deps []string
+	raw  []byte
+}
+
+// protoRegistry is the types and services of a FileDescriptorSet
+type protoRegistry struct {
+	files    []*protoFile
+	byFile   map[string]*protoFile
+	messages map[string]*protoMessage
+	enums    map[string]*protoEnum
+	services map[string]*protoService
+	symbols  map[string]*protoFile // fully-qualified names of types, services and methods
+}
+
+// parseDescriptorSet parses a FileDescriptorSet. Every dependency should be in the set
+func parseDescriptorSet(raw []byte) (*protoRegistry, error) {
+	fields, err := parseProtoWire(raw)
+	if err != nil {
+		return nil, err
+	}
+	reg := &protoRegistry{
+		byFile:   map[string]*protoFile{},
+		messages: map[string]*protoMessage{},
+		enums:    map[string]*protoEnum{},
+		services: map[string]*protoService{},
+		symbols:  map[string]*protoFile{},
+	}
+	for _, f := range fields {
+		if f.num == 1 && f.typ == protoBytes {
+			if err := reg.parseFile(f.data); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(reg.files) == 0 {
+		return nil, errors.New("no file in the descriptor set")
+	}
+
+	for _, file := range reg.files {
+		for _, dep := range file.deps {
+			if reg.byFile[dep] == nil {
+				return nil, fmt.Errorf("%s imports %s which is not in the set. build it with --include_imports", file.name, dep)
+			}
+		}
+	}
+	for _, m := range reg.messages {
+		for _, f := range m.fields {
+			switch f.kind {
+			case protoTypeMessage:
+				if f.message = reg.messages[f.typeName]; f.message == nil {
+					return nil, fmt.Errorf("type %s of %s.%s is not in the set", f.typeName, m.fullName, f.name)
+				}
+			case protoTypeEnum:
+				if f.enum = reg.enums[f.typeName]; f.enum == nil {
+					return nil, fmt.Errorf("type %s of %s.%s is not in the set", f.typeName, m.fullName, f.name)
+				}
+			case protoTypeGroup:
+				return nil, fmt.Errorf("group %s.%s is not supported", m.fullName, f.name)
+			}
+		}
+	}
+	for _, s := range reg.services {
+		for _, method := range s.methods {
+			method.input, method.output = reg.messages[method.inputType], reg.messages[method.outputType]
+			if method.input == nil || method.output == nil {
+				return nil, fmt.Errorf("types of %s/%s are not in the set", s.fullName, method.name)
+			}
+		}
+	}
+	return reg, nil
+}
+
+func (reg *protoRegistry) parseFile(raw []byte) error {
+	fields, err := parseProtoWire(raw)
+	if err != nil {
+		return err
+	}
+	file := &protoFile{raw: raw}
+	var pkg, syntax string
+	for _, f := range fields {
+		switch f.num {
+		case 1:
+			file.name = string(f.data)
+		case 2:
+			pkg = string(f.data)
+		case 3:
+			file.deps = append(file.deps, string(f.data))
+		case 12:
+			syntax = string(f.data)
+		}
+	}
+	if reg.byFile[file.name] != nil {
+		return fmt.Errorf("%s is in the set twice", file.name)
+	}
+	reg.files = append(reg.files, file)
+	reg.byFile[file.name] = file
+
+	prefix := ""
+	if pkg != "" {
+		prefix = pkg + "."
+	}
+	// repeated scalars are packed by default except in proto2
+	packed := syntax != "" && syntax != "proto2"
+	for _, f := range fields {
+		switch f.num {
+		case 4:
+			err = reg.parseMessage(file, prefix, f.data, packed)
+		case 5:
+			err = reg.parseEnum(file, prefix, f.data)
+		case 6:
+			err = reg.parseService(file, prefix, f.data)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s", file.name, err.Error())
+		}
+	}
+	return nil
+}
+
+func (reg *protoRegistry) parseMessage(file *protoFile, prefix string, raw []byte, packed bool) error {
+	fields, err := parseProtoWire(raw)
+	if err != nil {
+		return err
+	}
+	m := &protoMessage{byNumber: map[int]*protoField{}, byName: map[string]*protoField{}}
+	for _, f := range fields {
+		if f.num == 1 {
+			m.fullName = prefix + string(f.data)
+		}
+	}
+	for _, f := range fields {
+		switch f.num {
+		case 2:
+			field, err := parseProtoField(f.data, packed)
+			if err != nil {
+				return err
+			}
+			m.fields = append(m.fields, field)
+			m.byNumber[field.number] = field
+			m.byName[field.name] = field
+			m.byName[field.jsonName] = field
+		case 3:
+			err = reg.parseMessage(file, m.fullName+".", f.data, packed)
+		case 4:
+			err = reg.parseEnum(file, m.fullName+".", f.data)
+		case 7:
+			var options []protoWire
+			if options, err = parseProtoWire(f.data); err == nil {
+				for _, o := range options {
+					m.mapEntry = m.mapEntry || o.num == 7 && o.n != 0
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	reg.messages[m.fullName] = m
+	reg.symbols[m.fullName] = file
+	return nil
+}
+
+func parseProtoField(raw []byte, packed bool) (*protoField, error) {
+	fields, err := parseProtoWire(raw)
+	if err != nil {
+		return nil, err
+	}
+	field := &protoField{}
+	explicitPacked := -1
+	for _, f := range fields {
+		switch f.num {
+		case 1:
+			field.name = string(f.data)
+		case 3:
+			field.number = int(f.n)
+		case 4:
+			field.repeated = f.n == 3
+		case 5:
+			field.kind = int(f.n)
+		case 6:
+			field.typeName = strings.TrimPrefix(string(f.data), ".")
+		case 8:
+			options, err := parseProtoWire(f.data)
+			if err != nil {
+				return nil, err
+			}
+			for _, o := range options {
+				if o.num == 2 {
+					explicitPacked = int(o.n)
+				}
+			}
+		case 10:
+			field.jsonName = string(f.data)
+		}
+	}
+	if field.jsonName == "" {
+		field.jsonName = protoJSONName(field.name)
+	}
+	if field.repeated && isPackable(field.kind) {
+		field.packed = packed && explicitPacked != 0 || explicitPacked == 1
+	}
+	return field, nil
+}
+
+// protoJSONName converts a field name to lowerCamelCase as protoc does
+func protoJSONName(name string) string {
+	var b strings.Builder
+	upper := false
+	for _, c := range name {
+		switch {
+		case c == '_':
+			upper = true
+		case upper:
+			b.WriteRune(unicode.ToUpper(c))
+			upper = false
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+func isPackable(kind int) bool {
+	return kind != protoTypeString && kind != protoTypeBytes && kind != protoTypeMessage && kind != protoTypeGroup
+}
+
+func (reg *protoRegistry) parseEnum(file *protoFile, prefix string, raw []byte) error {
+	fields, err := parseProtoWire(raw)
+	if err != nil {
+		return err
+	}
+	e := &protoEnum{}
+	for _, f := range fields {
+		switch f.num {
+		case 1:
+			e.fullName = prefix + string(f.data)
+		case 2:
+			values, err := parseProtoWire(f.data)
+			if err != nil {
+				return err
+			}
+			var v protoEnumValue
+			for _, value := range values {
+				switch value.num {
+				case 1:
+					v.name = string(value.data)
+				case 2:
+					v.number = int32(value.n)
+				}
+			}
+			e.values = append(e.values, v)
+		}
+	}
+	reg.enums[e.fullName] = e
+	reg.symbols[e.fullName] = file
+	return nil
+}
+
+func (reg *protoRegistry) parseService(file *protoFile, prefix string, raw []byte) error {
+	fields, err := parseProtoWire(raw)
+	if err != nil {
+		return err
+	}
+	s := &protoService{methods: map[string]*protoMethod{}}
+	for _, f := range fields {
+		switch f.num {
+		case 1:
+			s.fullName = prefix + string(f.data)
+		case 2:
+			values, err := parseProtoWire(f.data)
+			if err != nil {
+				return err
+			}
+			m := &protoMethod{}
+			for _, value := range values {
+				switch value.num {
+				case 1:
+					m.name = string(value.data)
+				case 2:
+					m.inputType = strings.TrimPrefix(string(value.data), ".")
+				case 3:
+					m.outputType = strings.TrimPrefix(string(value.data), ".")
+				case 5:
+					m.clientStreaming = value.n != 0
+				case 6:
+					m.serverStreaming = value.n != 0
+				}
+			}
+			s.methods[m.name] = m
+		}
+	}
+	reg.services[s.fullName] = s
+	reg.symbols[s.fullName] = file
+	for name := range s.methods {
+		reg.symbols[s.fullName+"."+name] = file
+	}
+	return nil
+}
+
+// serviceNames returns fully-qualified names of the services in order
+func (reg *protoRegistry) serviceNames() []string {
+	names := make([]string, 0, len(reg.services))
+	for name := range reg.services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// method finds a method by the path of a gRPC request. e.g. /helloworld.Greeter/SayHello
+func (reg *protoRegistry) method(path string) *protoMethod {
+	i := strings.LastIndex(path, "/")
+	if i <= 0 {
+		return nil
+	}
+	s := reg.services[strings.TrimPrefix(path[:i], "/")]
+	if s == nil {
+		return nil
+	}
+	return s.methods[path[i+1:]]
+}
+
+// encode converts a value in the proto3 JSON mapping to the binary message. path locates errors
+func (m *protoMessage) encode(v interface{}, path string) ([]byte, error) {
+	if encode, ok := protoWellKnownEncoders[m.fullName]; ok {
+		return encode(m, v, path)
+	}
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: %s should be an object", path, m.fullName)
+	}
+	for key := range obj {
+		if m.byName[key] == nil {
+			return nil, fmt.Errorf("%s: %s has no field %s", path, m.fullName, key)
+		}
+	}
+
+	var b []byte
+	for _, f := range m.fields {
+		val, ok := obj[f.jsonName]
+		if !ok {
+			val, ok = obj[f.name]
+		}
+		if !ok || val == nil && f.typeName != "google.protobuf.Value" {
+			continue
+		}
+		fieldPath := path + "." + f.jsonName
+		var err error
+		switch {
+		case f.message != nil && f.message.mapEntry:
+			b, err = appendProtoMap(b, f, val, fieldPath)
+		case f.repeated:
+			items, ok := val.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s: should be an array", fieldPath)
+			}
+			if f.packed {
+				var packed []byte
+				for i, item := range items {
+					if packed, err = appendProtoScalar(packed, f, item, fmt.Sprintf("%s[%d]", fieldPath, i)); err != nil {
+						return nil, err
+					}
+				}
+				b = appendProtoBytes(b, f.number, packed)
+				continue
+			}
+			for i, item := range items {
+				if b, err = appendProtoValue(b, f, item, fmt.Sprintf("%s[%d]", fieldPath, i)); err != nil {
+					return nil, err
+				}
+			}
+		default:
+			b, err = appendProtoValue(b, f, val, fieldPath)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+func appendProtoMap(b []byte, f *protoField, val interface{}, path string) ([]byte, error) {
+	obj, ok := val.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: should be an object", path)
+	}
+	keyField, valueField := f.message.byNumber[1], f.message.byNumber[2]
+	if keyField == nil || valueField == nil {
+		return nil, fmt.Errorf("%s: invalid map entry %s", path, f.message.fullName)
+	}
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		var k interface{} = key
+		if keyField.kind == protoTypeBool {
+			bv, err := strconv.ParseBool(key)
+			if err != nil {
+				return nil, fmt.Errorf("%s: key %s should be true or false", path, key)
+			}
+			k = bv
+		}
+		entry, err := appendProtoValue(nil, keyField, k, path)
+		if err != nil {
+			return nil, err
+		}
+		if obj[key] != nil || valueField.typeName == "google.protobuf.Value" {
+			if entry, err = appendProtoValue(entry, valueField, obj[key], path+"."+key); err != nil {
+				return nil, err
+			}
+		}
+		b = appendProtoBytes(b, f.number, entry)
+	}
+	return b, nil
+}
+
+// appendProtoValue appends a field with a single value
+func appendProtoValue(b []byte, f *protoField, v interface{}, path string) ([]byte, error) {
+	switch f.kind {
+	case protoTypeMessage:
+		data, err := f.message.encode(v, path)
+		if err != nil {
+			return nil, err
+		}
+		return appendProtoBytes(b, f.number, data), nil
+	case protoTypeString:
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s: should be a string", path)
+		}
+		return appendProtoString(b, f.number, s), nil
+	case protoTypeBytes:
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s: should be a base64 string", path)
+		}
+		data, err := decodeProtoBase64(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: should be a base64 string", path)
+		}
+		return appendProtoBytes(b, f.number, data), nil
+	}
+	return appendProtoScalar(appendProtoKey(b, f.number, protoWireType(f.kind)), f, v, path)
+}
+
+func decodeProtoBase64(s string) ([]byte, error) {
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.URLEncoding, base64.RawStdEncoding, base64.RawURLEncoding} {
+		if data, err := enc.DecodeString(s); err == nil {
+			return data, nil
+		}
+	}
+	return nil, errors.New("invalid base64")
+}
+
+func protoWireType(kind int) int {
+	switch kind {
+	case protoTypeDouble, protoTypeFixed64, protoTypeSfixed64:
+		return protoFixed64
+	case protoTypeFloat, protoTypeFixed32, protoTypeSfixed32:
+		return protoFixed32
+	case protoTypeString, protoTypeBytes, protoTypeMessage:
+		return protoBytes
+	}
+	return protoVarint
+}
+
+// appendProtoScalar appends a numeric, bool or enum value without the key
+func appendProtoScalar(b []byte, f *protoField, v interface{}, path string) ([]byte, error) {
+	var err error
+	switch f.kind {
+	case protoTypeBool:
+		bv, ok := v.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%s: should be true or false", path)
+		}
+		if bv {
+			return append(b, 1), nil
+		}
+		return append(b, 0), nil
+	case protoTypeEnum:
+		if name, ok := v.(string); ok {
+			for _, ev := range f.enum.values {
+				if ev.name == name {
+					return binary.AppendUvarint(b, uint64(int64(ev.number))), nil
+				}
+			}
+			return nil, fmt.Errorf("%s: %s is not a value of %s", path, name, f.enum.fullName)
+		}
+		n, err := protoJSONInt(v, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%s: should be a name of %s", path, f.enum.fullName)
+		}
+		return binary.AppendUvarint(b, uint64(n)), nil
+	case protoTypeInt32, protoTypeInt64, protoTypeSint32, protoTypeSint64, protoTypeSfixed32, protoTypeSfixed64:
+		bits := 64
+		if f.kind == protoTypeInt32 || f.kind == protoTypeSint32 || f.kind == protoTypeSfixed32 {
+			bits = 32
+		}
+		var n int64
+		if n, err = protoJSONInt(v, bits); err != nil {
+			break
+		}
+		switch f.kind {
+		case protoTypeSint32, protoTypeSint64:
+			return binary.AppendUvarint(b, uint64(n<<1^n>>63)), nil
+		case protoTypeSfixed32:
+			return binary.LittleEndian.AppendUint32(b, uint32(n)), nil
+		case protoTypeSfixed64:
+			return binary.LittleEndian.AppendUint64(b, uint64(n)), nil
+		}
+		return binary.AppendUvarint(b, uint64(n)), nil
+	case protoTypeUint32, protoTypeUint64, protoTypeFixed32, protoTypeFixed64:
+		bits := 64
+		if f.kind == protoTypeUint32 || f.kind == protoTypeFixed32 {
+			bits = 32
+		}
+		var n uint64
+		if n, err = protoJSONUint(v, bits); err != nil {
+			break
+		}
+		switch f.kind {
+		case protoTypeFixed32:
+			return binary.LittleEndian.AppendUint32(b, uint32(n)), nil
+		case protoTypeFixed64:
+			return binary.LittleEndian.AppendUint64(b, n), nil
+		}
+		return binary.AppendUvarint(b, n), nil
+	case protoTypeFloat, protoTypeDouble:
+		var x float64
+		if x, err = protoJSONFloat(v); err != nil {
+			break
+		}
+		if f.kind == protoTypeFloat {
+			return binary.LittleEndian.AppendUint32(b, math.Float32bits(float32(x))), nil
+		}
+		return binary.LittleEndian.AppendUint64(b, math.Float64bits(x)), nil
+	default:
+		return nil, fmt.Errorf("%s: field type %d is not supported", path, f.kind)
+	}
+	return nil, fmt.Errorf("%s: %s", path, err.Error())
+}
+
+// protoJSONInt converts a JSON number or a numeric string to a signed integer of the size
+func protoJSONInt(v interface{}, bits int) (int64, error) {
+	var s string
+	switch v := v.(type) {
+	case json.Number:
+		s = v.String()
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		s = v
+	default:
+		return 0, errors.New("should be an integer")
+	}
+	n, err := strconv.ParseInt(s, 10, bits)
+	if err != nil {
+		f, ferr := strconv.ParseFloat(s, 64)
+		if ferr != nil || f != math.Trunc(f) || f < -math.Exp2(float64(bits-1)) || f >= math.Exp2(float64(bits-1)) {
+			return 0, fmt.Errorf("should be an integer of %d bits", bits)
+		}
+		n = int64(f)
+	}
+	return n, nil
+}
+
+// protoJSONUint converts a JSON number or a numeric string to an unsigned integer of the size
+func protoJSONUint(v interface{}, bits int) (uint64, error) {
+	var s string
+	switch v := v.(type) {
+	case json.Number:
+		s = v.String()
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		s = v
+	default:
+		return 0, errors.New("should be an unsigned integer")
+	}
+	n, err := strconv.ParseUint(s, 10, bits)
+	if err != nil {
+		f, ferr := strconv.ParseFloat(s, 64)
+		if ferr != nil || f != math.Trunc(f) || f < 0 || f >= math.Exp2(float64(bits)) {
+			return 0, fmt.Errorf("should be an unsigned integer of %d bits", bits)
+		}
+		n = uint64(f)
+	}
+	return n, nil
+}
+
+// protoJSONFloat converts a JSON number, a numeric string, NaN or Infinity to a float
+func protoJSONFloat(v interface{}) (float64, error) {
+	switch v := v.(type) {
+	case json.Number:
+		return v.Float64()
+	case float64:
+		return v, nil
+	case string:
+		switch v {
+		case "NaN":
+			return math.NaN(), nil
+		case "Infinity":
+			return math.Inf(1), nil
+		case "-Infinity":
+			return math.Inf(-1), nil
+		}
+		return strconv.ParseFloat(v, 64)
+	}
+	return 0, errors.New("should be a number")
+}
+
+// decode converts a binary message to a value in the proto3 JSON mapping. Unknown fields are dropped
+func (m *protoMessage) decode(b []byte) (interface{}, error) {
+	if decode, ok := protoWellKnownDecoders[m.fullName]; ok {
+		return decode(m, b)
+	}
+	wire, err := parseProtoWire(b)
+	if err != nil {
+		return nil, err
+	}
+	obj := map[string]interface{}{}
+	for _, w := range wire {
+		f := m.byNumber[w.num]
+		if f == nil {
+			continue
+		}
+		switch {
+		case f.message != nil && f.message.mapEntry:
+			entry, err := f.message.decodeMapEntry(w)
+			if err != nil {
+				return nil, err
+			}
+			entries, _ := obj[f.jsonName].(map[string]interface{})
+			if entries == nil {
+				entries = map[string]interface{}{}
+				obj[f.jsonName] = entries
+			}
+			for k, v := range entry {
+				entries[k] = v
+			}
+		case f.repeated:
+			items, _ := obj[f.jsonName].([]interface{})
+			if w.typ == protoBytes && isPackable(f.kind) {
+				unpacked, err := decodeProtoPacked(f, w.data)
+				if err != nil {
+					return nil, err
+				}
+				obj[f.jsonName] = append(items, unpacked...)
+				continue
+			}
+			v, err := decodeProtoValue(f, w)
+			if err != nil {
+				return nil, err
+			}
+			obj[f.jsonName] = append(items, v)
+		default:
+			v, err := decodeProtoValue(f, w)
+			if err != nil {
+				return nil, err
+			}
+			obj[f.jsonName] = v
+		}
+	}
+	return obj, nil
+}
+
+func (m *protoMessage) decodeMapEntry(w protoWire) (map[string]interface{}, error) {
+	if w.typ != protoBytes {
+		return nil, fmt.Errorf("invalid entry of %s", m.fullName)
+	}
+	decoded, err := m.decode(w.data)
+	if err != nil {
+		return nil, err
+	}
+	entry := decoded.(map[string]interface{})
+	key, value := entry["key"], entry["value"]
+	if value == nil {
+		value = protoDefault(m.byNumber[2])
+	}
+	if key == nil {
+		key = protoDefault(m.byNumber[1])
+	}
+	return map[string]interface{}{fmt.Sprint(key): value}, nil
+}
+
+// protoDefault is the JSON value of a field which is not on the wire
+func protoDefault(f *protoField) interface{} {
+	if f == nil {
+		return nil
+	}
+	switch f.kind {
+	case protoTypeString, protoTypeBytes:
+		return ""
+	case protoTypeBool:
+		return false
+	case protoTypeInt64, protoTypeUint64, protoTypeSint64, protoTypeFixed64, protoTypeSfixed64:
+		return "0"
+	case protoTypeEnum:
+		if len(f.enum.values) > 0 {
+			return f.enum.values[0].name
+		}
+	case protoTypeMessage:
+		return map[string]interface{}{}
+	}
+	return 0
+}
+
+func decodeProtoPacked(f *protoField, data []byte) ([]interface{}, error) {
+	var items []interface{}
+	for len(data) > 0 {
+		w := protoWire{typ: protoWireType(f.kind)}
+		switch w.typ {
+		case protoVarint:
+			var n int
+			if w.n, n = binary.Uvarint(data); n <= 0 {
+				return nil, errProtoTruncated
+			}
+			data = data[n:]
+		case protoFixed32:
+			if len(data) < 4 {
+				return nil, errProtoTruncated
+			}
+			w.n, data = uint64(binary.LittleEndian.Uint32(data)), data[4:]
+		case protoFixed64:
+			if len(data) < 8 {
+				return nil, errProtoTruncated
+			}
+			w.n, data = binary.LittleEndian.Uint64(data), data[8:]
+		}
+		v, err := decodeProtoValue(f, w)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, v)
+	}
+	return items, nil
+}
+
+// decodeProtoValue converts a single value on the wire. 64 bit integers are strings as in the JSON mapping
+func decodeProtoValue(f *protoField, w protoWire) (interface{}, error) {
+	if w.typ != protoWireType(f.kind) {
+		return nil, fmt.Errorf("field %s has wire type %d", f.name, w.typ)
+	}
+	switch f.kind {
+	case protoTypeMessage:
+		return f.message.decode(w.data)
+	case protoTypeString:
+		return string(w.data), nil
+	case protoTypeBytes:
+		return base64.StdEncoding.EncodeToString(w.data), nil
+	case protoTypeBool:
+		return w.n != 0, nil
+	case protoTypeEnum:
+		for _, ev := range f.enum.values {
+			if ev.number == int32(w.n) {
+				return ev.name, nil
+			}
+		}
+		return int32(w.n), nil
+	case protoTypeInt32, protoTypeSfixed32:
+		return int32(w.n), nil
+	case protoTypeSint32:
+		return int32(uint32(w.n)>>1) ^ -int32(w.n&1), nil
+	case protoTypeUint32, protoTypeFixed32:
+		return uint32(w.n), nil
+	case protoTypeInt64, protoTypeSfixed64:
+		return strconv.FormatInt(int64(w.n), 10), nil
+	case protoTypeSint64:
+		return strconv.FormatInt(int64(w.n>>1)^-int64(w.n&1), 10), nil
+	case protoTypeUint64, protoTypeFixed64:
+		return strconv.FormatUint(w.n, 10), nil
+	case protoTypeFloat:
+		return protoJSONNumber(float64(math.Float32frombits(uint32(w.n)))), nil
+	case protoTypeDouble:
+		return protoJSONNumber(math.Float64frombits(w.n)), nil
+	}
+	return nil, fmt.Errorf("field type %d of %s is not supported", f.kind, f.name)
+}
+
+func protoJSONNumber(x float64) interface{} {
+	switch {
+	case math.IsNaN(x):
+		return "NaN"
+	case math.IsInf(x, 1):
+		return "Infinity"
+	case math.IsInf(x, -1):
+		return "-Infinity"
+	}
+	return x
+}
+
+// well-known types have their own JSON forms
+var (
+	protoWellKnownEncoders map[string]func(m *protoMessage, v interface{}, path string) ([]byte, error)
+	protoWellKnownDecoders map[string]func(m *protoMessage, b []byte) (interface{}, error)
+)
+
+func init() {
+	protoWellKnownEncoders = map[string]func(*protoMessage, interface{}, string) ([]byte, error){
+		"google.protobuf.Timestamp": encodeProtoTimestamp,
+		"google.protobuf.Duration":  encodeProtoDuration,
+		"google.protobuf.Struct": func(_ *protoMessage, v interface{}, path string) ([]byte, error) {
+			return encodeProtoStruct(v, path)
+		},
+		"google.protobuf.Value": func(_ *protoMessage, v interface{}, path string) ([]byte, error) {
+			return encodeProtoStructValue(v, path)
+		},
+		"google.protobuf.ListValue": func(_ *protoMessage, v interface{}, path string) ([]byte, error) {
+			return encodeProtoListValue(v, path)
+		},
+		"google.protobuf.FieldMask": encodeProtoFieldMask,
+		"google.protobuf.Any": func(_ *protoMessage, _ interface{}, path string) ([]byte, error) {
+			return nil, fmt.Errorf("%s: google.protobuf.Any is not supported", path)
+		},
+	}
+	protoWellKnownDecoders = map[string]func(*protoMessage, []byte) (interface{}, error){
+		"google.protobuf.Timestamp": decodeProtoTimestamp,
+		"google.protobuf.Duration":  decodeProtoDuration,
+		"google.protobuf.Struct": func(_ *protoMessage, b []byte) (interface{}, error) {
+			return decodeProtoStruct(b)
+		},
+		"google.protobuf.Value": func(_ *protoMessage, b []byte) (interface{}, error) {
+			return decodeProtoStructValue(b)
+		},
+		"google.protobuf.ListValue": func(_ *protoMessage, b []byte) (interface{}, error) {
+			return decodeProtoListValue(b)
+		},
+		"google.protobuf.FieldMask": decodeProtoFieldMask,
+	}
+	for _, wrapper := range []string{"Double", "Float", "Int64", "UInt64", "Int32", "UInt32", "Bool", "String", "Bytes"} {
+		name := "google.protobuf." + wrapper + "Value"
+		protoWellKnownEncoders[name] = encodeProtoWrapper
+		protoWellKnownDecoders[name] = decodeProtoWrapper
+	}
+}
+
+// seconds and nanos of Timestamp and Duration
+func appendProtoSecondsNanos(seconds int64, nanos int32) []byte {
+	var b []byte
+	if seconds != 0 {
+		b = appendProtoVarint(b, 1, uint64(seconds))
+	}
+	if nanos != 0 {
+		b = appendProtoVarint(b, 2, uint64(int64(nanos)))
+	}
+	return b
+}
+
+func parseProtoSecondsNanos(b []byte) (int64, int32, error) {
+	wire, err := parseProtoWire(b)
+	if err != nil {
+		return 0, 0, err
+	}
+	var seconds int64
+	var nanos int32
+	for _, w := range wire {
+		switch w.num {
+		case 1:
+			seconds = int64(w.n)
+		case 2:
+			nanos = int32(w.n)
+		}
+	}
+	return seconds, nanos, nil
+}
+
+func encodeProtoTimestamp(_ *protoMessage, v interface{}, path string) ([]byte, error) {
+	s, _ := v.(string)
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return nil, fmt.Errorf("%s: should be a timestamp in RFC 3339", path)
+	}
+	return appendProtoSecondsNanos(t.Unix(), int32(t.Nanosecond())), nil
+}
+
+func decodeProtoTimestamp(_ *protoMessage, b []byte) (interface{}, error) {
+	seconds, nanos, err := parseProtoSecondsNanos(b)
+	if err != nil {
+		return nil, err
+	}
+	return time.Unix(seconds, int64(nanos)).UTC().Format(time.RFC3339Nano), nil
+}
+
+func encodeProtoDuration(_ *protoMessage, v interface{}, path string) ([]byte, error) {
+	s, _ := v.(string)
+	if !strings.HasSuffix(s, "s") {
+		return nil, fmt.Errorf("%s: should be a duration in seconds. e.g. 1.5s", path)
+	}
+	s = strings.TrimSuffix(s, "s")
+	negative := strings.HasPrefix(s, "-")
+	whole, frac := strings.TrimPrefix(s, "-"), ""
+	if i := strings.IndexByte(whole, '.'); i >= 0 {
+		whole, frac = whole[:i], whole[i+1:]
+	}
+	seconds, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil || len(frac) > 9 || strings.Trim(frac, "0123456789") != "" {
+		return nil, fmt.Errorf("%s: should be a duration in seconds. e.g. 1.5s", path)
+	}
+	nanos, _ := strconv.Atoi((frac + "000000000")[:9])
+	if negative {
+		seconds, nanos = -seconds, -nanos
+	}
+	return appendProtoSecondsNanos(seconds, int32(nanos)), nil
+}
+
+func decodeProtoDuration(_ *protoMessage, b []byte) (interface{}, error) {
+	seconds, nanos, err := parseProtoSecondsNanos(b)
+	if err != nil {
+		return nil, err
+	}
+	sign := ""
+	if seconds < 0 || nanos < 0 {
+		sign, seconds, nanos = "-", -seconds, -nanos
+	}
+	s := sign + strconv.FormatInt(seconds, 10)
+	if nanos != 0 {
+		s += "." + strings.TrimRight(fmt.Sprintf("%09d", nanos), "0")
+	}
+	return s + "s", nil
+}
+
+func encodeProtoWrapper(m *protoMessage, v interface{}, path string) ([]byte, error) {
+	return appendProtoValue(nil, m.byNumber[1], v, path)
+}
+
+func decodeProtoWrapper(m *protoMessage, b []byte) (interface{}, error) {
+	decoded, err := (&protoMessage{fullName: "wrapper", byNumber: m.byNumber}).decode(b)
+	if err != nil {
+		return nil, err
+	}
+	if v, ok := decoded.(map[string]interface{})[m.byNumber[1].jsonName]; ok {
+		return v, nil
+	}
+	return protoDefault(m.byNumber[1]), nil
+}
+
+// Struct, Value and ListValue are any JSON. Their field numbers are fixed by struct.proto
+func encodeProtoStruct(v interface{}, path string) ([]byte, error) {
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: should be an object", path)
+	}
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var b []byte
+	for _, key := range keys {
+		value, err := encodeProtoStructValue(obj[key], path+"."+key)
+		if err != nil {
+			return nil, err
+		}
+		b = appendProtoBytes(b, 1, appendProtoBytes(appendProtoString(nil, 1, key), 2, value))
+	}
+	return b, nil
+}
+
+func encodeProtoStructValue(v interface{}, path string) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return appendProtoVarint(nil, 1, 0), nil
+	case bool:
+		if v {
+			return appendProtoVarint(nil, 4, 1), nil
+		}
+		return appendProtoVarint(nil, 4, 0), nil
+	case string:
+		return appendProtoString(nil, 3, v), nil
+	case map[string]interface{}:
+		data, err := encodeProtoStruct(v, path)
+		return appendProtoBytes(nil, 5, data), err
+	case []interface{}:
+		data, err := encodeProtoListValue(v, path)
+		return appendProtoBytes(nil, 6, data), err
+	}
+	x, err := protoJSONFloat(v)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err.Error())
+	}
+	return binary.LittleEndian.AppendUint64(appendProtoKey(nil, 2, protoFixed64), math.Float64bits(x)), nil
+}
+
+func encodeProtoListValue(v interface{}, path string) ([]byte, error) {
+	items, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: should be an array", path)
+	}
+	var b []byte
+	for i, item := range items {
+		value, err := encodeProtoStructValue(item, fmt.Sprintf("%s[%d]", path, i))
+		if err != nil {
+			return nil, err
+		}
+		b = appendProtoBytes(b, 1, value)
+	}
+	return b, nil
+}
+
+func decodeProtoStruct(b []byte) (interface{}, error) {
+	wire, err := parseProtoWire(b)
+	if err != nil {
+		return nil, err
+	}
+	obj := map[string]interface{}{}
+	for _, w := range wire {
+		if w.num != 1 {
+			continue
+		}
+		entry, err := parseProtoWire(w.data)
+		if err != nil {
+			return nil, err
+		}
+		var key string
+		var value interface{}
+		for _, e := range entry {
+			switch e.num {
+			case 1:
+				key = string(e.data)
+			case 2:
+				if value, err = decodeProtoStructValue(e.data); err != nil {
+					return nil, err
+				}
+			}
+		}
+		obj[key] = value
+	}
+	return obj, nil
+}
+
+func decodeProtoStructValue(b []byte) (interface{}, error) {
+	wire, err := parseProtoWire(b)
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	for _, w := range wire {
+		switch w.num {
+		case 1:
+			value = nil
+		case 2:
+			value = protoJSONNumber(math.Float64frombits(w.n))
+		case 3:
+			value = string(w.data)
+		case 4:
+			value = w.n != 0
+		case 5:
+			value, err = decodeProtoStruct(w.data)
+		case 6:
+			value, err = decodeProtoListValue(w.data)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return value, nil
+}
+
+func decodeProtoListValue(b []byte) (interface{}, error) {
+	wire, err := parseProtoWire(b)
+	if err != nil {
+		return nil, err
+	}
+	items := []interface{}{}
+	for _, w := range wire {
+		if w.num == 1 {
+			item, err := decodeProtoStructValue(w.data)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+// FieldMask is paths in lowerCamelCase joined by commas
+func encodeProtoFieldMask(_ *protoMessage, v interface{}, path string) ([]byte, error) {
+	s, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("%s: should be a string of paths", path)
+	}
+	var b []byte
+	for _, p := range strings.Split(s, ",") {
+		if p == "" {
+			continue
+		}
+		var snake strings.Builder
+		for _, c := range p {
+			if unicode.IsUpper(c) {
+				snake.WriteByte('_')
+				c = unicode.ToLower(c)
+			}
+			snake.WriteRune(c)
+		}
+		b = appendProtoString(b, 1, snake.String())
+	}
+	return b, nil
+}
+
+func decodeProtoFieldMask(_ *protoMessage, b []byte) (interface{}, error) {
+	wire, err := parseProtoWire(b)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, w := range wire {
+		if w.num == 1 {
+			paths = append(paths, protoJSONName(string(w.data)))
+		}
+	}
+	return strings.Join(paths, ","), nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// baseURL returns the URL where this service is reached by the client, ending with a slash.
+// The configured public URL wins. Otherwise it is built from the request, and
+// X-Forwarded-Proto, X-Forwarded-Host and X-Forwarded-Prefix are used if the peer is a trusted proxy
+func baseURL(r *http.Request) string {
+	if cfg.PublicURL != "" {
+		return cfg.PublicURL
+	}
+
+	scheme, host, prefix := "http", r.Host, ""
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if isTrustedProxy(r.RemoteAddr) {
+		if proto := forwardedValue(r, "X-Forwarded-Proto"); proto == "http" || proto == "https" {
+			scheme = proto
+		}
+		if forwardedHost := forwardedValue(r, "X-Forwarded-Host"); forwardedHost != "" {
+			host = forwardedHost
+		}
+		prefix = strings.TrimSuffix(forwardedValue(r, "X-Forwarded-Prefix"), "/")
+		if prefix != "" && !strings.HasPrefix(prefix, "/") {
+			prefix = "/" + prefix
+		}
+	}
+	return scheme + "://" + host + prefix + cfg.PathPrefix + "/"
+}
+
+// serverBaseURL is baseURL without a request. It is only the path if the public URL is not configured
+func serverBaseURL() string {
+	if cfg.PublicURL != "" {
+		return cfg.PublicURL
+	}
+	return cfg.PathPrefix + "/"
+}
+
+// forwardedValue returns the first value of the header which a chain of proxies may have appended to
+func forwardedValue(r *http.Request, name string) string {
+	v := r.Header.Get(name)
+	if i := strings.Index(v, ","); i >= 0 {
+		v = v[:i]
+	}
+	return strings.TrimSpace(v)
+}
+
+// isTrustedProxy checks whether the peer address is in the trusted proxies
+func isTrustedProxy(remoteAddr string) bool {
+	if len(cfg.TrustedProxies) == 0 {
+		return false
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, proxy := range cfg.TrustedProxies {
+		if network := parseProxy(proxy); network != nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseProxy parses a trusted proxy which is an IP address or a CIDR. It returns nil if invalid
+func parseProxy(proxy string) *net.IPNet {
+	if !strings.Contains(proxy, "/") {
+		ip := net.ParseIP(proxy)
+		if ip == nil {
+			return nil
+		}
+		if ip.To4() != nil {
+			return &net.IPNet{IP: ip, Mask: net.CIDRMask(32, 32)}
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+	}
+	_, network, err := net.ParseCIDR(proxy)
+	if err != nil {
+		return nil
+	}
+	return network
+}
+
+// mountPrefix serves the handler under the path prefix. Requests outside the prefix are not found
+func mountPrefix(next http.Handler, prefix string) http.Handler {
+	if prefix == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != prefix && !strings.HasPrefix(r.URL.Path, prefix+"/") {
+			http.NotFound(w, r)
+			return
+		}
+		http.StripPrefix(prefix, next).ServeHTTP(w, r)
+	})
+}
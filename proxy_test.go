@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Public URL", func() {
+	var saved config
+
+	BeforeEach(func() {
+		saved = cfg
+		cfg = defaultConfig()
+	})
+
+	AfterEach(func() {
+		cfg = saved
+	})
+
+	newRequest := func(remoteAddr string, headers map[string]string) *http.Request {
+		req := httptest.NewRequest("POST", "/create", nil)
+		req.Host = "dummy.local:3000"
+		req.RemoteAddr = remoteAddr
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		return req
+	}
+
+	forwarded := map[string]string{
+		"X-Forwarded-Proto":  "https",
+		"X-Forwarded-Host":   "api.example.com, proxy.internal",
+		"X-Forwarded-Prefix": "/dummy/",
+	}
+
+	It("should use the configured public URL", func() {
+		cfg.PublicURL = "https://mock.example.com/"
+		Expect(baseURL(newRequest("10.0.0.1:1234", forwarded))).To(Equal("https://mock.example.com/"))
+	})
+
+	It("should be built from the request", func() {
+		cfg.PathPrefix = "/api"
+		Expect(baseURL(newRequest("10.0.0.1:1234", nil))).To(Equal("http://dummy.local:3000/api/"))
+	})
+
+	It("should ignore forwarded headers from untrusted peers", func() {
+		cfg.TrustedProxies = []string{"192.168.0.0/16"}
+		Expect(baseURL(newRequest("10.0.0.1:1234", forwarded))).To(Equal("http://dummy.local:3000/"))
+	})
+
+	It("should use forwarded headers from trusted proxies", func() {
+		cfg.TrustedProxies = []string{"127.0.0.1", "10.0.0.0/8"}
+		Expect(baseURL(newRequest("10.0.0.1:1234", forwarded))).To(Equal("https://api.example.com/dummy/"))
+	})
+
+	It("should serve the router under the path prefix", func() {
+		handler := mountPrefix(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.URL.Path))
+		}), "/dummy")
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/dummy/echo", nil))
+		Expect(rec.Body.String()).To(Equal("/echo"))
+
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", "/dummyecho", nil))
+		Expect(rec.Code).To(Equal(http.StatusNotFound))
+	})
+})
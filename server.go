@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// newServer creates the HTTP server with the configured timeouts. HTTP/2 is served over TLS,
+// and over plain connections with prior knowledge if h2c is enabled
+func newServer(c serverConfig, addr string, handler http.Handler) *http.Server {
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(c.H2C)
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadTimeout:       c.ReadTimeout,
+		ReadHeaderTimeout: c.ReadHeaderTimeout,
+		WriteTimeout:      c.WriteTimeout,
+		IdleTimeout:       c.IdleTimeout,
+		Protocols:         protocols,
+	}
+}
+
+// serve runs the servers until SIGTERM or SIGINT. A server with TLSConfig serves HTTPS.
+// On a signal, readiness checks fail for the grace period so that load balancers stop sending requests.
+// Then the servers stop accepting connections and wait for in-flight requests until the shutdown timeout
+func serve(servers []*http.Server, c serverConfig) error {
+	errc := make(chan error, len(servers))
+	for _, server := range servers {
+		go func(server *http.Server) {
+			if server.TLSConfig != nil {
+				errc <- server.ListenAndServeTLS("", "")
+			} else {
+				errc <- server.ListenAndServe()
+			}
+		}(server)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	defer signal.Stop(signals)
+
+	var err error
+	select {
+	case err = <-errc:
+		log.Errorf("server stopped: %s", err.Error())
+		atomic.StoreInt32(&draining, 1)
+	case sig := <-signals:
+		log.Infof("shutting down on %s. failing readiness checks for %s", sig, c.ShutdownGrace)
+		failReadiness(c.ShutdownGrace, signals)
+	}
+	log.Infof("draining requests up to %s", c.ShutdownTimeout)
+
+	var wg sync.WaitGroup
+	for _, server := range servers {
+		wg.Add(1)
+		go func(server *http.Server) {
+			defer wg.Done()
+			shutdown(server, c.ShutdownTimeout)
+		}(server)
+	}
+	wg.Wait()
+	return err
+}
+
+// failReadiness makes /readyz report draining and waits for the grace period. Another signal cuts it short
+func failReadiness(grace time.Duration, signals <-chan os.Signal) {
+	atomic.StoreInt32(&draining, 1)
+	if grace <= 0 {
+		return
+	}
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case sig := <-signals:
+		log.Infof("skipping the rest of the grace period on %s", sig)
+	}
+}
+
+// shutdown drains in-flight requests until the timeout and closes connections which are left
+func shutdown(server *http.Server, drainTimeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Warnf("fail to drain requests in %s: %s", drainTimeout, err.Error())
+		return server.Close()
+	}
+	return nil
+}
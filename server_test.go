@@ -0,0 +1,143 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Server", func() {
+	var saved config
+	var listener net.Listener
+	var server *http.Server
+
+	start := func(handler http.HandlerFunc) string {
+		var err error
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		server = newServer(cfg.Server, listener.Addr().String(), handler)
+		go server.Serve(listener)
+		return "http://" + listener.Addr().String()
+	}
+
+	BeforeEach(func() {
+		saved = cfg
+		cfg = defaultConfig()
+		cfg.Server.WriteTimeout = 100 * time.Millisecond
+	})
+
+	AfterEach(func() {
+		if server != nil {
+			server.Close()
+		}
+		atomic.StoreInt32(&draining, 0)
+		cfg = saved
+	})
+
+	It("should let delayed dummies exceed the write timeout", func() {
+		url := start(func(w http.ResponseWriter, r *http.Request) {
+			delayResponse(w, r, 300)
+			w.Write([]byte("late"))
+		})
+
+		res, err := http.Get(url)
+		Expect(err).NotTo(HaveOccurred())
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		Expect(string(body)).To(Equal("late"))
+	})
+
+	It("should drain in-flight requests on shutdown", func() {
+		started := make(chan struct{})
+		url := start(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			delayResponse(w, r, 200)
+			w.Write([]byte("done"))
+		})
+
+		result := make(chan string, 1)
+		go func() {
+			defer GinkgoRecover()
+			res, err := http.Get(url)
+			Expect(err).NotTo(HaveOccurred())
+			body, _ := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			result <- string(body)
+		}()
+		<-started
+
+		Expect(shutdown(server, time.Second)).To(Succeed())
+		Eventually(result).Should(Receive(Equal("done")))
+		_, err := http.Get(url)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should fail readiness checks for the grace period before draining", func() {
+		signals := make(chan os.Signal, 1)
+		done := make(chan struct{})
+		go func() {
+			failReadiness(time.Minute, signals)
+			close(done)
+		}()
+		Eventually(func() int32 { return atomic.LoadInt32(&draining) }).Should(BeEquivalentTo(1))
+		Consistently(done, 100*time.Millisecond).ShouldNot(BeClosed())
+
+		signals <- os.Interrupt
+		Eventually(done).Should(BeClosed())
+	})
+
+	Context("with h2c", func() {
+		var client *http.Client
+
+		BeforeEach(func() {
+			cfg.Server.H2C = true
+			cfg.Server.WriteTimeout = 0
+			protocols := new(http.Protocols)
+			protocols.SetUnencryptedHTTP2(true)
+			client = &http.Client{Transport: &http.Transport{Protocols: protocols}}
+		})
+
+		It("should serve HTTP/2 with prior knowledge", func() {
+			url := start(createRoute().ServeHTTP)
+
+			res, err := client.Get(url + "/echo")
+			Expect(err).NotTo(HaveOccurred())
+			res.Body.Close()
+			Expect(res.ProtoMajor).To(Equal(2))
+			Expect(res.Header.Get("X-Echo-Protocol")).To(Equal("HTTP/2.0"))
+		})
+
+		It("should flush streamed responses through middlewares", func() {
+			release, done := make(chan struct{}), make(chan struct{})
+			handler := accessLog(cfg.AccessLog, tracing(cfg.Tracing, instrument(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("first\n"))
+				w.(http.Flusher).Flush()
+				<-release
+			}))))
+			url := start(func(w http.ResponseWriter, r *http.Request) {
+				defer close(done)
+				handler.ServeHTTP(w, r)
+			})
+			defer func() {
+				// the handler reads cfg until the request is logged
+				close(release)
+				Eventually(done).Should(BeClosed())
+			}()
+
+			res, err := client.Get(url + "/stream")
+			Expect(err).NotTo(HaveOccurred())
+			defer res.Body.Close()
+			buf := make([]byte, 6)
+			_, err = io.ReadFull(res.Body, buf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(buf)).To(Equal("first\n"))
+		})
+	})
+})
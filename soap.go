@@ -0,0 +1,404 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"text/template"
+)
+
+// namespaces of SOAP envelopes
+const (
+	soap11Namespace = "http://schemas.xmlsoap.org/soap/envelope/"
+	soap12Namespace = "http://www.w3.org/2003/05/soap-envelope"
+)
+
+// soapModel makes a dummy a SOAP endpoint. A request is answered by the operation whose action, body element
+// and matchers match it. The one with more matchers wins, and the first one wins on a tie
+//
+//	"soap": {
+//	  "version": "1.1",
+//	  "operations": [
+//	    {"action": "urn:billing/GetInvoice", "matchers": [{"in": "xpath", "name": "//InvoiceId", "op": "equals", "value": "42"}],
+//	     "response": "<GetInvoiceResponse xmlns=\"urn:billing\"><Id>{{xpath \"//InvoiceId\"}}</Id></GetInvoiceResponse>"},
+//	    {"element": "{urn:billing}Pay", "fault": {"code": "Client", "reason": "card declined"}}
+//	  ],
+//	  "wsdl": "<definitions ...>"
+//	}
+type soapModel struct {
+	Version    string          `json:"version"` // 1.1 or 1.2. 1.1 if empty
+	Operations []soapOperation `json:"operations"`
+	WSDL       string          `json:"wsdl"` // served on GET ?wsdl
+}
+
+// soapOperation is a response to requests of an operation. An operation without action and element
+// answers every request
+type soapOperation struct {
+	Action   string         `json:"action"`   // SOAPAction, or action of the content type in SOAP 1.2
+	Element  string         `json:"element"`  // name of the first element in Body. {namespace}local or local
+	Matchers []matcherModel `json:"matchers"` // e.g. xpath matchers on the envelope
+	Response string         `json:"response"` // template of the content of Body, or of the whole envelope
+	Fault    *soapFault     `json:"fault"`    // fault sent instead of the response
+}
+
+// soapFault is a fault. Codes of either version are accepted and translated to the dummy's version
+type soapFault struct {
+	Code   string `json:"code"`   // Client, Server, Sender, Receiver, VersionMismatch, MustUnderstand or DataEncodingUnknown
+	Reason string `json:"reason"` // faultstring in SOAP 1.1
+	Detail string `json:"detail"` // XML in the detail
+}
+
+// fault codes in SOAP 1.1 and 1.2
+var soapFaultCodes = map[string][2]string{
+	"VersionMismatch":     {"VersionMismatch", "VersionMismatch"},
+	"MustUnderstand":      {"MustUnderstand", "MustUnderstand"},
+	"DataEncodingUnknown": {"Client", "DataEncodingUnknown"},
+	"Client":              {"Client", "Sender"},
+	"Sender":              {"Client", "Sender"},
+	"Server":              {"Server", "Receiver"},
+	"Receiver":            {"Server", "Receiver"},
+}
+
+// validate soapModel. do not trust any input
+func (m *soapModel) validate() error {
+	if m.Version != "" && m.Version != "1.1" && m.Version != "1.2" {
+		return fmt.Errorf("SOAP version '%s' is not supported. use 1.1 or 1.2", m.Version)
+	}
+	if len(m.Operations) == 0 {
+		return errors.New("soap has no operation")
+	}
+	for i := range m.Operations {
+		if err := m.Operations[i].validate(); err != nil {
+			return fmt.Errorf("operations[%d]: %s", i, err.Error())
+		}
+	}
+	if m.WSDL != "" {
+		if _, err := parseXMLDocument([]byte(m.WSDL)); err != nil {
+			return fmt.Errorf("wsdl is not XML: %s", err.Error())
+		}
+	}
+	return nil
+}
+
+func (op *soapOperation) validate() error {
+	if op.Element != "" {
+		if _, err := parseSOAPElementName(op.Element); err != nil {
+			return err
+		}
+	}
+	for i := range op.Matchers {
+		if err := op.Matchers[i].validate(); err != nil {
+			return err
+		}
+	}
+	if op.Fault != nil {
+		if op.Response != "" {
+			return errors.New("set either response or fault")
+		}
+		if _, ok := soapFaultCodes[op.Fault.Code]; !ok && op.Fault.Code != "" {
+			return fmt.Errorf("fault code '%s' is unknown", op.Fault.Code)
+		}
+		return nil
+	}
+	if _, err := soapTemplate(op.Response, nil); err != nil {
+		return fmt.Errorf("invalid response template: %s", err.Error())
+	}
+	return nil
+}
+
+// parseSOAPElementName parses {namespace}local or local. The namespace is empty for any namespace
+func parseSOAPElementName(s string) (xml.Name, error) {
+	if !strings.HasPrefix(s, "{") {
+		if strings.ContainsAny(s, "{}") {
+			return xml.Name{}, fmt.Errorf("element '%s' should be {namespace}local or local", s)
+		}
+		return xml.Name{Local: s}, nil
+	}
+	i := strings.IndexByte(s, '}')
+	if i < 0 || i == len(s)-1 {
+		return xml.Name{}, fmt.Errorf("element '%s' should be {namespace}local or local", s)
+	}
+	return xml.Name{Space: s[1:i], Local: s[i+1:]}, nil
+}
+
+// version returns the SOAP version of the dummy
+func (m *soapModel) version() string {
+	if m.Version == "" {
+		return "1.1"
+	}
+	return m.Version
+}
+
+// soapRequest is a parsed request envelope
+type soapRequest struct {
+	doc     *xmlDocument
+	action  string
+	element xml.Name        // the first element in Body. empty if Body is empty
+	cert    *clientCertInfo // verified client certificate
+}
+
+// soapTemplate parses a response template. Functions read the request, and their values are escaped for XML.
+//
+//	{{xpath "//InvoiceId"}} the first value which XPath selects in the request
+//	{{action}}              the action of the request
+//	{{element}}             local name of the first element in Body
+//	{{certSubject}}         subject of the verified client certificate. certIssuer and certFingerprint too
+//	{{range certSANs}}      SANs of the verified client certificate
+func soapTemplate(text string, req *soapRequest) (*template.Template, error) {
+	escape := func(s string) string {
+		var b strings.Builder
+		xml.EscapeText(&b, []byte(s))
+		return b.String()
+	}
+	return template.New("response").Funcs(template.FuncMap{
+		"xpath": func(expr string) (string, error) {
+			e, err := parseXPath(expr)
+			if err != nil {
+				return "", err
+			}
+			if items := e.eval(req.doc, req.doc.root); len(items) > 0 {
+				return escape(items[0].stringValue()), nil
+			}
+			return "", nil
+		},
+		"action":  func() string { return escape(req.action) },
+		"element": func() string { return escape(req.element.Local) },
+		"certSubject": func() string {
+			if req.cert == nil {
+				return ""
+			}
+			return escape(req.cert.Subject)
+		},
+		"certIssuer": func() string {
+			if req.cert == nil {
+				return ""
+			}
+			return escape(req.cert.Issuer)
+		},
+		"certFingerprint": func() string {
+			if req.cert == nil {
+				return ""
+			}
+			return req.cert.Fingerprint
+		},
+		"certSANs": func() []string {
+			if req.cert == nil {
+				return nil
+			}
+			sans := make([]string, len(req.cert.SANs))
+			for i, san := range req.cert.SANs {
+				sans[i] = escape(san)
+			}
+			return sans
+		},
+	}).Parse(text)
+}
+
+// parseSOAPRequest parses the envelope in the body. It returns a fault if the request is not a SOAP request
+// of the version
+func parseSOAPRequest(r *http.Request, body []byte, version string) (*soapRequest, *soapFault) {
+	doc, err := parseXMLDocument(body)
+	if err != nil {
+		return nil, &soapFault{Code: "Client", Reason: "invalid XML: " + err.Error()}
+	}
+	envelope := doc.documentElement()
+	if envelope.name.Local != "Envelope" {
+		return nil, &soapFault{Code: "Client", Reason: "the document element is not Envelope"}
+	}
+	namespace := soap11Namespace
+	if version == "1.2" {
+		namespace = soap12Namespace
+	}
+	if envelope.name.Space != namespace {
+		return nil, &soapFault{Code: "VersionMismatch", Reason: fmt.Sprintf("envelope of SOAP %s in %s is expected", version, namespace)}
+	}
+
+	req := &soapRequest{doc: doc, cert: clientCert(r)}
+	for _, child := range envelope.children {
+		if child.name.Local == "Body" && child.name.Space == namespace {
+			if len(child.children) > 0 {
+				req.element = child.children[0].name
+			}
+			req.action = soapAction(r)
+			return req, nil
+		}
+	}
+	return nil, &soapFault{Code: "Client", Reason: "the envelope has no Body"}
+}
+
+// soapAction returns SOAPAction of SOAP 1.1, or the action parameter of the content type of SOAP 1.2
+func soapAction(r *http.Request) string {
+	if action := r.Header.Get("SOAPAction"); action != "" {
+		return strings.Trim(action, `"`)
+	}
+	if _, params, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil {
+		return params["action"]
+	}
+	return ""
+}
+
+// match returns the operation which answers the request
+func (m *soapModel) match(r *http.Request, body []byte, req *soapRequest) *soapOperation {
+	var found *soapOperation
+	bestMatchers := -1
+	for i := range m.Operations {
+		op := &m.Operations[i]
+		if op.Action != "" && op.Action != req.action || len(op.Matchers) <= bestMatchers {
+			continue
+		}
+		if op.Element != "" {
+			name, _ := parseSOAPElementName(op.Element)
+			if name.Local != req.element.Local || name.Space != "" && name.Space != req.element.Space {
+				continue
+			}
+		}
+		matched := true
+		for j := range op.Matchers {
+			if !op.Matchers[j].match(r, r.URL.Path, body) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			found, bestMatchers = op, len(op.Matchers)
+		}
+	}
+	return found
+}
+
+// serveSOAP answers the SOAP request with the dummy, or the WSDL on GET ?wsdl
+func serveSOAP(w http.ResponseWriter, r *http.Request, dummyOne *dummyModel) {
+	soap := dummyOne.SOAP
+	version := soap.version()
+	if _, ok := r.URL.Query()["wsdl"]; ok && r.Method == http.MethodGet && soap.WSDL != "" {
+		if err := setDummyHeaders(w, dummyOne); err != nil {
+			requestLog(r).Errorf("JSON marshal error: %s", err.Error())
+		}
+		w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, soap.WSDL)
+		countDummyHit(dummyOne)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeSOAPFault(w, version, http.StatusMethodNotAllowed, &soapFault{Code: "Client", Reason: "SOAP is served on POST"})
+		return
+	}
+
+	body := readBody(r)
+	req, fault := parseSOAPRequest(r, body, version)
+	if fault != nil {
+		writeSOAPFault(w, version, 0, fault)
+		return
+	}
+	op := soap.match(r, body, req)
+	if op == nil {
+		writeSOAPFault(w, version, 0, &soapFault{Code: "Client",
+			Reason: fmt.Sprintf("no operation matches action '%s' and element {%s}%s", req.action, req.element.Space, req.element.Local)})
+		return
+	}
+
+	if err := setDummyHeaders(w, dummyOne); err != nil {
+		requestLog(r).Errorf("JSON marshal error: %s", err.Error())
+	}
+	countDummyHit(dummyOne)
+	if op.Fault != nil {
+		writeSOAPFault(w, version, 0, op.Fault)
+		return
+	}
+	content, err := renderSOAPResponse(r, op, req)
+	if err != nil {
+		requestLog(r).Errorf("fail to render the response of dummy %s: %s", dummyOne.ID.Hex(), err.Error())
+		writeSOAPFault(w, version, 0, &soapFault{Code: "Server", Reason: "fail to render the response: " + err.Error()})
+		return
+	}
+	writeSOAPEnvelope(w, version, http.StatusOK, content)
+}
+
+// renderSOAPResponse executes the response template of the operation in a span
+func renderSOAPResponse(r *http.Request, op *soapOperation, req *soapRequest) (string, error) {
+	s := startSpan(r.Context(), "render soap response")
+	var content bytes.Buffer
+	tmpl, err := soapTemplate(op.Response, req)
+	if err == nil {
+		err = tmpl.Execute(&content, nil)
+	}
+	if s != nil {
+		s.Attributes["soap.action"] = req.action
+		s.Attributes["soap.element"] = req.element.Local
+	}
+	s.finish(err)
+	return content.String(), err
+}
+
+// writeSOAPEnvelope wraps the content in an envelope unless it is an envelope already
+func writeSOAPEnvelope(w http.ResponseWriter, version string, status int, content string) {
+	namespace, contentType := soap11Namespace, "text/xml"
+	if version == "1.2" {
+		namespace, contentType = soap12Namespace, "application/soap+xml"
+	}
+	w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	w.WriteHeader(status)
+	if isSOAPEnvelope(content) {
+		io.WriteString(w, content)
+		return
+	}
+	io.WriteString(w, xml.Header)
+	fmt.Fprintf(w, `<soap:Envelope xmlns:soap="%s"><soap:Body>%s</soap:Body></soap:Envelope>`, namespace, content)
+}
+
+// isSOAPEnvelope reports whether the first element of the content is Envelope
+func isSOAPEnvelope(content string) bool {
+	dec := xml.NewDecoder(strings.NewReader(content))
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return false
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			return start.Name.Local == "Envelope" && (start.Name.Space == soap11Namespace || start.Name.Space == soap12Namespace)
+		}
+	}
+}
+
+// writeSOAPFault writes the fault of the version. Faults are 500 except Sender faults of SOAP 1.2 which are 400.
+// status overrides it if it is not 0
+func writeSOAPFault(w http.ResponseWriter, version string, status int, fault *soapFault) {
+	codes, ok := soapFaultCodes[fault.Code]
+	if !ok {
+		codes = soapFaultCodes["Server"]
+	}
+	var b strings.Builder
+	if version == "1.2" {
+		if status == 0 {
+			status = http.StatusInternalServerError
+			if codes[1] == "Sender" {
+				status = http.StatusBadRequest
+			}
+		}
+		fmt.Fprintf(&b, `<soap:Fault><soap:Code><soap:Value>soap:%s</soap:Value></soap:Code><soap:Reason><soap:Text xml:lang="en">`, codes[1])
+		xml.EscapeText(&b, []byte(fault.Reason))
+		b.WriteString(`</soap:Text></soap:Reason>`)
+		if fault.Detail != "" {
+			b.WriteString(`<soap:Detail>` + fault.Detail + `</soap:Detail>`)
+		}
+	} else {
+		if status == 0 {
+			status = http.StatusInternalServerError
+		}
+		fmt.Fprintf(&b, `<soap:Fault><faultcode>soap:%s</faultcode><faultstring>`, codes[0])
+		xml.EscapeText(&b, []byte(fault.Reason))
+		b.WriteString(`</faultstring>`)
+		if fault.Detail != "" {
+			b.WriteString(`<detail>` + fault.Detail + `</detail>`)
+		}
+	}
+	b.WriteString(`</soap:Fault>`)
+	writeSOAPEnvelope(w, version, status, b.String())
+}
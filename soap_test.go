@@ -0,0 +1,184 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/globalsign/mgo/bson"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const testSOAPRequest = `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" xmlns:b="urn:billing">
+  <s:Body>
+    <b:GetInvoice currency="EUR">
+      <b:InvoiceId>42</b:InvoiceId>
+      <b:Lines><b:Line sku="a1">2</b:Line><b:Line sku="b2">5</b:Line></b:Lines>
+    </b:GetInvoice>
+  </s:Body>
+</s:Envelope>`
+
+var _ = Describe("SOAP", func() {
+	var dummy dummyModel
+
+	newSOAPDummy := func(soap *soapModel) {
+		dummy = dummyModel{ID: bson.NewObjectId()}
+		reqModel := requestModel{SOAP: soap}
+		Expect(reqModel.validate()).To(Succeed())
+		Expect(dummy.updateWithRequestData(&reqModel)).To(Succeed())
+	}
+	post := func(body string, header http.Header) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/billing", strings.NewReader(body))
+		for key := range header {
+			req.Header.Set(key, header.Get(key))
+		}
+		w := httptest.NewRecorder()
+		serveSOAP(w, req, &dummy)
+		return w
+	}
+
+	It("should evaluate XPath on XML bodies", func() {
+		body := []byte(testSOAPRequest)
+		Expect(xpathValues(body, "//InvoiceId")).To(Equal([]string{"42"}))
+		Expect(xpathValues(body, "//b:InvoiceId")).To(Equal([]string{"42"}))
+		Expect(xpathValues(body, "//x:InvoiceId")).To(BeEmpty(), "undeclared prefix")
+		Expect(xpathValues(body, "/Envelope/Body/GetInvoice/@currency")).To(Equal([]string{"EUR"}))
+		Expect(xpathValues(body, "//Line[2]/@sku")).To(Equal([]string{"b2"}))
+		Expect(xpathValues(body, "//Line[@sku='a1']/text()")).To(Equal([]string{"2"}))
+		Expect(xpathValues(body, `//GetInvoice[InvoiceId="42"]/Lines/*`)).To(Equal([]string{"2", "5"}))
+		Expect(xpathValues(body, "//Line/..")).To(Equal([]string{"25", "25"}))
+		Expect(xpathValues([]byte("not xml"), "//a")).To(BeEmpty())
+
+		for _, invalid := range []string{"", "//", "a[", "a[0]", "@id/a", "a[b=c]", "a:b:c"} {
+			_, err := parseXPath(invalid)
+			Expect(err).To(HaveOccurred(), invalid)
+		}
+	})
+
+	It("should take string values of mixed content in order", func() {
+		body := []byte(`<a>1<b>2<c>3</c></b>4<d/>5</a>`)
+		Expect(xpathValues(body, "/a")).To(Equal([]string{"12345"}))
+		Expect(xpathValues(body, "/a/text()")).To(Equal([]string{"145"}))
+		Expect(xpathValues(body, "//b")).To(Equal([]string{"23"}))
+		Expect(xpathValues(body, "/a[b='23']/d/..")).To(Equal([]string{"12345"}))
+	})
+
+	It("should parse large and deeply nested bodies in bounded time", func() {
+		deep := strings.Repeat("<a>", maxXMLDepth+1) + strings.Repeat("</a>", maxXMLDepth+1)
+		_, err := parseXMLDocument([]byte(deep))
+		Expect(err).To(MatchError(ContainSubstring("nested deeper")))
+		deep = strings.Repeat("<a>x", maxXMLDepth) + strings.Repeat("</a>", maxXMLDepth)
+		Expect(xpathValues([]byte(deep), "/a")).To(Equal([]string{strings.Repeat("x", maxXMLDepth)}))
+
+		wide := []byte("<a>" + strings.Repeat("x<b/>", 200000) + "</a>")
+		done := make(chan []string, 1)
+		go func() { done <- xpathValues(wide, "/a/text()") }()
+		Eventually(done, 5).Should(Receive(HaveLen(1)))
+	})
+
+	Context("with SOAP 1.1", func() {
+		BeforeEach(func() {
+			newSOAPDummy(&soapModel{
+				Operations: []soapOperation{
+					{Element: "{urn:billing}GetInvoice", Response: `<GetInvoiceResponse xmlns="urn:billing"><Id>{{xpath "//InvoiceId"}}</Id><Action>{{action}}</Action></GetInvoiceResponse>`},
+					{Action: "urn:billing/GetInvoice", Fault: &soapFault{Code: "Sender", Reason: "invoice <42> is locked", Detail: "<code>LOCKED</code>"},
+						Matchers: []matcherModel{{In: "xpath", Name: "//b:InvoiceId", Op: matchEquals, Value: "42"}}},
+				},
+				WSDL: `<definitions xmlns="http://schemas.xmlsoap.org/wsdl/" name="Billing"/>`,
+			})
+		})
+
+		It("should answer the operation of the body element with a templated envelope", func() {
+			w := post(testSOAPRequest, nil)
+			Expect(w.Code).To(Equal(http.StatusOK))
+			Expect(w.Header().Get("Content-Type")).To(Equal("text/xml; charset=utf-8"))
+			Expect(w.Body.String()).To(ContainSubstring(`<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body>` +
+				`<GetInvoiceResponse xmlns="urn:billing"><Id>42</Id><Action></Action></GetInvoiceResponse></soap:Body></soap:Envelope>`))
+			Expect(xpathValues(w.Body.Bytes(), "//Id")).To(Equal([]string{"42"}))
+		})
+
+		It("should prefer the operation of the action with more matchers", func() {
+			w := post(testSOAPRequest, http.Header{"Soapaction": {`"urn:billing/GetInvoice"`}})
+			Expect(w.Code).To(Equal(http.StatusInternalServerError))
+			Expect(xpathValues(w.Body.Bytes(), "//Fault/faultcode")).To(Equal([]string{"soap:Client"}))
+			Expect(xpathValues(w.Body.Bytes(), "//Fault/faultstring")).To(Equal([]string{"invoice <42> is locked"}))
+			Expect(xpathValues(w.Body.Bytes(), "//Fault/detail/code")).To(Equal([]string{"LOCKED"}))
+
+			w = post(strings.Replace(testSOAPRequest, ">42<", ">7<", 1), http.Header{"Soapaction": {"urn:billing/GetInvoice"}})
+			Expect(w.Code).To(Equal(http.StatusOK))
+		})
+
+		It("should fault requests which are not SOAP 1.1", func() {
+			w := post(`<a>`, nil)
+			Expect(w.Code).To(Equal(http.StatusInternalServerError))
+			Expect(xpathValues(w.Body.Bytes(), "//faultcode")).To(Equal([]string{"soap:Client"}))
+
+			w = post(strings.Replace(testSOAPRequest, soap11Namespace, soap12Namespace, 1), nil)
+			Expect(xpathValues(w.Body.Bytes(), "//faultcode")).To(Equal([]string{"soap:VersionMismatch"}))
+
+			w = post(strings.Replace(testSOAPRequest, "GetInvoice", "Pay", -1), nil)
+			Expect(xpathValues(w.Body.Bytes(), "//faultstring")).To(ContainElement(ContainSubstring("no operation matches")))
+		})
+
+		It("should serve the WSDL", func() {
+			req, _ := http.NewRequest("GET", "/billing?wsdl", nil)
+			w := httptest.NewRecorder()
+			serveSOAP(w, req, &dummy)
+			Expect(w.Code).To(Equal(http.StatusOK))
+			Expect(w.Header().Get("Content-Type")).To(Equal("text/xml; charset=utf-8"))
+			Expect(w.Body.String()).To(HavePrefix("<definitions"))
+
+			req, _ = http.NewRequest("GET", "/billing", nil)
+			w = httptest.NewRecorder()
+			serveSOAP(w, req, &dummy)
+			Expect(w.Code).To(Equal(http.StatusMethodNotAllowed))
+		})
+	})
+
+	Context("with SOAP 1.2", func() {
+		BeforeEach(func() {
+			newSOAPDummy(&soapModel{Version: "1.2", Operations: []soapOperation{
+				{Action: "urn:billing/Pay", Fault: &soapFault{Code: "Client", Reason: "card declined"}},
+				{Action: "urn:billing/Refund", Fault: &soapFault{Code: "Server", Reason: "try later"}},
+				{Response: `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope"><s:Body><Done/></s:Body></s:Envelope>`},
+			}})
+			Expect(dummy.ContentType).To(Equal("application/soap+xml"))
+		})
+
+		It("should take the action from the content type", func() {
+			request := strings.Replace(testSOAPRequest, soap11Namespace, soap12Namespace, 1)
+			w := post(request, http.Header{"Content-Type": {`application/soap+xml; charset=utf-8; action="urn:billing/Pay"`}})
+			Expect(w.Code).To(Equal(http.StatusBadRequest))
+			Expect(w.Header().Get("Content-Type")).To(Equal("application/soap+xml; charset=utf-8"))
+			Expect(xpathValues(w.Body.Bytes(), "//Fault/Code/Value")).To(Equal([]string{"soap:Sender"}))
+			Expect(xpathValues(w.Body.Bytes(), "//Fault/Reason/Text")).To(Equal([]string{"card declined"}))
+
+			w = post(request, http.Header{"Content-Type": {`application/soap+xml; action="urn:billing/Refund"`}})
+			Expect(w.Code).To(Equal(http.StatusInternalServerError))
+			Expect(xpathValues(w.Body.Bytes(), "//Fault/Code/Value")).To(Equal([]string{"soap:Receiver"}))
+
+			w = post(request, nil)
+			Expect(w.Code).To(Equal(http.StatusOK))
+			Expect(w.Body.String()).To(Equal(`<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope"><s:Body><Done/></s:Body></s:Envelope>`))
+		})
+	})
+
+	It("should validate SOAP dummies", func() {
+		invalid := []*soapModel{
+			{},
+			{Version: "2.0", Operations: []soapOperation{{}}},
+			{Operations: []soapOperation{{Element: "{urn:a"}}},
+			{Operations: []soapOperation{{Response: "{{xpath"}}},
+			{Operations: []soapOperation{{Fault: &soapFault{Code: "Nope"}}}},
+			{Operations: []soapOperation{{Response: "<a/>", Fault: &soapFault{}}}},
+			{Operations: []soapOperation{{Matchers: []matcherModel{{In: "xpath", Name: "a[", Op: matchPresent}}}}},
+			{Operations: []soapOperation{{}}, WSDL: "<definitions>"},
+		}
+		for _, m := range invalid {
+			Expect(m.validate()).To(HaveOccurred(), "%+v", m)
+		}
+		Expect((&requestModel{SOAP: &soapModel{Operations: []soapOperation{{}}}, GraphQL: &graphQLModel{}}).validate()).To(HaveOccurred())
+	})
+})
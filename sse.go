@@ -0,0 +1,154 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sseKeepAlive is how often a comment is sent while the stream is idle so that proxies keep it open
+var sseKeepAlive = 15 * time.Second
+
+// sseModel is a stream of Server-Sent Events of a dummy
+//
+//	sse:
+//	  loop: true
+//	  events:
+//	    - {event: notification, id: "1", data: '{"text": "hello"}', delay: 1000}
+//	    - {event: notification, id: "2", data: '{"text": "world"}', delay: 1000}
+type sseModel struct {
+	Events []sseEvent `json:"events"`
+	Loop   bool       `json:"loop"` // start over after the last event
+}
+
+// sseEvent is an event in the stream. Data with newlines is sent in multiple data lines
+type sseEvent struct {
+	Event string `json:"event,omitempty"` // event name. message if empty
+	Data  string `json:"data"`
+	ID    string `json:"id,omitempty"`    // clients send it back in Last-Event-ID on reconnect
+	Retry int    `json:"retry,omitempty"` // milliseconds clients wait before reconnecting
+	Delay int    `json:"delay,omitempty"` // milliseconds to wait before sending
+}
+
+// validate sseModel. do not trust any input
+func (m *sseModel) validate() error {
+	if len(m.Events) == 0 {
+		return errors.New("sse has no event")
+	}
+	total := 0
+	for i, event := range m.Events {
+		if strings.ContainsAny(event.Event, "\r\n") || strings.ContainsAny(event.ID, "\r\n\x00") {
+			return fmt.Errorf("events[%d]: event and id should be a line", i)
+		}
+		if event.Retry < 0 || event.Delay < 0 {
+			return fmt.Errorf("events[%d]: retry and delay should not be negative", i)
+		}
+		total += event.Delay
+	}
+	if m.Loop && total == 0 {
+		return errors.New("a looped sse should have a delay")
+	}
+	return nil
+}
+
+// sseLineBreaks are line terminators of text/event-stream. Each line of data is sent as a data field
+var sseLineBreaks = strings.NewReplacer("\r\n", "\n", "\r", "\n")
+
+// write writes the event in the text/event-stream format
+func (e *sseEvent) write(w http.ResponseWriter) error {
+	var b strings.Builder
+	if e.ID != "" {
+		b.WriteString("id: " + e.ID + "\n")
+	}
+	if e.Event != "" {
+		b.WriteString("event: " + e.Event + "\n")
+	}
+	if e.Retry > 0 {
+		b.WriteString("retry: " + strconv.Itoa(e.Retry) + "\n")
+	}
+	for _, line := range strings.Split(sseLineBreaks.Replace(e.Data), "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+	_, err := w.Write([]byte(b.String()))
+	return err
+}
+
+// sseResumeIndex returns the index of the event after Last-Event-ID. The stream starts over for an unknown ID
+func sseResumeIndex(m *sseModel, lastEventID string) int {
+	if lastEventID == "" {
+		return 0
+	}
+	for i := range m.Events {
+		if m.Events[i].ID == lastEventID {
+			if i+1 == len(m.Events) && m.Loop {
+				return 0
+			}
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// serveSSE streams the events of the dummy until the client disconnects.
+// A reconnecting client continues after Last-Event-ID
+func serveSSE(w http.ResponseWriter, r *http.Request, dummyOne *dummyModel) {
+	if err := setDummyHeaders(w, dummyOne); err != nil {
+		requestLog(r).Errorf("JSON marshal error: %s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.Header().Del("Content-Length")
+
+	rc := http.NewResponseController(w)
+	// the stream is open as long as the client wants
+	rc.SetWriteDeadline(time.Time{})
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		requestLog(r).Warnf("fail to stream events: %s", err.Error())
+		return
+	}
+	countDummyHit(dummyOne)
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	// wait returns false if the client has gone away. comments are sent while waiting
+	wait := func(d time.Duration) bool {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		for {
+			select {
+			case <-timer.C:
+				return true
+			case <-keepAlive.C:
+				if _, err := w.Write([]byte(": keep-alive\n\n")); err != nil || rc.Flush() != nil {
+					return false
+				}
+			case <-r.Context().Done():
+				return false
+			}
+		}
+	}
+
+	events := dummyOne.SSE.Events
+	for i := sseResumeIndex(dummyOne.SSE, r.Header.Get("Last-Event-ID")); i < len(events); i++ {
+		if events[i].Delay > 0 && !wait(time.Duration(events[i].Delay)*time.Millisecond) {
+			return
+		}
+		if events[i].write(w) != nil || rc.Flush() != nil {
+			return
+		}
+		if i+1 == len(events) && dummyOne.SSE.Loop {
+			i = -1
+		}
+	}
+	// stay open until the client disconnects. it would reconnect and get the stream again otherwise
+	for wait(time.Hour) {
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rs/cors"
+)
+
+// readSSEEvent reads lines of an event up to the blank line. Comments are skipped
+func readSSEEvent(br *bufio.Reader) []string {
+	var lines []string
+	for {
+		line, err := br.ReadString('\n')
+		Expect(err).NotTo(HaveOccurred())
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			if len(lines) > 0 {
+				return lines
+			}
+			continue
+		}
+		if !strings.HasPrefix(line, ":") {
+			lines = append(lines, line)
+		}
+	}
+}
+
+var _ = Describe("Server-Sent Events", func() {
+	var server *httptest.Server
+	var dummy dummyModel
+
+	BeforeEach(func() {
+		dummy = dummyModel{SSE: &sseModel{Events: []sseEvent{
+			{Event: "notification", ID: "1", Data: "first", Retry: 3000},
+			{ID: "2", Data: "second\nline", Delay: 20},
+			{ID: "3", Data: "third", Delay: 20},
+		}}}
+		handler := cors.New(cors.Options{AllowedOrigins: []string{"*"}}).Handler(instrument(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			serveSSE(w, r, &dummy)
+		})))
+		server = httptest.NewServer(accessLog(cfg.AccessLog, tracing(cfg.Tracing, handler)))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	get := func(lastEventID string) (*http.Response, *bufio.Reader) {
+		req, _ := http.NewRequest("GET", server.URL+"/feed", nil)
+		req.Header.Set("Origin", "http://app.test")
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		res, err := http.DefaultClient.Do(req)
+		Expect(err).NotTo(HaveOccurred())
+		return res, bufio.NewReader(res.Body)
+	}
+
+	It("should stream events through CORS and stay open", func() {
+		res, br := get("")
+		defer res.Body.Close()
+		Expect(res.Header.Get("Content-Type")).To(Equal("text/event-stream; charset=utf-8"))
+		Expect(res.Header.Get("Access-Control-Allow-Origin")).To(Equal("*"))
+
+		Expect(readSSEEvent(br)).To(Equal([]string{"id: 1", "event: notification", "retry: 3000", "data: first"}))
+		Expect(readSSEEvent(br)).To(Equal([]string{"id: 2", "data: second", "data: line"}))
+		Expect(readSSEEvent(br)).To(Equal([]string{"id: 3", "data: third"}))
+
+		ended := make(chan struct{})
+		go func() {
+			defer close(ended)
+			br.ReadByte()
+		}()
+		Consistently(ended, 100*time.Millisecond).ShouldNot(BeClosed())
+		res.Body.Close()
+		Eventually(ended).Should(BeClosed())
+	})
+
+	It("should resume after Last-Event-ID", func() {
+		res, br := get("2")
+		defer res.Body.Close()
+		Expect(readSSEEvent(br)).To(Equal([]string{"id: 3", "data: third"}))
+	})
+
+	It("should loop the events", func() {
+		dummy.SSE.Loop = true
+		res, br := get("3")
+		defer res.Body.Close()
+		Expect(readSSEEvent(br)[0]).To(Equal("id: 1"))
+		Expect(readSSEEvent(br)[0]).To(Equal("id: 2"))
+		Expect(readSSEEvent(br)[0]).To(Equal("id: 3"))
+		Expect(readSSEEvent(br)[0]).To(Equal("id: 1"))
+	})
+
+	It("should send comments while idle", func() {
+		saved := sseKeepAlive
+		sseKeepAlive = 10 * time.Millisecond
+		defer func() { sseKeepAlive = saved }()
+		dummy.SSE.Events = []sseEvent{{Data: "late", Delay: 50}}
+
+		res, br := get("")
+		defer res.Body.Close()
+		line, err := br.ReadString('\n')
+		Expect(err).NotTo(HaveOccurred())
+		Expect(line).To(Equal(": keep-alive\n"))
+	})
+
+	It("should send each line of data as a field", func() {
+		w := httptest.NewRecorder()
+		event := sseEvent{Event: "log", Data: "a\rb\r\nc\nd\r"}
+		Expect(event.write(w)).To(Succeed())
+		Expect(w.Body.String()).To(Equal("event: log\ndata: a\ndata: b\ndata: c\ndata: d\ndata: \n\n"))
+	})
+
+	It("should validate streams", func() {
+		Expect((&sseModel{}).validate()).NotTo(Succeed())
+		Expect((&sseModel{Events: []sseEvent{{ID: "a\nb"}}}).validate()).NotTo(Succeed())
+		Expect((&sseModel{Events: []sseEvent{{Data: "x"}}, Loop: true}).validate()).NotTo(Succeed())
+		Expect((&sseModel{Events: []sseEvent{{Data: "x", Delay: 1}}, Loop: true}).validate()).To(Succeed())
+	})
+})
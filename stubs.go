@@ -0,0 +1,425 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	log "github.com/sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// sourceFile prefixes the source of dummies loaded from stub files. e.g. file:pets.yaml
+const sourceFile = "file:"
+
+var errorReadOnly = &errorResponse{"ReadOnly", "Project is managed by stub files. Change the files instead"}
+
+// stubFileModel is a stub file. A file adds dummies to a project
+//
+//	project: pet store
+//	dummies:
+//	  - method: GET
+//	    path: /pets/{id}
+//	    status: 200
+//	    ...
+type stubFileModel struct {
+	Project string        `json:"project"` // project name. The file name is used if empty
+	Dummies []interface{} `json:"dummies"`
+}
+
+// stubModel is a dummy in a stub file. It is requestModel plus where and how to match
+type stubModel struct {
+	requestModel
+	Method   string         `json:"method"`
+	Path     string         `json:"path"`
+	Matchers []matcherModel `json:"matchers"`
+	Delay    int            `json:"delay"` // milliseconds to wait before responding
+}
+
+// validate stubModel. do not trust any input
+func (m *stubModel) validate() error {
+	if err := m.requestModel.validate(); err != nil {
+		return err
+	}
+	if !isProjectMethod(m.Method) {
+		return fmt.Errorf("method '%s' is not supported", m.Method)
+	}
+	if !strings.HasPrefix(m.Path, "/") {
+		return fmt.Errorf("path should start with /")
+	}
+	if m.WebSocket != nil && m.Method != "GET" {
+		return fmt.Errorf("websocket dummies should be GET")
+	}
+	for i := range m.Matchers {
+		if err := m.Matchers[i].validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isProjectMethod(method string) bool {
+	for _, m := range projectMethods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// stubError points the location of an invalid stub
+type stubError struct {
+	File string
+	Line int
+	Msg  string
+}
+
+func (e *stubError) Error() string {
+	if e.Line == 0 {
+		return e.File + ": " + e.Msg
+	}
+	return e.File + ":" + strconv.Itoa(e.Line) + ": " + e.Msg
+}
+
+// stubProjectID derives the project ID from the name so that the URL stays the same across restarts
+func stubProjectID(name string) bson.ObjectId {
+	sum := sha1.Sum([]byte(name))
+	return bson.ObjectId(sum[:12])
+}
+
+var yamlLineError = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+
+// parseStubFile parses a YAML or JSON stub file. rel is the path to report errors
+func parseStubFile(rel string, raw []byte) (*projectModel, []dummyModel, error) {
+	var doc interface{}
+	if strings.HasSuffix(rel, ".json") {
+		if err := json.Unmarshal(raw, &doc); err != nil {
+			stubErr := &stubError{File: rel, Msg: err.Error()}
+			if syntaxErr, ok := err.(*json.SyntaxError); ok {
+				stubErr.Line = lineAt(raw, int(syntaxErr.Offset))
+			}
+			return nil, nil, stubErr
+		}
+	} else {
+		if err := yaml.Unmarshal(raw, &doc); err != nil {
+			stubErr := &stubError{File: rel, Msg: err.Error()}
+			if m := yamlLineError.FindStringSubmatch(err.Error()); m != nil {
+				stubErr.Line, _ = strconv.Atoi(m[1])
+				stubErr.Msg = m[2]
+			}
+			return nil, nil, stubErr
+		}
+		doc = normalizeYAML(doc)
+	}
+
+	// go through JSON so that fields are named as the API does
+	byt, err := json.Marshal(doc)
+	if err != nil {
+		return nil, nil, &stubError{File: rel, Msg: err.Error()}
+	}
+	var file stubFileModel
+	if err := json.Unmarshal(byt, &file); err != nil {
+		return nil, nil, &stubError{File: rel, Line: 1, Msg: "should have 'project' and a list of 'dummies'"}
+	}
+	if file.Project == "" {
+		file.Project = strings.TrimSuffix(filepath.Base(rel), filepath.Ext(rel))
+	}
+	project := &projectModel{
+		ID:       stubProjectID(file.Project),
+		Name:     file.Project,
+		ReadOnly: true,
+	}
+
+	lines := itemLines(raw, "dummies")
+	dummies := make([]dummyModel, 0, len(file.Dummies))
+	for i, item := range file.Dummies {
+		stubErr := &stubError{File: rel}
+		if i < len(lines) {
+			stubErr.Line = lines[i]
+		}
+
+		var stub stubModel
+		byt, _ := json.Marshal(item)
+		if err := json.Unmarshal(byt, &stub); err != nil {
+			stubErr.Msg = fmt.Sprintf("dummies[%d]: %s", i, err.Error())
+			return nil, nil, stubErr
+		}
+		stub.Method = strings.ToUpper(stub.Method)
+		if err := stub.validate(); err != nil {
+			stubErr.Msg = fmt.Sprintf("dummies[%d]: %s", i, err.Error())
+			return nil, nil, stubErr
+		}
+
+		var dummy dummyModel
+		if err := dummy.updateWithRequestData(&stub.requestModel); err != nil {
+			stubErr.Msg = fmt.Sprintf("dummies[%d]: %s", i, err.Error())
+			return nil, nil, stubErr
+		}
+		dummy.ID = bson.NewObjectId()
+		dummy.Project = project.ID
+		dummy.Source = sourceFile + rel
+		dummy.Method = stub.Method
+		dummy.Path = stub.Path
+		dummy.Matchers = stub.Matchers
+		dummy.Delay = stub.Delay
+		dummies = append(dummies, dummy)
+	}
+	return project, dummies, nil
+}
+
+// lineAt returns the line number at the byte offset
+func lineAt(raw []byte, offset int) int {
+	if offset > len(raw) {
+		offset = len(raw)
+	}
+	return strings.Count(string(raw[:offset]), "\n") + 1
+}
+
+// itemLines returns line numbers where items of the list under the key start.
+// Both block style YAML and JSON are handled. It is best effort to point errors
+func itemLines(raw []byte, key string) []int {
+	src := string(raw)
+	if i := strings.Index(src, `"`+key+`"`); i >= 0 && strings.HasPrefix(strings.TrimSpace(src), "{") {
+		return jsonItemLines(raw, i+len(key)+2)
+	}
+
+	var lines []int
+	keyIndent, itemIndent := -1, -1
+	for n, line := range strings.Split(src, "\n") {
+		trimmed := strings.TrimLeft(line, " ")
+		indent := len(line) - len(trimmed)
+		if keyIndent < 0 {
+			if strings.HasPrefix(trimmed, key+":") {
+				keyIndent = indent
+			}
+			continue
+		}
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		isItem := strings.HasPrefix(trimmed, "- ") || trimmed == "-"
+		if indent < keyIndent || indent == keyIndent && !isItem {
+			break
+		}
+		if isItem && (itemIndent < 0 || indent == itemIndent) {
+			itemIndent = indent
+			lines = append(lines, n+1)
+		}
+	}
+	return lines
+}
+
+// jsonItemLines returns line numbers of objects in the JSON array after the offset
+func jsonItemLines(raw []byte, offset int) []int {
+	var lines []int
+	depth := 0
+	inString, escaped := false, false
+	for i := offset; i < len(raw); i++ {
+		c := raw[i]
+		if inString {
+			if escaped {
+				escaped = false
+			} else if c == '\\' {
+				escaped = true
+			} else if c == '"' {
+				inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			inString = true
+		case '[', '{':
+			if depth == 1 && c == '{' {
+				lines = append(lines, lineAt(raw, i))
+			}
+			depth++
+		case ']', '}':
+			depth--
+			if depth <= 0 {
+				return lines
+			}
+		}
+	}
+	return lines
+}
+
+// stubLoader loads stub files in a directory into the database and applies changes of them
+type stubLoader struct {
+	dir   string
+	files map[string]stubFileState // relative path to the state
+}
+
+type stubFileState struct {
+	modTime time.Time
+	size    int64
+	project bson.ObjectId
+}
+
+func newStubLoader(dir string) *stubLoader {
+	return &stubLoader{dir: dir, files: map[string]stubFileState{}}
+}
+
+// reset removes dummies and projects loaded by the previous run
+func (l *stubLoader) reset() error {
+	err := observeStore(context.Background(), "remove_stub_dummies", func() error {
+		_, err := db.C(collectionDummy).RemoveAll(bson.M{"source": bson.RegEx{Pattern: "^" + sourceFile}})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return observeStore(context.Background(), "remove_stub_projects", func() error {
+		_, err := db.C(collectionProject).RemoveAll(bson.M{"readonly": true})
+		return err
+	})
+}
+
+// removeStubDummies removes dummies loaded from the stub file
+func removeStubDummies(rel string) error {
+	return observeStore(context.Background(), "remove_stub_dummies", func() error {
+		_, err := db.C(collectionDummy).RemoveAll(bson.M{"source": sourceFile + rel})
+		return err
+	})
+}
+
+// sync applies added, changed and deleted files since the last sync.
+// An invalid file is reported and the last valid version of it is kept
+func (l *stubLoader) sync() {
+	seen := map[string]bool{}
+	err := filepath.Walk(l.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		ext := filepath.Ext(path)
+		if info.IsDir() || ext != ".yaml" && ext != ".yml" && ext != ".json" {
+			return nil
+		}
+		rel, err := filepath.Rel(l.dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		seen[rel] = true
+
+		state, ok := l.files[rel]
+		if ok && state.modTime.Equal(info.ModTime()) && state.size == info.Size() {
+			return nil
+		}
+		// remember the version even if it is invalid not to report it again
+		state.modTime, state.size = info.ModTime(), info.Size()
+		l.files[rel] = state
+		l.loadFile(path, rel)
+		return nil
+	})
+	if err != nil {
+		log.Errorf("fail to read stub files in %s: %s", l.dir, err.Error())
+		return
+	}
+
+	for rel, state := range l.files {
+		if seen[rel] {
+			continue
+		}
+		if err := removeStubDummies(rel); err != nil {
+			log.Errorf("fail to remove dummies of %s: %s", rel, err.Error())
+			continue
+		}
+		delete(l.files, rel)
+		evictProjectCaches(state.project)
+		l.removeOrphan(state.project)
+		log.Infof("unloaded stub file %s", rel)
+	}
+}
+
+func (l *stubLoader) loadFile(path, rel string) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Errorf("fail to read stub file %s: %s", rel, err.Error())
+		return
+	}
+	project, dummies, err := parseStubFile(rel, raw)
+	if err != nil {
+		log.WithField("file", rel).Errorf("invalid stub file %s", err.Error())
+		return
+	}
+
+	update := bson.M{
+		"$set":         bson.M{"name": project.Name, "readonly": true},
+		"$setOnInsert": bson.M{"createdat": time.Now()},
+	}
+	err = observeStore(context.Background(), "upsert_project", func() error {
+		_, err := db.C(collectionProject).UpsertId(project.ID, update)
+		return err
+	})
+	if err != nil {
+		log.Errorf("fail to save project of %s: %s", rel, err.Error())
+		return
+	}
+	if err := removeStubDummies(rel); err != nil {
+		log.Errorf("fail to remove dummies of %s: %s", rel, err.Error())
+		return
+	}
+	evictProjectCaches(project.ID)
+	for i := range dummies {
+		err := observeStore(context.Background(), "insert_dummy", func() error {
+			return db.C(collectionDummy).Insert(&dummies[i])
+		})
+		if err != nil {
+			log.Errorf("fail to save dummies of %s: %s", rel, err.Error())
+			return
+		}
+	}
+
+	state := l.files[rel]
+	previous := state.project
+	state.project = project.ID
+	l.files[rel] = state
+	if previous != "" && previous != project.ID {
+		l.removeOrphan(previous)
+	}
+	log.Infof("loaded %d dummies from %s into %s", len(dummies), rel, serverBaseURL()+"mock/"+project.ID.Hex())
+}
+
+// removeOrphan removes the project if no stub file adds dummies to it any more
+func (l *stubLoader) removeOrphan(projectID bson.ObjectId) {
+	if projectID == "" {
+		return
+	}
+	for _, state := range l.files {
+		if state.project == projectID {
+			return
+		}
+	}
+	err := observeStore(context.Background(), "remove_project", func() error {
+		return db.C(collectionProject).RemoveId(projectID)
+	})
+	if err != nil {
+		log.Errorf("fail to remove project %s: %s", projectID.Hex(), err.Error())
+		return
+	}
+	evictProjectCaches(projectID)
+}
+
+// watch syncs the directory periodically until stop is closed
+func (l *stubLoader) watch(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			l.sync()
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const testStubFile = `project: stub pets
+dummies:
+  - method: get
+    path: /pets/{id}
+    status: 200
+    content_type: application/json
+    charset: utf-8
+    content: '{"id": 1}'
+    matchers:
+      - {in: query, name: kind, op: equals, value: dog}
+
+  - method: DELETE
+    path: /pets/{id}
+    status: 204
+    content_type: text/plain
+    charset: utf-8
+`
+
+var _ = Describe("Stub files", func() {
+	Context("with a valid file", func() {
+		It("should parse dummies", func() {
+			project, dummies, err := parseStubFile("pets.yaml", []byte(testStubFile))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(project.Name).To(Equal("stub pets"))
+			Expect(project.ID).To(Equal(stubProjectID("stub pets")))
+			Expect(dummies).To(HaveLen(2))
+			Expect(dummies[0].Method).To(Equal("GET"))
+			Expect(dummies[0].Source).To(Equal("file:pets.yaml"))
+			Expect(dummies[0].Matchers).To(HaveLen(1))
+			Expect(dummies[1].Status).To(Equal(204))
+		})
+
+		It("should name the project after the file", func() {
+			project, _, err := parseStubFile("dir/users.json", []byte(`{"dummies": []}`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(project.Name).To(Equal("users"))
+		})
+	})
+
+	Context("with an invalid file", func() {
+		It("should point the line of the invalid dummy", func() {
+			src := `dummies:
+  - method: GET
+    path: /a
+    status: 200
+    content_type: text/plain
+    charset: utf-8
+  - method: GET
+    path: b
+    status: 200
+`
+			_, _, err := parseStubFile("a.yaml", []byte(src))
+			Expect(err).To(MatchError("a.yaml:7: dummies[1]: content type is empty"))
+		})
+
+		It("should point the line of YAML syntax error", func() {
+			_, _, err := parseStubFile("a.yaml", []byte("dummies:\n  - method: GET\n  path: [\n"))
+			Expect(err).To(HaveOccurred())
+			Expect(err.(*stubError).Line).NotTo(BeZero())
+		})
+
+		It("should point the line in JSON", func() {
+			src := `{
+	"dummies": [
+		{"method": "GET", "path": "/a", "status": 200, "content_type": "text/plain", "charset": "utf-8"},
+		{"method": "TRACE", "path": "/a", "status": 200, "content_type": "text/plain", "charset": "utf-8"}
+	]
+}`
+			_, _, err := parseStubFile("a.json", []byte(src))
+			Expect(err).To(MatchError("a.json:4: dummies[1]: method 'TRACE' is not supported"))
+		})
+	})
+
+	Context("with a stub directory", func() {
+		It("should apply adds, changes and deletes", func() {
+			dir, err := ioutil.TempDir("", "stubs")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.RemoveAll(dir)
+
+			file := filepath.Join(dir, "pets.yaml")
+			Expect(ioutil.WriteFile(file, []byte(testStubFile), 0644)).To(Succeed())
+			loader := newStubLoader(dir)
+			loader.sync()
+
+			projectID := stubProjectID("stub pets")
+			req, _ := http.NewRequest("GET", "/mock/"+projectID.Hex()+"/pets/1?kind=dog", nil)
+			w := httptest.NewRecorder()
+			createRoute().ServeHTTP(w, req)
+			Expect(w.Code).To(Equal(http.StatusOK))
+			Expect(w.Body.String()).To(Equal(`{"id": 1}`))
+
+			req, _ = http.NewRequest("PUT", "/projects/"+projectID.Hex(), nil)
+			w = httptest.NewRecorder()
+			createRoute().ServeHTTP(w, req)
+			Expect(w.Code).To(Equal(http.StatusForbidden))
+
+			Expect(os.Remove(file)).To(Succeed())
+			loader.sync()
+			count, _ := testDB.C(collectionDummy).Find(map[string]interface{}{"project": projectID}).Count()
+			Expect(count).To(BeZero())
+			count, _ = testDB.C(collectionProject).FindId(projectID).Count()
+			Expect(count).To(BeZero())
+		})
+	})
+})
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	log "github.com/sirupsen/logrus"
+)
+
+// files of the auto-generated certificates in the directory
+const (
+	caCertFile   = "ca.pem"
+	caKeyFile    = "ca-key.pem"
+	leafCertFile = "cert.pem"
+	leafKeyFile  = "key.pem"
+)
+
+const (
+	caValidity   = 10 * 365 * 24 * time.Hour
+	leafValidity = 825 * 24 * time.Hour // the longest validity which Apple platforms accept
+	leafRenewal  = 30 * 24 * time.Hour  // renew the leaf if it expires within this
+)
+
+var errorNoCA = &errorResponse{"NotFound", "No CA is generated. TLS is off or uses the configured certificate"}
+
+// caCertPEM is the auto-generated CA certificate served to be trusted. nil if certificates are configured
+var caCertPEM []byte
+
+// loadTLSConfig loads the configured certificate, or the auto-generated one, and asks client certificates
+func loadTLSConfig(c tlsConfig) (*tls.Config, error) {
+	var cert tls.Certificate
+	var err error
+	if c.CertFile != "" {
+		cert, err = tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	} else {
+		var caPEM []byte
+		cert, caPEM, err = autoCertificate(c.AutoCert.Dir, c.AutoCert.Hosts)
+		caCertPEM = caPEM
+	}
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if err := applyClientAuth(tlsConfig, c); err != nil {
+		return nil, err
+	}
+	return tlsConfig, nil
+}
+
+// autoCertificate loads the CA and the leaf certificate in the directory. They are generated if
+// they do not exist. The leaf is regenerated if hosts change or it expires soon, but the CA is kept
+// so that it has to be trusted only once
+func autoCertificate(dir string, hosts []string) (tls.Certificate, []byte, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	ca, caKey, caPEM, err := loadOrCreateCA(dir)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+
+	certPath, keyPath := filepath.Join(dir, leafCertFile), filepath.Join(dir, leafKeyFile)
+	if cert, err := tls.LoadX509KeyPair(certPath, keyPath); err == nil && leafIsValid(cert, ca, hosts) {
+		return cert, caPEM, nil
+	}
+
+	log.Infof("generating a TLS certificate for %v in %s", hosts, dir)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: newSerial(),
+		Subject:      pkix.Name{CommonName: hosts[0], Organization: []string{"Dummy HTTP Responser"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(leafValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM, err := encodeKey(key)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	if err := ioutil.WriteFile(certPath, certPEM, 0644); err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	if err := ioutil.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	return cert, caPEM, err
+}
+
+func loadOrCreateCA(dir string) (*x509.Certificate, *ecdsa.PrivateKey, []byte, error) {
+	certPath, keyPath := filepath.Join(dir, caCertFile), filepath.Join(dir, caKeyFile)
+	if pair, err := tls.LoadX509KeyPair(certPath, keyPath); err == nil {
+		ca, err := x509.ParseCertificate(pair.Certificate[0])
+		key, ok := pair.PrivateKey.(*ecdsa.PrivateKey)
+		if err == nil && ok && time.Now().Before(ca.NotAfter) {
+			caPEM, err := ioutil.ReadFile(certPath)
+			return ca, key, caPEM, err
+		}
+	} else if !os.IsNotExist(err) {
+		if _, statErr := os.Stat(certPath); statErr == nil {
+			return nil, nil, nil, errors.New("invalid CA in " + dir + ": " + err.Error())
+		}
+	}
+
+	log.Infof("generating a CA in %s. trust %s to accept the certificate", dir, certPath)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          newSerial(),
+		Subject:               pkix.Name{CommonName: "Dummy HTTP Responser CA", Organization: []string{"Dummy HTTP Responser"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM, err := encodeKey(key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err := ioutil.WriteFile(certPath, caPEM, 0644); err != nil {
+		return nil, nil, nil, err
+	}
+	if err := ioutil.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		return nil, nil, nil, err
+	}
+	// the leaf signed by the old CA is not valid any more
+	os.Remove(filepath.Join(dir, leafCertFile))
+	return ca, key, caPEM, nil
+}
+
+// leafIsValid checks the leaf is signed by the CA, covers the hosts and does not expire soon
+func leafIsValid(cert tls.Certificate, ca *x509.Certificate, hosts []string) bool {
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil || time.Now().Add(leafRenewal).After(leaf.NotAfter) || leaf.CheckSignatureFrom(ca) != nil {
+		return false
+	}
+	var names []string
+	names = append(names, leaf.DNSNames...)
+	for _, ip := range leaf.IPAddresses {
+		names = append(names, ip.String())
+	}
+	want := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			host = ip.String()
+		}
+		want = append(want, host)
+	}
+	if len(names) != len(want) {
+		return false
+	}
+	sort.Strings(names)
+	sort.Strings(want)
+	for i := range names {
+		if names[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func newSerial() *big.Int {
+	serial, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	return serial
+}
+
+func encodeKey(key *ecdsa.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+}
+
+// handler for GET /tls/ca.pem
+// It serves the auto-generated CA to be trusted by browsers and devices
+func handleDownloadCA(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if caCertPEM == nil {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(requestError(r, errorNoCA))
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-pem-file")
+	w.Header().Set("Content-Disposition", `attachment; filename="dummy-http-responser-ca.pem"`)
+	w.WriteHeader(http.StatusOK)
+	w.Write(caCertPEM)
+}
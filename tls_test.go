@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("TLS", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "certs")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+		caCertPEM = nil
+	})
+
+	It("should generate a certificate signed by a persisted CA", func() {
+		cert, caPEM, err := autoCertificate(dir, []string{"localhost", "127.0.0.1"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(filepath.Join(dir, caCertFile)).To(BeAnExistingFile())
+		info, err := os.Stat(filepath.Join(dir, caKeyFile))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0600)))
+
+		roots := x509.NewCertPool()
+		Expect(roots.AppendCertsFromPEM(caPEM)).To(BeTrue())
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		Expect(err).NotTo(HaveOccurred())
+		for _, host := range []string{"localhost", "127.0.0.1"} {
+			_, err = leaf.Verify(x509.VerifyOptions{DNSName: host, Roots: roots})
+			Expect(err).NotTo(HaveOccurred(), host)
+		}
+
+		// the same CA and leaf are used on restart
+		again, caAgain, err := autoCertificate(dir, []string{"127.0.0.1", "localhost"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(caAgain).To(Equal(caPEM))
+		Expect(again.Certificate[0]).To(Equal(cert.Certificate[0]))
+
+		// the leaf is regenerated for new hosts by the same CA
+		renewed, caRenewed, err := autoCertificate(dir, []string{"localhost", "dummy.test"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(caRenewed).To(Equal(caPEM))
+		leaf, err = x509.ParseCertificate(renewed.Certificate[0])
+		Expect(err).NotTo(HaveOccurred())
+		_, err = leaf.Verify(x509.VerifyOptions{DNSName: "dummy.test", Roots: roots})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should serve HTTPS and the CA", func() {
+		c := defaultConfig().TLS
+		c.AutoCert.Dir = dir
+		tlsConfig, err := loadTLSConfig(c)
+		Expect(err).NotTo(HaveOccurred())
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		server := newServer(defaultConfig().Server, listener.Addr().String(), createRoute())
+		server.TLSConfig = tlsConfig
+		go server.ServeTLS(listener, "", "")
+		defer server.Close()
+
+		roots := x509.NewCertPool()
+		Expect(roots.AppendCertsFromPEM(caCertPEM)).To(BeTrue())
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}, ForceAttemptHTTP2: true}}
+		res, err := client.Get("https://" + listener.Addr().String() + "/tls/ca.pem")
+		Expect(err).NotTo(HaveOccurred())
+		defer res.Body.Close()
+		body, _ := ioutil.ReadAll(res.Body)
+		Expect(res.StatusCode).To(Equal(http.StatusOK))
+		Expect(res.ProtoMajor).To(Equal(2))
+		Expect(res.Header.Get("Content-Type")).To(Equal("application/x-pem-file"))
+		Expect(body).To(Equal(caCertPEM))
+	})
+
+	It("should not serve a CA for a configured certificate", func() {
+		rec := httptest.NewRecorder()
+		handleDownloadCA(rec, httptest.NewRequest("GET", "/tls/ca.pem", nil), nil)
+		Expect(rec.Code).To(Equal(http.StatusNotFound))
+	})
+})
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	mathrand "math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// OTLP span kinds and status codes
+const (
+	spanKindInternal = 1
+	spanKindServer   = 2
+
+	spanStatusError = 2
+)
+
+// span is a unit of work in a trace. It is exported in OTLP/HTTP JSON
+type span struct {
+	TraceID      string
+	SpanID       string
+	ParentSpanID string
+	Sampled      bool
+	TraceState   string // tracestate from the client, kept to propagate
+	Name         string
+	Kind         int
+	Start        time.Time
+	End          time.Time
+	Attributes   map[string]interface{}
+	Error        string
+}
+
+// traceparent formats the span as a W3C traceparent header
+func (s *span) traceparent() string {
+	flags := "00"
+	if s.Sampled {
+		flags = "01"
+	}
+	return "00-" + s.TraceID + "-" + s.SpanID + "-" + flags
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func isHex(s string, n int) bool {
+	if len(s) != n || strings.Trim(s, "0") == "" {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil && strings.ToLower(s) == s
+}
+
+// parseTraceparent parses a W3C traceparent header. e.g. 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func parseTraceparent(v string) (traceID, spanID string, sampled, ok bool) {
+	parts := strings.Split(strings.TrimSpace(v), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || parts[0] == "00" && len(parts) != 4 {
+		return "", "", false, false
+	}
+	if !isHex(parts[1], 32) || !isHex(parts[2], 16) || len(parts[3]) != 2 {
+		return "", "", false, false
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil {
+		return "", "", false, false
+	}
+	return parts[1], parts[2], flags&1 == 1, true
+}
+
+// spanOf returns the span of the context or nil
+func spanOf(ctx context.Context) *span {
+	s, _ := ctx.Value(spanContextKey).(*span)
+	return s
+}
+
+// startSpan starts a child span of the span in the context. It returns nil if there is no parent
+func startSpan(ctx context.Context, name string) *span {
+	parent := spanOf(ctx)
+	if parent == nil {
+		return nil
+	}
+	return &span{
+		TraceID:      parent.TraceID,
+		SpanID:       randomHex(8),
+		ParentSpanID: parent.SpanID,
+		Sampled:      parent.Sampled,
+		Name:         name,
+		Kind:         spanKindInternal,
+		Start:        time.Now(),
+		Attributes:   map[string]interface{}{},
+	}
+}
+
+// finish ends the span and queues it to export. It is safe on nil
+func (s *span) finish(err error) {
+	if s == nil {
+		return
+	}
+	s.End = time.Now()
+	if err != nil {
+		s.Error = err.Error()
+	}
+	if s.Sampled {
+		exporter.enqueue(s)
+	}
+}
+
+// tracing starts a server span for each request. The trace continues from traceparent of
+// the client, or starts if there is none
+func tracing(c tracingConfig, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := &span{
+			SpanID:     randomHex(8),
+			Name:       r.Method + " " + routeOf(r.URL.Path),
+			Kind:       spanKindServer,
+			Start:      time.Now(),
+			Attributes: map[string]interface{}{},
+		}
+		if traceID, parentID, sampled, ok := parseTraceparent(r.Header.Get("traceparent")); ok {
+			s.TraceID, s.ParentSpanID, s.Sampled = traceID, parentID, sampled
+			s.TraceState = r.Header.Get("tracestate")
+		} else {
+			s.TraceID = randomHex(16)
+			s.Sampled = c.Endpoint != "" && mathrand.Float64() < c.SampleRate
+		}
+		s.Sampled = s.Sampled && c.Endpoint != ""
+
+		r = r.WithContext(context.WithValue(r.Context(), spanContextKey, s))
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		s.Attributes["http.request.method"] = r.Method
+		s.Attributes["http.route"] = routeOf(r.URL.Path)
+		s.Attributes["url.path"] = r.URL.Path
+		s.Attributes["network.protocol.version"] = protocolVersion(r)
+		s.Attributes["http.response.status_code"] = sw.status
+		info := infoOf(r)
+		if info.id != "" {
+			s.Attributes["request_id"] = info.id
+		}
+		if info.dummyID != "" {
+			s.Attributes["dummy.id"] = info.dummyID
+		}
+		var err error
+		if sw.status >= http.StatusInternalServerError {
+			err = errorStatus(sw.status)
+		}
+		s.finish(err)
+	})
+}
+
+// protocolVersion is the HTTP version in OpenTelemetry convention. e.g. 1.1 or 2
+func protocolVersion(r *http.Request) string {
+	if r.ProtoMajor == 1 {
+		return "1." + strconv.Itoa(r.ProtoMinor)
+	}
+	return strconv.Itoa(r.ProtoMajor)
+}
+
+type errorStatus int
+
+func (e errorStatus) Error() string {
+	return http.StatusText(int(e))
+}
+
+// echoTraceHeaders reflects trace headers of the request so that the client can link the response.
+// The server span is sent if the client did not send traceparent
+func echoTraceHeaders(w http.ResponseWriter, r *http.Request) {
+	if v := r.Header.Get("traceparent"); v != "" {
+		w.Header().Set("traceparent", v)
+		if state := r.Header.Get("tracestate"); state != "" {
+			w.Header().Set("tracestate", state)
+		}
+		return
+	}
+	if s := spanOf(r.Context()); s != nil {
+		w.Header().Set("traceparent", s.traceparent())
+	}
+}
+
+// spanExporter sends spans to an OTLP/HTTP collector in batches.
+// Spans are dropped when the queue is full not to slow requests down
+type spanExporter struct {
+	c      tracingConfig
+	queue  chan *span
+	flush  chan chan struct{}
+	client *http.Client
+}
+
+var exporter = newSpanExporter(tracingConfig{})
+
+func newSpanExporter(c tracingConfig) *spanExporter {
+	return &spanExporter{
+		c:      c,
+		queue:  make(chan *span, 2048),
+		flush:  make(chan chan struct{}),
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (e *spanExporter) enqueue(s *span) {
+	select {
+	case e.queue <- s:
+	default:
+		log.Debug("span queue is full. dropping a span")
+	}
+}
+
+// run exports spans until stop is closed. Spans left in the queue are exported on stop
+func (e *spanExporter) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(e.c.FlushInterval)
+	defer ticker.Stop()
+	var batch []*span
+	send := func() {
+		if len(batch) > 0 {
+			e.export(batch)
+			batch = nil
+		}
+	}
+	for {
+		select {
+		case s := <-e.queue:
+			batch = append(batch, s)
+			if len(batch) >= e.c.BatchSize {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case done := <-e.flush:
+			for len(e.queue) > 0 {
+				batch = append(batch, <-e.queue)
+			}
+			send()
+			close(done)
+		case <-stop:
+			for len(e.queue) > 0 {
+				batch = append(batch, <-e.queue)
+			}
+			send()
+			return
+		}
+	}
+}
+
+// forceFlush exports queued spans now and waits for it
+func (e *spanExporter) forceFlush() {
+	done := make(chan struct{})
+	e.flush <- done
+	<-done
+}
+
+// otlpValue is an AnyValue of OTLP JSON
+func otlpValue(v interface{}) map[string]interface{} {
+	switch t := v.(type) {
+	case int:
+		return map[string]interface{}{"intValue": strconv.Itoa(t)}
+	case bool:
+		return map[string]interface{}{"boolValue": t}
+	case float64:
+		return map[string]interface{}{"doubleValue": t}
+	}
+	return map[string]interface{}{"stringValue": toString(v)}
+}
+
+func toString(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	byt, _ := json.Marshal(v)
+	return string(byt)
+}
+
+func otlpAttributes(attrs map[string]interface{}) []map[string]interface{} {
+	list := make([]map[string]interface{}, 0, len(attrs))
+	keys := make([]string, 0, len(attrs))
+	for key := range attrs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		list = append(list, map[string]interface{}{"key": key, "value": otlpValue(attrs[key])})
+	}
+	return list
+}
+
+// export posts spans in OTLP/HTTP JSON
+func (e *spanExporter) export(spans []*span) {
+	otlpSpans := make([]map[string]interface{}, len(spans))
+	for i, s := range spans {
+		otlpSpan := map[string]interface{}{
+			"traceId":           s.TraceID,
+			"spanId":            s.SpanID,
+			"name":              s.Name,
+			"kind":              s.Kind,
+			"startTimeUnixNano": strconv.FormatInt(s.Start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(s.End.UnixNano(), 10),
+			"attributes":        otlpAttributes(s.Attributes),
+		}
+		if s.ParentSpanID != "" {
+			otlpSpan["parentSpanId"] = s.ParentSpanID
+		}
+		if s.TraceState != "" {
+			otlpSpan["traceState"] = s.TraceState
+		}
+		if s.Error != "" {
+			otlpSpan["status"] = map[string]interface{}{"code": spanStatusError, "message": s.Error}
+		}
+		otlpSpans[i] = otlpSpan
+	}
+	body, _ := json.Marshal(map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{
+				"attributes": otlpAttributes(map[string]interface{}{
+					"service.name":    e.c.ServiceName,
+					"service.version": version,
+				}),
+			},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]interface{}{"name": "dummy-http-responser"},
+				"spans": otlpSpans,
+			}},
+		}},
+	})
+
+	res, err := e.client.Post(e.c.Endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Warnf("fail to export %d spans: %s", len(spans), err.Error())
+		return
+	}
+	res.Body.Close()
+	if res.StatusCode >= 300 {
+		log.Warnf("fail to export %d spans: collector responded %d", len(spans), res.StatusCode)
+	}
+}
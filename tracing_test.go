@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const testTraceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+var _ = Describe("Tracing", func() {
+	var collector *httptest.Server
+	var mu sync.Mutex
+	var received []map[string]interface{}
+	var savedExporter *spanExporter
+	var stop chan struct{}
+	var c tracingConfig
+
+	BeforeEach(func() {
+		received = nil
+		collector = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+			Expect(r.URL.Path).To(Equal("/v1/traces"))
+			Expect(r.Header.Get("Content-Type")).To(Equal("application/json"))
+			body, _ := ioutil.ReadAll(r.Body)
+			var doc map[string]interface{}
+			Expect(json.Unmarshal(body, &doc)).To(Succeed())
+			mu.Lock()
+			received = append(received, doc)
+			mu.Unlock()
+		}))
+
+		c = defaultConfig().Tracing
+		c.Endpoint = collector.URL + "/v1/traces"
+		c.FlushInterval = time.Hour
+		savedExporter = exporter
+		exporter = newSpanExporter(c)
+		stop = make(chan struct{})
+		go exporter.run(stop)
+	})
+
+	AfterEach(func() {
+		close(stop)
+		exporter = savedExporter
+		collector.Close()
+	})
+
+	// spans returns exported spans by name
+	spans := func() map[string]map[string]interface{} {
+		exporter.forceFlush()
+		mu.Lock()
+		defer mu.Unlock()
+		found := map[string]map[string]interface{}{}
+		for _, doc := range received {
+			for _, rs := range doc["resourceSpans"].([]interface{}) {
+				for _, ss := range rs.(map[string]interface{})["scopeSpans"].([]interface{}) {
+					for _, s := range ss.(map[string]interface{})["spans"].([]interface{}) {
+						span := s.(map[string]interface{})
+						found[span["name"].(string)] = span
+					}
+				}
+			}
+		}
+		return found
+	}
+
+	handler := func() http.Handler {
+		return tracing(c, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			observeStore(r.Context(), "find_dummy", func() error { return nil })
+			echoTraceHeaders(w, r)
+			w.WriteHeader(http.StatusOK)
+		}))
+	}
+
+	It("should continue the trace of the client", func() {
+		req := httptest.NewRequest("GET", "/v1/5a0e3b7c9d1e2f3a4b5c6d7e", nil)
+		req.Header.Set("traceparent", testTraceparent)
+		req.Header.Set("tracestate", "vendor=abc")
+		rec := httptest.NewRecorder()
+		handler().ServeHTTP(rec, req)
+
+		Expect(rec.Header().Get("traceparent")).To(Equal(testTraceparent))
+		Expect(rec.Header().Get("tracestate")).To(Equal("vendor=abc"))
+
+		found := spans()
+		Expect(found).To(HaveKey("GET dummy"))
+		Expect(found).To(HaveKey("store find_dummy"))
+		server, store := found["GET dummy"], found["store find_dummy"]
+		Expect(server["traceId"]).To(Equal("4bf92f3577b34da6a3ce929d0e0e4736"))
+		Expect(server["parentSpanId"]).To(Equal("00f067aa0ba902b7"))
+		Expect(server["kind"]).To(Equal(float64(spanKindServer)))
+		Expect(server["traceState"]).To(Equal("vendor=abc"))
+		Expect(store["traceId"]).To(Equal(server["traceId"]))
+		Expect(store["parentSpanId"]).To(Equal(server["spanId"]))
+		Expect(server["attributes"]).To(ContainElement(map[string]interface{}{
+			"key": "http.response.status_code", "value": map[string]interface{}{"intValue": "200"},
+		}))
+	})
+
+	It("should trace rendering of response templates", func() {
+		dummy := dummyModel{ID: bson.NewObjectId()}
+		reqModel := requestModel{SOAP: &soapModel{Operations: []soapOperation{{Response: `<Id>{{xpath "//InvoiceId"}}</Id>`}}}}
+		Expect(dummy.updateWithRequestData(&reqModel)).To(Succeed())
+		handler := tracing(c, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { serveSOAP(w, r, &dummy) }))
+		req := httptest.NewRequest("POST", "/v1/5a0e3b7c9d1e2f3a4b5c6d7e", strings.NewReader(testSOAPRequest))
+		req.Header.Set("traceparent", testTraceparent)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		found := spans()
+		Expect(found).To(HaveKey("render soap response"))
+		Expect(found["render soap response"]["parentSpanId"]).To(Equal(found["POST dummy"]["spanId"]))
+		Expect(found["render soap response"]["attributes"]).To(ContainElement(map[string]interface{}{
+			"key": "soap.element", "value": map[string]interface{}{"stringValue": "GetInvoice"},
+		}))
+	})
+
+	It("should start a trace and send it back", func() {
+		rec := httptest.NewRecorder()
+		handler().ServeHTTP(rec, httptest.NewRequest("GET", "/echo", nil))
+
+		traceID, spanID, sampled, ok := parseTraceparent(rec.Header().Get("traceparent"))
+		Expect(ok).To(BeTrue())
+		Expect(sampled).To(BeTrue())
+		server := spans()["GET echo"]
+		Expect(server["traceId"]).To(Equal(traceID))
+		Expect(server["spanId"]).To(Equal(spanID))
+		Expect(server).NotTo(HaveKey("parentSpanId"))
+	})
+
+	It("should not export traces the client did not sample", func() {
+		req := httptest.NewRequest("GET", "/echo", nil)
+		req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+		handler().ServeHTTP(httptest.NewRecorder(), req)
+		Expect(spans()).To(BeEmpty())
+	})
+
+	It("should reject invalid traceparent", func() {
+		for _, v := range []string{
+			"",
+			"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+			"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+			"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+			"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		} {
+			_, _, _, ok := parseTraceparent(v)
+			Expect(ok).To(BeFalse(), v)
+		}
+		_, _, _, ok := parseTraceparent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future")
+		Expect(ok).To(BeTrue())
+	})
+})
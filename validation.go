@@ -0,0 +1,462 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// validation modes of a project
+const (
+	validationOff     = "off"     // requests are not validated
+	validationEnforce = "enforce" // invalid requests get 400 instead of the dummy
+	validationReport  = "report"  // violations are logged and added to 'Warning' header
+)
+
+var errorValidationMode = &errorResponse{"InvalidData", "validation should be one of off, enforce and report"}
+
+// specViolation is a part of the request which does not conform to the spec
+type specViolation struct {
+	In      string `json:"in"`   // path, query, header or body
+	Name    string `json:"name"` // parameter name or JSON pointer in the body
+	Message string `json:"message"`
+}
+
+func (v specViolation) String() string {
+	if v.Name == "" {
+		return v.In + ": " + v.Message
+	}
+	return v.In + " " + v.Name + ": " + v.Message
+}
+
+// validationErrorResponse is the body for requests violating the spec in enforce mode
+type validationErrorResponse struct {
+	errorResponse
+	Violations []specViolation `json:"violations"`
+	RequestID  string          `json:"request_id,omitempty"`
+}
+
+func isValidationMode(mode string) bool {
+	return mode == "" || mode == validationOff || mode == validationEnforce || mode == validationReport
+}
+
+// parsed specs are cached per project since parsing on every request is expensive
+var specCache = struct {
+	sync.Mutex
+	specs map[bson.ObjectId]cachedSpec
+}{specs: map[bson.ObjectId]cachedSpec{}}
+
+type cachedSpec struct {
+	raw  string
+	spec *apiSpec
+}
+
+// projectSpec returns the parsed spec attached to the project
+func projectSpec(project *projectModel) (*apiSpec, error) {
+	specCache.Lock()
+	cached, ok := specCache.specs[project.ID]
+	specCache.Unlock()
+	if ok && cached.raw == project.Spec {
+		return cached.spec, nil
+	}
+
+	spec, err := parseAPISpec([]byte(project.Spec))
+	if err != nil {
+		return nil, err
+	}
+	specCache.Lock()
+	specCache.specs[project.ID] = cachedSpec{raw: project.Spec, spec: spec}
+	specCache.Unlock()
+	return spec, nil
+}
+
+// evictProjectSpec drops the parsed spec of the project from the cache
+func evictProjectSpec(id bson.ObjectId) {
+	specCache.Lock()
+	delete(specCache.specs, id)
+	specCache.Unlock()
+}
+
+// findOperation returns the operation whose method and path template match the request
+func (s *apiSpec) findOperation(method, path string) *apiOperation {
+	var found *apiOperation
+	bestScore := -1
+	ops := s.operations()
+	for i := range ops {
+		if ops[i].Method != method {
+			continue
+		}
+		score, ok := matchPathTemplate(ops[i].Path, path)
+		if ok && score > bestScore {
+			found = &ops[i]
+			bestScore = score
+		}
+	}
+	return found
+}
+
+// parameters returns parameters of the operation. Operation level parameters
+// override path level ones with the same name and location
+func (s *apiSpec) parameters(op *apiOperation) []map[string]interface{} {
+	var params []map[string]interface{}
+	index := map[string]int{}
+	for _, list := range []interface{}{op.pathItem["parameters"], op.op["parameters"]} {
+		items, _ := list.([]interface{})
+		for _, item := range items {
+			param := s.resolve(item)
+			if param == nil {
+				continue
+			}
+			key := fmt.Sprint(param["in"]) + ":" + fmt.Sprint(param["name"])
+			if i, ok := index[key]; ok {
+				params[i] = param
+				continue
+			}
+			index[key] = len(params)
+			params = append(params, param)
+		}
+	}
+	return params
+}
+
+// validateRequest checks parameters and JSON body of the request.
+// path is the request path relative to the project
+func (s *apiSpec) validateRequest(r *http.Request, path string) []specViolation {
+	op := s.findOperation(r.Method, path)
+	if op == nil {
+		return []specViolation{{In: "path", Message: fmt.Sprintf("%s %s is not defined in the spec", r.Method, path)}}
+	}
+
+	var violations []specViolation
+	pathValues := pathParams(op.Path, path)
+	query := r.URL.Query()
+	for _, param := range s.parameters(op) {
+		name, _ := param["name"].(string)
+		in, _ := param["in"].(string)
+		required, _ := param["required"].(bool)
+
+		var values []string
+		switch in {
+		case "path":
+			if v, ok := pathValues[name]; ok {
+				values = []string{v}
+			}
+		case "query":
+			values = query[name]
+		case "header":
+			values = r.Header[http.CanonicalHeaderKey(name)]
+		case "body":
+			violations = append(violations, s.validateBody(r, param, nil)...)
+			continue
+		default:
+			// cookie and formData parameters are not validated
+			continue
+		}
+
+		if len(values) == 0 {
+			if required || in == "path" {
+				violations = append(violations, specViolation{In: in, Name: name, Message: "is required"})
+			}
+			continue
+		}
+
+		// Swagger 2 parameter is a schema itself
+		schema := param["schema"]
+		if schema == nil {
+			schema = param
+		}
+		for _, violation := range s.validateValue(s.parseParam(values, schema), schema, "", 0) {
+			violation.In = in
+			violation.Name = name + violation.Name
+			violations = append(violations, violation)
+		}
+	}
+
+	if s.version == 3 {
+		if body := s.resolve(op.op["requestBody"]); body != nil {
+			content, _ := body["content"].(map[string]interface{})
+			violations = append(violations, s.validateBody(r, body, content)...)
+		}
+	}
+	return violations
+}
+
+// parseParam converts parameter values into the type of the schema
+func (s *apiSpec) parseParam(values []string, schemaRef interface{}) interface{} {
+	schema := s.resolve(schemaRef)
+	if schema == nil {
+		return values[0]
+	}
+	typ := schemaType(schema)
+	if typ == "array" {
+		if len(values) == 1 {
+			values = strings.Split(values[0], ",")
+		}
+		list := make([]interface{}, len(values))
+		for i, v := range values {
+			list[i] = s.parseParam([]string{v}, schema["items"])
+		}
+		return list
+	}
+	v := values[0]
+	switch typ {
+	case "integer", "number":
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f
+		}
+	case "boolean":
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+	}
+	return v
+}
+
+// validateBody validates the JSON request body. content is media types of OpenAPI 3 request body.
+// For Swagger 2, body is the parameter with the schema and content is nil
+func (s *apiSpec) validateBody(r *http.Request, body map[string]interface{}, content map[string]interface{}) []specViolation {
+	required, _ := body["required"].(bool)
+	// the body is restored for the dummy. e.g. SOAP or JSON-RPC dummies read it again
+	raw := readBody(r)
+	if len(raw) == 0 {
+		if required {
+			return []specViolation{{In: "body", Message: "is required"}}
+		}
+		return nil
+	}
+
+	schema := body["schema"]
+	if content != nil {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		media, ok := content[mediaType]
+		if !ok {
+			return []specViolation{{In: "body", Message: fmt.Sprintf("content type '%s' is not allowed", mediaType)}}
+		}
+		schema = nil
+		if m := s.resolve(media); m != nil {
+			schema = m["schema"]
+		}
+		if !strings.Contains(mediaType, "json") {
+			return nil
+		}
+	}
+	if schema == nil {
+		return nil
+	}
+
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return []specViolation{{In: "body", Message: "invalid JSON: " + err.Error()}}
+	}
+	violations := s.validateValue(v, schema, "", 0)
+	for i := range violations {
+		violations[i].In = "body"
+	}
+	return violations
+}
+
+var (
+	uuidPattern  = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	emailPattern = regexp.MustCompile(`^[^@\s]+@[^@\s]+$`)
+)
+
+// validateValue validates a decoded JSON value against the schema.
+// Name of each violation is the JSON pointer of the value and 'In' is left for the caller
+func (s *apiSpec) validateValue(v interface{}, schemaRef interface{}, at string, depth int) []specViolation {
+	schema := s.resolve(schemaRef)
+	if schema == nil || depth > maxSchemaDepth*4 {
+		return nil
+	}
+	fail := func(format string, args ...interface{}) []specViolation {
+		return []specViolation{{Name: at, Message: fmt.Sprintf(format, args...)}}
+	}
+
+	if v == nil {
+		if nullable, _ := schema["nullable"].(bool); nullable {
+			return nil
+		}
+	}
+
+	var msgs []specViolation
+	if allOf, ok := schema["allOf"].([]interface{}); ok {
+		for _, sub := range allOf {
+			msgs = append(msgs, s.validateValue(v, sub, at, depth+1)...)
+		}
+	}
+	if anyOf, ok := schema["anyOf"].([]interface{}); ok && s.countValid(v, anyOf, at, depth) == 0 {
+		msgs = append(msgs, fail("does not match any schema in anyOf")...)
+	}
+	if oneOf, ok := schema["oneOf"].([]interface{}); ok && s.countValid(v, oneOf, at, depth) != 1 {
+		msgs = append(msgs, fail("does not match exactly one schema in oneOf")...)
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok && !inEnum(v, enum) {
+		return append(msgs, fail("is not one of the allowed values")...)
+	}
+
+	typ := schemaType(schema)
+	switch typ {
+	case "object":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return append(msgs, fail("should be an object")...)
+		}
+		required, _ := schema["required"].([]interface{})
+		for _, name := range required {
+			if _, ok := obj[fmt.Sprint(name)]; !ok {
+				msgs = append(msgs, fail("'%s' is required", name)...)
+			}
+		}
+		props, _ := schema["properties"].(map[string]interface{})
+		for name, val := range obj {
+			pointer := at + "/" + strings.Replace(strings.Replace(name, "~", "~0", -1), "/", "~1", -1)
+			if prop, ok := props[name]; ok {
+				msgs = append(msgs, s.validateValue(val, prop, pointer, depth+1)...)
+				continue
+			}
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					msgs = append(msgs, fail("'%s' is not allowed", name)...)
+				}
+			case map[string]interface{}:
+				msgs = append(msgs, s.validateValue(val, additional, pointer, depth+1)...)
+			}
+		}
+	case "array":
+		list, ok := v.([]interface{})
+		if !ok {
+			return append(msgs, fail("should be an array")...)
+		}
+		if min, ok := toFloat(schema["minItems"]); ok && float64(len(list)) < min {
+			msgs = append(msgs, fail("should have at least %v items", min)...)
+		}
+		if max, ok := toFloat(schema["maxItems"]); ok && float64(len(list)) > max {
+			msgs = append(msgs, fail("should have at most %v items", max)...)
+		}
+		for i, item := range list {
+			msgs = append(msgs, s.validateValue(item, schema["items"], at+"/"+strconv.Itoa(i), depth+1)...)
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return append(msgs, fail("should be a string")...)
+		}
+		length := float64(utf8.RuneCountInString(str))
+		if min, ok := toFloat(schema["minLength"]); ok && length < min {
+			msgs = append(msgs, fail("should be at least %v characters", min)...)
+		}
+		if max, ok := toFloat(schema["maxLength"]); ok && length > max {
+			msgs = append(msgs, fail("should be at most %v characters", max)...)
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			if re := s.patterns[pattern]; re != nil && !re.MatchString(str) {
+				msgs = append(msgs, fail("should match '%s'", pattern)...)
+			}
+		}
+		if format, ok := schema["format"].(string); ok && !validFormat(format, str) {
+			msgs = append(msgs, fail("should be a valid %s", format)...)
+		}
+	case "integer", "number":
+		num, ok := toFloat(v)
+		if !ok && typ == "integer" {
+			return append(msgs, fail("should be an integer")...)
+		} else if !ok {
+			return append(msgs, fail("should be a number")...)
+		}
+		if typ == "integer" && num != float64(int64(num)) {
+			return append(msgs, fail("should be an integer")...)
+		}
+		exclusiveMin, _ := schema["exclusiveMinimum"].(bool)
+		exclusiveMax, _ := schema["exclusiveMaximum"].(bool)
+		if min, ok := toFloat(schema["minimum"]); ok && (num < min || exclusiveMin && num == min) {
+			msgs = append(msgs, fail("should be greater than or equal to %v", min)...)
+		}
+		if max, ok := toFloat(schema["maximum"]); ok && (num > max || exclusiveMax && num == max) {
+			msgs = append(msgs, fail("should be less than or equal to %v", max)...)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return append(msgs, fail("should be a boolean")...)
+		}
+	}
+	return msgs
+}
+
+func (s *apiSpec) countValid(v interface{}, schemas []interface{}, at string, depth int) int {
+	n := 0
+	for _, sub := range schemas {
+		if len(s.validateValue(v, sub, at, depth+1)) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// inEnum compares values in JSON form since numbers from YAML and JSON differ in type
+func inEnum(v interface{}, enum []interface{}) bool {
+	want, _ := json.Marshal(v)
+	for _, e := range enum {
+		if f, ok := toFloat(e); ok {
+			e = f
+		}
+		got, _ := json.Marshal(e)
+		if string(got) == string(want) {
+			return true
+		}
+	}
+	return false
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+func validFormat(format, str string) bool {
+	var err error
+	switch format {
+	case "date-time":
+		_, err = time.Parse(time.RFC3339, str)
+	case "date":
+		_, err = time.Parse("2006-01-02", str)
+	case "email":
+		return emailPattern.MatchString(str)
+	case "uuid":
+		return uuidPattern.MatchString(str)
+	}
+	return err == nil
+}
+
+// pathParams extracts values of template variables from the path.
+// The path must be matched with the template
+func pathParams(template, path string) map[string]string {
+	params := map[string]string{}
+	pathSegs := splitPath(path)
+	for i, seg := range splitPath(template) {
+		if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") && i < len(pathSegs) {
+			params[seg[1:len(seg)-1]] = pathSegs[i]
+		}
+	}
+	return params
+}
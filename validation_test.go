@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/globalsign/mgo/bson"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const testValidationSpec = `
+openapi: 3.0.0
+paths:
+  /pets/{petId}:
+    parameters:
+      - name: petId
+        in: path
+        required: true
+        schema:
+          type: integer
+    get:
+      parameters:
+        - name: fields
+          in: query
+          schema:
+            type: string
+            enum: [name, kind]
+        - name: X-API-KEY
+          in: header
+          required: true
+          schema:
+            type: string
+      responses:
+        '200':
+          description: a pet
+          content:
+            application/json:
+              example: {"id": 1}
+  /pets:
+    post:
+      requestBody:
+        required: true
+        content:
+          application/json:
+            schema:
+              type: object
+              required: [name]
+              additionalProperties: false
+              properties:
+                name:
+                  type: string
+                  minLength: 1
+                  pattern: '^[a-z ]+$'
+                tags:
+                  type: array
+                  items:
+                    type: string
+      responses:
+        '201':
+          description: created
+`
+
+var _ = Describe("Spec validation", func() {
+	var spec *apiSpec
+
+	BeforeEach(func() {
+		var err error
+		spec, err = parseAPISpec([]byte(testValidationSpec))
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	Context("with parameters", func() {
+		It("should pass a valid request", func() {
+			req, _ := http.NewRequest("GET", "/pets/3?fields=name", nil)
+			req.Header.Set("X-API-KEY", "secret")
+			Expect(spec.validateRequest(req, "/pets/3")).To(BeEmpty())
+		})
+
+		It("should report invalid parameters", func() {
+			req, _ := http.NewRequest("GET", "/pets/three?fields=owner", nil)
+			violations := spec.validateRequest(req, "/pets/three")
+			Expect(violations).To(ConsistOf(
+				specViolation{In: "path", Name: "petId", Message: "should be an integer"},
+				specViolation{In: "query", Name: "fields", Message: "is not one of the allowed values"},
+				specViolation{In: "header", Name: "X-API-KEY", Message: "is required"},
+			))
+		})
+
+		It("should report an undefined operation", func() {
+			req, _ := http.NewRequest("DELETE", "/pets/3", nil)
+			Expect(spec.validateRequest(req, "/pets/3")).To(HaveLen(1))
+		})
+	})
+
+	Context("with JSON body", func() {
+		It("should report schema violations", func() {
+			req, _ := http.NewRequest("POST", "/pets", bytes.NewBufferString(`{"tags": ["a", 1], "age": 3}`))
+			req.Header.Set("Content-Type", "application/json")
+			violations := spec.validateRequest(req, "/pets")
+			Expect(violations).To(ConsistOf(
+				specViolation{In: "body", Name: "", Message: "'name' is required"},
+				specViolation{In: "body", Name: "/tags/1", Message: "should be a string"},
+				specViolation{In: "body", Name: "", Message: "'age' is not allowed"},
+			))
+		})
+
+		It("should report strings not matching the pattern", func() {
+			req, _ := http.NewRequest("POST", "/pets", bytes.NewBufferString(`{"name": "Tom"}`))
+			req.Header.Set("Content-Type", "application/json")
+			Expect(spec.validateRequest(req, "/pets")).To(ConsistOf(
+				specViolation{In: "body", Name: "/name", Message: "should match '^[a-z ]+$'"},
+			))
+			Expect(spec.patterns).To(HaveKey("^[a-z ]+$"))
+		})
+
+		It("should report a missing body", func() {
+			req, _ := http.NewRequest("POST", "/pets", nil)
+			Expect(spec.validateRequest(req, "/pets")).To(ConsistOf(
+				specViolation{In: "body", Message: "is required"},
+			))
+		})
+	})
+
+	Context("with project in report mode", func() {
+		It("should leave the body for dummies which read it", func() {
+			project := projectModel{ID: bson.NewObjectId(), Spec: testValidationSpec, Validation: validationReport}
+			req, _ := http.NewRequest("POST", "/mock/"+project.ID.Hex()+"/pets", bytes.NewBufferString(`{"jsonrpc": "2.0", "method": "add", "id": 1}`))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			Expect(validateProjectRequest(w, req, &project, "/pets")).To(BeTrue())
+			Expect(w.Header()["Warning"]).NotTo(BeEmpty())
+
+			dummy := dummyModel{ID: bson.NewObjectId(), JSONRPC: `{"methods": [{"method": "add", "result": 3}]}`}
+			serveJSONRPC(w, req, &dummy)
+			Expect(w.Body.String()).To(MatchJSON(`{"jsonrpc": "2.0", "result": 3, "id": 1}`))
+		})
+	})
+
+	Context("with cached project", func() {
+		It("should drop the parsed spec and GraphQL configs on eviction", func() {
+			project := projectModel{ID: bson.NewObjectId(), Spec: testValidationSpec}
+			cached, err := projectSpec(&project)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(projectSpec(&project)).To(BeIdenticalTo(cached))
+
+			dummy := dummyModel{ID: bson.NewObjectId(), Project: project.ID, GraphQL: `{"schema": "type Query { a: Int }"}`}
+			other := dummyModel{ID: bson.NewObjectId(), GraphQL: dummy.GraphQL}
+			_, err = dummyGraphQL(&dummy)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = dummyGraphQL(&other)
+			Expect(err).NotTo(HaveOccurred())
+
+			evictProjectCaches(project.ID)
+			Expect(specCache.specs).NotTo(HaveKey(project.ID))
+			Expect(graphQLCache.dummies).NotTo(HaveKey(dummy.ID))
+			Expect(graphQLCache.dummies).To(HaveKey(other.ID))
+			Expect(projectSpec(&project)).NotTo(BeIdenticalTo(cached))
+		})
+	})
+
+	Context("with project in enforce mode", func() {
+		It("should response 400 with violations", func() {
+			project := projectModel{
+				ID:         bson.NewObjectId(),
+				Name:       "pet store",
+				Spec:       testValidationSpec,
+				Validation: validationEnforce,
+			}
+			if err := testDB.C(collectionProject).Insert(&project); err != nil {
+				panic(err.Error())
+			}
+
+			req, _ := http.NewRequest("GET", "/mock/"+project.ID.Hex()+"/pets/three", nil)
+			w := httptest.NewRecorder()
+			createRoute().ServeHTTP(w, req)
+			Expect(w.Code).To(Equal(http.StatusBadRequest))
+
+			var resp validationErrorResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				panic(err.Error())
+			}
+			Expect(resp.ErrorType).To(Equal("ValidationFailed"))
+			Expect(resp.Violations).To(HaveLen(2))
+
+			// should delete test data
+			testDB.C(collectionProject).RemoveId(project.ID)
+		})
+	})
+})
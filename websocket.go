@@ -0,0 +1,537 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// WebSocket opcodes of RFC 6455
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+)
+
+// WebSocket close codes
+const (
+	wsCloseNormal      = 1000
+	wsCloseProtocol    = 1002
+	wsCloseTooBig      = 1009
+	wsCloseWaitTimeout = time.Second // how long the client's close frame is waited for
+)
+
+// wsMaxMessageBytes caps incoming messages even if the body size is unlimited
+const wsMaxMessageBytes = math.MaxInt32
+
+// wsGUID is appended to Sec-WebSocket-Key to compute Sec-WebSocket-Accept
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+var (
+	errorUpgradeRequired   = &errorResponse{"UpgradeRequired", "The dummy is a WebSocket endpoint. Connect with a WebSocket client"}
+	errorWebSocketProtocol = errors.New("WebSocket protocol error")
+	errorWebSocketTooBig   = errors.New("WebSocket message is too big")
+)
+
+// webSocketModel is a scripted WebSocket conversation of a dummy
+//
+//	websocket:
+//	  on_connect: [{text: '{"type": "welcome"}'}]
+//	  replies:
+//	    - matchers: [{in: body, op: json, value: '{"type": "ping"}'}]
+//	      messages: [{text: '{"type": "pong"}', delay: 100}]
+//	  pushes: [{interval: 5000, message: {text: '{"type": "tick"}'}}]
+//	  close_after: 10
+//	  close_code: 4000
+type webSocketModel struct {
+	OnConnect   []webSocketMessage `json:"on_connect"`   // sent after the handshake
+	Replies     []webSocketReply   `json:"replies"`      // the first reply whose matchers match an incoming message is sent
+	Pushes      []webSocketPush    `json:"pushes"`       // sent periodically
+	Echo        bool               `json:"echo"`         // send incoming messages back if no reply matches
+	CloseAfter  int                `json:"close_after"`  // close after sending this many messages. 0 means no limit
+	Timeout     int                `json:"timeout"`      // milliseconds to close after the handshake. 0 means no limit
+	CloseCode   int                `json:"close_code"`   // close code sent on close_after or timeout. 1000 if not set
+	CloseReason string             `json:"close_reason"` // close reason sent with the code
+}
+
+// webSocketMessage is a message to send. Binary is base64 encoded
+type webSocketMessage struct {
+	Text   string `json:"text,omitempty"`
+	Binary string `json:"binary,omitempty"`
+	Delay  int    `json:"delay,omitempty"` // milliseconds to wait before sending
+}
+
+// webSocketReply answers incoming messages. Matchers are on the message as a body
+type webSocketReply struct {
+	Matchers []matcherModel     `json:"matchers"`
+	Messages []webSocketMessage `json:"messages"`
+}
+
+// webSocketPush sends the message every interval
+type webSocketPush struct {
+	Interval int              `json:"interval"` // milliseconds
+	Message  webSocketMessage `json:"message"`
+}
+
+// validate webSocketModel. do not trust any input
+func (m *webSocketModel) validate() error {
+	for i := range m.OnConnect {
+		if err := m.OnConnect[i].validate(); err != nil {
+			return fmt.Errorf("on_connect[%d]: %s", i, err.Error())
+		}
+	}
+	for i, reply := range m.Replies {
+		for j := range reply.Matchers {
+			if reply.Matchers[j].In != "body" {
+				return fmt.Errorf("replies[%d]: matchers should be in body", i)
+			}
+			if err := reply.Matchers[j].validate(); err != nil {
+				return fmt.Errorf("replies[%d]: %s", i, err.Error())
+			}
+		}
+		for j := range reply.Messages {
+			if err := reply.Messages[j].validate(); err != nil {
+				return fmt.Errorf("replies[%d].messages[%d]: %s", i, j, err.Error())
+			}
+		}
+	}
+	for i := range m.Pushes {
+		if m.Pushes[i].Interval <= 0 {
+			return fmt.Errorf("pushes[%d]: interval should be positive", i)
+		}
+		if err := m.Pushes[i].Message.validate(); err != nil {
+			return fmt.Errorf("pushes[%d]: %s", i, err.Error())
+		}
+	}
+	if m.CloseAfter < 0 || m.Timeout < 0 {
+		return errors.New("close_after and timeout should not be negative")
+	}
+	if m.CloseCode != 0 && !isSendableCloseCode(m.CloseCode) {
+		return fmt.Errorf("close code %d can not be sent", m.CloseCode)
+	}
+	if len(m.CloseReason) > 123 {
+		return errors.New("close reason should be 123 bytes or less")
+	}
+	return nil
+}
+
+func (m *webSocketMessage) validate() error {
+	if m.Text != "" && m.Binary != "" {
+		return errors.New("set either text or binary")
+	}
+	if _, err := base64.StdEncoding.DecodeString(m.Binary); err != nil {
+		return errors.New("binary should be base64 encoded")
+	}
+	if m.Delay < 0 {
+		return errors.New("delay should not be negative")
+	}
+	return nil
+}
+
+// frame returns the opcode and the payload of the message
+func (m *webSocketMessage) frame() (byte, []byte) {
+	if m.Binary != "" {
+		payload, _ := base64.StdEncoding.DecodeString(m.Binary)
+		return wsBinary, payload
+	}
+	return wsText, []byte(m.Text)
+}
+
+// isSendableCloseCode checks the code is defined for endpoints to send, or is for applications
+func isSendableCloseCode(code int) bool {
+	return code >= 1000 && code <= 1003 || code >= 1007 && code <= 1014 || code >= 3000 && code <= 4999
+}
+
+// wsConn reads and writes WebSocket frames on a hijacked connection
+type wsConn struct {
+	conn    net.Conn
+	br      *bufio.Reader
+	maxSize int64      // max size of incoming messages
+	mu      sync.Mutex // serializes writes
+}
+
+// headerHas checks a comma separated header has the token regardless of case
+func headerHas(h http.Header, name, token string) bool {
+	for _, v := range h[http.CanonicalHeaderKey(name)] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// upgradeWebSocket completes the opening handshake. Headers set on w are sent with 101.
+// It writes an error response and returns nil if the request is not a valid handshake
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) *wsConn {
+	writeError := func(status int, e *errorResponse) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(requestError(r, e))
+	}
+	if r.Method != http.MethodGet || !headerHas(r.Header, "Connection", "upgrade") || !headerHas(r.Header, "Upgrade", "websocket") {
+		w.Header().Set("Upgrade", "websocket")
+		writeError(http.StatusUpgradeRequired, errorUpgradeRequired)
+		return nil
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		writeError(http.StatusBadRequest, &errorResponse{"InvalidHandshake", "Sec-WebSocket-Version should be 13"})
+		return nil
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if nonce, err := base64.StdEncoding.DecodeString(key); err != nil || len(nonce) != 16 {
+		writeError(http.StatusBadRequest, &errorResponse{"InvalidHandshake", "Sec-WebSocket-Key is invalid"})
+		return nil
+	}
+
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		// HTTP/2 connections can not be taken over
+		writeError(http.StatusHTTPVersionNotSupported, &errorResponse{"HTTPVersionNotSupported", "WebSocket is served over HTTP/1.1"})
+		return nil
+	}
+	// deadlines of the server are for HTTP requests, not for long lived connections
+	conn.SetDeadline(time.Time{})
+
+	sum := sha1.Sum([]byte(key + wsGUID))
+	w.Header().Del("Content-Type")
+	w.Header().Del("Content-Length")
+	w.Header().Set("Upgrade", "websocket")
+	w.Header().Set("Connection", "Upgrade")
+	w.Header().Set("Sec-WebSocket-Accept", base64.StdEncoding.EncodeToString(sum[:]))
+	brw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	w.Header().Write(brw)
+	brw.WriteString("\r\n")
+	if err := brw.Flush(); err != nil {
+		conn.Close()
+		return nil
+	}
+	maxSize := cfg.Limits.MaxBodyBytes
+	if maxSize <= 0 || maxSize > wsMaxMessageBytes {
+		maxSize = wsMaxMessageBytes
+	}
+	return &wsConn{conn: conn, br: brw.Reader, maxSize: maxSize}
+}
+
+// writeFrame writes an unmasked final frame
+func (c *wsConn) writeFrame(opcode byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	frame := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, byte(n))
+	case n <= 0xffff:
+		frame = append(frame, 126, byte(n>>8), byte(n))
+	default:
+		frame = append(frame, 127, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.BigEndian.PutUint64(frame[2:], uint64(n))
+	}
+	_, err := c.conn.Write(append(frame, payload...))
+	return err
+}
+
+// writeClose writes a close frame with the code and the reason
+func (c *wsConn) writeClose(code int, reason string) error {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	return c.writeFrame(wsClose, append(payload, reason...))
+}
+
+// readFrame reads a frame from the client and unmasks it
+func (c *wsConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(c.br, head[:]); err != nil {
+		return
+	}
+	fin, opcode = head[0]&0x80 != 0, head[0]&0x0f
+	if head[0]&0x70 != 0 || head[1]&0x80 == 0 {
+		// no extension is negotiated, and clients have to mask frames
+		return false, 0, nil, errorWebSocketProtocol
+	}
+	size := uint64(head[1] & 0x7f)
+	switch size {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		size = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		size = binary.BigEndian.Uint64(ext[:])
+	}
+	if opcode >= wsClose && (size > 125 || !fin) {
+		return false, 0, nil, errorWebSocketProtocol
+	}
+	if size > uint64(c.maxSize) {
+		return false, 0, nil, errorWebSocketTooBig
+	}
+	var mask [4]byte
+	if _, err = io.ReadFull(c.br, mask[:]); err != nil {
+		return
+	}
+	// the buffer grows as the payload arrives rather than by the declared size
+	var buf bytes.Buffer
+	if _, err = io.CopyN(&buf, c.br, int64(size)); err != nil {
+		return
+	}
+	payload = buf.Bytes()
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// readMessage reads a text or binary message joining fragments. Pings are answered on the way.
+// A close frame is returned as a message of wsClose
+func (c *wsConn) readMessage() (byte, []byte, error) {
+	var opcode byte
+	var message []byte
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch op {
+		case wsPing:
+			if err := c.writeFrame(wsPong, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			return wsClose, payload, nil
+		case wsContinuation:
+			if opcode == 0 {
+				return 0, nil, errorWebSocketProtocol
+			}
+		case wsText, wsBinary:
+			if opcode != 0 {
+				return 0, nil, errorWebSocketProtocol
+			}
+			opcode = op
+		default:
+			return 0, nil, errorWebSocketProtocol
+		}
+		message = append(message, payload...)
+		if int64(len(message)) > c.maxSize {
+			return 0, nil, errorWebSocketTooBig
+		}
+		if fin {
+			return opcode, message, nil
+		}
+	}
+}
+
+// wsIncoming is a message from the client
+type wsIncoming struct {
+	opcode  byte
+	payload []byte
+}
+
+// runWebSocket plays the script on the connection until the script closes it or the client goes away
+func runWebSocket(c *wsConn, r *http.Request, script *webSocketModel) {
+	defer c.conn.Close()
+
+	stop := make(chan struct{}) // closed when the script is over
+	defer close(stop)
+	incoming := make(chan wsIncoming)
+	gone := make(chan struct{}) // closed when the client closes or the connection breaks
+	var clientClose []byte      // payload of the client's close frame
+	var readErr error
+	go func() {
+		defer close(gone)
+		for {
+			opcode, payload, err := c.readMessage()
+			if err != nil {
+				readErr = err
+				return
+			}
+			if opcode == wsClose {
+				clientClose = payload
+				return
+			}
+			select {
+			case incoming <- wsIncoming{opcode, payload}:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	pushes := make(chan *webSocketMessage)
+	for i := range script.Pushes {
+		go func(push *webSocketPush) {
+			ticker := time.NewTicker(time.Duration(push.Interval) * time.Millisecond)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					select {
+					case pushes <- &push.Message:
+					case <-stop:
+						return
+					}
+				case <-stop:
+					return
+				}
+			}
+		}(&script.Pushes[i])
+	}
+
+	var timeout <-chan time.Time
+	if script.Timeout > 0 {
+		timer := time.NewTimer(time.Duration(script.Timeout) * time.Millisecond)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	sent := 0
+	// send returns false when the conversation is over
+	send := func(m *webSocketMessage) bool {
+		if m.Delay > 0 {
+			timer := time.NewTimer(time.Duration(m.Delay) * time.Millisecond)
+			defer timer.Stop()
+			select {
+			case <-timer.C:
+			case <-gone:
+				return false
+			}
+		}
+		opcode, payload := m.frame()
+		if c.writeFrame(opcode, payload) != nil {
+			return false
+		}
+		sent++
+		return script.CloseAfter == 0 || sent < script.CloseAfter
+	}
+	closeBy := func(code int, reason string) {
+		c.writeClose(code, reason)
+		select {
+		case <-gone:
+		case <-time.After(wsCloseWaitTimeout):
+		}
+	}
+	closeByScript := func() {
+		code := script.CloseCode
+		if code == 0 {
+			code = wsCloseNormal
+		}
+		closeBy(code, script.CloseReason)
+	}
+
+	for i := range script.OnConnect {
+		if !send(&script.OnConnect[i]) {
+			closeByScript()
+			return
+		}
+	}
+	for {
+		select {
+		case in := <-incoming:
+			reply := matchWebSocketReply(script.Replies, r, in.payload)
+			if reply == nil && script.Echo {
+				reply = &webSocketReply{Messages: []webSocketMessage{{Text: string(in.payload)}}}
+				if in.opcode == wsBinary {
+					reply.Messages[0] = webSocketMessage{Binary: base64.StdEncoding.EncodeToString(in.payload)}
+				}
+			}
+			if reply == nil {
+				continue
+			}
+			for i := range reply.Messages {
+				if !send(&reply.Messages[i]) {
+					closeByScript()
+					return
+				}
+			}
+		case m := <-pushes:
+			if !send(m) {
+				closeByScript()
+				return
+			}
+		case <-timeout:
+			closeByScript()
+			return
+		case <-gone:
+			switch {
+			case readErr == errorWebSocketTooBig:
+				c.writeClose(wsCloseTooBig, "")
+			case readErr == errorWebSocketProtocol:
+				c.writeClose(wsCloseProtocol, "")
+			case readErr == nil && len(clientClose) >= 2:
+				// echo the code of the client to complete the closing handshake
+				c.writeFrame(wsClose, clientClose[:2])
+			case readErr == nil:
+				c.writeFrame(wsClose, nil)
+			}
+			return
+		}
+	}
+}
+
+// matchWebSocketReply returns the first reply whose matchers match the message
+func matchWebSocketReply(replies []webSocketReply, r *http.Request, message []byte) *webSocketReply {
+	for i := range replies {
+		matched := true
+		for j := range replies[i].Matchers {
+			if !replies[i].Matchers[j].match(r, "", message) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return &replies[i]
+		}
+	}
+	return nil
+}
+
+// serveWebSocket serves a WebSocket dummy. Headers of the dummy are sent with the handshake
+func serveWebSocket(w http.ResponseWriter, r *http.Request, dummyOne *dummyModel) {
+	if err := setDummyHeaders(w, dummyOne); err != nil {
+		requestLog(r).Errorf("JSON marshal error: %s", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	c := upgradeWebSocket(w, r)
+	if c == nil {
+		return
+	}
+	countDummyHit(dummyOne)
+	runWebSocket(c, r, dummyOne.WebSocket)
+}
+
+// handler for GET /echo/ws
+// It sends every message back
+func handleEchoWebSocket(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	c := upgradeWebSocket(w, r)
+	if c == nil {
+		return
+	}
+	runWebSocket(c, r, &webSocketModel{Echo: true})
+}
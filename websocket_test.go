@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// wsTestClient is a minimal WebSocket client which masks frames as browsers do
+type wsTestClient struct {
+	conn net.Conn
+	br   *bufio.Reader
+}
+
+func dialWebSocket(addr, path string) (*wsTestClient, *http.Response) {
+	conn, err := net.Dial("tcp", addr)
+	Expect(err).NotTo(HaveOccurred())
+	req, _ := http.NewRequest("GET", "http://"+addr+path, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	Expect(req.Write(conn)).To(Succeed())
+	br := bufio.NewReader(conn)
+	res, err := http.ReadResponse(br, req)
+	Expect(err).NotTo(HaveOccurred())
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return &wsTestClient{conn, br}, res
+}
+
+func (c *wsTestClient) send(opcode byte, payload string) {
+	mask := []byte{1, 2, 3, 4}
+	frame := []byte{0x80 | opcode, 0x80 | byte(len(payload))}
+	frame = append(frame, mask...)
+	for i := 0; i < len(payload); i++ {
+		frame = append(frame, payload[i]^mask[i%4])
+	}
+	_, err := c.conn.Write(frame)
+	Expect(err).NotTo(HaveOccurred())
+}
+
+func (c *wsTestClient) read() (byte, string) {
+	var head [2]byte
+	_, err := io.ReadFull(c.br, head[:])
+	Expect(err).NotTo(HaveOccurred())
+	Expect(head[1]&0x80).To(BeZero(), "server frames should not be masked")
+	payload := make([]byte, head[1]&0x7f)
+	_, err = io.ReadFull(c.br, payload)
+	Expect(err).NotTo(HaveOccurred())
+	return head[0] & 0x0f, string(payload)
+}
+
+func (c *wsTestClient) readClose() int {
+	opcode, payload := c.read()
+	Expect(opcode).To(Equal(byte(wsClose)))
+	return int(binary.BigEndian.Uint16([]byte(payload)))
+}
+
+var _ = Describe("WebSocket", func() {
+	var server *httptest.Server
+	var dummy dummyModel
+
+	BeforeEach(func() {
+		dummy = dummyModel{Headers: `{"X-Mock":"chat"}`}
+		server = httptest.NewServer(accessLog(cfg.AccessLog, instrument(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/echo/ws" {
+				createRoute().ServeHTTP(w, r)
+				return
+			}
+			serveWebSocket(w, r, &dummy)
+		}))))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	addr := func() string {
+		return strings.TrimPrefix(server.URL, "http://")
+	}
+
+	It("should echo messages on /echo/ws", func() {
+		c, res := dialWebSocket(addr(), "/echo/ws")
+		defer c.conn.Close()
+		Expect(res.StatusCode).To(Equal(http.StatusSwitchingProtocols))
+		Expect(res.Header.Get("Sec-WebSocket-Accept")).To(Equal("s3pPLMBiTxaQ9kYGzzhZRbK+xOo="))
+		Expect(res.Header.Get("X-Request-ID")).NotTo(BeEmpty())
+
+		c.send(wsText, "hello")
+		opcode, payload := c.read()
+		Expect(opcode).To(Equal(byte(wsText)))
+		Expect(payload).To(Equal("hello"))
+		c.send(wsBinary, "\x00\x01")
+		opcode, payload = c.read()
+		Expect(opcode).To(Equal(byte(wsBinary)))
+		Expect(payload).To(Equal("\x00\x01"))
+
+		c.send(wsPing, "beat")
+		opcode, payload = c.read()
+		Expect(opcode).To(Equal(byte(wsPong)))
+		Expect(payload).To(Equal("beat"))
+
+		c.send(wsClose, "\x03\xe8")
+		Expect(c.readClose()).To(Equal(1000))
+	})
+
+	It("should refuse frames over the hard cap when bodies are unlimited", func() {
+		saved := cfg.Limits.MaxBodyBytes
+		cfg.Limits.MaxBodyBytes = 0
+		defer func() { cfg.Limits.MaxBodyBytes = saved }()
+		c, _ := dialWebSocket(addr(), "/echo/ws")
+		defer c.conn.Close()
+
+		frame := []byte{0x80 | wsBinary, 0x80 | 127, 0x80, 0, 0, 0, 0, 0, 0, 0, 1, 2, 3, 4}
+		_, err := c.conn.Write(frame)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(c.readClose()).To(Equal(wsCloseTooBig))
+	})
+
+	It("should play the script of the dummy", func() {
+		dummy.WebSocket = &webSocketModel{
+			OnConnect: []webSocketMessage{{Text: `{"type":"welcome"}`}},
+			Replies: []webSocketReply{
+				{Matchers: []matcherModel{{In: "body", Op: matchJSON, Value: `{"type":"ping"}`}}, Messages: []webSocketMessage{{Text: "pong", Delay: 10}}},
+				{Matchers: []matcherModel{{In: "body", Op: matchContains, Value: "bye"}}, Messages: []webSocketMessage{{Text: "see you"}}},
+			},
+			CloseAfter:  3,
+			CloseCode:   4000,
+			CloseReason: "done",
+		}
+		c, res := dialWebSocket(addr(), "/chat")
+		defer c.conn.Close()
+		Expect(res.StatusCode).To(Equal(http.StatusSwitchingProtocols))
+		Expect(res.Header.Get("X-Mock")).To(Equal("chat"))
+
+		_, payload := c.read()
+		Expect(payload).To(Equal(`{"type":"welcome"}`))
+		c.send(wsText, "not scripted")
+		c.send(wsText, `{ "type": "ping" }`)
+		_, payload = c.read()
+		Expect(payload).To(Equal("pong"))
+		c.send(wsText, "bye")
+		_, payload = c.read()
+		Expect(payload).To(Equal("see you"))
+
+		opcode, payload := c.read()
+		Expect(opcode).To(Equal(byte(wsClose)))
+		Expect(binary.BigEndian.Uint16([]byte(payload))).To(Equal(uint16(4000)))
+		Expect(payload[2:]).To(Equal("done"))
+	})
+
+	It("should push messages until the timeout", func() {
+		dummy.WebSocket = &webSocketModel{
+			Pushes:  []webSocketPush{{Interval: 20, Message: webSocketMessage{Text: "tick"}}},
+			Timeout: 70,
+		}
+		c, _ := dialWebSocket(addr(), "/feed")
+		defer c.conn.Close()
+
+		ticks := 0
+		for {
+			opcode, payload := c.read()
+			if opcode == wsClose {
+				Expect(binary.BigEndian.Uint16([]byte(payload))).To(Equal(uint16(1000)))
+				break
+			}
+			Expect(payload).To(Equal("tick"))
+			ticks++
+		}
+		Expect(ticks).To(BeNumerically(">=", 2))
+	})
+
+	It("should ask plain requests to upgrade", func() {
+		dummy.WebSocket = &webSocketModel{Echo: true}
+		res, err := http.Get(server.URL + "/chat")
+		Expect(err).NotTo(HaveOccurred())
+		res.Body.Close()
+		Expect(res.StatusCode).To(Equal(http.StatusUpgradeRequired))
+		Expect(res.Header.Get("Upgrade")).To(Equal("websocket"))
+	})
+
+	It("should validate scripts", func() {
+		Expect((&webSocketModel{Echo: true}).validate()).To(Succeed())
+		Expect((&webSocketModel{CloseCode: 1006}).validate()).NotTo(Succeed())
+		Expect((&webSocketModel{Pushes: []webSocketPush{{Interval: 0}}}).validate()).NotTo(Succeed())
+		Expect((&webSocketModel{OnConnect: []webSocketMessage{{Binary: "not base64!"}}}).validate()).NotTo(Succeed())
+		Expect((&webSocketModel{Replies: []webSocketReply{{Matchers: []matcherModel{{In: "header", Name: "X", Op: matchPresent}}}}}).validate()).NotTo(Succeed())
+	})
+})
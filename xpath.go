@@ -0,0 +1,454 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// A subset of XPath 1.0 is evaluated on XML bodies. Location paths with /, //, ., .., *, @attr, text()
+// and predicates like [2], [@id='1'] or [Name="x"] are supported.
+// A prefix is looked up in the namespaces declared in the document, and a name without a prefix
+// matches elements of any namespace, so that expressions do not depend on how a client names prefixes
+
+// maxXMLDepth limits nesting of elements in parsed documents
+const maxXMLDepth = 256
+
+// xmlNode is an element. The root of a document is a node above the document element
+type xmlNode struct {
+	name     xml.Name // Space is the namespace URI
+	attrs    []xml.Attr
+	children []*xmlNode
+	parent   *xmlNode
+	texts    []xmlText // character data directly in the element
+}
+
+// xmlText is character data which comes before the child at the index
+type xmlText struct {
+	data   string
+	before int
+}
+
+// text is the character data directly in the element
+func (n *xmlNode) text() string {
+	var b strings.Builder
+	for _, t := range n.texts {
+		b.WriteString(t.data)
+	}
+	return b.String()
+}
+
+// value is the string value of the element, which is character data of it and its descendants in order.
+// It is computed when it is needed, as storing it on every ancestor is quadratic
+func (n *xmlNode) value() string {
+	var b strings.Builder
+	n.writeValue(&b)
+	return b.String()
+}
+
+func (n *xmlNode) writeValue(b *strings.Builder) {
+	texts := n.texts
+	for i, child := range n.children {
+		for len(texts) > 0 && texts[0].before <= i {
+			b.WriteString(texts[0].data)
+			texts = texts[1:]
+		}
+		child.writeValue(b)
+	}
+	for _, t := range texts {
+		b.WriteString(t.data)
+	}
+}
+
+type xmlDocument struct {
+	root       *xmlNode
+	namespaces map[string]string // prefixes declared in the document. the first declaration wins
+}
+
+// parseXMLDocument parses a document. Entities other than the predefined ones are not expanded
+func parseXMLDocument(body []byte) (*xmlDocument, error) {
+	dec := xml.NewDecoder(bytes.NewReader(body))
+	doc := &xmlDocument{root: &xmlNode{}, namespaces: map[string]string{}}
+	current := doc.root
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if current == doc.root && len(doc.root.children) > 0 {
+				return nil, errors.New("more than one document element")
+			}
+			if depth++; depth > maxXMLDepth {
+				return nil, fmt.Errorf("elements are nested deeper than %d", maxXMLDepth)
+			}
+			node := &xmlNode{name: t.Name, parent: current}
+			for _, a := range t.Attr {
+				switch {
+				case a.Name.Space == "xmlns":
+					if _, ok := doc.namespaces[a.Name.Local]; !ok {
+						doc.namespaces[a.Name.Local] = a.Value
+					}
+				case a.Name.Space == "" && a.Name.Local == "xmlns":
+				default:
+					node.attrs = append(node.attrs, a)
+				}
+			}
+			current.children = append(current.children, node)
+			current = node
+		case xml.EndElement:
+			current = current.parent
+			depth--
+		case xml.CharData:
+			if current == doc.root {
+				continue
+			}
+			current.texts = append(current.texts, xmlText{data: string(t), before: len(current.children)})
+		}
+	}
+	if len(doc.root.children) == 0 {
+		return nil, errors.New("no document element")
+	}
+	return doc, nil
+}
+
+// documentElement is the top element of the document
+func (doc *xmlDocument) documentElement() *xmlNode {
+	return doc.root.children[0]
+}
+
+// matchName checks a name test of a step. prefix is empty for any namespace
+func (doc *xmlDocument) matchName(name xml.Name, prefix, local string) bool {
+	if local != "*" && name.Local != local {
+		return false
+	}
+	if prefix == "" {
+		return true
+	}
+	space, ok := doc.namespaces[prefix]
+	return ok && name.Space == space
+}
+
+type xpathExpr struct {
+	absolute bool
+	steps    []xpathStep
+}
+
+type xpathStep struct {
+	descendant bool // after //
+	self       bool // .
+	parent     bool // ..
+	attribute  bool // @name
+	text       bool // text()
+	prefix     string
+	local      string
+	predicates []xpathPredicate
+}
+
+// xpathPredicate filters by the position, or by the existence or the value of a relative path
+type xpathPredicate struct {
+	position int
+	path     *xpathExpr
+	op       string // =, != or empty for existence
+	value    string
+}
+
+// xpathItem is an element, or the value of an attribute or text when node is nil
+type xpathItem struct {
+	node  *xmlNode
+	value string
+}
+
+// stringValue is the value of the item. That of an element is computed here
+func (item xpathItem) stringValue() string {
+	if item.node != nil {
+		return item.node.value()
+	}
+	return item.value
+}
+
+// parseXPath parses an expression of the supported subset
+func parseXPath(s string) (*xpathExpr, error) {
+	p := &xpathParser{s: strings.TrimSpace(s)}
+	if p.s == "" {
+		return nil, errors.New("empty XPath")
+	}
+	e, err := p.path()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.s) {
+		return nil, fmt.Errorf("unexpected '%s' at %d", p.s[p.pos:], p.pos)
+	}
+	return e, nil
+}
+
+type xpathParser struct {
+	s   string
+	pos int
+}
+
+func (p *xpathParser) peek(prefix string) bool {
+	return strings.HasPrefix(p.s[p.pos:], prefix)
+}
+
+func (p *xpathParser) skipSpace() {
+	for p.pos < len(p.s) && p.s[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+func (p *xpathParser) path() (*xpathExpr, error) {
+	e := &xpathExpr{}
+	descendant := false
+	switch {
+	case p.peek("//"):
+		p.pos += 2
+		e.absolute, descendant = true, true
+	case p.peek("/"):
+		p.pos++
+		e.absolute = true
+		if p.pos == len(p.s) || strings.ContainsRune("]=! ", rune(p.s[p.pos])) {
+			return e, nil
+		}
+	}
+	for {
+		step, err := p.step(descendant)
+		if err != nil {
+			return nil, err
+		}
+		e.steps = append(e.steps, step)
+		if p.peek("//") {
+			p.pos += 2
+			descendant = true
+		} else if p.peek("/") {
+			p.pos++
+			descendant = false
+		} else {
+			break
+		}
+		if step.attribute || step.text {
+			return nil, fmt.Errorf("nothing can follow an attribute or text() at %d", p.pos)
+		}
+	}
+	return e, nil
+}
+
+func (p *xpathParser) step(descendant bool) (xpathStep, error) {
+	step := xpathStep{descendant: descendant}
+	switch {
+	case p.peek(".."):
+		p.pos += 2
+		step.parent = true
+	case p.peek("."):
+		p.pos++
+		step.self = true
+	case p.peek("text()"):
+		p.pos += len("text()")
+		step.text = true
+	default:
+		if p.peek("@") {
+			p.pos++
+			step.attribute = true
+		}
+		start := p.pos
+		for _, c := range p.s[p.pos:] {
+			if !unicode.IsLetter(c) && !unicode.IsDigit(c) && !strings.ContainsRune("_-.:*", c) {
+				break
+			}
+			p.pos += len(string(c))
+		}
+		name := p.s[start:p.pos]
+		if name == "" {
+			return step, fmt.Errorf("expected a name at %d", start)
+		}
+		step.local = name
+		if i := strings.IndexByte(name, ':'); i >= 0 {
+			step.prefix, step.local = name[:i], name[i+1:]
+		}
+		if step.local == "" || step.prefix == "*" || strings.Contains(step.local, ":") {
+			return step, fmt.Errorf("invalid name '%s'", name)
+		}
+	}
+	for p.peek("[") {
+		p.pos++
+		pred, err := p.predicate()
+		if err != nil {
+			return step, err
+		}
+		if !p.peek("]") {
+			return step, fmt.Errorf("expected ] at %d", p.pos)
+		}
+		p.pos++
+		step.predicates = append(step.predicates, pred)
+	}
+	return step, nil
+}
+
+func (p *xpathParser) predicate() (xpathPredicate, error) {
+	var pred xpathPredicate
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.s) && p.s[p.pos] >= '0' && p.s[p.pos] <= '9' {
+		p.pos++
+	}
+	if p.pos > start {
+		n, err := strconv.Atoi(p.s[start:p.pos])
+		if err != nil || n < 1 {
+			return pred, fmt.Errorf("invalid position at %d", start)
+		}
+		pred.position = n
+		p.skipSpace()
+		return pred, nil
+	}
+
+	path, err := p.path()
+	if err != nil {
+		return pred, err
+	}
+	pred.path = path
+	p.skipSpace()
+	switch {
+	case p.peek("!="):
+		pred.op = "!="
+	case p.peek("="):
+		pred.op = "="
+	default:
+		return pred, nil
+	}
+	p.pos += len(pred.op)
+	p.skipSpace()
+	if p.pos == len(p.s) || p.s[p.pos] != '\'' && p.s[p.pos] != '"' {
+		return pred, fmt.Errorf("expected a quoted literal at %d", p.pos)
+	}
+	end := strings.IndexByte(p.s[p.pos+1:], p.s[p.pos])
+	if end < 0 {
+		return pred, fmt.Errorf("unterminated literal at %d", p.pos)
+	}
+	pred.value = p.s[p.pos+1 : p.pos+1+end]
+	p.pos += end + 2
+	p.skipSpace()
+	return pred, nil
+}
+
+// eval selects items from the context node. An absolute path starts at the root
+func (e *xpathExpr) eval(doc *xmlDocument, context *xmlNode) []xpathItem {
+	if e.absolute {
+		context = doc.root
+	}
+	items := []xpathItem{{node: context}}
+	for i := range e.steps {
+		var next []xpathItem
+		for _, item := range items {
+			if item.node != nil {
+				next = append(next, e.steps[i].eval(doc, item.node)...)
+			}
+		}
+		items = next
+	}
+	return items
+}
+
+func (s *xpathStep) eval(doc *xmlDocument, context *xmlNode) []xpathItem {
+	contexts := []*xmlNode{context}
+	if s.descendant {
+		contexts = descendantsOrSelf(context, contexts[:0])
+	}
+	var items []xpathItem
+	for _, c := range contexts {
+		var group []*xmlNode
+		switch {
+		case s.self:
+			group = []*xmlNode{c}
+		case s.parent:
+			if c.parent != nil {
+				group = []*xmlNode{c.parent}
+			}
+		case s.text:
+			if len(c.texts) > 0 {
+				items = append(items, xpathItem{value: c.text()})
+			}
+			continue
+		case s.attribute:
+			for _, a := range c.attrs {
+				if doc.matchName(a.Name, s.prefix, s.local) {
+					items = append(items, xpathItem{value: a.Value})
+				}
+			}
+			continue
+		default:
+			for _, child := range c.children {
+				if doc.matchName(child.name, s.prefix, s.local) {
+					group = append(group, child)
+				}
+			}
+		}
+		for _, pred := range s.predicates {
+			group = pred.filter(doc, group)
+		}
+		for _, n := range group {
+			items = append(items, xpathItem{node: n})
+		}
+	}
+	return items
+}
+
+func descendantsOrSelf(n *xmlNode, nodes []*xmlNode) []*xmlNode {
+	nodes = append(nodes, n)
+	for _, child := range n.children {
+		nodes = descendantsOrSelf(child, nodes)
+	}
+	return nodes
+}
+
+func (pred *xpathPredicate) filter(doc *xmlDocument, nodes []*xmlNode) []*xmlNode {
+	if pred.position > 0 {
+		if pred.position > len(nodes) {
+			return nil
+		}
+		return nodes[pred.position-1 : pred.position]
+	}
+	var kept []*xmlNode
+	for _, n := range nodes {
+		items := pred.path.eval(doc, n)
+		ok := pred.op == "" && len(items) > 0
+		for _, item := range items {
+			if pred.op == "" || ok {
+				break
+			}
+			value := item.stringValue()
+			ok = pred.op == "=" && value == pred.value || pred.op == "!=" && value != pred.value
+		}
+		if ok {
+			kept = append(kept, n)
+		}
+	}
+	return kept
+}
+
+// xpathValues returns string values of the items which the expression selects in the XML body
+func xpathValues(body []byte, expr string) []string {
+	e, err := parseXPath(expr)
+	if err != nil {
+		return nil
+	}
+	doc, err := parseXMLDocument(body)
+	if err != nil {
+		return nil
+	}
+	var values []string
+	for _, item := range e.eval(doc, doc.root) {
+		values = append(values, item.stringValue())
+	}
+	return values
+}